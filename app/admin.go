@@ -0,0 +1,46 @@
+package app
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// RequireAdmin guards admin-only handlers. The caller must present the
+// configured admin token either as a bearer token or in X-Admin-Token.
+// Admin endpoints are disabled entirely when no token is configured.
+func (app *App) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.AdminToken == "" {
+			http.Error(w, "Admin endpoints disabled", http.StatusForbidden)
+			return
+		}
+
+		if !app.isAdmin(r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// isAdmin reports whether r carries the configured admin token in either
+// header. Both are checked, as the bearer token may be a service account
+// token for RequireServiceAccount instead.
+func (app *App) isAdmin(r *http.Request) bool {
+	if app.AdminToken == "" {
+		return false
+	}
+
+	tokens := []string{r.Header.Get("X-Admin-Token")}
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		tokens = append(tokens, bearer)
+	}
+	for _, token := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(app.AdminToken)) == 1 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,443 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/nesymno/run-tests-example/cache"
+	"github.com/nesymno/run-tests-example/metrics"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// APIKeyHeader carries a key managed through /admin/apikeys.
+const APIKeyHeader = "X-API-Key"
+
+var apiKeyHeaderKey = http.CanonicalHeaderKey(APIKeyHeader)
+
+const (
+	apiKeyCachePrefix = "api_keys:"
+	apiKeyUsedPrefix  = "api_keys:used:"
+	// apiKeyTouchInterval limits how often a key's last_used_at is
+	// written, so a busy key costs one UPDATE per interval.
+	apiKeyTouchInterval = time.Minute
+	// defaultRotationOverlap is how long a rotated key keeps working
+	// unless the rotation says otherwise.
+	defaultRotationOverlap = time.Hour
+)
+
+var errAlreadyRotated = errors.New("API key has already been rotated")
+
+var apiKeyChecks = metrics.NewCounterVec("app_api_key_checks_total",
+	"API key checks by result: ok, missing, invalid, expired or error.", "result")
+
+// apiKeyColumns are scanned by scanAPIKey.
+const apiKeyColumns = "id, name, key_prefix, created_at, expires_at, last_used_at, replaced_by"
+
+// apiKeyEntry is what validating a key needs, cached in Redis by hash.
+type apiKeyEntry struct {
+	ID        int        `json:"id"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (e apiKeyEntry) expired(now time.Time) bool {
+	return e.ExpiresAt != nil && !now.Before(*e.ExpiresAt)
+}
+
+// cacheTTL is how long e may be cached: at most max, and never past its
+// expiry, which is when it has to be rejected.
+func (e apiKeyEntry) cacheTTL(max time.Duration, now time.Time) time.Duration {
+	if e.ExpiresAt != nil {
+		if until := e.ExpiresAt.Sub(now); until < max {
+			return until
+		}
+	}
+	return max
+}
+
+// RequireAPIKey requires a valid, unexpired key in X-API-Key while
+// RequireAPIKeys is set, and lets every request through otherwise.
+func (app *App) RequireAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !app.RequireAPIKeys {
+			next(w, r)
+			return
+		}
+
+		key := r.Header.Get(apiKeyHeaderKey)
+		if key == "" {
+			apiKeyChecks.With("missing").Inc()
+			http.Error(w, "API key required", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := r.Context()
+		entry, err := app.lookupAPIKey(ctx, key)
+		if err != nil {
+			apiKeyChecks.With("error").Inc()
+			writeDBError(w, "API key check failed", err)
+			return
+		}
+		if entry == nil {
+			apiKeyChecks.With("invalid").Inc()
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if entry.expired(app.skewedNow()) {
+			apiKeyChecks.With("expired").Inc()
+			http.Error(w, "API key expired", http.StatusUnauthorized)
+			return
+		}
+
+		apiKeyChecks.With("ok").Inc()
+		app.touchAPIKey(ctx, entry.ID)
+		next(w, r)
+	}
+}
+
+// lookupAPIKey finds a key by its hash, in Redis first and then in
+// Postgres, nil for unknown keys. Unknown keys are not cached, so a key
+// works as soon as it is created.
+func (app *App) lookupAPIKey(ctx context.Context, key string) (*apiKeyEntry, error) {
+	cacheKey := apiKeyCachePrefix + hashAPIKey(key)
+	if app.Rds != nil && app.APIKeyCacheTTL > 0 {
+		b, err := app.Rds.Get(ctx, cacheKey).Bytes()
+		if err == nil {
+			var entry apiKeyEntry
+			if err := json.Unmarshal(b, &entry); err == nil {
+				cache.AreaRecord.Hit()
+				return &entry, nil
+			}
+		} else if err != redis.Nil {
+			log.Printf("API key cache read failed: %v", err)
+		}
+		cache.AreaRecord.Miss()
+	}
+
+	start := time.Now()
+	var entry apiKeyEntry
+	err := app.DB.QueryRowContext(ctx,
+		"SELECT id, expires_at FROM api_keys WHERE key_hash = $1", hashAPIKey(key),
+	).Scan(&entry.ID, &entry.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if app.Rds != nil && app.APIKeyCacheTTL > 0 {
+		if ttl := entry.cacheTTL(app.APIKeyCacheTTL, app.skewedNow()); ttl > 0 {
+			b, _ := json.Marshal(entry)
+			if err := app.Rds.Set(ctx, cacheKey, b, ttl).Err(); err != nil {
+				log.Printf("API key cache write failed: %v", err)
+			} else {
+				cache.AreaRecord.Fill(time.Since(start), len(b))
+			}
+		}
+	}
+	return &entry, nil
+}
+
+// touchAPIKey records that a key was used, at most once per
+// apiKeyTouchInterval across all instances.
+func (app *App) touchAPIKey(ctx context.Context, id int) {
+	if app.Rds != nil {
+		first, err := app.Rds.SetNX(ctx, apiKeyUsedPrefix+strconv.Itoa(id), 1, apiKeyTouchInterval).Result()
+		if err == nil && !first {
+			return
+		}
+	}
+	if _, err := app.DB.ExecContext(ctx, "UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1", id); err != nil {
+		log.Printf("Failed to record use of API key %d: %v", id, err)
+	}
+}
+
+// forgetAPIKey drops a key's cached validation after it changed, so the
+// change applies to the next request.
+func (app *App) forgetAPIKey(ctx context.Context, hash string) {
+	if app.Rds == nil {
+		return
+	}
+	if err := app.Rds.Del(ctx, apiKeyCachePrefix+hash).Err(); err != nil {
+		log.Printf("API key cache invalidation failed: %v", err)
+	}
+}
+
+// APIKeysHandler lists (GET) or creates (POST) API keys.
+func (app *App) APIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		keys, err := app.listAPIKeys(r.Context())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		setJSONContentType(w)
+		app.writeJSON(w, r, keys)
+	case "POST":
+		var req types.APIKeyRequest
+		if err := app.decodeJSON(r, &req); err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+		if req.Name == "" {
+			http.Error(w, "API key name is required", http.StatusBadRequest)
+			return
+		}
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(app.skewedNow()) {
+			http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+			return
+		}
+
+		creds, err := app.createAPIKey(r.Context(), app.DB, req.Name, req.ExpiresAt)
+		if err != nil {
+			writeDBError(w, "Database error", err)
+			return
+		}
+		app.writeAPIKeyCredentials(w, r, creds)
+	default:
+		methodNotAllowed(w, r, "GET", "POST")
+	}
+}
+
+// APIKeyHandler shows (GET), updates the name or expiry of (PATCH) or
+// revokes (DELETE) an API key.
+func (app *App) APIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	switch r.Method {
+	case "GET":
+	case "PATCH":
+		var req types.APIKeyRequest
+		if err := app.decodeJSON(r, &req); err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+		if req.Name == "" && req.ExpiresAt == nil {
+			http.Error(w, "Nothing to update: set name or expires_at", http.StatusBadRequest)
+			return
+		}
+
+		// An expiry in the past is allowed here: it disables the key
+		// while keeping its record
+		var hash string
+		err := app.DB.QueryRowContext(ctx, `
+			UPDATE api_keys SET name = COALESCE(NULLIF($1, ''), name), expires_at = COALESCE($2, expires_at)
+			WHERE id = $3 RETURNING key_hash`, req.Name, req.ExpiresAt, id,
+		).Scan(&hash)
+		if err == sql.ErrNoRows {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeDBError(w, "Database error", err)
+			return
+		}
+		app.forgetAPIKey(ctx, hash)
+	case "DELETE":
+		var hash string
+		err := app.DB.QueryRowContext(ctx, "DELETE FROM api_keys WHERE id = $1 RETURNING key_hash", id).Scan(&hash)
+		if err == sql.ErrNoRows {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		app.forgetAPIKey(ctx, hash)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		methodNotAllowed(w, r, "GET", "PATCH", "DELETE")
+		return
+	}
+
+	key, err := scanAPIKey(app.DB.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	setJSONContentType(w)
+	app.writeJSON(w, r, key)
+}
+
+// RotateAPIKeyHandler replaces an API key (POST) with a new one of the
+// same name and expiry. The old key keeps working for overlap_seconds, so
+// clients can switch over without failed requests.
+func (app *App) RotateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, r, "POST")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+		return
+	}
+
+	overlap := defaultRotationOverlap
+	if r.ContentLength != 0 {
+		var req types.APIKeyRotation
+		if err := app.decodeJSON(r, &req); err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+		if req.OverlapSeconds != nil {
+			if *req.OverlapSeconds < 0 {
+				http.Error(w, "overlap_seconds must not be negative", http.StatusBadRequest)
+				return
+			}
+			overlap = time.Duration(*req.OverlapSeconds) * time.Second
+		}
+	}
+
+	creds, err := app.rotateAPIKey(r.Context(), id, overlap)
+	if err == sql.ErrNoRows {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	if err == errAlreadyRotated {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeDBError(w, "Rotation error", err)
+		return
+	}
+	app.writeAPIKeyCredentials(w, r, creds)
+}
+
+func (app *App) writeAPIKeyCredentials(w http.ResponseWriter, r *http.Request, creds *types.APIKeyCredentials) {
+	setJSONContentType(w)
+	w.Header().Set("Location", "/admin/apikeys/"+strconv.Itoa(creds.ID))
+	app.writeJSONStatus(w, r, http.StatusCreated, creds)
+}
+
+func (app *App) listAPIKeys(ctx context.Context) ([]types.APIKey, error) {
+	rows, err := app.DB.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []types.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// createAPIKey stores a new key's hash and returns the key, which is not
+// stored anywhere.
+func (app *App) createAPIKey(ctx context.Context, db dbtx, name string, expiresAt *time.Time) (*types.APIKeyCredentials, error) {
+	key, err := randomKey("ak_")
+	if err != nil {
+		return nil, err
+	}
+
+	creds := &types.APIKeyCredentials{
+		APIKey: types.APIKey{Name: name, Prefix: key[:len("ak_")+8], ExpiresAt: expiresAt},
+		Key:    key,
+	}
+	err = db.QueryRowContext(ctx, `
+		INSERT INTO api_keys (name, key_hash, key_prefix, expires_at) VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, expires_at`, name, hashAPIKey(key), creds.Prefix, expiresAt,
+	).Scan(&creds.ID, &creds.CreatedAt, &creds.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	utcAPIKey(&creds.APIKey)
+	return creds, nil
+}
+
+// rotateAPIKey creates the replacement of a key and cuts the old key's
+// validity down to overlap from now.
+func (app *App) rotateAPIKey(ctx context.Context, id int, overlap time.Duration) (*types.APIKeyCredentials, error) {
+	tx, err := app.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		name       string
+		hash       string
+		expiresAt  *time.Time
+		replacedBy *int
+	)
+	err = tx.QueryRowContext(ctx,
+		"SELECT name, key_hash, expires_at, replaced_by FROM api_keys WHERE id = $1 FOR UPDATE", id,
+	).Scan(&name, &hash, &expiresAt, &replacedBy)
+	if err != nil {
+		return nil, err
+	}
+	if replacedBy != nil {
+		return nil, errAlreadyRotated
+	}
+
+	creds, err := app.createAPIKey(ctx, tx, name, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE api_keys SET replaced_by = $1,
+			expires_at = LEAST(COALESCE(expires_at, 'infinity'), CURRENT_TIMESTAMP + make_interval(secs => $2))
+		WHERE id = $3`, creds.ID, overlap.Seconds(), id)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	app.forgetAPIKey(ctx, hash)
+	return creds, nil
+}
+
+// rowScanner is a *sql.Row or *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIKey(row rowScanner) (*types.APIKey, error) {
+	var key types.APIKey
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.CreatedAt, &key.ExpiresAt, &key.LastUsedAt, &key.ReplacedBy)
+	if err != nil {
+		return nil, err
+	}
+	utcAPIKey(&key)
+	return &key, nil
+}
+
+func utcAPIKey(key *types.APIKey) {
+	key.CreatedAt = key.CreatedAt.UTC()
+	for _, t := range []*time.Time{key.ExpiresAt, key.LastUsedAt} {
+		if t != nil {
+			*t = t.UTC()
+		}
+	}
+}
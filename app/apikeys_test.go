@@ -0,0 +1,107 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyCacheTTLStopsAtExpiry(t *testing.T) {
+	now := time.Now()
+	soon := now.Add(10 * time.Second)
+	later := now.Add(time.Hour)
+
+	assert.Equal(t, time.Minute, apiKeyEntry{}.cacheTTL(time.Minute, now))
+	assert.Equal(t, time.Minute, apiKeyEntry{ExpiresAt: &later}.cacheTTL(time.Minute, now))
+	assert.Equal(t, 10*time.Second, apiKeyEntry{ExpiresAt: &soon}.cacheTTL(time.Minute, now))
+
+	assert.False(t, apiKeyEntry{ExpiresAt: &soon}.expired(now))
+	assert.True(t, apiKeyEntry{ExpiresAt: &soon}.expired(soon), "a key is rejected from its expiry on")
+	assert.False(t, apiKeyEntry{}.expired(now), "keys without expiry never expire")
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	mr := miniredis.RunT(t)
+	app := &App{
+		Rds:            redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		RequireAPIKeys: true,
+		APIKeyCacheTTL: time.Minute,
+	}
+	ctx := context.Background()
+
+	cacheEntry := func(key string, entry apiKeyEntry) {
+		b, err := json.Marshal(entry)
+		require.NoError(t, err)
+		require.NoError(t, mr.Set(apiKeyCachePrefix+hashAPIKey(key), string(b)))
+		// Marks the key as recently used, so no last_used_at update is
+		// written
+		require.NoError(t, app.Rds.Set(ctx, apiKeyUsedPrefix+strconv.Itoa(entry.ID), 1, time.Minute).Err())
+	}
+	valid, err := randomKey("ak_")
+	require.NoError(t, err)
+	cacheEntry(valid, apiKeyEntry{ID: 1})
+	expired, err := randomKey("ak_")
+	require.NoError(t, err)
+	past := time.Now().Add(-time.Second)
+	cacheEntry(expired, apiKeyEntry{ID: 2, ExpiresAt: &past})
+	recent, err := randomKey("ak_")
+	require.NoError(t, err)
+	justNow := time.Now().Add(-time.Minute)
+	cacheEntry(recent, apiKeyEntry{ID: 3, ExpiresAt: &justNow})
+
+	calls := 0
+	handler := app.RequireAPIKey(func(w http.ResponseWriter, r *http.Request) { calls++ })
+	check := func(key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/data", nil)
+		if key != "" {
+			r.Header.Set(APIKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, check(valid).Code)
+	assert.Equal(t, 1, calls)
+
+	rec := check("")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "required")
+
+	rec = check(expired)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "expired")
+	assert.Equal(t, 1, calls)
+
+	// Within the tolerated skew, a key that just expired is still accepted
+	app.ClockSkew = 2 * time.Minute
+	assert.Equal(t, http.StatusOK, check(recent).Code)
+	assert.Equal(t, 2, calls)
+	app.ClockSkew = 0
+	assert.Equal(t, http.StatusUnauthorized, check(recent).Code)
+
+	// Revoking a key drops its cached validation
+	app.forgetAPIKey(ctx, hashAPIKey(valid))
+	assert.False(t, mr.Exists(apiKeyCachePrefix+hashAPIKey(valid)))
+
+	app.RequireAPIKeys = false
+	assert.Equal(t, http.StatusOK, check("").Code, "keys are optional unless required")
+	assert.Equal(t, 3, calls)
+}
+
+func TestAPIKeysAreDistinctFromTenantKeys(t *testing.T) {
+	key, err := randomKey("ak_")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, "ak_"))
+	assert.Len(t, key, len("ak_")+64)
+}
@@ -4,116 +4,692 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"log/slog"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/redis/go-redis/v9"
 
+	"github.com/nesymno/run-tests-example/cache"
+	"github.com/nesymno/run-tests-example/config"
+	"github.com/nesymno/run-tests-example/encryption"
+	"github.com/nesymno/run-tests-example/events"
+	"github.com/nesymno/run-tests-example/jsonpolicy"
+	"github.com/nesymno/run-tests-example/satoken"
 	"github.com/nesymno/run-tests-example/types"
+	"github.com/nesymno/run-tests-example/worker"
 )
 
+// APIVersion is the version of the API's response shapes. Cached responses
+// are namespaced by it, so versions never serve each other's payloads.
+const APIVersion = "v1"
+
 type App struct {
 	DB  *sql.DB
 	Rds *redis.Client
+
+	// ListCache caches GET /api/data results per normalized filter.
+	ListCache *cache.QueryCache
+	// CacheStrategy is what writes do to ListCache: cache.StrategyInvalidate
+	// (the default) or cache.StrategyWriteThrough.
+	CacheStrategy string
+
+	// HTTPClient is shared by every outbound HTTP call, see httpclient.
+	HTTPClient *http.Client
+
+	// Jobs is the background job queue used by async writes.
+	Jobs *worker.Queue
+	// Schedules manages recurring job definitions.
+	Schedules *worker.Scheduler
+	// Batch buffers writes for bulk inserts; nil unless write-behind mode
+	// is enabled.
+	Batch *worker.Batcher
+	// QueueLimits make /readyz fail while Jobs or Batch lag behind.
+	QueueLimits QueueLimits
+	// SLOs tracks the objectives of routes, reported by /debug/slo.
+	SLOs *SLOTracker
+	// Events broadcasts change events to the /api/events subscribers of
+	// this replica; nil disables the feed.
+	Events *events.Broadcaster
+	// Feed controls heartbeats and idle timeouts of the event feed.
+	Feed FeedPolicy
+
+	// Retention controls expiry of old test_data rows.
+	Retention RetentionPolicy
+	// CacheVerify controls the list cache checks of RunCacheVerify.
+	CacheVerify CacheVerifyPolicy
+	// Concurrency limits the /api requests in flight; see
+	// ConcurrencyMiddleware.
+	Concurrency ConcurrencyPolicy
+	// Warm keeps pooled connections established; see RunWarmConnections.
+	Warm WarmPolicy
+	// HealthChecks caches the dependency checks of /health and /readyz.
+	HealthChecks HealthCheckPolicy
+	// Snapshots controls the periodic state snapshots of RunSnapshots.
+	Snapshots SnapshotPolicy
+
+	// Keyring encrypts test_data secrets; nil rejects rows with one.
+	Keyring *encryption.Keyring
+
+	// AdminToken authorizes requests to the /admin endpoints.
+	AdminToken string
+	// ServiceAuth verifies the Kubernetes service account tokens required
+	// on the /api routes; nil leaves them open.
+	ServiceAuth satoken.Verifier
+	// ServiceAccounts limits which service accounts ServiceAuth accepts.
+	ServiceAccounts satoken.Allowlist
+
+	// RequireAPIKeys makes RequireAPIKey demand a key from /admin/apikeys.
+	RequireAPIKeys bool
+	// APIKeyCacheTTL is how long a validated API key is cached in Redis,
+	// 0 to look every key up in Postgres.
+	APIKeyCacheTTL time.Duration
+
+	// ClockSkew is how far the clocks of clients and other replicas may be
+	// off. Expiry times set by clients are honored that much longer.
+	ClockSkew time.Duration
+
+	// TrustedProxies are the proxies whose forwarding headers are believed
+	// when resolving the client address.
+	TrustedProxies []netip.Prefix
+
+	// Profile is the active APP_ENV profile, reported by /health.
+	Profile string
+	// DebugRequest enables the /debug/request echo endpoint.
+	DebugRequest bool
+	// EnableReset enables the /test/reset endpoint.
+	EnableReset bool
+	// SerializeRequests runs requests on the same test_data row one at a
+	// time, for deterministic tests; see SerializeByID.
+	SerializeRequests bool
+	// StrictJSON rejects request bodies with fields the endpoint does not
+	// know, which are ignored otherwise.
+	StrictJSON bool
+	// ServerTiming adds the Server-Timing header to responses; see
+	// ServerTimingMiddleware.
+	ServerTiming bool
+	// MethodOverride lets POST requests ask for PUT, PATCH or DELETE with
+	// MethodOverrideHeader; see MethodOverrideMiddleware.
+	MethodOverride bool
+	// JSON is the field naming and null policy of responses; requests
+	// may override it, see jsonpolicy.ForRequest.
+	JSON jsonpolicy.Policy
+	// CoverDir receives the counters of /admin/coverage/flush, normally
+	// GOCOVERDIR; coverage flushes are disabled when empty.
+	CoverDir string
+	// Dependencies are checked by /debug/connectivity.
+	Dependencies []Dependency
+	// Settings is the configuration report served by /debug/env.
+	Settings []config.Setting
+
+	// Release identifies the deployed build; levels set through
+	// /admin/loglevel only apply to replicas of the same release.
+	Release string
+	// DefaultLogLevel is the level used while none is stored.
+	DefaultLogLevel slog.Level
+
+	readOnly    atomic.Bool
+	started     atomic.Bool
+	dataFlight  flightGroup[dataList]
+	rowLocks    keyedLock
+	retention   retentionState
+	cacheVerify cacheVerifyState
+	concurrency concurrencyState
+	health      healthState
+	feeds       feedState
+	logLevel    logLevelState
 }
 
 func (app *App) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	checks := app.healthChecks(r.Context(), r.URL.Query().Get("force") == "true")
+
 	// Check database health
 	dbStatus := "healthy"
-	if err := app.DB.Ping(); err != nil {
+	if checks.db != nil {
 		dbStatus = "unhealthy"
 	}
 
 	// Check Redis health
 	cacheStatus := "healthy"
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := app.Rds.Ping(ctx).Err(); err != nil {
+	if checks.redis != nil || checks.listCache != nil {
 		cacheStatus = "unhealthy"
+	} else if app.ListCache.Degraded() {
+		cacheStatus = "degraded"
+	}
+
+	status := "healthy"
+	if dbStatus == "unhealthy" || cacheStatus == "unhealthy" {
+		status = "unhealthy"
 	}
 
 	response := types.HealthResponse{
-		Status:    "healthy",
+		Status:    status,
 		Timestamp: time.Now(),
 		Version:   "1.0.0",
 		Database:  dbStatus,
 		Cache:     cacheStatus,
+		Profile:   app.Profile,
+	}
+	if checks.memory != nil {
+		memory := *checks.memory
+		memory.Degraded = app.ListCache.Degraded()
+		response.CacheMemory = &memory
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	setJSONContentType(w)
+	app.writeJSON(w, r, response)
 }
 
 func (app *App) DataHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "POST" {
 		// Insert new data
 		var data types.TestData
-		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		if err := app.decodeJSON(r, &data); err != nil {
+			writeDecodeError(w, err)
 			return
 		}
+		if run := testRunFrom(r.Context()); run != "" {
+			data.TestRunID = run
+		}
+		if err := app.checkData(&data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx := context.WithoutCancel(r.Context())
+		tenant := tenantFrom(ctx)
 
-		ctx := context.Background()
-		_, err := app.DB.ExecContext(ctx,
-			"INSERT INTO test_data (name, data) VALUES ($1, $2)",
-			data.Name, data.Data)
+		// The secret is sealed before the row is queued, as the queues
+		// keep it in Redis
+		sealed, err := app.sealData(data)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Insert error: %v", err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Encryption error: %v", err), http.StatusInternalServerError)
 			return
 		}
 
-		// Invalidate cache
-		app.Rds.Del(ctx, "test_data_cache")
+		// Async mode: hand the write to the worker and acknowledge it
+		if r.URL.Query().Get("async") == "true" {
+			if tenant != nil {
+				http.Error(w, "Async writes are not supported for tenants", http.StatusBadRequest)
+				return
+			}
+			job, err := app.Jobs.Enqueue(ctx, insertDataJob, sealed)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Enqueue error: %v", err), http.StatusInternalServerError)
+				return
+			}
 
-		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(map[string]string{"status": "created"})
+			setJSONContentType(w)
+			w.Header().Set("Location", "/api/jobs/"+job.ID)
+			app.writeJSONStatus(w, r, http.StatusAccepted, jsonpolicy.Fields{"status": job.Status, "job_id": job.ID})
+			return
+		}
+
+		// Write-behind mode: buffer the row for the batch writer. Tenant rows
+		// are written right away, as the batch writer only knows the shared
+		// tables.
+		if app.Batch != nil && tenant == nil {
+			if err := app.Batch.Add(ctx, sealed); err != nil {
+				http.Error(w, fmt.Sprintf("Buffer error: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			app.writeJSONStatus(w, r, http.StatusAccepted, jsonpolicy.Fields{"status": "accepted"})
+			return
+		}
+
+		version, err := app.insertData(ctx, sealed)
+		if err != nil {
+			writeDBError(w, "Insert error", err)
+			return
+		}
+
+		setConsistencyToken(w, version)
+		app.writeJSONStatus(w, r, http.StatusCreated, jsonpolicy.Fields{"status": "created"})
+		return
+	}
+	if r.Method != "GET" {
+		methodNotAllowed(w, r, "GET", "POST")
 		return
 	}
 
-	// GET request - return data with caching
-	ctx := context.Background()
+	// GET request - identical concurrent requests share one execution
+	filter := dataFilter{
+		Tag:     r.URL.Query().Get("tag"),
+		Status:  r.URL.Query().Get("status"),
+		TestRun: r.URL.Query().Get("test_run_id"),
+		Tenant:  tenantFrom(r.Context()),
+	}
+	if filter.Status != "" && !validStatus(filter.Status) {
+		http.Error(w, fmt.Sprintf("Invalid status %q", filter.Status), http.StatusBadRequest)
+		return
+	}
+	fields, err := parseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Fields = fields
+	include, cacheMetadata, err := parseIncludes(r.URL.Query().Get("include"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Include = include
 
-	// Try to get from cache first
-	cached, err := app.Rds.Get(ctx, "test_data_cache").Result()
-	if err == nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("X-Cache", "HIT")
-		w.Write([]byte(cached))
+	if stream := r.URL.Query().Get("stream"); stream != "" {
+		if !validStream(stream) {
+			http.Error(w, fmt.Sprintf("Invalid stream %q (use true or ndjson)", stream), http.StatusBadRequest)
+			return
+		}
+		// Expanding a chunk would query the connection the stream is
+		// still reading from
+		if filter.Include != nil {
+			http.Error(w, "include cannot be combined with stream", http.StatusBadRequest)
+			return
+		}
+		app.writeDataStream(w, r, filter, stream, func(fn func(types.TestData) error) error {
+			return app.eachData(r.Context(), filter, fn)
+		})
 		return
 	}
 
-	// Cache miss, get from database
-	rows, err := app.DB.QueryContext(ctx, "SELECT id, name, data FROM test_data ORDER BY id")
+	opts := listOptions{MinEpoch: consistencyToken(r)}
+	refresh, err := app.wantsRefresh(r)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
-	defer rows.Close()
+	opts.Refresh = refresh
+
+	// Requests carrying a write version only share flights with requests
+	// that saw the same write, never with a load that started before it.
+	// Refreshes only share with other refreshes.
+	key := r.URL.Path + "?" + r.URL.Query().Encode()
+	if opts.MinEpoch > 0 {
+		key += "#" + strconv.FormatInt(opts.MinEpoch, 10)
+	}
+	if opts.Refresh {
+		key += "#refresh"
+	}
+	if filter.Tenant != nil {
+		key += "#tenant=" + strconv.Itoa(filter.Tenant.ID)
+	}
+	// The flight outlives a caller that goes away, but not the deadline of
+	// the one running it; callers with more time left than that one run
+	// the listing again rather than fail with it
+	result, err, shared := app.dataFlight.DoContext(r.Context(), key, func() (dataList, error) {
+		ctx, cancel := detach(r.Context())
+		defer cancel()
+		result, err := app.listData(ctx, filter, opts)
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return result, err
+	})
+	if shared && err == context.DeadlineExceeded && r.Context().Err() == nil {
+		ctx, cancel := detach(r.Context())
+		defer cancel()
+		result, err = app.listData(ctx, filter, opts)
+		shared = false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if shared {
+		w.Header().Set("X-Coalesced", "true")
+	}
+
+	setJSONContentType(w)
+	w.Header().Set("X-Cache", result.cache)
+	if cacheMetadata {
+		w.Header().Set("X-Cache-Key", filter.normalized())
+		w.Header().Set("X-Cache-Epoch", strconv.FormatInt(result.epoch, 10))
+	}
+	body, err := jsonpolicy.Rewrite(result.body, reflect.TypeFor[[]types.TestData](), jsonpolicy.ForRequest(r, app.JSON))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(body)
+}
+
+// insertData stores a new row, invalidates the list cache and returns the
+// write version for read-your-writes.
+func (app *App) insertData(ctx context.Context, sealed sealedData) (int64, error) {
+	data := sealed.TestData
+	err := app.db(ctx).QueryRowContext(ctx, `
+		INSERT INTO test_data (name, data, tags, status, created_at, updated_at, tenant_id, secret, test_run_id)
+		VALUES ($1, $2, $3, $4, COALESCE($5, CURRENT_TIMESTAMP), COALESCE($6, $5, CURRENT_TIMESTAMP), $7, $8, NULLIF($9, ''))
+		RETURNING id`,
+		data.Name, data.Data, pq.Array(data.Tags), data.Status, nullTime(data.CreatedAt), nullTime(data.UpdatedAt),
+		tenantColumn(tenantFrom(ctx)), sealed.SealedSecret, data.TestRunID).Scan(&data.ID)
+	if err != nil {
+		return 0, err
+	}
+	app.publishEvent(ctx, eventDataCreated, eventData(data))
+
+	return app.writeVersion(ctx, app.invalidateList(ctx)), nil
+}
+
+// invalidateList drops every cached list query after a write and returns
+// the new cache epoch, 0 if invalidation failed. In write-through mode the
+// unfiltered list of the caller's tenant is reloaded and cached right away.
+func (app *App) invalidateList(ctx context.Context) int64 {
+	if app.CacheStrategy == cache.StrategyWriteThrough {
+		all := dataFilter{Tenant: tenantFrom(ctx)}
+		epoch, err := app.ListCache.Refresh(ctx, all.normalized(), func(ctx context.Context) ([]byte, error) {
+			return app.queryData(ctx, all)
+		})
+		if err != nil && !errors.Is(err, cache.ErrInvalidationDeferred) {
+			log.Printf("List cache refresh failed: %v", err)
+		}
+		return epoch
+	}
+
+	epoch, err := app.ListCache.Invalidate(ctx)
+	if err != nil && !errors.Is(err, cache.ErrInvalidationDeferred) {
+		log.Printf("List cache invalidation failed: %v", err)
+	}
+	return epoch
+}
+
+// dataList is a rendered GET /api/data response and where it came from.
+type dataList struct {
+	body  []byte
+	cache string
+	// epoch is the cache epoch the listing was read or stored at, 0 when
+	// the cache was bypassed
+	epoch int64
+}
+
+// dataFilter narrows a GET /api/data listing. Empty fields match all rows.
+type dataFilter struct {
+	Tag    string
+	Status string
+	// TestRun limits the listing to rows created by one test run
+	TestRun string
+
+	// Include are the rowIncludes expanded on each row
+	Include []string
+
+	// Fields are the JSON names of the fields rendered, nil for all
+	Fields []string
+
+	// Tenant limits the listing to a tenant's rows, nil to the shared ones
+	Tenant *types.Tenant
+}
+
+const listDataQuery = `
+	SELECT id, name, data, tags, status, created_at, updated_at, secret, COALESCE(test_run_id, '') FROM test_data
+	WHERE ($1 = '' OR $1 = ANY(tags)) AND ($2 = '' OR status::text = $2)
+	AND tenant_id IS NOT DISTINCT FROM $3 AND ($4 = '' OR test_run_id = $4)
+	ORDER BY id`
+
+// selects reports whether the listing renders the named field.
+func (f dataFilter) selects(name string) bool {
+	return f.Fields == nil || slices.Contains(f.Fields, name)
+}
+
+func (f dataFilter) args() []any {
+	return []any{f.Tag, f.Status, tenantColumn(f.Tenant), f.TestRun}
+}
+
+// normalized renders the filter canonically, so equivalent requests share
+// a cache entry regardless of parameter order.
+func (f dataFilter) normalized() string {
+	v := url.Values{}
+	if f.Tag != "" {
+		v.Set("tag", f.Tag)
+	}
+	if f.Status != "" {
+		v.Set("status", f.Status)
+	}
+	if f.TestRun != "" {
+		v.Set("test_run_id", f.TestRun)
+	}
+	if f.Include != nil {
+		v.Set("include", strings.Join(f.Include, ","))
+	}
+	if f.Fields != nil {
+		v.Set("fields", strings.Join(f.Fields, ","))
+	}
+	if f.Tenant != nil {
+		v.Set("tenant", strconv.Itoa(f.Tenant.ID))
+	}
+	return v.Encode()
+}
+
+// listOptions controls how listData uses the cache.
+type listOptions struct {
+	// MinEpoch is the client's last write version; the cache is bypassed
+	// until its epoch reaches it
+	MinEpoch int64
+	// Refresh skips the cache read but still stores the fresh result
+	Refresh bool
+}
+
+// listData serves a listing from the cache when allowed by opts and from
+// the database otherwise.
+func (app *App) listData(ctx context.Context, filter dataFilter, opts listOptions) (dataList, error) {
+	query := filter.normalized()
+	var epoch int64
+	cacheable := app.ListCache.Available(ctx)
+	if cacheable {
+		var err error
+		epoch, err = app.ListCache.Epoch(ctx)
+		cacheable = err == nil && epoch >= opts.MinEpoch
+	}
+
+	// Try to get from cache first
+	if cacheable && !opts.Refresh {
+		if cached, ok, err := app.ListCache.GetAt(ctx, epoch, query); err == nil && ok {
+			cache.AreaList.Hit()
+			return dataList{body: cached, cache: "HIT", epoch: epoch}, nil
+		}
+		cache.AreaList.Miss()
+	}
+
+	// Cache miss, get from database
+	start := time.Now()
+	jsonData, err := app.queryData(ctx, filter)
+	if err != nil {
+		return dataList{}, err
+	}
+
+	// The cache is degraded or has not seen the client's write yet, keep
+	// it out
+	if !cacheable {
+		return dataList{body: jsonData, cache: "BYPASS"}, nil
+	}
+
+	// Cache the result under the epoch read before the query, so a write
+	// that lands meanwhile leaves it unreachable
+	app.ListCache.SetAt(ctx, epoch, query, jsonData)
+	cache.AreaList.Fill(time.Since(start), len(jsonData))
+
+	if opts.Refresh {
+		return dataList{body: jsonData, cache: "REFRESH", epoch: epoch}, nil
+	}
+	return dataList{body: jsonData, cache: "MISS", epoch: epoch}, nil
+}
 
+// queryData renders the listing for filter straight from the database.
+func (app *App) queryData(ctx context.Context, filter dataFilter) ([]byte, error) {
 	var results []types.TestData
+	err := app.eachData(ctx, filter, func(data types.TestData) error {
+		results = append(results, data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := app.expand(ctx, filter, results); err != nil {
+		return nil, fmt.Errorf("Database error: %v", err)
+	}
+
+	var jsonData []byte
+	if filter.Fields != nil {
+		jsonData, err = jsonpolicy.Marshal(projectData(results, filter.Fields), jsonpolicy.Policy{})
+	} else {
+		jsonData, err = jsonpolicy.Marshal(results, jsonpolicy.Policy{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Encode error: %v", err)
+	}
+	return jsonData, nil
+}
+
+// eachData runs the listing query for filter and calls fn with each row
+// as it is read, without expanding includes. It stops at the first error
+// fn returns.
+func (app *App) eachData(ctx context.Context, filter dataFilter, fn func(types.TestData) error) error {
+	rows, err := app.db(ctx).QueryContext(ctx, listDataQuery, filter.args()...)
+	if err != nil {
+		return fmt.Errorf("Database error: %v", err)
+	}
+	return app.scanData(rows, filter, fn)
+}
+
+// scanData calls fn with each row of rows, which selects the columns of
+// listDataQuery, and closes rows.
+func (app *App) scanData(rows *sql.Rows, filter dataFilter, fn func(types.TestData) error) error {
+	defer rows.Close()
+
 	for rows.Next() {
 		var data types.TestData
-		if err := rows.Scan(&data.ID, &data.Name, &data.Data); err != nil {
-			http.Error(w, fmt.Sprintf("Scan error: %v", err), http.StatusInternalServerError)
-			return
+		var createdAt, updatedAt sql.NullTime
+		var secret []byte
+		if err := rows.Scan(&data.ID, &data.Name, &data.Data, pq.Array(&data.Tags), &data.Status, &createdAt, &updatedAt, &secret, &data.TestRunID); err != nil {
+			return fmt.Errorf("Scan error: %v", err)
+		}
+		data.CreatedAt = createdAt.Time.UTC()
+		data.UpdatedAt = updatedAt.Time.UTC()
+		if !filter.selects("secret") {
+			secret = nil
+		}
+		var err error
+		if data.Secret, err = app.openSecret(secret); err != nil {
+			return fmt.Errorf("Decrypt error for row %d: %v", data.ID, err)
+		}
+		if err := fn(data); err != nil {
+			return err
 		}
-		results = append(results, data)
 	}
 
 	if err := rows.Err(); err != nil {
-		http.Error(w, fmt.Sprintf("Rows error: %v", err), http.StatusInternalServerError)
-		return
+		return fmt.Errorf("Rows error: %v", err)
+	}
+	return nil
+}
+
+// normalizeData fills in defaults for optional fields, converts timestamps
+// to UTC and rejects unknown statuses before a row is written.
+func normalizeData(data *types.TestData) error {
+	if data.Tags == nil {
+		data.Tags = []string{}
+	}
+	if data.Status == "" {
+		data.Status = types.StatusActive
+	}
+	if !validStatus(data.Status) {
+		return fmt.Errorf("Invalid status %q", data.Status)
+	}
+
+	// The columns are timestamps without a time zone, which would keep the
+	// wall clock of an offset time and drop the offset
+	data.CreatedAt = data.CreatedAt.UTC()
+	data.UpdatedAt = data.UpdatedAt.UTC()
+	if !data.CreatedAt.IsZero() && !data.UpdatedAt.IsZero() && data.UpdatedAt.Before(data.CreatedAt) {
+		return fmt.Errorf("updated_at is before created_at")
+	}
+	return nil
+}
+
+// checkData normalizes a row to insert and checks what normalizeData
+// leaves to the callers: that its secret can be sealed and its test run
+// ID.
+func (app *App) checkData(data *types.TestData) error {
+	if err := normalizeData(data); err != nil {
+		return err
+	}
+	if data.Secret != "" && app.Keyring == nil {
+		return errNoKeyring
 	}
+	if data.TestRunID != "" && !validTestRunID(data.TestRunID) {
+		return fmt.Errorf("Invalid test_run_id %q", data.TestRunID)
+	}
+	return nil
+}
 
-	// Cache the result
-	if jsonData, err := json.Marshal(results); err == nil {
-		app.Rds.Set(ctx, "test_data_cache", jsonData, 5*time.Minute)
+// nullTime passes a zero time to the database as NULL.
+func nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
 	}
+	return t
+}
+
+// decodeJSON decodes the request body into v, rejecting bodies that are
+// not declared as JSON, and unknown fields when StrictJSON is set.
+func (app *App) decodeJSON(r *http.Request, v any) error {
+	if err := checkJSONContentType(r.Header.Get("Content-Type")); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(skipBOM(r.Body))
+	if app.StrictJSON {
+		dec.DisallowUnknownFields()
+	}
+	return dec.Decode(v)
+}
+
+// jsonContentType is the Content-Type of every JSON response. Setting the
+// header to it rather than with Header().Set saves an allocation a
+// response; it must never be modified.
+var jsonContentType = []string{"application/json"}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("X-Cache", "MISS")
-	json.NewEncoder(w).Encode(results)
+func setJSONContentType(w http.ResponseWriter) {
+	w.Header()["Content-Type"] = jsonContentType
+}
+
+// writeJSON encodes v as the response body under the request's JSON
+// policy.
+func (app *App) writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	app.writeJSONStatus(w, r, http.StatusOK, v)
+}
+
+// writeJSONStatus is writeJSON with a status. v is encoded before the
+// status is written, so a value that cannot be encoded is reported as a
+// 500 with a JSON error instead of a truncated body.
+func (app *App) writeJSONStatus(w http.ResponseWriter, r *http.Request, status int, v any) {
+	policy := jsonpolicy.ForRequest(r, app.JSON)
+	b, err := jsonpolicy.Marshal(v, policy)
+	if err != nil {
+		log.Printf("Encoding response to %s %s failed: %v", r.Method, r.URL.Path, err)
+		b, _ = jsonpolicy.Marshal(jsonpolicy.Fields{"error": fmt.Sprintf("Encode error: %v", err)}, policy)
+		w.Header().Del("Location")
+		setJSONContentType(w)
+		status = http.StatusInternalServerError
+	}
+	w.WriteHeader(status)
+	w.Write(append(b, '\n'))
+}
+
+func validStatus(status string) bool {
+	return status == types.StatusActive || status == types.StatusArchived
 }
 
 func (app *App) CacheHandler(w http.ResponseWriter, r *http.Request) {
@@ -126,8 +702,8 @@ func (app *App) CacheHandler(w http.ResponseWriter, r *http.Request) {
 			Value string `json:"value"`
 			TTL   int    `json:"ttl"`
 		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		if err := app.decodeJSON(r, &req); err != nil {
+			writeDecodeError(w, err)
 			return
 		}
 
@@ -142,8 +718,11 @@ func (app *App) CacheHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(map[string]string{"status": "cached"})
+		app.writeJSONStatus(w, r, http.StatusCreated, jsonpolicy.Fields{"status": "cached"})
+		return
+	}
+	if r.Method != "GET" {
+		methodNotAllowed(w, r, "GET", "POST")
 		return
 	}
 
@@ -164,8 +743,8 @@ func (app *App) CacheHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"key": key, "value": value})
+	setJSONContentType(w)
+	app.writeJSON(w, r, jsonpolicy.Fields{"key": key, "value": value})
 }
 
 func (app *App) RootHandler(w http.ResponseWriter, r *http.Request) {
@@ -174,5 +753,9 @@ func (app *App) RootHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "- /health - Health check with DB status\n")
 	fmt.Fprintf(w, "- /api/test - Test data from database\n")
 	fmt.Fprintf(w, "- /api/data - CRUD operations on test data\n")
+	fmt.Fprintf(w, "- /api/data/{id}/comments - Comments on test data\n")
 	fmt.Fprintf(w, "- /api/cache - Redis cache operations\n")
+	fmt.Fprintf(w, "- /api/jobs - Delayed background jobs\n")
+	fmt.Fprintf(w, "- /api/schedules - Recurring background jobs\n")
+	fmt.Fprintf(w, "- /admin/maintenance - Maintenance mode switch (admin only)\n")
 }
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/nesymno/run-tests-example/jsonpolicy"
+	"github.com/nesymno/run-tests-example/types"
+	"github.com/nesymno/run-tests-example/worker"
+)
+
+// WriteBehindKey is the Redis list buffering rows in write-behind mode.
+const WriteBehindKey = "write_behind:test_data"
+
+// batchRow is a buffered row as passed to the bulk insert, with its secret
+// already sealed and rendered as a bytea literal.
+type batchRow struct {
+	types.TestData
+	Secret string `json:"secret,omitempty"`
+}
+
+// InsertDataBatch bulk-inserts rows buffered by the write-behind batcher
+// and invalidates the list cache once for the whole batch.
+func (app *App) InsertDataBatch(ctx context.Context, items []string) error {
+	rows := make([]batchRow, 0, len(items))
+	for _, item := range items {
+		data, err := app.decodeSealedData([]byte(item))
+		if err != nil {
+			return fmt.Errorf("invalid buffered item: %v", err)
+		}
+		if err := normalizeData(&data.TestData); err != nil {
+			return fmt.Errorf("invalid buffered item: %v", err)
+		}
+		row := batchRow{TestData: data.TestData}
+		if data.SealedSecret != nil {
+			row.Secret = `\x` + hex.EncodeToString(data.SealedSecret)
+		}
+		rows = append(rows, row)
+	}
+
+	batch, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+
+	// Rows with a duplicate name are dropped; retrying the batch could never
+	// make them succeed and would block everything buffered behind them
+	_, err = app.DB.ExecContext(ctx, `
+		INSERT INTO test_data (name, data, tags, status, created_at, updated_at, secret, test_run_id)
+		SELECT name, data, tags, status,
+			COALESCE(created_at, CURRENT_TIMESTAMP), COALESCE(updated_at, created_at, CURRENT_TIMESTAMP), secret, test_run_id
+		FROM jsonb_to_recordset($1::jsonb)
+			AS x(name TEXT, data TEXT, tags TEXT[], status test_data_status, created_at TIMESTAMP, updated_at TIMESTAMP, secret BYTEA, test_run_id TEXT)
+		ON CONFLICT DO NOTHING`, batch)
+	if err != nil {
+		return err
+	}
+
+	app.invalidateList(ctx)
+	app.publishEvent(ctx, eventDataBatch, map[string]int{"rows": len(rows)})
+	return nil
+}
+
+// BatchFlushHandler flushes all buffered write-behind rows immediately.
+func (app *App) BatchFlushHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, r, "POST")
+		return
+	}
+	if app.Batch == nil {
+		http.Error(w, "Write-behind mode is disabled", http.StatusNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	flushed, err := app.Batch.Flush(ctx)
+	if err == worker.ErrFlushInProgress {
+		http.Error(w, "Flush already in progress", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Flush error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	pending, _ := app.Batch.Pending(ctx)
+	setJSONContentType(w)
+	app.writeJSON(w, r, jsonpolicy.Fields{"flushed": int64(flushed), "pending": pending})
+}
@@ -0,0 +1,28 @@
+package app
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nesymno/run-tests-example/cache"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// DebugCacheReportHandler summarizes the hit ratio, fill latency and value
+// sizes of every cache area since start, with the Redis memory usage when
+// it can be read.
+func (app *App) DebugCacheReportHandler(w http.ResponseWriter, r *http.Request) {
+	setJSONContentType(w)
+	app.writeJSON(w, r, app.cacheReport(r.Context()))
+}
+
+func (app *App) cacheReport(ctx context.Context) types.CacheReport {
+	report := types.CacheReport{Areas: cache.Report()}
+	if app.Rds != nil {
+		if memory, err := cache.MemoryStats(ctx, app.Rds); err == nil {
+			memory.Degraded = app.ListCache.Degraded()
+			report.Memory = &memory
+		}
+	}
+	return report
+}
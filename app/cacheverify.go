@@ -0,0 +1,267 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/nesymno/run-tests-example/cache"
+	"github.com/nesymno/run-tests-example/jsonpolicy"
+	"github.com/nesymno/run-tests-example/metrics"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+var (
+	cacheVerifyChecked = metrics.NewCounter("app_cache_verify_checked_total",
+		"test_data rows compared with the cached list by the cache verifier.")
+	cacheVerifyDivergences = metrics.NewCounterVec("app_cache_verify_divergences_total",
+		"test_data rows whose cached copy differed from PostgreSQL, by kind.", "kind")
+	cacheVerifySkipped = metrics.NewCounter("app_cache_verify_skipped_total",
+		"Cache verifier runs discarded because a write invalidated the list meanwhile.")
+	cacheVerifyHeals = metrics.NewCounter("app_cache_verify_heals_total",
+		"List cache refreshes made by the cache verifier after a divergence.")
+)
+
+// Kinds of types.CacheDivergence.
+const (
+	divergenceChanged = "changed"
+	divergenceMissing = "missing"
+	divergenceDeleted = "deleted"
+)
+
+// CacheVerifyPolicy controls the cache verifier, which compares sampled
+// test_data rows with their copies in the cached unfiltered list. Each run
+// samples up to Sample rows from PostgreSQL and as many from the cached
+// list. With Heal set, a divergence refreshes the list cache.
+type CacheVerifyPolicy struct {
+	// Interval between runs of RunCacheVerify, 0 to only verify on request
+	Interval time.Duration
+	Sample   int
+	Heal     bool
+}
+
+type cacheVerifyState struct {
+	mu   sync.Mutex
+	last *types.CacheVerifyReport
+}
+
+// sampleDataQuery selects the shared rows with the given IDs and up to $2
+// more at random, in the columns of listDataQuery.
+const sampleDataQuery = `
+	SELECT id, name, data, tags, status, created_at, updated_at, secret, COALESCE(test_run_id, '') FROM test_data
+	WHERE tenant_id IS NULL AND (id = ANY($1) OR id IN (
+		SELECT id FROM test_data WHERE tenant_id IS NULL ORDER BY random() LIMIT $2
+	))
+	ORDER BY id`
+
+// RunCacheVerify verifies the list cache every Interval until ctx is done.
+// It does nothing when Interval is not positive.
+func (app *App) RunCacheVerify(ctx context.Context) {
+	if app.CacheVerify.Interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(app.CacheVerify.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := app.VerifyCache(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("cache verify: %v", err)
+				}
+				continue
+			}
+			if len(report.Divergences) > 0 {
+				log.Printf("cache verify: %d of %d rows diverge from the cached list at epoch %d",
+					len(report.Divergences), report.Checked, report.Epoch)
+			}
+		}
+	}
+}
+
+// VerifyCache compares sampled rows with the cached unfiltered list of the
+// shared rows, the one write-through keeps warm. Tenant lists and filtered
+// lists are not checked.
+func (app *App) VerifyCache(ctx context.Context) (*types.CacheVerifyReport, error) {
+	report := &types.CacheVerifyReport{StartedAt: time.Now(), Divergences: []types.CacheDivergence{}}
+	all := dataFilter{}
+
+	epoch, err := app.ListCache.Epoch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cache error: %v", err)
+	}
+	report.Epoch = epoch
+
+	body, ok, err := app.ListCache.GetAt(ctx, epoch, all.normalized())
+	if err != nil {
+		return nil, fmt.Errorf("cache error: %v", err)
+	}
+	if ok {
+		report.Cached = true
+		cached, err := cachedRecords(body)
+		if err != nil {
+			return nil, err
+		}
+		sampled := sampleIDs(cached, app.CacheVerify.Sample)
+		fresh, err := app.sampleRows(ctx, sampled, app.CacheVerify.Sample)
+		if err != nil {
+			return nil, err
+		}
+
+		// A write meanwhile has made the list unreachable, and the rows
+		// read since may well differ from it
+		if current, err := app.ListCache.Epoch(ctx); err != nil || current != epoch {
+			report.Skipped = true
+			cacheVerifySkipped.Inc()
+		} else {
+			report.Checked, report.Divergences = compareRecords(cached, fresh, sampled)
+		}
+	}
+
+	cacheVerifyChecked.Add(uint64(report.Checked))
+	for _, d := range report.Divergences {
+		cacheVerifyDivergences.With(d.Kind).Inc()
+	}
+
+	if len(report.Divergences) > 0 && app.CacheVerify.Heal {
+		_, err := app.ListCache.Refresh(ctx, all.normalized(), func(ctx context.Context) ([]byte, error) {
+			return app.queryData(ctx, all)
+		})
+		switch {
+		case errors.Is(err, cache.ErrInvalidationDeferred):
+			// The trailing invalidation of the debounce window heals it
+		case err != nil:
+			log.Printf("cache verify: refresh failed: %v", err)
+		default:
+			report.Healed = true
+			cacheVerifyHeals.Inc()
+		}
+	}
+
+	report.FinishedAt = time.Now()
+	app.cacheVerify.mu.Lock()
+	app.cacheVerify.last = report
+	app.cacheVerify.mu.Unlock()
+
+	return report, nil
+}
+
+// cachedRecords splits a cached list into its rows, keyed by ID and
+// encoded as they were cached.
+func cachedRecords(body []byte) (map[int]json.RawMessage, error) {
+	var rows []json.RawMessage
+	if err := json.Unmarshal(body, &rows); err != nil {
+		return nil, fmt.Errorf("cached list is not a JSON array: %v", err)
+	}
+
+	records := make(map[int]json.RawMessage, len(rows))
+	for _, row := range rows {
+		var key struct {
+			ID int `json:"id"`
+		}
+		if err := json.Unmarshal(row, &key); err != nil {
+			return nil, fmt.Errorf("cached row is not a JSON object: %v", err)
+		}
+		records[key.ID] = row
+	}
+	return records, nil
+}
+
+// sampleIDs picks up to n IDs of records at random.
+func sampleIDs(records map[int]json.RawMessage, n int) []int {
+	ids := make([]int, 0, len(records))
+	for id := range records {
+		ids = append(ids, id)
+	}
+	rand.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+	return ids[:min(n, len(ids))]
+}
+
+// sampleRows reads the rows with the given IDs and up to n more at random,
+// each encoded as the list encodes it.
+func (app *App) sampleRows(ctx context.Context, ids []int, n int) (map[int]json.RawMessage, error) {
+	rows, err := app.DB.QueryContext(ctx, sampleDataQuery, pq.Array(ids), n)
+	if err != nil {
+		return nil, fmt.Errorf("Database error: %v", err)
+	}
+
+	fresh := map[int]json.RawMessage{}
+	err = app.scanData(rows, dataFilter{}, func(data types.TestData) error {
+		row, err := json.Marshal(data)
+		fresh[data.ID] = row
+		return err
+	})
+	return fresh, err
+}
+
+// compareRecords compares the rows read from PostgreSQL, fresh, with the
+// cached ones, for every fresh row and the sampled cached IDs. It returns
+// how many rows it compared and those that differ, by ID.
+func compareRecords(cached, fresh map[int]json.RawMessage, sampled []int) (int, []types.CacheDivergence) {
+	ids := slices.Clone(sampled)
+	for id := range fresh {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+
+	divergences := []types.CacheDivergence{}
+	for _, id := range ids {
+		cachedRow, inCache := cached[id]
+		freshRow, inDB := fresh[id]
+		switch {
+		case !inDB:
+			divergences = append(divergences, types.CacheDivergence{ID: id, Kind: divergenceDeleted})
+		case !inCache:
+			divergences = append(divergences, types.CacheDivergence{ID: id, Kind: divergenceMissing})
+		case string(cachedRow) != string(freshRow):
+			divergences = append(divergences, types.CacheDivergence{ID: id, Kind: divergenceChanged})
+		}
+	}
+	return len(ids), divergences
+}
+
+// CacheVerifyHandler reports the last cache verification (GET) or runs one
+// now (POST).
+func (app *App) CacheVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	var report *types.CacheVerifyReport
+
+	switch r.Method {
+	case "GET":
+		app.cacheVerify.mu.Lock()
+		report = app.cacheVerify.last
+		app.cacheVerify.mu.Unlock()
+	case "POST":
+		var err error
+		report, err = app.VerifyCache(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		methodNotAllowed(w, r, "GET", "POST")
+		return
+	}
+
+	setJSONContentType(w)
+	app.writeJSON(w, r, jsonpolicy.Fields{
+		"interval_seconds": int(app.CacheVerify.Interval / time.Second),
+		"heal":             app.CacheVerify.Heal,
+		"total_checked":    cacheVerifyChecked.Value(),
+		"last_run":         report,
+	})
+}
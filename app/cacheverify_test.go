@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/cache"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+func encodeRows(t *testing.T, rows ...types.TestData) map[int]json.RawMessage {
+	encoded := map[int]json.RawMessage{}
+	for _, row := range rows {
+		b, err := json.Marshal(row)
+		require.NoError(t, err)
+		encoded[row.ID] = b
+	}
+	return encoded
+}
+
+func TestCachedRecordsMatchRowEncoding(t *testing.T) {
+	rows := []types.TestData{
+		{ID: 1, Name: "a", Tags: []string{"x"}, Status: types.StatusActive, CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{ID: 2, Name: "b", Tags: []string{}, Status: types.StatusArchived, Secret: "s"},
+	}
+	body, err := json.Marshal(rows)
+	require.NoError(t, err)
+
+	cached, err := cachedRecords(body)
+	require.NoError(t, err)
+	assert.Equal(t, encodeRows(t, rows...), cached, "a cached row must compare equal to the row encoded alone")
+
+	cached, err = cachedRecords([]byte("null"))
+	require.NoError(t, err)
+	assert.Empty(t, cached)
+
+	_, err = cachedRecords([]byte(`{"id":1}`))
+	assert.Error(t, err)
+}
+
+func TestCompareRecords(t *testing.T) {
+	same := types.TestData{ID: 1, Name: "same", Tags: []string{}, Status: types.StatusActive}
+	changed := types.TestData{ID: 2, Name: "old", Tags: []string{}, Status: types.StatusActive}
+	deleted := types.TestData{ID: 3, Name: "deleted", Tags: []string{}, Status: types.StatusActive}
+	missing := types.TestData{ID: 4, Name: "missing", Tags: []string{}, Status: types.StatusActive}
+	unsampled := types.TestData{ID: 5, Name: "unsampled", Tags: []string{}, Status: types.StatusActive}
+
+	cached := encodeRows(t, same, changed, deleted, unsampled)
+	changed.Name = "new"
+	fresh := encodeRows(t, same, changed, missing)
+
+	checked, divergences := compareRecords(cached, fresh, []int{1, 2, 3})
+	assert.Equal(t, 4, checked)
+	assert.Equal(t, []types.CacheDivergence{
+		{ID: 2, Kind: divergenceChanged},
+		{ID: 3, Kind: divergenceDeleted},
+		{ID: 4, Kind: divergenceMissing},
+	}, divergences)
+
+	checked, divergences = compareRecords(cached, encodeRows(t, same), []int{1})
+	assert.Equal(t, 1, checked)
+	assert.Empty(t, divergences)
+}
+
+func TestSampleIDs(t *testing.T) {
+	records := encodeRows(t, types.TestData{ID: 1}, types.TestData{ID: 2}, types.TestData{ID: 3})
+
+	ids := sampleIDs(records, 2)
+	assert.Len(t, ids, 2)
+	for _, id := range ids {
+		assert.Contains(t, records, id)
+	}
+	assert.ElementsMatch(t, []int{1, 2, 3}, sampleIDs(records, 10))
+}
+
+func TestVerifyCacheWithoutCachedList(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rds.Close() })
+	app := &App{
+		ListCache:   cache.NewQueryCache(rds, "list", time.Minute),
+		CacheVerify: CacheVerifyPolicy{Sample: 10, Heal: true},
+	}
+
+	// Nothing is cached, so PostgreSQL is never queried
+	report, err := app.VerifyCache(context.Background())
+	require.NoError(t, err)
+	assert.False(t, report.Cached)
+	assert.Zero(t, report.Checked)
+	assert.Empty(t, report.Divergences)
+	assert.False(t, report.Healed)
+
+	app.cacheVerify.mu.Lock()
+	defer app.cacheVerify.mu.Unlock()
+	assert.Same(t, report, app.cacheVerify.last)
+}
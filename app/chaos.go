@@ -0,0 +1,187 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/nesymno/run-tests-example/metrics"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// chaosPrefix keys the fault injection settings of each test run; the
+// request counters deciding which requests fail live next to them under
+// chaosPrefix + run + ":count".
+const chaosPrefix = "chaos:"
+
+const (
+	defaultChaosTTL = time.Hour
+	maxChaosTTL     = 24 * time.Hour
+)
+
+var chaosInjected = metrics.NewCounterVec("app_chaos_injected_total",
+	"Faults injected into test run requests, by kind (read, write, latency).", "kind")
+
+// ChaosHandler reports (GET), sets (PUT) or clears (DELETE) the faults
+// injected into the requests of one test run. Settings expire after
+// ttl_seconds, so a crashed suite cannot leave its faults behind.
+func (app *App) ChaosHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	run := r.PathValue("run")
+	if !validTestRunID(run) {
+		http.Error(w, "Invalid test run ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+	case "PUT":
+		var cfg types.ChaosConfig
+		if err := app.decodeJSON(r, &cfg); err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+		if err := validateChaos(&cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ttl := time.Duration(cfg.TTLSeconds) * time.Second
+		cfg.ExpiresAt = time.Now().Add(ttl).UTC().Truncate(time.Second)
+
+		b, err := json.Marshal(cfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Counting starts over, so the same settings fail the same requests
+		pipe := app.Rds.TxPipeline()
+		pipe.Set(ctx, chaosPrefix+run, b, ttl)
+		pipe.Del(ctx, chaosPrefix+run+":count")
+		if _, err := pipe.Exec(ctx); err != nil {
+			http.Error(w, fmt.Sprintf("Redis error: %v", err), http.StatusInternalServerError)
+			return
+		}
+	case "DELETE":
+		if err := app.Rds.Del(ctx, chaosPrefix+run, chaosPrefix+run+":count").Err(); err != nil {
+			http.Error(w, fmt.Sprintf("Redis error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		methodNotAllowed(w, r, "GET", "PUT", "DELETE")
+		return
+	}
+
+	cfg, err := app.chaosConfig(ctx, run)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Redis error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if cfg == nil {
+		http.Error(w, "No faults set for this run", http.StatusNotFound)
+		return
+	}
+	setJSONContentType(w)
+	app.writeJSON(w, r, cfg)
+}
+
+func validateChaos(cfg *types.ChaosConfig) error {
+	if cfg.ReadFailPercent < 0 || cfg.ReadFailPercent > 100 || cfg.WriteFailPercent < 0 || cfg.WriteFailPercent > 100 {
+		return fmt.Errorf("Fail percentages must be between 0 and 100")
+	}
+	if cfg.LatencyMS < 0 || time.Duration(cfg.LatencyMS)*time.Millisecond > time.Minute {
+		return fmt.Errorf("latency_ms must be between 0 and 60000")
+	}
+	if cfg.Status == 0 {
+		cfg.Status = http.StatusServiceUnavailable
+	}
+	if cfg.Status < 400 || cfg.Status > 599 {
+		return fmt.Errorf("status must be a 4xx or 5xx code")
+	}
+	if cfg.TTLSeconds == 0 {
+		cfg.TTLSeconds = int(defaultChaosTTL.Seconds())
+	}
+	if cfg.TTLSeconds < 0 || time.Duration(cfg.TTLSeconds)*time.Second > maxChaosTTL {
+		return fmt.Errorf("ttl_seconds must be between 1 and %d", int(maxChaosTTL.Seconds()))
+	}
+	return nil
+}
+
+// chaosConfig returns the faults set for run, nil if none.
+func (app *App) chaosConfig(ctx context.Context, run string) (*types.ChaosConfig, error) {
+	b, err := app.Rds.Get(ctx, chaosPrefix+run).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cfg types.ChaosConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Chaos injects the faults set for the request's test run. Which requests
+// fail is decided by counting the run's reads and writes, not at random, so
+// a run that sends the same requests in the same order sees the same
+// failures. Requests of other runs, or without a run, are untouched, and
+// faults are skipped when Redis cannot be read.
+func (app *App) Chaos(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		run := testRunFrom(r.Context())
+		if run == "" {
+			next(w, r)
+			return
+		}
+		ctx := r.Context()
+		cfg, err := app.chaosConfig(ctx, run)
+		if err != nil || cfg == nil {
+			next(w, r)
+			return
+		}
+
+		if cfg.LatencyMS > 0 {
+			chaosInjected.With("latency").Inc()
+			select {
+			case <-time.After(time.Duration(cfg.LatencyMS) * time.Millisecond):
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		kind, percent := "write", cfg.WriteFailPercent
+		if isReadMethod(r.Method) {
+			kind, percent = "read", cfg.ReadFailPercent
+		}
+		if percent > 0 {
+			pipe := app.Rds.TxPipeline()
+			n := pipe.HIncrBy(ctx, chaosPrefix+run+":count", kind, 1)
+			// Relative to Redis's clock, as ExpiresAt is on the clock of the
+			// replica that set it. The count may outlive the faults by up to
+			// the TTL, and is reset by the next PUT anyway.
+			pipe.ExpireNX(ctx, chaosPrefix+run+":count", time.Duration(cfg.TTLSeconds)*time.Second)
+			if _, err := pipe.Exec(ctx); err == nil && chaosHit(n.Val(), percent) {
+				chaosInjected.With(kind).Inc()
+				w.Header().Set("X-Chaos", "injected")
+				http.Error(w, fmt.Sprintf("Injected failure for test run %s", run), cfg.Status)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// chaosHit reports whether the nth request fails when percent of them
+// should: exactly percent of every 100 consecutive requests fail, spread
+// evenly.
+func chaosHit(n int64, percent int) bool {
+	p := int64(percent)
+	return n*p/100 > (n-1)*p/100
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChaosHitFailsExactShare(t *testing.T) {
+	for _, percent := range []int{0, 1, 10, 33, 100} {
+		hits := 0
+		for n := int64(1); n <= 100; n++ {
+			if chaosHit(n, percent) {
+				hits++
+			}
+		}
+		assert.Equal(t, percent, hits, "percent %d", percent)
+	}
+	assert.False(t, chaosHit(1, 10))
+	assert.True(t, chaosHit(10, 10))
+}
+
+func TestChaosIsScopedToTestRun(t *testing.T) {
+	mr := miniredis.RunT(t)
+	app := &App{Rds: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+
+	put := httptest.NewRequest("PUT", "/admin/chaos/abc", strings.NewReader(`{"write_fail_percent": 50}`))
+	put.SetPathValue("run", "abc")
+	rec := httptest.NewRecorder()
+	app.ChaosHandler(rec, put)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `"status":503`)
+	assert.Equal(t, defaultChaosTTL, mr.TTL(chaosPrefix+"abc"))
+
+	handler := app.TestRunMiddleware(app.Chaos(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(method, run string) int {
+		r := httptest.NewRequest(method, "/api/data", nil)
+		if run != "" {
+			r.Header.Set(TestRunHeader, run)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	var codes []int
+	for range 4 {
+		codes = append(codes, serve("POST", "abc"))
+	}
+	assert.Equal(t, []int{200, 503, 200, 503}, codes, "every second write fails")
+	assert.Equal(t, defaultChaosTTL, mr.TTL(chaosPrefix+"abc:count"), "the count expires on Redis's clock")
+	assert.Equal(t, http.StatusOK, serve("GET", "abc"), "reads are not failed")
+	assert.Equal(t, http.StatusOK, serve("POST", "other"))
+	assert.Equal(t, http.StatusOK, serve("POST", ""))
+
+	del := httptest.NewRequest("DELETE", "/admin/chaos/abc", nil)
+	del.SetPathValue("run", "abc")
+	app.ChaosHandler(httptest.NewRecorder(), del)
+	cfg, err := app.chaosConfig(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.Nil(t, cfg)
+	assert.Equal(t, http.StatusOK, serve("POST", "abc"))
+}
+
+func TestValidateChaos(t *testing.T) {
+	app := &App{}
+	for _, body := range []string{`{"read_fail_percent": 101}`, `{"status": 200}`, `{"ttl_seconds": 100000}`, `{"latency_ms": -1}`} {
+		r := httptest.NewRequest("PUT", "/admin/chaos/abc", strings.NewReader(body))
+		r.SetPathValue("run", "abc")
+		rec := httptest.NewRecorder()
+		app.ChaosHandler(rec, r)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+}
@@ -0,0 +1,70 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/lib/pq"
+
+	"github.com/nesymno/run-tests-example/generator"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// checksumQuery selects the rows of listDataQuery in an order every
+// database agrees on: names are unique, and the C collation compares them
+// bytewise whatever the database's locale.
+const checksumQuery = `
+	SELECT name, data, tags, status FROM test_data
+	WHERE ($1 = '' OR $1 = ANY(tags)) AND ($2 = '' OR status::text = $2)
+	AND tenant_id IS NOT DISTINCT FROM $3 AND ($4 = '' OR test_run_id = $4)
+	ORDER BY name COLLATE "C"`
+
+// ChecksumHandler returns the SHA-256 of the rows GET /api/data would list
+// for the same tag, status and test_run_id, so two databases can be
+// compared without transferring them. It covers what generator.WriteRow
+// writes, so right after a seed it equals the seed's checksum.
+func (app *App) ChecksumHandler(w http.ResponseWriter, r *http.Request) {
+	filter := dataFilter{
+		Tag:     r.URL.Query().Get("tag"),
+		Status:  r.URL.Query().Get("status"),
+		TestRun: r.URL.Query().Get("test_run_id"),
+		Tenant:  tenantFrom(r.Context()),
+	}
+	if filter.Status != "" && !validStatus(filter.Status) {
+		http.Error(w, fmt.Sprintf("Invalid status %q", filter.Status), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	rows, err := app.db(ctx).QueryContext(ctx, checksumQuery, filter.args()...)
+	if err != nil {
+		writeDBError(w, "Database error", err)
+		return
+	}
+	defer rows.Close()
+
+	sum := sha256.New()
+	var count int64
+	for rows.Next() {
+		var row types.TestData
+		if err := rows.Scan(&row.Name, &row.Data, pq.Array(&row.Tags), &row.Status); err != nil {
+			http.Error(w, fmt.Sprintf("Scan error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		generator.WriteRow(sum, row)
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		writeDBError(w, "Database error", err)
+		return
+	}
+
+	setJSONContentType(w)
+	app.writeJSON(w, r, types.DataChecksum{
+		Algorithm: "sha256",
+		Checksum:  hex.EncodeToString(sum.Sum(nil)),
+		Rows:      count,
+	})
+}
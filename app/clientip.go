@@ -0,0 +1,146 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ParseTrustedProxies parses a comma-separated list of CIDRs or single
+// addresses, the proxies allowed to report the client address.
+func ParseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %v", entry, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", entry, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// ClientIP returns the client address resolved by ClientIPMiddleware.
+func ClientIP(ctx context.Context) netip.Addr {
+	if v := requestValuesFrom(ctx); v != nil {
+		return v.clientIP
+	}
+	return netip.Addr{}
+}
+
+// ClientIPMiddleware resolves the real client address and stores it in the
+// request context for ClientIP.
+func (app *App) ClientIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, v := withRequestValues(r)
+		v.clientIP = app.clientIP(r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *App) trusted(addr netip.Addr) bool {
+	for _, prefix := range app.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP resolves the client address of r. Forwarding headers are only
+// believed when the connection comes from a trusted proxy, and are read
+// right to left: the first address not belonging to a trusted proxy is the
+// client, as everything to its left could have been sent by the client
+// itself. Forwarded takes precedence over X-Forwarded-For, which takes
+// precedence over X-Real-IP.
+func (app *App) clientIP(r *http.Request) netip.Addr {
+	remote := parseHostAddr(r.RemoteAddr)
+	if !remote.IsValid() || !app.trusted(remote) {
+		return remote
+	}
+
+	hops := forwardedFor(r.Header.Values("Forwarded"))
+	if len(hops) == 0 {
+		hops = xForwardedFor(r.Header.Values("X-Forwarded-For"))
+	}
+	if len(hops) == 0 {
+		if real := parseHostAddr(r.Header.Get("X-Real-IP")); real.IsValid() {
+			return real
+		}
+		return remote
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		if !hops[i].IsValid() {
+			// Garbage the proxy passed along; nothing left of it can be
+			// trusted either
+			return remote
+		}
+		if !app.trusted(hops[i]) || i == 0 {
+			return hops[i]
+		}
+	}
+	return remote
+}
+
+// xForwardedFor collects the addresses of every X-Forwarded-For header in
+// order. Unparseable entries become invalid addresses.
+func xForwardedFor(headers []string) []netip.Addr {
+	var hops []netip.Addr
+	for _, header := range headers {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, parseHostAddr(strings.TrimSpace(hop)))
+		}
+	}
+	return hops
+}
+
+// forwardedFor collects the for= addresses of every RFC 7239 Forwarded
+// header in order. Obfuscated and unknown identifiers become invalid
+// addresses.
+func forwardedFor(headers []string) []netip.Addr {
+	var hops []netip.Addr
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(name, "for") {
+					continue
+				}
+				value = strings.Trim(value, `"`)
+				hops = append(hops, parseHostAddr(value))
+			}
+		}
+	}
+	return hops
+}
+
+// parseHostAddr parses an address with or without a port, IPv6 addresses
+// optionally in brackets. It returns the zero Addr if s is not one.
+func parseHostAddr(s string) netip.Addr {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	prefixes, err := ParseTrustedProxies(" 10.0.0.0/8, 192.168.1.7 ,::1,")
+	require.NoError(t, err)
+	require.Len(t, prefixes, 3)
+	assert.Equal(t, "10.0.0.0/8", prefixes[0].String())
+	assert.Equal(t, "192.168.1.7/32", prefixes[1].String())
+	assert.Equal(t, "::1/128", prefixes[2].String())
+
+	prefixes, err = ParseTrustedProxies("")
+	require.NoError(t, err)
+	assert.Empty(t, prefixes)
+
+	_, err = ParseTrustedProxies("10.0.0.0/33")
+	assert.Error(t, err)
+	_, err = ParseTrustedProxies("proxy.local")
+	assert.Error(t, err)
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8")
+	require.NoError(t, err)
+	app := &App{TrustedProxies: trusted}
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"direct", "203.0.113.9:5000", nil, "203.0.113.9"},
+		{"untrusted remote ignores headers", "203.0.113.9:5000",
+			map[string]string{"X-Forwarded-For": "1.2.3.4"}, "203.0.113.9"},
+		{"trusted proxy", "10.0.0.2:5000",
+			map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"spoofed entries left of the client are skipped", "10.0.0.2:5000",
+			map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1, 10.0.0.3"}, "198.51.100.1"},
+		{"all hops trusted", "10.0.0.2:5000",
+			map[string]string{"X-Forwarded-For": "10.1.1.1, 10.0.0.3"}, "10.1.1.1"},
+		{"garbage hop stops the walk", "10.0.0.2:5000",
+			map[string]string{"X-Forwarded-For": "198.51.100.1, nonsense"}, "10.0.0.2"},
+		{"forwarded wins over x-forwarded-for", "10.0.0.2:5000",
+			map[string]string{
+				"Forwarded":       `for=198.51.100.2;proto=https, for="[2001:db8::1]:4711"`,
+				"X-Forwarded-For": "198.51.100.1",
+			}, "2001:db8::1"},
+		{"x-real-ip", "10.0.0.2:5000",
+			map[string]string{"X-Real-IP": "198.51.100.3"}, "198.51.100.3"},
+		{"trusted proxy without headers", "10.0.0.2:5000", nil, "10.0.0.2"},
+		{"ipv4-mapped remote", "[::ffff:10.0.0.2]:5000",
+			map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			assert.Equal(t, tt.want, app.clientIP(r).String())
+		})
+	}
+}
+
+func TestClientIPMiddleware(t *testing.T) {
+	app := &App{}
+	var got string
+	handler := app.ClientIPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = ClientIP(r.Context()).String()
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "203.0.113.9:5000"
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, "203.0.113.9", got)
+}
@@ -0,0 +1,30 @@
+package app
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// skewedNow is the earliest the time may be on a client's clock. Expiry
+// times set by clients are checked against it, so a client whose clock is
+// behind within ClockSkew does not see its key expire early.
+func (app *App) skewedNow() time.Time {
+	return time.Now().Add(-app.ClockSkew)
+}
+
+// TimeHandler reports the replica's wall clock, for clients to measure
+// their offset from it, and its monotonic clock, for measuring durations
+// that a stepped wall clock would distort.
+func (app *App) TimeHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	setJSONContentType(w)
+	app.writeJSON(w, r, types.ServerTime{
+		Time:            now.UTC(),
+		UnixNanos:       now.UnixNano(),
+		MonotonicNanos:  now.Sub(processStart).Nanoseconds(),
+		StartedAt:       processStart.UTC(),
+		ClockSkewMillis: app.ClockSkew.Milliseconds(),
+	})
+}
@@ -0,0 +1,41 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+func TestTimeHandler(t *testing.T) {
+	app := &App{ClockSkew: 1500 * time.Millisecond}
+	read := func() types.ServerTime {
+		rec := httptest.NewRecorder()
+		app.TimeHandler(rec, httptest.NewRequest("GET", "/api/time", nil))
+		var st types.ServerTime
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &st))
+		return st
+	}
+
+	before := time.Now()
+	first := read()
+	assert.WithinRange(t, first.Time, before.Add(-time.Second), time.Now().Add(time.Second))
+	assert.Equal(t, first.Time.UnixNano(), first.UnixNanos)
+	assert.Equal(t, int64(1500), first.ClockSkewMillis)
+	assert.Positive(t, first.MonotonicNanos)
+
+	time.Sleep(time.Millisecond)
+	second := read()
+	assert.Greater(t, second.MonotonicNanos, first.MonotonicNanos)
+	assert.Equal(t, first.StartedAt, second.StartedAt)
+}
+
+func TestSkewedNowIsBehind(t *testing.T) {
+	app := &App{ClockSkew: time.Minute}
+	assert.WithinDuration(t, time.Now().Add(-time.Minute), app.skewedNow(), time.Second)
+}
@@ -0,0 +1,76 @@
+package app
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nesymno/run-tests-example/metrics"
+)
+
+var (
+	coalescedRequests = metrics.NewCounter("app_coalesced_requests_total",
+		"Requests answered from another in-flight request's result.")
+	coalescedExecutions = metrics.NewCounter("app_coalesced_executions_total",
+		"Upstream executions performed on behalf of coalesced requests.")
+)
+
+// flightCall is an in-flight or completed execution shared by every caller
+// asking for the same key.
+type flightCall[T any] struct {
+	done chan struct{}
+	val  T
+	err  error
+	// followers counts the callers waiting on another's execution
+	followers int
+}
+
+// flightGroup coalesces concurrent calls with the same key into a single
+// execution. The zero value is ready to use.
+type flightGroup[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[T]
+}
+
+// Do runs fn once for all concurrent callers with the same key. shared
+// reports whether the result came from another caller's execution.
+func (g *flightGroup[T]) Do(key string, fn func() (T, error)) (val T, err error, shared bool) {
+	return g.DoContext(context.Background(), key, fn)
+}
+
+// DoContext is Do for callers that stop waiting for another caller's
+// execution once ctx is done, returning ctx's error. The execution itself
+// goes on for the others.
+func (g *flightGroup[T]) DoContext(ctx context.Context, key string, fn func() (T, error)) (val T, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall[T])
+	}
+	if c, ok := g.calls[key]; ok {
+		c.followers++
+		g.mu.Unlock()
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return val, ctx.Err(), true
+		}
+		coalescedRequests.Inc()
+		return c.val, c.err, true
+	}
+	c := &flightCall[T]{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		shared := c.followers > 0
+		g.mu.Unlock()
+		close(c.done)
+		if shared {
+			coalescedExecutions.Inc()
+		}
+	}()
+
+	c.val, c.err = fn()
+	return c.val, c.err, false
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlightGroupCoalescesConcurrentCalls(t *testing.T) {
+	var g flightGroup[int]
+	var executions, sharedCount atomic.Int32
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	fn := func() (int, error) {
+		executions.Add(1)
+		close(entered)
+		<-release
+		return 42, nil
+	}
+
+	const callers = 10
+	before := coalescedExecutions.Value()
+	var wg sync.WaitGroup
+	call := func() {
+		defer wg.Done()
+		val, err, shared := g.Do("key", fn)
+		assert.NoError(t, err)
+		assert.Equal(t, 42, val)
+		if shared {
+			sharedCount.Add(1)
+		}
+	}
+
+	wg.Add(callers)
+	go call()
+	<-entered
+	for i := 1; i < callers; i++ {
+		go call()
+	}
+
+	// Give the followers time to join the in-flight call
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), executions.Load())
+	assert.Equal(t, int32(callers-1), sharedCount.Load())
+	assert.Equal(t, before+1, coalescedExecutions.Value())
+}
+
+func TestFlightGroupRunsAgainAfterCompletion(t *testing.T) {
+	var g flightGroup[int]
+	calls := 0
+	before := coalescedExecutions.Value()
+
+	for i := 0; i < 3; i++ {
+		_, _, shared := g.Do("key", func() (int, error) {
+			calls++
+			return calls, nil
+		})
+		assert.False(t, shared)
+	}
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, before, coalescedExecutions.Value(), "executions no one else waited on are not counted")
+}
@@ -0,0 +1,153 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/lib/pq"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// CommentsHandler lists (GET) or adds (POST) comments on a test_data row.
+func (app *App) CommentsHandler(w http.ResponseWriter, r *http.Request) {
+	dataID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid data ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	switch r.Method {
+	case "GET":
+		var exists bool
+		err := app.db(ctx).QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM test_data WHERE id = $1 AND tenant_id IS NOT DISTINCT FROM $2)",
+			dataID, tenantColumn(tenantFrom(ctx))).Scan(&exists)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "Data not found", http.StatusNotFound)
+			return
+		}
+
+		comments, err := app.commentsFor(ctx, []int{dataID})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		list := comments[dataID]
+		if list == nil {
+			list = []types.Comment{}
+		}
+		setJSONContentType(w)
+		app.writeJSON(w, r, list)
+	case "POST":
+		var req struct {
+			Body string `json:"body"`
+		}
+		if err := app.decodeJSON(r, &req); err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+		if req.Body == "" {
+			http.Error(w, "Comment body is required", http.StatusBadRequest)
+			return
+		}
+
+		// Insert only if the parent exists, so a missing parent is a 404
+		// rather than a foreign key violation
+		comment := types.Comment{DataID: dataID, Body: req.Body}
+		err := app.db(ctx).QueryRowContext(ctx, `
+			INSERT INTO test_data_comments (data_id, body)
+			SELECT $1, $2 WHERE EXISTS (
+				SELECT 1 FROM test_data WHERE id = $1 AND tenant_id IS NOT DISTINCT FROM $3)
+			RETURNING id, created_at`, dataID, req.Body, tenantColumn(tenantFrom(ctx)),
+		).Scan(&comment.ID, &comment.CreatedAt)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Data not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeDBError(w, "Insert error", err)
+			return
+		}
+		comment.CreatedAt = comment.CreatedAt.UTC()
+		app.publishEvent(ctx, eventCommentCreated, comment)
+		// Cached listings may include the row's comments
+		setConsistencyToken(w, app.writeVersion(ctx, app.invalidateList(ctx)))
+
+		setJSONContentType(w)
+		app.writeJSONStatus(w, r, http.StatusCreated, comment)
+	default:
+		methodNotAllowed(w, r, "GET", "POST")
+	}
+}
+
+// CommentHandler deletes a single comment.
+func (app *App) CommentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		methodNotAllowed(w, r, "DELETE")
+		return
+	}
+
+	dataID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid data ID", http.StatusBadRequest)
+		return
+	}
+	commentID, err := strconv.Atoi(r.PathValue("comment_id"))
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	res, err := app.db(ctx).ExecContext(ctx, `
+		DELETE FROM test_data_comments WHERE id = $1 AND data_id = $2
+		AND data_id IN (SELECT id FROM test_data WHERE tenant_id IS NOT DISTINCT FROM $3)`,
+		commentID, dataID, tenantColumn(tenantFrom(ctx)))
+	if err != nil {
+		writeDBError(w, "Delete error", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	app.publishEvent(ctx, eventCommentDeleted, map[string]int{"id": commentID, "data_id": dataID})
+	setConsistencyToken(w, app.writeVersion(ctx, app.invalidateList(ctx)))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// commentsFor loads the comments of several rows with a single query,
+// avoiding one query per row when eager-loading a list.
+func (app *App) commentsFor(ctx context.Context, dataIDs []int) (map[int][]types.Comment, error) {
+	rows, err := app.db(ctx).QueryContext(ctx, `
+		SELECT id, data_id, body, created_at FROM test_data_comments
+		WHERE data_id = ANY($1)
+		ORDER BY data_id, id`, pq.Array(dataIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := make(map[int][]types.Comment)
+	for rows.Next() {
+		var c types.Comment
+		if err := rows.Scan(&c.ID, &c.DataID, &c.Body, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		c.CreatedAt = c.CreatedAt.UTC()
+		comments[c.DataID] = append(comments[c.DataID], c)
+	}
+	return comments, rows.Err()
+}
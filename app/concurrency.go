@@ -0,0 +1,161 @@
+package app
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nesymno/run-tests-example/metrics"
+)
+
+var concurrencyRejected = metrics.NewCounter("app_concurrency_rejected_total",
+	"API requests rejected with 503 because the adaptive concurrency limit was reached.")
+
+// concurrencyBackoff is the factor the limit is multiplied by while a
+// dependency is slow.
+const concurrencyBackoff = 0.75
+
+// ConcurrencyPolicy limits the /api requests served at once, adapting the
+// limit to the latency of PostgreSQL and Redis with AIMD: every Interval
+// both are pinged, and the limit drops by a quarter while either is slower
+// than LatencyTarget or fails, and grows by one otherwise. Requests past
+// the limit get a fast 503 instead of queueing for a connection.
+type ConcurrencyPolicy struct {
+	// Max is the limit while dependencies are fast, 0 to disable limiting.
+	Max int
+	// Min is the floor of the limit, so some requests always get through
+	// to notice the dependencies have recovered.
+	Min           int
+	LatencyTarget time.Duration
+	Interval      time.Duration
+}
+
+type concurrencyState struct {
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	// latency is the slowest dependency of the last probe
+	latency time.Duration
+}
+
+// acquire takes a slot if fewer than the limit are in flight.
+func (s *concurrencyState) acquire(p ConcurrencyPolicy) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.limit == 0 {
+		s.limit = float64(p.Max)
+	}
+	if s.inFlight >= int(s.limit) {
+		return false
+	}
+	s.inFlight++
+	return true
+}
+
+func (s *concurrencyState) release() {
+	s.mu.Lock()
+	s.inFlight--
+	s.mu.Unlock()
+}
+
+// adjust applies the result of a probe to the limit.
+func (s *concurrencyState) adjust(p ConcurrencyPolicy, latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.limit == 0 {
+		s.limit = float64(p.Max)
+	}
+	s.latency = latency
+	if err != nil || latency > p.LatencyTarget {
+		s.limit = max(float64(min(p.Min, p.Max)), s.limit*concurrencyBackoff)
+	} else {
+		s.limit = min(float64(p.Max), s.limit+1)
+	}
+}
+
+func (s *concurrencyState) snapshot() (limit, inFlight int, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int(s.limit), s.inFlight, s.latency
+}
+
+// RegisterConcurrencyMetrics exports the adaptive concurrency limit, the
+// requests in flight and the latency it adapts to, read at scrape time.
+func (app *App) RegisterConcurrencyMetrics() {
+	metrics.NewGaugeFunc("app_concurrency_limit", "Current adaptive limit of /api requests in flight.", func() float64 {
+		limit, _, _ := app.concurrency.snapshot()
+		return float64(limit)
+	})
+	metrics.NewGaugeFunc("app_concurrency_in_flight", "/api requests in flight counted against the concurrency limit.", func() float64 {
+		_, inFlight, _ := app.concurrency.snapshot()
+		return float64(inFlight)
+	})
+	metrics.NewGaugeFunc("app_concurrency_dependency_latency_seconds", "Ping latency of the slower of PostgreSQL and Redis at the last probe.", func() float64 {
+		_, _, latency := app.concurrency.snapshot()
+		return latency.Seconds()
+	})
+}
+
+// RunConcurrencyLimit probes the dependencies every Interval and adapts
+// the limit until ctx is done. It does nothing when Max is not positive.
+func (app *App) RunConcurrencyLimit(ctx context.Context) {
+	if app.Concurrency.Max <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(app.Concurrency.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			latency, err := app.probeDependencies(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("concurrency limit: %v", err)
+			}
+			app.concurrency.adjust(app.Concurrency, latency, err)
+		}
+	}
+}
+
+// probeDependencies pings PostgreSQL and Redis and returns the slower
+// latency. A ping taking ten times LatencyTarget is given up on.
+func (app *App) probeDependencies(ctx context.Context) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*app.Concurrency.LatencyTarget)
+	defer cancel()
+
+	start := time.Now()
+	if err := app.DB.PingContext(ctx); err != nil {
+		return time.Since(start), err
+	}
+	dbLatency := time.Since(start)
+
+	start = time.Now()
+	err := app.Rds.Ping(ctx).Err()
+	return max(dbLatency, time.Since(start)), err
+}
+
+// ConcurrencyMiddleware rejects /api requests past the adaptive
+// concurrency limit with 503 and a Retry-After hint. The event feed is
+// not limited, as its subscribers stay connected.
+func (app *App) ConcurrencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.Concurrency.Max <= 0 || !strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/api/events" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !app.concurrency.acquire(app.Concurrency) {
+			concurrencyRejected.Inc()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many requests in flight, retry later", http.StatusServiceUnavailable)
+			return
+		}
+		defer app.concurrency.release()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,87 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrencyLimitAIMD(t *testing.T) {
+	p := ConcurrencyPolicy{Max: 10, Min: 4, LatencyTarget: 50 * time.Millisecond}
+	var s concurrencyState
+	limit := func() int {
+		limit, _, _ := s.snapshot()
+		return limit
+	}
+
+	s.adjust(p, 10*time.Millisecond, nil)
+	assert.Equal(t, 10, limit(), "starts at Max")
+
+	s.adjust(p, 80*time.Millisecond, nil)
+	assert.Equal(t, 7, limit())
+	s.adjust(p, 10*time.Millisecond, errors.New("connection refused"))
+	assert.Equal(t, 5, limit())
+	for range 5 {
+		s.adjust(p, time.Second, nil)
+	}
+	assert.Equal(t, 4, limit(), "never below Min")
+
+	for range 10 {
+		s.adjust(p, time.Millisecond, nil)
+	}
+	assert.Equal(t, 10, limit(), "never above Max")
+	_, _, latency := s.snapshot()
+	assert.Equal(t, time.Millisecond, latency)
+}
+
+func TestConcurrencyMiddlewareRejectsPastLimit(t *testing.T) {
+	app := &App{Concurrency: ConcurrencyPolicy{Max: 2, Min: 1}}
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	handler := app.ConcurrencyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/data" {
+			started.Done()
+			<-release
+		}
+	}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	var done sync.WaitGroup
+	for range 2 {
+		started.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			serve("/api/data")
+		}()
+	}
+	started.Wait()
+
+	w := serve("/api/cache")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, serve("/health").Code, "only /api is limited")
+	assert.Equal(t, http.StatusOK, serve("/api/events").Code, "the event feed is not limited")
+
+	close(release)
+	done.Wait()
+	assert.Equal(t, http.StatusOK, serve("/api/cache").Code)
+	_, inFlight, _ := app.concurrency.snapshot()
+	assert.Zero(t, inFlight)
+}
+
+func TestConcurrencyMiddlewareDisabled(t *testing.T) {
+	app := &App{}
+	w := httptest.NewRecorder()
+	app.ConcurrencyMiddleware(http.HandlerFunc(okHandler)).ServeHTTP(w, httptest.NewRequest("GET", "/api/data", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+const connectivityTimeout = 3 * time.Second
+
+// Dependency is a host:port the app relies on, checked by
+// /debug/connectivity.
+type Dependency struct {
+	Name string
+	Addr string
+}
+
+// ParseDependencies parses a comma-separated list of name=host:port pairs.
+func ParseDependencies(list string) ([]Dependency, error) {
+	var deps []Dependency
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, addr, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid dependency %q, want name=host:port", entry)
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("invalid dependency %q: %v", entry, err)
+		}
+		deps = append(deps, Dependency{Name: name, Addr: addr})
+	}
+	return deps, nil
+}
+
+// DebugConnectivityHandler resolves and dials every dependency in parallel
+// and reports how far each got, with timings.
+func (app *App) DebugConnectivityHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*connectivityTimeout)
+	defer cancel()
+
+	report := types.ConnectivityReport{
+		Targets:   make([]types.ConnectivityResult, len(app.Dependencies)),
+		Timestamp: time.Now(),
+	}
+
+	var wg sync.WaitGroup
+	for i, dep := range app.Dependencies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Targets[i] = checkConnectivity(ctx, dep)
+		}()
+	}
+	wg.Wait()
+
+	setJSONContentType(w)
+	app.writeJSON(w, r, report)
+}
+
+// checkConnectivity resolves dep's host, then opens and closes a TCP
+// connection to it.
+func checkConnectivity(ctx context.Context, dep Dependency) types.ConnectivityResult {
+	result := types.ConnectivityResult{Name: dep.Name, Address: dep.Addr}
+
+	host, port, err := net.SplitHostPort(dep.Addr)
+	if err != nil {
+		result.Stage, result.Error = "parse", err.Error()
+		return result
+	}
+
+	dnsCtx, cancel := context.WithTimeout(ctx, connectivityTimeout)
+	defer cancel()
+	start := time.Now()
+	addrs, err := net.DefaultResolver.LookupHost(dnsCtx, host)
+	result.DNSMillis = millis(time.Since(start))
+	if err != nil {
+		result.Stage, result.Error = "dns", err.Error()
+		return result
+	}
+	result.Resolved = addrs
+
+	dialer := net.Dialer{Timeout: connectivityTimeout}
+	start = time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(addrs[0], port))
+	result.ConnectMillis = millis(time.Since(start))
+	if err != nil {
+		result.Stage, result.Error = "connect", err.Error()
+		return result
+	}
+	conn.Close()
+
+	result.Reachable = true
+	return result
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDependencies(t *testing.T) {
+	deps, err := ParseDependencies("api=api.internal:443, mirror=[::1]:8080")
+	require.NoError(t, err)
+	assert.Equal(t, []Dependency{{Name: "api", Addr: "api.internal:443"}, {Name: "mirror", Addr: "[::1]:8080"}}, deps)
+
+	_, err = ParseDependencies("api.internal:443")
+	assert.Error(t, err)
+	_, err = ParseDependencies("api=api.internal")
+	assert.Error(t, err)
+}
+
+func TestCheckConnectivity(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	result := checkConnectivity(context.Background(), Dependency{Name: "up", Addr: ln.Addr().String()})
+	assert.True(t, result.Reachable, result.Error)
+	assert.Equal(t, []string{"127.0.0.1"}, result.Resolved)
+
+	// Grab a free port and close it again, so nothing listens there
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := closed.Addr().String()
+	closed.Close()
+
+	result = checkConnectivity(context.Background(), Dependency{Name: "down", Addr: addr})
+	assert.False(t, result.Reachable)
+	assert.Equal(t, "connect", result.Stage)
+	assert.NotEmpty(t, result.Error)
+
+	result = checkConnectivity(context.Background(), Dependency{Name: "nx", Addr: "no-such-host.invalid:80"})
+	assert.False(t, result.Reachable)
+	assert.Equal(t, "dns", result.Stage)
+}
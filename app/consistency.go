@@ -0,0 +1,100 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConsistencyHeader carries the read-your-writes token. POST /api/data
+// returns it, along with a cookie of the same value, and GET /api/data
+// accepts it from either.
+const ConsistencyHeader = "X-Consistency-Token"
+
+const consistencyCookie = "consistency_token"
+
+// consistencyTTL bounds how long a token is kept in the cookie. Past the
+// list cache TTL every entry older than the write has expired anyway.
+const consistencyTTL = 5 * time.Minute
+
+// writeVersion returns the token for a write whose cache update produced
+// epoch. If the update failed, epoch is 0 and the token is set one past the
+// current epoch, so reads bypass the cache until some later write does
+// invalidate it. It returns 0 when Redis cannot be read at all.
+func (app *App) writeVersion(ctx context.Context, epoch int64) int64 {
+	if epoch > 0 {
+		return epoch
+	}
+
+	current, err := app.ListCache.Epoch(ctx)
+	if err != nil {
+		log.Printf("List cache epoch read failed: %v", err)
+		return 0
+	}
+	return current + 1
+}
+
+// setConsistencyToken hands the write version to the client.
+func setConsistencyToken(w http.ResponseWriter, version int64) {
+	if version <= 0 {
+		return
+	}
+
+	token := strconv.FormatInt(version, 10)
+	w.Header().Set(ConsistencyHeader, token)
+	http.SetCookie(w, &http.Cookie{
+		Name:     consistencyCookie,
+		Value:    token,
+		Path:     "/api/data",
+		MaxAge:   int(consistencyTTL / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// consistencyToken returns the write version the client has seen, 0 if it
+// sent none or an invalid one.
+func consistencyToken(r *http.Request) int64 {
+	token := r.Header.Get(ConsistencyHeader)
+	if token == "" {
+		if cookie, err := r.Cookie(consistencyCookie); err == nil {
+			token = cookie.Value
+		}
+	}
+
+	version, err := strconv.ParseInt(token, 10, 64)
+	if err != nil || version < 0 {
+		return 0
+	}
+	return version
+}
+
+// wantsRefresh reports whether a read asks to skip the cache and store a
+// fresh result, with ?refresh=true or Cache-Control: no-cache. Both are
+// admin only. Cache-Control from anyone else is ignored, as browsers send
+// it on every reload, but ?refresh=true is refused so tests relying on it
+// fail loudly.
+func (app *App) wantsRefresh(r *http.Request) (bool, error) {
+	param := r.URL.Query().Get("refresh") == "true"
+	header := false
+	for _, directive := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+			header = true
+		}
+	}
+
+	if !param && !header {
+		return false, nil
+	}
+	if !app.isAdmin(r) {
+		if param {
+			return false, errors.New("refresh requires the admin token")
+		}
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/cache"
+)
+
+func TestConsistencyTokenRoundTrip(t *testing.T) {
+	rec := httptest.NewRecorder()
+	setConsistencyToken(rec, 42)
+	assert.Equal(t, "42", rec.Header().Get(ConsistencyHeader))
+
+	// From the header
+	r := httptest.NewRequest("GET", "/api/data", nil)
+	r.Header.Set(ConsistencyHeader, "42")
+	assert.Equal(t, int64(42), consistencyToken(r))
+
+	// From the cookie
+	r = httptest.NewRequest("GET", "/api/data", nil)
+	for _, c := range rec.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	assert.Equal(t, int64(42), consistencyToken(r))
+
+	r = httptest.NewRequest("GET", "/api/data", nil)
+	r.Header.Set(ConsistencyHeader, "soon")
+	assert.Equal(t, int64(0), consistencyToken(r))
+}
+
+func TestNoConsistencyTokenWithoutVersion(t *testing.T) {
+	rec := httptest.NewRecorder()
+	setConsistencyToken(rec, 0)
+	assert.Empty(t, rec.Header().Get(ConsistencyHeader))
+	assert.Empty(t, rec.Result().Cookies())
+}
+
+func TestWriteVersionAfterFailedInvalidationIsAhead(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rds.Close() })
+	app := &App{ListCache: cache.NewQueryCache(rds, "list", time.Minute)}
+
+	epoch, err := app.ListCache.Invalidate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, epoch, app.writeVersion(ctx, epoch))
+
+	// Reads must bypass the cache until a later write invalidates it
+	assert.Equal(t, epoch+1, app.writeVersion(ctx, 0))
+
+	mr.Close()
+	assert.Equal(t, int64(0), app.writeVersion(ctx, 0))
+}
+
+func TestWantsRefresh(t *testing.T) {
+	app := &App{AdminToken: "secret"}
+	request := func(url, cacheControl string, admin bool) *http.Request {
+		r := httptest.NewRequest("GET", url, nil)
+		if cacheControl != "" {
+			r.Header.Set("Cache-Control", cacheControl)
+		}
+		if admin {
+			r.Header.Set("X-Admin-Token", "secret")
+		}
+		return r
+	}
+
+	refresh, err := app.wantsRefresh(request("/api/data", "", true))
+	require.NoError(t, err)
+	assert.False(t, refresh)
+
+	refresh, err = app.wantsRefresh(request("/api/data?refresh=true", "", true))
+	require.NoError(t, err)
+	assert.True(t, refresh)
+
+	refresh, err = app.wantsRefresh(request("/api/data", "max-age=0, No-Cache", true))
+	require.NoError(t, err)
+	assert.True(t, refresh)
+
+	// Browsers send no-cache on reload; without the token it is ignored
+	refresh, err = app.wantsRefresh(request("/api/data", "no-cache", false))
+	require.NoError(t, err)
+	assert.False(t, refresh)
+
+	_, err = app.wantsRefresh(request("/api/data?refresh=true", "", false))
+	assert.Error(t, err)
+}
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/coverage"
+
+	"github.com/nesymno/run-tests-example/jsonpolicy"
+)
+
+// CoverageFlushHandler writes the coverage counters collected so far to
+// CoverDir, so end-to-end runs get coverage data without stopping the
+// server. ?reset=true clears the counters afterwards, so the next flush
+// only covers what ran since; it needs a binary built with
+// -covermode=atomic.
+func (app *App) CoverageFlushHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, r, "POST")
+		return
+	}
+	// Binaries built without -cover have no meta-data to write
+	if app.CoverDir == "" || coverage.WriteMeta(io.Discard) != nil {
+		http.Error(w, "Coverage is disabled, build with -cover and set GOCOVERDIR", http.StatusNotFound)
+		return
+	}
+
+	// The meta-data file is written at startup already; writing it again
+	// is harmless and covers a directory emptied since
+	if err := coverage.WriteMetaDir(app.CoverDir); err != nil {
+		http.Error(w, fmt.Sprintf("Coverage error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := coverage.WriteCountersDir(app.CoverDir); err != nil {
+		http.Error(w, fmt.Sprintf("Coverage error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	reset := r.URL.Query().Get("reset") == "true"
+	if reset {
+		if err := coverage.ClearCounters(); err != nil {
+			http.Error(w, fmt.Sprintf("Counters written but not reset: %v", err), http.StatusConflict)
+			return
+		}
+	}
+
+	setJSONContentType(w)
+	app.writeJSON(w, r, jsonpolicy.Fields{"dir": app.CoverDir, "reset": reset})
+}
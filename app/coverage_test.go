@@ -0,0 +1,24 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoverageFlushHandler(t *testing.T) {
+	call := func(app *App, method string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		app.CoverageFlushHandler(rec, httptest.NewRequest(method, "/admin/coverage/flush", nil))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusMethodNotAllowed, call(&App{CoverDir: t.TempDir()}, "GET").Code)
+	assert.Equal(t, http.StatusNotFound, call(&App{}, "POST").Code)
+	// Test binaries have no meta-data to write, even under go test -cover
+	rec := call(&App{CoverDir: t.TempDir()}, "POST")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Contains(t, rec.Body.String(), "build with -cover")
+}
@@ -0,0 +1,227 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+func TestNormalizeDataDefaults(t *testing.T) {
+	data := types.TestData{Name: "n"}
+	require.NoError(t, normalizeData(&data))
+
+	assert.Equal(t, []string{}, data.Tags)
+	assert.Equal(t, types.StatusActive, data.Status)
+}
+
+func TestNormalizeDataRejectsUnknownStatus(t *testing.T) {
+	data := types.TestData{Name: "n", Status: "deleted"}
+	assert.Error(t, normalizeData(&data))
+}
+
+func TestDecodeJSONStrict(t *testing.T) {
+	body := `{"name":"n","colour":"red"}`
+
+	var data types.TestData
+	lenient := &App{}
+	require.NoError(t, lenient.decodeJSON(httptest.NewRequest("POST", "/api/data", strings.NewReader(body)), &data))
+	assert.Equal(t, "n", data.Name)
+
+	strict := &App{StrictJSON: true}
+	err := strict.decodeJSON(httptest.NewRequest("POST", "/api/data", strings.NewReader(body)), &data)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "colour")
+}
+
+func TestDecodeJSONContentType(t *testing.T) {
+	app := &App{}
+	decode := func(contentType, body string) (types.TestData, error) {
+		r := httptest.NewRequest("POST", "/api/data", strings.NewReader(body))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		var data types.TestData
+		err := app.decodeJSON(r, &data)
+		return data, err
+	}
+
+	for _, contentType := range []string{
+		"",
+		"application/json",
+		"Application/JSON",
+		"application/json; charset=utf-8",
+		"application/json;charset=UTF-8",
+		"application/json ; charset=\"utf-8\"",
+		"application/json; charset=utf8; profile=x",
+		"application/merge-patch+json",
+		"application/vnd.api+json; charset=us-ascii",
+	} {
+		data, err := decode(contentType, `{"name":"n"}`)
+		require.NoError(t, err, contentType)
+		assert.Equal(t, "n", data.Name, contentType)
+	}
+
+	for _, contentType := range []string{
+		"text/plain",
+		"application/x-www-form-urlencoded",
+		"application/jsonp",
+		"text/json+xml",
+		"application/json; charset=utf-16",
+		"application/json; charset=latin1",
+		"application/json; charset",
+		"application/",
+	} {
+		_, err := decode(contentType, `{"name":"n"}`)
+		var mtErr *mediaTypeError
+		assert.ErrorAs(t, err, &mtErr, contentType)
+	}
+}
+
+func TestDecodeJSONSkipsBOM(t *testing.T) {
+	app := &App{}
+	var data types.TestData
+	r := httptest.NewRequest("POST", "/api/data", strings.NewReader("\xef\xbb\xbf{\"name\":\"bom\"}"))
+	require.NoError(t, app.decodeJSON(r, &data))
+	assert.Equal(t, "bom", data.Name)
+
+	// Only a leading BOM is dropped, and short bodies still decode
+	for body, wantErr := range map[string]bool{"1": false, "": true, "\xef\xbb": true, "{\"name\":\"\xef\xbb\xbf\"}": false} {
+		var v any
+		err := app.decodeJSON(httptest.NewRequest("POST", "/", strings.NewReader(body)), &v)
+		assert.Equal(t, wantErr, err != nil, "%q", body)
+	}
+}
+
+func TestTagsRoundTripThroughPostgresArrayEncoding(t *testing.T) {
+	tags := []string{"plain", "with space", `quote"d`, "comma,separated", ""}
+
+	encoded, err := pq.Array(tags).Value()
+	require.NoError(t, err)
+
+	var decoded []string
+	require.NoError(t, pq.Array(&decoded).Scan([]byte(encoded.(string))))
+	assert.Equal(t, tags, decoded)
+}
+
+func TestEmptyTagsEncodeAsEmptyArray(t *testing.T) {
+	encoded, err := pq.Array([]string{}).Value()
+	require.NoError(t, err)
+	assert.Equal(t, "{}", encoded)
+
+	var decoded []string
+	require.NoError(t, pq.Array(&decoded).Scan([]byte("{}")))
+	assert.Empty(t, decoded)
+}
+
+func TestTestDataJSONRoundTrip(t *testing.T) {
+	in := types.TestData{ID: 1, Name: "n", Data: "d", Tags: []string{"a", "b"}, Status: types.StatusArchived}
+
+	raw, err := json.Marshal(in)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":1,"name":"n","data":"d","tags":["a","b"],"status":"archived"}`, string(raw))
+
+	var out types.TestData
+	require.NoError(t, json.Unmarshal(raw, &out))
+	assert.Equal(t, in, out)
+}
+
+func TestTimestampsNormalizeToUTC(t *testing.T) {
+	var data types.TestData
+	require.NoError(t, json.Unmarshal([]byte(`{"name":"n","created_at":"2024-03-10T09:30:00.123456+02:00"}`), &data))
+	require.NoError(t, normalizeData(&data))
+
+	assert.Equal(t, time.UTC, data.CreatedAt.Location())
+	assert.True(t, data.UpdatedAt.IsZero(), "updated_at is left to default to created_at")
+
+	raw, err := json.Marshal(data)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `"created_at":"2024-03-10T07:30:00.123456Z"`)
+	assert.NotContains(t, string(raw), "updated_at")
+
+	var out types.TestData
+	require.NoError(t, json.Unmarshal(raw, &out))
+	assert.True(t, data.CreatedAt.Equal(out.CreatedAt))
+	assert.Equal(t, data.CreatedAt.Format(time.RFC3339Nano), out.CreatedAt.Format(time.RFC3339Nano))
+}
+
+func TestNormalizeDataRejectsUpdateBeforeCreate(t *testing.T) {
+	created := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	data := types.TestData{Name: "n", CreatedAt: created, UpdatedAt: created.Add(-time.Second)}
+	assert.Error(t, normalizeData(&data))
+
+	data.UpdatedAt = created
+	assert.NoError(t, normalizeData(&data))
+}
+
+func TestParseFields(t *testing.T) {
+	fields, err := parseFields("name, id,name")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id", "name"}, fields)
+
+	fields, err = parseFields("")
+	require.NoError(t, err)
+	assert.Nil(t, fields)
+
+	_, err = parseFields("id,password")
+	assert.EqualError(t, err, `unknown field "password"`)
+}
+
+func TestFieldsAreInCacheKey(t *testing.T) {
+	a := dataFilter{Tag: "smoke", Fields: []string{"id", "name"}}
+	b := dataFilter{Tag: "smoke"}
+	assert.Equal(t, "fields=id%2Cname&tag=smoke", a.normalized())
+	assert.NotEqual(t, a.normalized(), b.normalized())
+	assert.True(t, a.selects("id"))
+	assert.False(t, a.selects("comments"))
+	assert.True(t, b.selects("comments"))
+}
+
+func TestProjectData(t *testing.T) {
+	rows := []types.TestData{
+		{ID: 1, Name: "n", Data: "d", Tags: []string{"a"}, Status: types.StatusActive, TestRunID: "run-1"},
+		{ID: 2, Name: "m", Status: types.StatusActive},
+	}
+
+	raw, err := json.Marshal(projectData(rows, []string{"id", "tags", "test_run_id"}))
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"id":1,"tags":["a"],"test_run_id":"run-1"},{"id":2,"tags":null}]`, string(raw))
+}
+
+func TestParseIncludes(t *testing.T) {
+	rows, cacheMetadata, err := parseIncludes("comments, cache-metadata,comments")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"comments"}, rows)
+	assert.True(t, cacheMetadata)
+
+	rows, cacheMetadata, err = parseIncludes("cache-metadata")
+	require.NoError(t, err)
+	assert.Nil(t, rows)
+	assert.True(t, cacheMetadata)
+
+	_, _, err = parseIncludes("audit")
+	assert.EqualError(t, err, `unknown include "audit" (available: comments, cache-metadata)`)
+}
+
+func TestIncludesAreInCacheKey(t *testing.T) {
+	f := dataFilter{Include: []string{"comments"}}
+	assert.Equal(t, "include=comments", f.normalized())
+}
+
+func TestExpandSkipsIncludesLeftOutByFields(t *testing.T) {
+	queries := includeQueries.With("comments").Value()
+	filter := dataFilter{Include: []string{"comments"}, Fields: []string{"id"}}
+
+	// A nil App has no database, so any query would panic
+	require.NoError(t, (*App)(nil).expand(context.Background(), filter, []types.TestData{{ID: 1}}))
+	require.NoError(t, (*App)(nil).expand(context.Background(), dataFilter{Include: []string{"comments"}}, nil))
+	assert.Equal(t, queries, includeQueries.With("comments").Value())
+}
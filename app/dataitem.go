@@ -0,0 +1,201 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// dataRowColumns are the columns of listDataQuery, for the single-row
+// queries of DataItemHandler.
+const dataRowColumns = "id, name, data, tags, status, created_at, updated_at, secret, COALESCE(test_run_id, '')"
+
+// DataItemHandler reads (GET), replaces (PUT), partially updates (PATCH)
+// or deletes (DELETE) a single test_data row.
+func (app *App) DataItemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid data ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		app.getDataItem(w, r, id)
+	case "PUT":
+		var data types.TestData
+		if err := app.decodeJSON(r, &data); err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+		if err := normalizeData(&data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		app.updateDataItem(w, r, id, types.DataPatch{
+			Name:   &data.Name,
+			Data:   &data.Data,
+			Tags:   &data.Tags,
+			Status: &data.Status,
+			Secret: &data.Secret,
+		}, data.UpdatedAt)
+	case "PATCH":
+		var patch types.DataPatch
+		if err := app.decodeJSON(r, &patch); err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+		if patch == (types.DataPatch{}) {
+			http.Error(w, "Nothing to update", http.StatusBadRequest)
+			return
+		}
+		if patch.Status != nil && !validStatus(*patch.Status) {
+			http.Error(w, fmt.Sprintf("Invalid status %q", *patch.Status), http.StatusBadRequest)
+			return
+		}
+		app.updateDataItem(w, r, id, patch, time.Time{})
+	case "DELETE":
+		app.deleteDataItem(w, r, id)
+	default:
+		methodNotAllowed(w, r, "GET", "PUT", "PATCH", "DELETE")
+	}
+}
+
+func (app *App) getDataItem(w http.ResponseWriter, r *http.Request, id int) {
+	include, _, err := parseIncludes(r.URL.Query().Get("include"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	data, err := app.scanDataRow(app.db(ctx).QueryRowContext(ctx,
+		"SELECT "+dataRowColumns+" FROM test_data WHERE id = $1 AND tenant_id IS NOT DISTINCT FROM $2",
+		id, tenantColumn(tenantFrom(ctx))))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeDBError(w, "Database error", err)
+		return
+	}
+
+	rows := []types.TestData{data}
+	if err := app.expand(ctx, dataFilter{Include: include}, rows); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	app.writeJSON(w, r, rows[0])
+}
+
+// updateDataItem applies patch to a row, stamping it with updatedAt or the
+// current time, and responds with the updated row.
+func (app *App) updateDataItem(w http.ResponseWriter, r *http.Request, id int, patch types.DataPatch, updatedAt time.Time) {
+	var secret []byte
+	if patch.Secret != nil {
+		var err error
+		if secret, err = app.sealSecret(*patch.Secret); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	var tags any
+	if patch.Tags != nil {
+		tags = pq.Array(*patch.Tags)
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	data, err := app.scanDataRow(app.db(ctx).QueryRowContext(ctx, `
+		UPDATE test_data SET
+			name = COALESCE($3, name),
+			data = COALESCE($4, data),
+			tags = COALESCE($5, tags),
+			status = COALESCE($6, status),
+			secret = CASE WHEN $7 THEN $8 ELSE secret END,
+			updated_at = COALESCE($9, CURRENT_TIMESTAMP)
+		WHERE id = $1 AND tenant_id IS NOT DISTINCT FROM $2
+		RETURNING `+dataRowColumns,
+		id, tenantColumn(tenantFrom(ctx)), patch.Name, patch.Data, tags, patch.Status,
+		patch.Secret != nil, secret, nullTime(updatedAt)))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeDBError(w, "Update error", err)
+		return
+	}
+	app.publishEvent(ctx, eventDataUpdated, eventData(data))
+	setConsistencyToken(w, app.writeVersion(ctx, app.invalidateList(ctx)))
+
+	setJSONContentType(w)
+	app.writeJSON(w, r, data)
+}
+
+func (app *App) deleteDataItem(w http.ResponseWriter, r *http.Request, id int) {
+	ctx := context.WithoutCancel(r.Context())
+	tx, err := app.beginTx(ctx)
+	if err != nil {
+		writeDBError(w, "Delete error", err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Comments are deleted explicitly, as a partitioned test_data has no
+	// foreign key to cascade from
+	tenant := tenantColumn(tenantFrom(ctx))
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM test_data_comments WHERE data_id IN
+		(SELECT id FROM test_data WHERE id = $1 AND tenant_id IS NOT DISTINCT FROM $2)`, id, tenant)
+	if err != nil {
+		writeDBError(w, "Delete error", err)
+		return
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM test_data WHERE id = $1 AND tenant_id IS NOT DISTINCT FROM $2", id, tenant)
+	if err != nil {
+		writeDBError(w, "Delete error", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeDBError(w, "Delete error", err)
+		return
+	}
+	app.publishEvent(ctx, eventDataDeleted, map[string]int{"id": id})
+	setConsistencyToken(w, app.writeVersion(ctx, app.invalidateList(ctx)))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// scanDataRow reads a row selecting dataRowColumns. Unlike scanData it
+// returns the database's error as is, so that callers can map it to a
+// status.
+func (app *App) scanDataRow(row *sql.Row) (types.TestData, error) {
+	var data types.TestData
+	var createdAt, updatedAt sql.NullTime
+	var secret []byte
+	if err := row.Scan(&data.ID, &data.Name, &data.Data, pq.Array(&data.Tags), &data.Status, &createdAt, &updatedAt, &secret, &data.TestRunID); err != nil {
+		return data, err
+	}
+	data.CreatedAt = createdAt.Time.UTC()
+	data.UpdatedAt = updatedAt.Time.UTC()
+
+	var err error
+	if data.Secret, err = app.openSecret(secret); err != nil {
+		return data, fmt.Errorf("Decrypt error for row %d: %v", data.ID, err)
+	}
+	return data, nil
+}
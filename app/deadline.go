@@ -0,0 +1,142 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/nesymno/run-tests-example/httpclient"
+	"github.com/nesymno/run-tests-example/metrics"
+	"github.com/nesymno/run-tests-example/servertiming"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// stageTransit is the stage of a request whose deadline passed before it
+// came in.
+const stageTransit = "transit"
+
+var deadlinesExceeded = metrics.NewCounterVec("app_deadline_exceeded_total",
+	"Requests answered 504 because the deadline their caller sent passed, by stage.", "stage")
+
+// DeadlineMiddleware gives a request the deadline its caller sends in
+// X-Request-Deadline or Grpc-Timeout. The database, Redis and outbound
+// calls made for it give up at the deadline, and outbound calls pass on
+// the time left. A request whose response has not started by the deadline
+// is answered 504 with the stage it was in, so that tests can check that
+// a deadline is honored end to end. As with any timeout, a write may have
+// been applied even so: writes complete regardless of the caller.
+func (app *App) DeadlineMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok, err := httpclient.ParseDeadline(r.Header, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		dw := &deadlineWriter{ResponseWriter: w, app: app, r: r, deadline: deadline}
+		if !time.Now().Before(deadline) {
+			dw.exceeded(stageTransit)
+			return
+		}
+		ctx, cancel := httpclient.PropagateDeadline(r.Context(), deadline)
+		defer cancel()
+		// The clients note the call the deadline passed during in the
+		// request's timings
+		if dw.timings = servertiming.FromContext(ctx); dw.timings == nil {
+			ctx, dw.timings = servertiming.WithTimings(ctx)
+		}
+		dw.ctx = ctx
+
+		next.ServeHTTP(dw, r.WithContext(ctx))
+		// Handlers that see their context done may return without a word
+		if !dw.wrote && ctx.Err() == context.DeadlineExceeded {
+			dw.exceeded(dw.stage())
+		}
+	})
+}
+
+// detach returns a context for work that other requests share, which
+// must go on when ctx's caller goes away but still ends at ctx's deadline,
+// if any, so that the clients give up at it.
+func detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	detached := context.WithoutCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(detached, deadline)
+	}
+	return detached, func() {}
+}
+
+// deadlineWriter answers 504 instead of a response the handler starts
+// once the request's deadline passed, discarding the handler's body.
+type deadlineWriter struct {
+	http.ResponseWriter
+	app      *App
+	r        *http.Request
+	ctx      context.Context
+	timings  *servertiming.Timings
+	deadline time.Time
+	wrote    bool
+	replaced bool
+}
+
+// stage is where the request was when its deadline passed: in the call to
+// PostgreSQL or Redis it interrupted, in the app otherwise.
+func (w *deadlineWriter) stage() string {
+	if stage := w.timings.Interrupted(); stage != "" {
+		return stage
+	}
+	return servertiming.App
+}
+
+func (w *deadlineWriter) exceeded(stage string) {
+	w.wrote, w.replaced = true, true
+	deadlinesExceeded.With(stage).Inc()
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Retry-After")
+	setJSONContentType(w)
+	w.app.writeJSONStatus(w.ResponseWriter, w.r, http.StatusGatewayTimeout, types.DeadlineExceeded{
+		Error:    "deadline exceeded in " + stage,
+		Stage:    stage,
+		Deadline: w.deadline.UTC(),
+	})
+}
+
+func (w *deadlineWriter) WriteHeader(status int) {
+	// Informational responses leave the final status to come
+	if !w.wrote && status >= 200 {
+		w.start()
+	}
+	if !w.replaced {
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *deadlineWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.start()
+	}
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// start replaces the response the handler starts with a 504 if the
+// deadline has passed. The clock is checked too, as a context detached
+// for shared work may see the same deadline pass before the request's
+// does.
+func (w *deadlineWriter) start() {
+	w.wrote = true
+	if w.ctx.Err() == context.DeadlineExceeded || !time.Now().Before(w.deadline) {
+		w.exceeded(w.stage())
+	}
+}
+
+func (w *deadlineWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
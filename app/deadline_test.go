@@ -0,0 +1,164 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/faults"
+	"github.com/nesymno/run-tests-example/servertiming"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// hangingDB is a database whose pings only return once their context is
+// done.
+type hangingDB struct{}
+
+func (hangingDB) Connect(context.Context) (driver.Conn, error) { return hangingConn{}, nil }
+func (hangingDB) Driver() driver.Driver                        { return nil }
+
+type hangingConn struct{}
+
+func (hangingConn) Ping(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (hangingConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (hangingConn) Close() error                        { return nil }
+func (hangingConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+func serveWithDeadline(app *App, handler http.HandlerFunc, header, value string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/api/data", nil)
+	if header != "" {
+		r.Header.Set(header, value)
+	}
+	w := httptest.NewRecorder()
+	app.DeadlineMiddleware(handler).ServeHTTP(w, r)
+	return w
+}
+
+func deadlineExceeded(t *testing.T, w *httptest.ResponseRecorder) types.DeadlineExceeded {
+	t.Helper()
+	require.Equal(t, http.StatusGatewayTimeout, w.Code, w.Body.String())
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var body types.DeadlineExceeded
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return body
+}
+
+func TestDeadlineInterruptsDatabaseCall(t *testing.T) {
+	db := sql.OpenDB(servertiming.Connector(hangingDB{}))
+	defer db.Close()
+	app := &App{DB: db}
+
+	w := serveWithDeadline(app, func(w http.ResponseWriter, r *http.Request) {
+		if err := app.DB.PingContext(r.Context()); err != nil {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		}
+	}, "Grpc-Timeout", "20m")
+
+	body := deadlineExceeded(t, w)
+	assert.Equal(t, "deadline exceeded in db", body.Error)
+	assert.Equal(t, servertiming.DB, body.Stage)
+	assert.Empty(t, w.Header().Get("Retry-After"))
+	assert.NotContains(t, w.Body.String(), "context deadline exceeded", "the handler's body is discarded")
+}
+
+func TestDeadlineExceededInApp(t *testing.T) {
+	app := &App{}
+	w := serveWithDeadline(app, func(w http.ResponseWriter, r *http.Request) {
+		// Gives up without a response, as Chaos does
+		<-r.Context().Done()
+	}, "Grpc-Timeout", "10m")
+	assert.Equal(t, servertiming.App, deadlineExceeded(t, w).Stage)
+
+	w = serveWithDeadline(app, func(w http.ResponseWriter, r *http.Request) {
+		// Completes regardless of the caller
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"status":"created"}`))
+	}, "Grpc-Timeout", "10m")
+	assert.Equal(t, servertiming.App, deadlineExceeded(t, w).Stage)
+}
+
+func TestDeadlinePassedOnArrival(t *testing.T) {
+	app := &App{}
+	deadline := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	w := serveWithDeadline(app, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler called after the deadline")
+	}, "X-Request-Deadline", deadline.Format(time.RFC3339))
+
+	body := deadlineExceeded(t, w)
+	assert.Equal(t, stageTransit, body.Stage)
+	assert.True(t, deadline.Equal(body.Deadline))
+}
+
+func TestResponseWithinDeadline(t *testing.T) {
+	app := &App{}
+	deadline := time.Now().Add(time.Minute).UTC().Truncate(time.Millisecond)
+	w := serveWithDeadline(app, func(w http.ResponseWriter, r *http.Request) {
+		got, ok := r.Context().Deadline()
+		assert.True(t, ok)
+		assert.True(t, deadline.Equal(got))
+		http.Error(w, "Data not found", http.StatusNotFound)
+	}, "X-Request-Deadline", deadline.Format(time.RFC3339Nano))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "Data not found\n", w.Body.String())
+
+	w = serveWithDeadline(app, okHandler, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serveWithDeadline(app, okHandler, "Grpc-Timeout", "1s")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "invalid Grpc-Timeout \"1s\", want up to 8 digits and a unit\n", w.Body.String())
+}
+
+// newSlowListingApp returns an app whose list query takes delay, with the
+// database calls timed for the deadline stage.
+func newSlowListingApp(t *testing.T, delay time.Duration) *App {
+	dbFaults := new(faults.Injector).SlowEvery(1, delay)
+	app, listing := newFaultyApp(t, dbFaults, new(faults.Injector))
+	app.DB = sql.OpenDB(servertiming.Connector(faults.Connector(listing, dbFaults)))
+	t.Cleanup(func() { app.DB.Close() })
+	return app
+}
+
+func TestDeadlineInterruptsListQuery(t *testing.T) {
+	app := newSlowListingApp(t, time.Minute)
+
+	start := time.Now()
+	w := serveWithDeadline(app, app.DataHandler, "Grpc-Timeout", "50m")
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, servertiming.DB, deadlineExceeded(t, w).Stage)
+}
+
+func TestCoalescedListOutlivesLeaderDeadline(t *testing.T) {
+	app := newSlowListingApp(t, 300*time.Millisecond)
+
+	leader := make(chan *httptest.ResponseRecorder)
+	go func() { leader <- serveWithDeadline(app, app.DataHandler, "Grpc-Timeout", "50m") }()
+	require.Eventually(t, func() bool {
+		app.dataFlight.mu.Lock()
+		defer app.dataFlight.mu.Unlock()
+		return len(app.dataFlight.calls) == 1
+	}, time.Second, time.Millisecond)
+
+	// Joins the leader's flight, then lists again once the leader's
+	// deadline failed it
+	w := serveWithDeadline(app, app.DataHandler, "", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, listingJSON, w.Body.String())
+	assert.Empty(t, w.Header().Get("X-Coalesced"))
+
+	assert.Equal(t, servertiming.DB, deadlineExceeded(t, <-leader).Stage)
+}
@@ -0,0 +1,216 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/lib/pq"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// secretAAD binds sealed secrets to their column, so a ciphertext copied
+// into another encrypted column does not decrypt there.
+var secretAAD = []byte("test_data.secret")
+
+var errNoKeyring = errors.New("secret requires FIELD_ENCRYPTION_KEYS to be set")
+
+// resealBatchSize is how many rows resealSecrets loads at a time.
+const resealBatchSize = 500
+
+// sealSecret encrypts a row's secret for storage, nil when it has none.
+func (app *App) sealSecret(secret string) ([]byte, error) {
+	if secret == "" {
+		return nil, nil
+	}
+	if app.Keyring == nil {
+		return nil, errNoKeyring
+	}
+	return app.Keyring.Encrypt([]byte(secret), secretAAD)
+}
+
+// sealedData is a row with its secret sealed, the form in which rows are
+// queued in Redis, which keeps them in plain JSON, and then written.
+type sealedData struct {
+	types.TestData
+	// SealedSecret replaces TestData.Secret, which is left empty. Rows
+	// queued before it was introduced have the secret there instead.
+	SealedSecret []byte `json:"sealed_secret,omitempty"`
+}
+
+// sealData seals the secret of data.
+func (app *App) sealData(data types.TestData) (sealedData, error) {
+	secret, err := app.sealSecret(data.Secret)
+	if err != nil {
+		return sealedData{}, err
+	}
+	data.Secret = ""
+	return sealedData{TestData: data, SealedSecret: secret}, nil
+}
+
+// decodeSealedData decodes a queued row. The secret of rows queued unsealed is
+// sealed now.
+func (app *App) decodeSealedData(raw []byte) (sealedData, error) {
+	var data sealedData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return sealedData{}, err
+	}
+	if data.Secret != "" {
+		return app.sealData(data.TestData)
+	}
+	return data, nil
+}
+
+// openSecret decrypts a secret stored by sealSecret.
+func (app *App) openSecret(sealed []byte) (string, error) {
+	if sealed == nil {
+		return "", nil
+	}
+	if app.Keyring == nil {
+		return "", errNoKeyring
+	}
+	secret, err := app.Keyring.Decrypt(sealed, secretAAD)
+	return string(secret), err
+}
+
+// EncryptionHandler reports how many secrets each key has sealed (GET) or
+// reseals every secret not sealed with the primary key (POST), after which
+// the other keys can be retired.
+func (app *App) EncryptionHandler(w http.ResponseWriter, r *http.Request) {
+	if app.Keyring == nil {
+		http.Error(w, "Field encryption is disabled", http.StatusNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	tables, err := app.secretTables(ctx)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	report := types.EncryptionReport{PrimaryKey: app.Keyring.Primary(), Keys: map[string]int64{}}
+	switch r.Method {
+	case "GET":
+	case "POST":
+		for _, table := range tables {
+			n, err := app.resealSecrets(ctx, table)
+			report.Resealed += n
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Reseal error in %s after %d rows: %v", table, report.Resealed, err), http.StatusInternalServerError)
+				return
+			}
+		}
+	default:
+		methodNotAllowed(w, r, "GET", "POST")
+		return
+	}
+
+	for _, table := range tables {
+		if err := app.countSecretKeys(ctx, table, report.Keys); err != nil {
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	setJSONContentType(w)
+	app.writeJSON(w, r, report)
+}
+
+// secretTables lists every table holding sealed secrets: the shared data
+// and archive tables and the data table of each schema-isolated tenant.
+func (app *App) secretTables(ctx context.Context) ([]string, error) {
+	tables := []string{"public.test_data", "public.test_data_archive"}
+	rows, err := app.DB.QueryContext(ctx,
+		"SELECT schema_name FROM tenants WHERE schema_name IS NOT NULL ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var schema string
+		if err := rows.Scan(&schema); err != nil {
+			return nil, err
+		}
+		tables = append(tables, pq.QuoteIdentifier(schema)+".test_data")
+	}
+	return tables, rows.Err()
+}
+
+// keyIDExpr extracts the key ID a secret was sealed with.
+const keyIDExpr = "convert_from(substring(secret FROM 1 FOR position(':'::bytea IN secret) - 1), 'UTF8')"
+
+func (app *App) countSecretKeys(ctx context.Context, table string, counts map[string]int64) error {
+	rows, err := app.DB.QueryContext(ctx,
+		"SELECT "+keyIDExpr+", COUNT(*) FROM "+table+" WHERE secret IS NOT NULL GROUP BY 1")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var n int64
+		if err := rows.Scan(&id, &n); err != nil {
+			return err
+		}
+		counts[id] += n
+	}
+	return rows.Err()
+}
+
+// resealSecrets rewrites the secrets of table not sealed with the primary
+// key, in batches. A row whose secret changed meanwhile is left to the next
+// run rather than overwritten with a stale value.
+func (app *App) resealSecrets(ctx context.Context, table string) (int64, error) {
+	var resealed int64
+	for {
+		rows, err := app.DB.QueryContext(ctx,
+			"SELECT id, secret FROM "+table+" WHERE secret IS NOT NULL AND "+keyIDExpr+" <> $1 ORDER BY id LIMIT $2",
+			app.Keyring.Primary(), resealBatchSize)
+		if err != nil {
+			return resealed, err
+		}
+		type sealedRow struct {
+			id     int
+			secret []byte
+		}
+		var batch []sealedRow
+		for rows.Next() {
+			var row sealedRow
+			if err := rows.Scan(&row.id, &row.secret); err != nil {
+				rows.Close()
+				return resealed, err
+			}
+			batch = append(batch, row)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return resealed, err
+		}
+
+		for _, row := range batch {
+			plain, err := app.Keyring.Decrypt(row.secret, secretAAD)
+			if err != nil {
+				return resealed, fmt.Errorf("row %d: %v", row.id, err)
+			}
+			sealed, err := app.Keyring.Encrypt(plain, secretAAD)
+			if err != nil {
+				return resealed, err
+			}
+			res, err := app.DB.ExecContext(ctx,
+				"UPDATE "+table+" SET secret = $1 WHERE id = $2 AND secret = $3", sealed, row.id, row.secret)
+			if err != nil {
+				return resealed, err
+			}
+			n, _ := res.RowsAffected()
+			resealed += n
+		}
+
+		if len(batch) < resealBatchSize {
+			return resealed, nil
+		}
+	}
+}
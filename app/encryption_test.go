@@ -0,0 +1,125 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/encryption"
+	"github.com/nesymno/run-tests-example/types"
+	"github.com/nesymno/run-tests-example/worker"
+)
+
+func testKeyring(t *testing.T, ids ...string) *encryption.Keyring {
+	t.Helper()
+	keys := make([]encryption.Key, len(ids))
+	for i, id := range ids {
+		keys[i] = encryption.Key{ID: id, Secret: bytes.Repeat([]byte(id[len(id)-1:]), encryption.KeySize)}
+	}
+	keyring, err := encryption.NewKeyring(keys)
+	require.NoError(t, err)
+	return keyring
+}
+
+func TestSecretsDecryptAfterRotation(t *testing.T) {
+	app := &App{Keyring: testKeyring(t, "k1")}
+	sealed, err := app.sealSecret("hunter2")
+	require.NoError(t, err)
+	assert.NotContains(t, string(sealed), "hunter2")
+
+	app.Keyring = testKeyring(t, "k2", "k1")
+	secret, err := app.openSecret(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", secret)
+
+	resealed, err := app.sealSecret(secret)
+	require.NoError(t, err)
+	assert.Equal(t, "k2", encryption.KeyID(resealed))
+
+	none, err := app.sealSecret("")
+	require.NoError(t, err)
+	assert.Nil(t, none, "rows without a secret store NULL")
+}
+
+func TestSecretsRequireKeyring(t *testing.T) {
+	app := &App{}
+	_, err := app.sealSecret("hunter2")
+	assert.ErrorIs(t, err, errNoKeyring)
+
+	secret, err := app.openSecret(nil)
+	require.NoError(t, err)
+	assert.Empty(t, secret)
+}
+
+func TestBatchRowCarriesSealedSecret(t *testing.T) {
+	row := batchRow{TestData: types.TestData{Name: "a", Secret: "hunter2"}, Secret: `\x6b31`}
+	b, err := json.Marshal(row)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"secret":"\\x6b31"`)
+	assert.NotContains(t, string(b), "hunter2")
+}
+
+func TestQueuedRowsKeepSecretsSealed(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rds.Close() })
+	app := &App{Rds: rds, Jobs: worker.New(rds), Keyring: testKeyring(t, "k1")}
+	body := `{"name":"a","data":"x","secret":"hunter2"}`
+
+	post := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.DataHandler(w, httptest.NewRequest("POST", target, strings.NewReader(body)))
+		require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+		return w
+	}
+	w := post("/api/data?async=true")
+	var accepted struct {
+		JobID string `json:"job_id"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&accepted))
+
+	app.Batch = worker.NewBatcher(rds, WriteBehindKey, nil)
+	post("/api/data")
+
+	// Redis never holds the secret in plaintext
+	job, err := app.Jobs.Get(context.Background(), accepted.JobID)
+	require.NoError(t, err)
+	buffered, err := rds.LRange(context.Background(), WriteBehindKey, 0, -1).Result()
+	require.NoError(t, err)
+	require.Len(t, buffered, 1)
+	for _, queued := range []string{string(job.Payload), buffered[0]} {
+		assert.Contains(t, queued, `"sealed_secret"`)
+		assert.NotContains(t, queued, "hunter2")
+	}
+
+	// The worker gets the secret back, but the job endpoints leave it out
+	data, err := app.decodeSealedData(job.Payload)
+	require.NoError(t, err)
+	secret, err := app.openSecret(data.SealedSecret)
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", secret)
+
+	req := httptest.NewRequest("GET", "/api/jobs/"+accepted.JobID, nil)
+	req.SetPathValue("id", accepted.JobID)
+	w = httptest.NewRecorder()
+	app.JobHandler(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "secret")
+
+	// Rows queued before secrets were sealed on the way in are sealed now
+	data, err = app.decodeSealedData([]byte(`{"name":"a","secret":"hunter2"}`))
+	require.NoError(t, err)
+	assert.Empty(t, data.Secret)
+	secret, err = app.openSecret(data.SealedSecret)
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", secret)
+}
@@ -0,0 +1,20 @@
+package app
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/nesymno/run-tests-example/config"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// DebugEnvHandler reports every recognized setting with its value, source
+// and validation result, secrets redacted, plus variables that look like
+// misspelled settings.
+func (app *App) DebugEnvHandler(w http.ResponseWriter, r *http.Request) {
+	setJSONContentType(w)
+	app.writeJSON(w, r, types.EnvReport{
+		Settings:     app.Settings,
+		Unrecognized: config.Unrecognized(os.Environ()),
+	})
+}
@@ -0,0 +1,70 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+// Postgres error codes surfaced to clients with a specific status.
+const (
+	pgNotNullViolation     = "23502"
+	pgForeignKeyViolation  = "23503"
+	pgUniqueViolation      = "23505"
+	pgCheckViolation       = "23514"
+	pgStringTooLong        = "22001"
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+)
+
+// dbErrorStatus maps a database error to the HTTP status it should be
+// reported with. Conflicts become 409, constraint violations caused by the
+// request body 422, and transient transaction failures 503 so the client
+// retries.
+func dbErrorStatus(err error) int {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return http.StatusInternalServerError
+	}
+
+	switch pqErr.Code {
+	case pgUniqueViolation:
+		return http.StatusConflict
+	case pgForeignKeyViolation, pgNotNullViolation, pgCheckViolation, pgStringTooLong:
+		return http.StatusUnprocessableEntity
+	case pgSerializationFailure, pgDeadlockDetected:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeDBError reports a database error with the status from dbErrorStatus.
+// Retryable failures carry a Retry-After hint.
+func writeDBError(w http.ResponseWriter, prefix string, err error) {
+	status := dbErrorStatus(err)
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Detail != "" {
+		http.Error(w, fmt.Sprintf("%s: %s (%s)", prefix, pqErr.Message, pqErr.Detail), status)
+		return
+	}
+	http.Error(w, fmt.Sprintf("%s: %v", prefix, err), status)
+}
+
+// writeDecodeError reports a request body decodeJSON rejected: 415 for one
+// that is not declared as JSON, 400 for invalid JSON.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var mtErr *mediaTypeError
+	if errors.As(err, &mtErr) {
+		w.Header().Set("Accept", "application/json")
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+}
@@ -0,0 +1,91 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/jsonpolicy"
+)
+
+func TestDBErrorStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"unique violation", &pq.Error{Code: "23505"}, http.StatusConflict},
+		{"foreign key violation", &pq.Error{Code: "23503"}, http.StatusUnprocessableEntity},
+		{"not null violation", &pq.Error{Code: "23502"}, http.StatusUnprocessableEntity},
+		{"value too long", &pq.Error{Code: "22001"}, http.StatusUnprocessableEntity},
+		{"serialization failure", &pq.Error{Code: "40001"}, http.StatusServiceUnavailable},
+		{"deadlock", &pq.Error{Code: "40P01"}, http.StatusServiceUnavailable},
+		{"other postgres error", &pq.Error{Code: "42P01"}, http.StatusInternalServerError},
+		{"wrapped", fmt.Errorf("insert: %w", &pq.Error{Code: "23505"}), http.StatusConflict},
+		{"not a postgres error", errors.New("connection refused"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, dbErrorStatus(tt.err))
+		})
+	}
+}
+
+func TestWriteDBErrorSetsRetryAfterForTransientErrors(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeDBError(rec, "Insert error", &pq.Error{Code: "40001", Message: "could not serialize access"})
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), "could not serialize access")
+}
+
+func TestWriteDBErrorIncludesConflictDetail(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeDBError(rec, "Insert error", &pq.Error{
+		Code:    "23505",
+		Message: "duplicate key value violates unique constraint",
+		Detail:  "Key (name)=(test1) already exists.",
+	})
+
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Empty(t, rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), "Key (name)=(test1) already exists.")
+}
+
+func TestWriteJSONStatusSanitizesFloatSpecials(t *testing.T) {
+	app := &App{}
+	rec := httptest.NewRecorder()
+	app.writeJSONStatus(rec, httptest.NewRequest("GET", "/api/queues", nil), http.StatusCreated,
+		jsonpolicy.Fields{"lag": math.NaN(), "name": "jobs"})
+
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "{\"lag\":null,\"name\":\"jobs\"}\n", rec.Body.String())
+}
+
+func TestWriteJSONStatusReportsEncodeErrors(t *testing.T) {
+	app := &App{}
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Location", "/api/jobs/1")
+	app.writeJSONStatus(rec, httptest.NewRequest("POST", "/api/jobs", nil), http.StatusAccepted,
+		jsonpolicy.Fields{"job_id": "1", "done": make(chan struct{})})
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Empty(t, rec.Header().Get("Location"))
+
+	var body struct {
+		Error string `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Contains(t, body.Error, "Encode error: json: unsupported type")
+}
@@ -0,0 +1,413 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/nesymno/run-tests-example/events"
+	"github.com/nesymno/run-tests-example/jsonpolicy"
+	"github.com/nesymno/run-tests-example/metrics"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// EventsStream is the Redis stream change events are appended to. Every
+// replica's feed reads it, and the recent history it keeps lets clients
+// resume after a reconnect. Entry IDs are "<n>-0", n being the event ID
+// taken from eventsSeqKey.
+const (
+	EventsStream = "events"
+	eventsSeqKey = "events:seq"
+)
+
+// defaultEventHistory is how many events are kept when FeedPolicy.History
+// is not set.
+const defaultEventHistory = 1000
+
+// eventsReadBlock bounds each blocking read of RunEvents, so it notices
+// ctx being done.
+const eventsReadBlock = 5 * time.Second
+
+// appendEvent numbers and appends an event in one step, so IDs are
+// consecutive and entries are in ID order whichever replica publishes.
+var appendEvent = redis.NewScript(`
+local n = redis.call('INCR', KEYS[2])
+redis.call('XADD', KEYS[1], 'MAXLEN', '~', ARGV[1], n .. '-0', 'event', ARGV[2])
+return n
+`)
+
+var (
+	idleFeeds = metrics.NewCounter("app_events_idle_closed_total",
+		"Event feed connections closed after FeedPolicy.IdleTimeout without an event.")
+	eventGaps = metrics.NewCounter("app_events_resume_gaps_total",
+		"Resumed feed connections that missed events no longer in the history.")
+)
+
+// feedState ends the feeds of a replica shutting down.
+type feedState struct {
+	mu      sync.Mutex
+	closing chan struct{}
+}
+
+// feedsClosing is closed by CloseFeeds.
+func (app *App) feedsClosing() <-chan struct{} {
+	app.feeds.mu.Lock()
+	defer app.feeds.mu.Unlock()
+	if app.feeds.closing == nil {
+		app.feeds.closing = make(chan struct{})
+	}
+	return app.feeds.closing
+}
+
+// CloseFeeds ends the /api/events connections of this replica after an
+// event: disconnect with reason shutdown, so that clients reconnect to
+// another one. http.Server.Shutdown waits for them otherwise, as for any
+// request in flight.
+func (app *App) CloseFeeds() {
+	app.feedsClosing()
+	app.feeds.mu.Lock()
+	defer app.feeds.mu.Unlock()
+	select {
+	case <-app.feeds.closing:
+	default:
+		close(app.feeds.closing)
+	}
+}
+
+// FeedPolicy controls the liveness of /api/events connections.
+type FeedPolicy struct {
+	// Heartbeat is the interval of the comment frames that keep idle
+	// connections open through proxies and detect vanished clients, 0 for
+	// none.
+	Heartbeat time.Duration
+	// IdleTimeout closes connections that received no event for this long,
+	// 0 to keep them open. Heartbeats do not count as events.
+	IdleTimeout time.Duration
+	// History is roughly how many recent events are kept for clients to
+	// resume from, defaultEventHistory when 0.
+	History int64
+}
+
+// Event types.
+const (
+	eventDataCreated    = "data.created"
+	eventDataUpdated    = "data.updated"
+	eventDataDeleted    = "data.deleted"
+	eventDataBatch      = "data.batch"
+	eventCommentCreated = "comment.created"
+	eventCommentDeleted = "comment.deleted"
+)
+
+// publishEvent announces a change made for the caller's tenant. Failures
+// are only logged: the feed is best effort and never fails a write. The
+// data is encoded once for every subscriber, so under app.JSON without
+// the per-request overrides.
+func (app *App) publishEvent(ctx context.Context, typ string, data any) {
+	e := events.Event{Type: typ}
+	if tenant := tenantFrom(ctx); tenant != nil {
+		e.Tenant = tenant.ID
+	}
+	var err error
+	if e.Data, err = jsonpolicy.Marshal(data, app.JSON); err != nil {
+		log.Printf("Event %s not published: %v", typ, err)
+		return
+	}
+	history := app.Feed.History
+	if history <= 0 {
+		history = defaultEventHistory
+	}
+	b, _ := json.Marshal(e)
+	if err := appendEvent.Run(ctx, app.Rds, []string{EventsStream, eventsSeqKey}, history, b).Err(); err != nil {
+		log.Printf("Event %s not published: %v", typ, err)
+	}
+}
+
+// decodeEvent reads an event back from its stream entry.
+func decodeEvent(msg redis.XMessage) (events.Event, error) {
+	var e events.Event
+	id, _, _ := strings.Cut(msg.ID, "-")
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return e, fmt.Errorf("invalid event ID %q", msg.ID)
+	}
+	payload, _ := msg.Values["event"].(string)
+	if err := json.Unmarshal([]byte(payload), &e); err != nil {
+		return e, fmt.Errorf("event %s: %v", msg.ID, err)
+	}
+	e.ID = n
+	return e, nil
+}
+
+// RunEvents relays the events appended by every replica to the
+// subscribers of app.Events until ctx is done.
+func (app *App) RunEvents(ctx context.Context) {
+	// Start after the newest event; older ones are only replayed on request
+	last := ""
+	for last == "" {
+		latest, err := app.Rds.XRevRangeN(ctx, EventsStream, "+", "-", 1).Result()
+		switch {
+		case err == nil && len(latest) > 0:
+			last = latest[0].ID
+		case err == nil:
+			last = "0-0"
+		default:
+			log.Printf("Event feed: %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}
+
+	for ctx.Err() == nil {
+		streams, err := app.Rds.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{EventsStream, last},
+			Count:   100,
+			Block:   eventsReadBlock,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Event feed: %v", err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Second):
+				}
+			}
+			continue
+		}
+		for _, msg := range streams[0].Messages {
+			last = msg.ID
+			e, err := decodeEvent(msg)
+			if err != nil {
+				log.Printf("Ignoring malformed event: %v", err)
+				continue
+			}
+			app.Events.Publish(e)
+		}
+	}
+}
+
+// eventGap describes events a resuming client can no longer receive.
+type eventGap struct {
+	LastEventID int64 `json:"last_event_id"`
+	// Missed is how many events were trimmed from the history, absent
+	// when the history was reset, e.g. by a Redis restart, and the count
+	// is unknown.
+	Missed int64 `json:"missed,omitempty"`
+}
+
+// eventsAfter returns the retained events with an ID above after, and
+// the gap between after and the first of them, nil if there is none.
+func (app *App) eventsAfter(ctx context.Context, after int64) ([]events.Event, *eventGap, error) {
+	current, err := app.Rds.Get(ctx, eventsSeqKey).Int64()
+	if err != nil && err != redis.Nil {
+		return nil, nil, err
+	}
+
+	start := fmt.Sprintf("%d-0", after+1)
+	var gap *eventGap
+	if after > current {
+		// The client saw IDs that were never handed out since a reset
+		start = "-"
+		gap = &eventGap{LastEventID: after}
+	}
+	msgs, err := app.Rds.XRange(ctx, EventsStream, start, "+").Result()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	evs := make([]events.Event, 0, len(msgs))
+	for _, msg := range msgs {
+		e, err := decodeEvent(msg)
+		if err != nil {
+			log.Printf("Ignoring malformed event: %v", err)
+			continue
+		}
+		evs = append(evs, e)
+	}
+
+	if gap == nil && after < current {
+		// IDs are consecutive, so anything between after and the oldest
+		// retained event was trimmed
+		oldest := current + 1
+		if len(evs) > 0 {
+			oldest = evs[0].ID
+		}
+		if missed := oldest - after - 1; missed > 0 {
+			gap = &eventGap{LastEventID: after, Missed: missed}
+		}
+	}
+	return evs, gap, nil
+}
+
+// EventsHandler streams the change events of the caller's tenant as
+// server-sent events. ?buffer= and ?on_slow= override the buffer size and
+// slow consumer policy of the connection, to simulate slow clients.
+func (app *App) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, r, "GET")
+		return
+	}
+	if app.Events == nil {
+		http.Error(w, "Event feed is disabled", http.StatusNotFound)
+		return
+	}
+
+	buffer := 0
+	if v := r.URL.Query().Get("buffer"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > app.Events.Buffer {
+			http.Error(w, fmt.Sprintf("buffer must be between 1 and %d", app.Events.Buffer), http.StatusBadRequest)
+			return
+		}
+		buffer = n
+	}
+	// EventSource sends Last-Event-ID on reconnects; the parameter lets a
+	// client resume on its first connection too
+	resume := r.Header.Get("Last-Event-ID")
+	if v := r.URL.Query().Get("last_event_id"); v != "" {
+		resume = v
+	}
+	var after int64 = -1
+	if resume != "" {
+		n, err := strconv.ParseInt(resume, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("Invalid last event ID %q", resume), http.StatusBadRequest)
+			return
+		}
+		after = n
+	}
+	tenant := 0
+	if t := tenantFrom(r.Context()); t != nil {
+		tenant = t.ID
+	}
+	sub, err := app.Events.Subscribe(buffer, r.URL.Query().Get("on_slow"), func(e events.Event) bool {
+		return e.Tenant == tenant
+	})
+	if errors.Is(err, events.ErrTooManySubscribers) {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Too many event feed connections", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer app.Events.Unsubscribe(sub)
+
+	// Subscribed first, so nothing falls between the replay and the live
+	// events; live events the replay already sent are skipped by ID
+	var replay []events.Event
+	var gap *eventGap
+	if after >= 0 {
+		if replay, gap, err = app.eventsAfter(r.Context(), after); err != nil {
+			http.Error(w, fmt.Sprintf("Event history error: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	// The server's write timeout is meant for ordinary responses; the feed
+	// stays open until the client, IdleTimeout or a slow consumer ends it
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keep proxies such as nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if gap != nil {
+		eventGaps.Inc()
+		// Under the events' policy, so the feed uses one convention
+		b, _ := jsonpolicy.Marshal(gap, app.JSON)
+		fmt.Fprintf(w, "event: gap\ndata: %s\n\n", b)
+	}
+	sent := after
+	for _, e := range replay {
+		if e.Tenant == tenant {
+			writeEvent(w, e)
+		}
+		sent = e.ID
+	}
+	rc.Flush()
+
+	var heartbeat, idle <-chan time.Time
+	if app.Feed.Heartbeat > 0 {
+		ticker := time.NewTicker(app.Feed.Heartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	var idleTimer *time.Timer
+	if app.Feed.IdleTimeout > 0 {
+		idleTimer = time.NewTimer(app.Feed.IdleTimeout)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+
+	closing := app.feedsClosing()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-closing:
+			fmt.Fprint(w, "event: disconnect\ndata: {\"reason\":\"shutdown\"}\n\n")
+			rc.Flush()
+			return
+		case <-heartbeat:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-idle:
+			idleFeeds.Inc()
+			fmt.Fprint(w, "event: disconnect\ndata: {\"reason\":\"idle\"}\n\n")
+			rc.Flush()
+			return
+		case <-sub.Done():
+			fmt.Fprint(w, "event: disconnect\ndata: {\"reason\":\"slow consumer\"}\n\n")
+			rc.Flush()
+			return
+		case e := <-sub.Events():
+			if e.ID <= sent {
+				continue
+			}
+			sent = e.ID
+			// Tell the client what it missed before what comes next
+			if n := sub.TakeDropped(); n > 0 {
+				fmt.Fprintf(w, "event: dropped\ndata: {\"count\":%d}\n\n", n)
+			}
+			writeEvent(w, e)
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			if idleTimer != nil {
+				idleTimer.Reset(app.Feed.IdleTimeout)
+			}
+		}
+	}
+}
+
+// writeEvent writes e as a server-sent event whose ID a reconnecting
+// client sends back as Last-Event-ID.
+func writeEvent(w http.ResponseWriter, e events.Event) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data)
+}
+
+// eventData is a row as announced on the feed, without its secret.
+func eventData(data types.TestData) types.TestData {
+	data.Secret = ""
+	return data
+}
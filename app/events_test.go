@@ -0,0 +1,237 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/events"
+	"github.com/nesymno/run-tests-example/jsonpolicy"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// readEvent reads one server-sent event as "id type data", leaving out
+// the id when there is none.
+func readEvent(t *testing.T, lines *bufio.Reader) string {
+	t.Helper()
+	var id, typ, data string
+	for {
+		line, err := lines.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && typ != "" && id != "":
+			return id + " " + typ + " " + data
+		case line == "" && typ != "":
+			return typ + " " + data
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			typ = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestRunEventsRelaysNewEvents(t *testing.T) {
+	mr := miniredis.RunT(t)
+	app := &App{
+		Rds:    redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		Events: events.NewBroadcaster(8, events.DropOldest),
+	}
+	sub, err := app.Events.Subscribe(0, "", nil)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go app.RunEvents(ctx)
+
+	// Events appended before the relay started are not relayed, so keep
+	// publishing until one is
+	require.Eventually(t, func() bool {
+		app.publishEvent(ctx, eventDataCreated, map[string]int{"id": 1})
+		select {
+		case e := <-sub.Events():
+			assert.Equal(t, eventDataCreated, e.Type)
+			assert.Positive(t, e.ID)
+			return true
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestEventsResumeAfterLastEventID(t *testing.T) {
+	mr := miniredis.RunT(t)
+	app := &App{
+		Rds:    redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		Events: events.NewBroadcaster(8, events.DropOldest),
+	}
+	ctx := context.Background()
+	tenantCtx := context.WithValue(ctx, tenantKey{}, &types.Tenant{ID: 3})
+	app.publishEvent(ctx, eventDataCreated, eventData(types.TestData{ID: 1, Name: "one", Secret: "hidden"}))
+	app.publishEvent(tenantCtx, eventCommentCreated, map[string]int{"id": 1})
+	app.publishEvent(ctx, eventDataCreated, eventData(types.TestData{ID: 2, Name: "two"}))
+	app.publishEvent(ctx, eventDataCreated, eventData(types.TestData{ID: 3, Name: "three"}))
+
+	srv := httptest.NewServer(http.HandlerFunc(app.EventsHandler))
+	defer srv.Close()
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Event 2 belongs to another tenant
+	lines := bufio.NewReader(resp.Body)
+	assert.Equal(t, `3 data.created {"id":2,"name":"two","data":"","tags":null,"status":""}`, readEvent(t, lines))
+	assert.Equal(t, `4 data.created {"id":3,"name":"three","data":"","tags":null,"status":""}`, readEvent(t, lines))
+
+	// Live events continue after the replay
+	app.Events.Publish(events.Event{ID: 4, Type: eventDataCreated, Data: []byte(`"duplicate"`)})
+	app.Events.Publish(events.Event{ID: 5, Type: eventDataCreated, Data: []byte(`"live"`)})
+	assert.Equal(t, `5 data.created "live"`, readEvent(t, lines), "replayed events are not repeated")
+}
+
+func TestEventsReportGaps(t *testing.T) {
+	mr := miniredis.RunT(t)
+	app := &App{
+		Rds:    redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		Events: events.NewBroadcaster(8, events.DropOldest),
+	}
+	ctx := context.Background()
+	for i := range 5 {
+		app.publishEvent(ctx, eventDataCreated, i)
+	}
+	// Trim like MAXLEN would once the history is full
+	require.NoError(t, app.Rds.XTrimMaxLen(ctx, EventsStream, 2).Err())
+
+	evs, gap, err := app.eventsAfter(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, &eventGap{LastEventID: 1, Missed: 2}, gap)
+	require.Len(t, evs, 2)
+	assert.Equal(t, int64(4), evs[0].ID)
+
+	_, gap, err = app.eventsAfter(ctx, 3)
+	require.NoError(t, err)
+	assert.Nil(t, gap, "nothing after 3 was trimmed")
+
+	_, gap, err = app.eventsAfter(ctx, 5)
+	require.NoError(t, err)
+	assert.Nil(t, gap)
+
+	// The history was reset: everything retained is replayed
+	evs, gap, err = app.eventsAfter(ctx, 99)
+	require.NoError(t, err)
+	assert.Equal(t, &eventGap{LastEventID: 99}, gap)
+	assert.Len(t, evs, 2)
+
+	srv := httptest.NewServer(http.HandlerFunc(app.EventsHandler))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "?last_event_id=0")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	lines := bufio.NewReader(resp.Body)
+	assert.Equal(t, `gap {"last_event_id":0,"missed":3}`, readEvent(t, lines))
+	assert.Equal(t, `4 data.created 3`, readEvent(t, lines))
+}
+
+func TestEventsFollowJSONPolicy(t *testing.T) {
+	mr := miniredis.RunT(t)
+	app := &App{
+		Rds:    redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		Events: events.NewBroadcaster(8, events.DropOldest),
+		JSON:   jsonpolicy.Policy{Naming: jsonpolicy.CamelCase, Nulls: jsonpolicy.NullsOmit},
+	}
+	ctx := context.Background()
+	app.publishEvent(ctx, eventDataCreated, eventData(types.TestData{ID: 1, Name: "one"}))
+	app.publishEvent(ctx, eventCommentDeleted, map[string]int{"id": 1, "data_id": 1})
+	app.publishEvent(ctx, eventDataCreated, eventData(types.TestData{ID: 2, Name: "two"}))
+	require.NoError(t, app.Rds.XTrimMaxLen(ctx, EventsStream, 2).Err())
+
+	srv := httptest.NewServer(http.HandlerFunc(app.EventsHandler))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "?last_event_id=0")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	lines := bufio.NewReader(resp.Body)
+	assert.Equal(t, `gap {"lastEventId":0,"missed":1}`, readEvent(t, lines))
+	assert.Equal(t, `2 comment.deleted {"data_id":1,"id":1}`, readEvent(t, lines), "map keys are data")
+	assert.Equal(t, `3 data.created {"id":2,"name":"two","data":"","status":""}`, readEvent(t, lines))
+}
+
+func TestEventsHandlerValidatesOverrides(t *testing.T) {
+	app := &App{Events: events.NewBroadcaster(8, events.DropOldest)}
+	for _, query := range []string{"buffer=0", "buffer=9", "buffer=x", "on_slow=block"} {
+		rec := httptest.NewRecorder()
+		app.EventsHandler(rec, httptest.NewRequest("GET", "/api/events?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+	}
+	assert.Equal(t, 0, app.Events.Len())
+
+	rec := httptest.NewRecorder()
+	(&App{}).EventsHandler(rec, httptest.NewRequest("GET", "/api/events", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestEventsHeartbeatAndIdleTimeout(t *testing.T) {
+	app := &App{
+		Events: events.NewBroadcaster(8, events.DropOldest),
+		Feed:   FeedPolicy{Heartbeat: 20 * time.Millisecond, IdleTimeout: 200 * time.Millisecond},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(app.EventsHandler))
+	defer srv.Close()
+
+	start := time.Now()
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	lines := bufio.NewReader(resp.Body)
+	line, err := lines.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, ": heartbeat\n", line)
+
+	// Heartbeats keep the connection open but do not count as activity
+	assert.Equal(t, `disconnect {"reason":"idle"}`, readEvent(t, lines))
+	assert.GreaterOrEqual(t, time.Since(start), app.Feed.IdleTimeout)
+	require.Eventually(t, func() bool { return app.Events.Len() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestEventsRejectsConnectionsOverLimit(t *testing.T) {
+	app := &App{Events: events.NewBroadcaster(8, events.DropOldest)}
+	app.Events.MaxSubscribers = 1
+	_, err := app.Events.Subscribe(0, "", nil)
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	app.EventsHandler(rec, httptest.NewRequest("GET", "/api/events", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "5", rec.Header().Get("Retry-After"))
+}
+
+func TestCloseFeedsDisconnectsSubscribers(t *testing.T) {
+	app := &App{Events: events.NewBroadcaster(8, events.DropOldest)}
+	srv := httptest.NewServer(http.HandlerFunc(app.EventsHandler))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Eventually(t, func() bool { return app.Events.Len() == 1 }, time.Second, 5*time.Millisecond)
+
+	app.CloseFeeds()
+	app.CloseFeeds()
+	assert.Equal(t, `disconnect {"reason":"shutdown"}`, readEvent(t, bufio.NewReader(resp.Body)))
+	require.Eventually(t, func() bool { return app.Events.Len() == 0 }, time.Second, 10*time.Millisecond)
+}
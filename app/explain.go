@@ -0,0 +1,73 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DebugExplainHandler runs EXPLAIN (ANALYZE, BUFFERS) for one of the app's
+// queries and returns the JSON plan. ?query= selects the query ("list") and
+// ?filters= takes comma-separated key:value pairs, e.g. tag:alpha,status:active.
+func (app *App) DebugExplainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, r, "GET")
+		return
+	}
+
+	var query string
+	var args []any
+
+	switch r.URL.Query().Get("query") {
+	case "list":
+		filter, err := parseFilters(r.URL.Query().Get("filters"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query, args = listDataQuery, filter.args()
+	default:
+		http.Error(w, "Unknown query (supported: list)", http.StatusBadRequest)
+		return
+	}
+
+	var plan json.RawMessage
+	err := app.DB.QueryRowContext(r.Context(),
+		"EXPLAIN (ANALYZE, BUFFERS, FORMAT JSON) "+query, args...).Scan(&plan)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Explain error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	w.Write(plan)
+}
+
+func parseFilters(raw string) (dataFilter, error) {
+	var filter dataFilter
+	if raw == "" {
+		return filter, nil
+	}
+
+	for _, pair := range strings.Split(raw, ",") {
+		key, value, ok := strings.Cut(pair, ":")
+		if !ok {
+			return filter, fmt.Errorf("Invalid filter %q, expected key:value", pair)
+		}
+		switch key {
+		case "tag":
+			filter.Tag = value
+		case "status":
+			if !validStatus(value) {
+				return filter, fmt.Errorf("Invalid status %q", value)
+			}
+			filter.Status = value
+		case "test_run_id":
+			filter.TestRun = value
+		default:
+			return filter, fmt.Errorf("Unknown filter %q (supported: tag, status, test_run_id)", key)
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,35 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFilters(t *testing.T) {
+	filter, err := parseFilters("tag:alpha,status:archived")
+	require.NoError(t, err)
+	assert.Equal(t, dataFilter{Tag: "alpha", Status: "archived"}, filter)
+
+	filter, err = parseFilters("")
+	require.NoError(t, err)
+	assert.Equal(t, dataFilter{}, filter)
+
+	_, err = parseFilters("status:deleted")
+	assert.Error(t, err)
+	_, err = parseFilters("owner:me")
+	assert.Error(t, err)
+	_, err = parseFilters("tag")
+	assert.Error(t, err)
+}
+
+func TestFilterNormalizationIgnoresParameterOrder(t *testing.T) {
+	a, err := parseFilters("tag:alpha,status:active")
+	require.NoError(t, err)
+	b, err := parseFilters("status:active,tag:alpha")
+	require.NoError(t, err)
+
+	assert.Equal(t, a.normalized(), b.normalized())
+	assert.NotEqual(t, a.normalized(), dataFilter{Tag: "alpha"}.normalized())
+}
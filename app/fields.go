@@ -0,0 +1,71 @@
+package app
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// dataField is a selectable field of types.TestData.
+type dataField struct {
+	index     int
+	omitEmpty bool
+}
+
+// dataFields maps the JSON names of types.TestData to their fields.
+var dataFields = func() map[string]dataField {
+	t := reflect.TypeFor[types.TestData]()
+	fields := map[string]dataField{}
+	for i := range t.NumField() {
+		name, opts, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = dataField{
+			index:     i,
+			omitEmpty: strings.Contains(opts, "omitempty") || strings.Contains(opts, "omitzero"),
+		}
+	}
+	return fields
+}()
+
+// parseFields parses a ?fields= list of types.TestData JSON names into a
+// sorted set, nil for every field.
+func parseFields(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var fields []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := dataFields[name]; !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		fields = append(fields, name)
+	}
+	slices.Sort(fields)
+	return slices.Compact(fields), nil
+}
+
+// projectData reduces rows to the selected fields. Empty optional fields
+// are left out as they would be from the full row.
+func projectData(rows []types.TestData, fields []string) []map[string]any {
+	projected := make([]map[string]any, len(rows))
+	for i := range rows {
+		v := reflect.ValueOf(rows[i])
+		m := make(map[string]any, len(fields))
+		for _, name := range fields {
+			f := dataFields[name]
+			fv := v.Field(f.index)
+			if f.omitEmpty && (fv.IsZero() || fv.Kind() == reflect.Slice && fv.Len() == 0) {
+				continue
+			}
+			m[name] = fv.Interface()
+		}
+		projected[i] = m
+	}
+	return projected
+}
@@ -0,0 +1,67 @@
+package app
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"time"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// DebugGCHandler reports GC statistics. POST forces a collection first and
+// is restricted to admins.
+func (app *App) DebugGCHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		app.writeGCStats(w, r)
+	case "POST":
+		app.RequireAdmin(func(w http.ResponseWriter, r *http.Request) {
+			runtime.GC()
+			debug.FreeOSMemory()
+			app.writeGCStats(w, r)
+		})(w, r)
+	default:
+		methodNotAllowed(w, r, "GET", "POST")
+	}
+}
+
+func (app *App) writeGCStats(w http.ResponseWriter, r *http.Request) {
+	setJSONContentType(w)
+	app.writeJSON(w, r, readGCStats())
+}
+
+func readGCStats() types.GCStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+
+	// There is no getter for the GC percent in runtime/debug, and swapping
+	// it out to read it races with concurrent readers
+	sample := []metrics.Sample{{Name: "/gc/gogc:percent"}}
+	metrics.Read(sample)
+	gcPercent := int(int64(sample[0].Value.Uint64()))
+
+	response := types.GCStats{
+		NumGC:         mem.NumGC,
+		NumForcedGC:   mem.NumForcedGC,
+		PauseTotal:    gc.PauseTotal.String(),
+		HeapAlloc:     mem.HeapAlloc,
+		HeapInuse:     mem.HeapInuse,
+		HeapSys:       mem.HeapSys,
+		NextGC:        mem.NextGC,
+		Sys:           mem.Sys,
+		GCPercent:     gcPercent,
+		MemoryLimit:   debug.SetMemoryLimit(-1),
+		Goroutines:    runtime.NumGoroutine(),
+		GCCPUFraction: mem.GCCPUFraction,
+		Timestamp:     time.Now(),
+	}
+	if !gc.LastGC.IsZero() {
+		response.LastGC = &gc.LastGC
+	}
+	return response
+}
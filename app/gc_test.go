@@ -0,0 +1,30 @@
+package app
+
+import (
+	"runtime/debug"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadGCStatsLeavesGCPercentAlone(t *testing.T) {
+	old := debug.SetGCPercent(150)
+	t.Cleanup(func() { debug.SetGCPercent(old) })
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				assert.Equal(t, 150, readGCStats().GCPercent)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 150, debug.SetGCPercent(150), "concurrent reads changed the GC percent")
+
+	debug.SetGCPercent(-1)
+	assert.Equal(t, -1, readGCStats().GCPercent, "GC off")
+}
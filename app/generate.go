@@ -0,0 +1,68 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nesymno/run-tests-example/generator"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// GenerateHandler seeds test_data with a deterministic dataset chosen by
+// ?profile= (default small) and ?seed= (default 1).
+func (app *App) GenerateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, r, "POST")
+		return
+	}
+
+	name := r.URL.Query().Get("profile")
+	if name == "" {
+		name = "small"
+	}
+	profile, err := generator.Lookup(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	seed := uint64(1)
+	if v := r.URL.Query().Get("seed"); v != "" {
+		seed, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid seed", http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := app.Seed(r.Context(), profile, seed)
+	if err != nil {
+		writeDBError(w, "Generate error", err)
+		return
+	}
+
+	setJSONContentType(w)
+	app.writeJSONStatus(w, r, http.StatusCreated, result)
+}
+
+// Seed loads a generated dataset and invalidates the list cache.
+func (app *App) Seed(ctx context.Context, profile generator.Profile, seed uint64) (*types.SeedResult, error) {
+	start := time.Now()
+	inserted, checksum, err := generator.Load(ctx, app.DB, profile.Rows, seed)
+	if err != nil {
+		return nil, err
+	}
+
+	app.invalidateList(ctx)
+
+	return &types.SeedResult{
+		Profile:  profile.Name,
+		Rows:     profile.Rows,
+		Inserted: inserted,
+		Seed:     seed,
+		Checksum: checksum,
+		Duration: time.Since(start).String(),
+	}, nil
+}
@@ -0,0 +1,176 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nesymno/run-tests-example/cache"
+	"github.com/nesymno/run-tests-example/metrics"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// healthCheckTimeout bounds each round of dependency checks.
+const healthCheckTimeout = 2 * time.Second
+
+var healthProbes = metrics.NewCounterVec("app_health_probes_total",
+	"/health and /readyz probes by whether their dependency checks were cached or run for them.", "source")
+
+// HealthCheckPolicy caches the dependency checks of /health and /readyz,
+// so that a storm of probes costs a round of pings to PostgreSQL and Redis
+// per TTL rather than one per probe, and does not take connections from
+// requests.
+type HealthCheckPolicy struct {
+	// TTL is how long a round of checks answers probes, 0 to check on
+	// every probe. RunHealthChecks refreshes it before it expires, so
+	// probes do not wait for the checks.
+	TTL time.Duration
+}
+
+// healthChecks is the outcome of one round of dependency checks.
+type healthChecks struct {
+	at        time.Time
+	db        error
+	redis     error
+	listCache error
+	// memory is nil when the Redis memory stats could not be read
+	memory    *types.CacheMemory
+	queues    types.QueueReport
+	queuesErr error
+}
+
+type healthState struct {
+	mu     sync.Mutex
+	last   *healthChecks
+	flight flightGroup[*healthChecks]
+}
+
+// healthChecks returns the last round of checks if younger than the TTL,
+// and otherwise runs one, shared by every probe asking meanwhile. force
+// runs one regardless, as /readyz?force=true does.
+func (app *App) healthChecks(ctx context.Context, force bool) *healthChecks {
+	if !force && app.HealthChecks.TTL > 0 {
+		app.health.mu.Lock()
+		last := app.health.last
+		app.health.mu.Unlock()
+		if last != nil && time.Since(last.at) < app.HealthChecks.TTL {
+			healthProbes.With("cache").Inc()
+			return last
+		}
+	}
+	healthProbes.With("check").Inc()
+	return app.refreshHealthChecks(ctx)
+}
+
+// refreshHealthChecks runs a round of checks and caches it. The round
+// outlives a probe that gives up on it, as others may be waiting for it.
+func (app *App) refreshHealthChecks(ctx context.Context) *healthChecks {
+	checks, _, _ := app.health.flight.Do("checks", func() (*healthChecks, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), healthCheckTimeout)
+		defer cancel()
+		checks := app.checkDependencies(ctx)
+
+		app.health.mu.Lock()
+		app.health.last = checks
+		app.health.mu.Unlock()
+		return checks, nil
+	})
+	return checks
+}
+
+func (app *App) checkDependencies(ctx context.Context) *healthChecks {
+	checks := &healthChecks{at: time.Now()}
+	checks.db = app.DB.PingContext(ctx)
+	checks.redis = app.Rds.Ping(ctx).Err()
+	if checks.redis != nil {
+		return checks
+	}
+	// Unless CACHE_BACKEND moved it to memcached, this is Redis again
+	checks.listCache = app.ListCache.Ping(ctx)
+	if memory, err := cache.MemoryStats(ctx, app.Rds); err == nil {
+		checks.memory = &memory
+	}
+	checks.queues, checks.queuesErr = app.queueReport(ctx)
+	return checks
+}
+
+// RunHealthChecks refreshes the cached dependency checks twice per TTL
+// until ctx is done, so that probes find them fresh. It does nothing when
+// the TTL is not positive.
+func (app *App) RunHealthChecks(ctx context.Context) {
+	if app.HealthChecks.TTL <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(app.HealthChecks.TTL / 2)
+	defer ticker.Stop()
+
+	for {
+		app.refreshHealthChecks(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// MarkStarted makes /startupz succeed, once the app is done initializing
+// and about to serve.
+func (app *App) MarkStarted() {
+	app.started.Store(true)
+}
+
+// LivenessHandler serves /healthz, which only reflects the health of the
+// process: a replica whose dependencies are down is not helped by a
+// restart, so they are left to /readyz.
+func (app *App) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	setJSONContentType(w)
+	app.writeJSON(w, r, types.Readiness{Status: "alive"})
+}
+
+// StartupHandler serves /startupz, which fails until MarkStarted, so that
+// liveness and readiness probes wait for the app to start.
+func (app *App) StartupHandler(w http.ResponseWriter, r *http.Request) {
+	setJSONContentType(w)
+	if !app.started.Load() {
+		app.writeJSONStatus(w, r, http.StatusServiceUnavailable, types.Readiness{Status: "starting"})
+		return
+	}
+	app.writeJSON(w, r, types.Readiness{Status: "started"})
+}
+
+// StartingHandler serves the process while it connects to its
+// dependencies, before there is an App to serve: /health reports the
+// status "starting", /healthz succeeds, /readyz and /startupz fail, and
+// any other request is answered 503 with a Retry-After.
+func StartingHandler(profile string) http.Handler {
+	app := &App{Profile: profile}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", app.LivenessHandler)
+	mux.HandleFunc("GET /startupz", app.StartupHandler)
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		setJSONContentType(w)
+		app.writeJSONStatus(w, r, http.StatusServiceUnavailable, types.Readiness{
+			Status:  "starting",
+			Reasons: []string{"connecting to the database and Redis"},
+		})
+	})
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		setJSONContentType(w)
+		app.writeJSON(w, r, types.HealthResponse{
+			Status:    "starting",
+			Timestamp: time.Now(),
+			Version:   "1.0.0",
+			Database:  "starting",
+			Cache:     "starting",
+			Profile:   profile,
+		})
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Service starting", http.StatusServiceUnavailable)
+	})
+	return mux
+}
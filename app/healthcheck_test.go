@@ -0,0 +1,151 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/cache"
+	"github.com/nesymno/run-tests-example/types"
+	"github.com/nesymno/run-tests-example/worker"
+)
+
+// pingServer is a database that only answers pings, and counts them.
+type pingServer struct {
+	pings atomic.Int64
+	down  atomic.Bool
+}
+
+func (s *pingServer) Connect(context.Context) (driver.Conn, error) { return pingConn{s}, nil }
+func (s *pingServer) Driver() driver.Driver                        { return nil }
+
+type pingConn struct{ server *pingServer }
+
+func (c pingConn) Ping(context.Context) error {
+	c.server.pings.Add(1)
+	if c.server.down.Load() {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (pingConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (pingConn) Close() error                        { return nil }
+func (pingConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+func healthApp(t *testing.T, ttl time.Duration) (*App, *pingServer) {
+	server := &pingServer{}
+	db := sql.OpenDB(server)
+	t.Cleanup(func() { db.Close() })
+	mr := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rds.Close() })
+	return &App{
+		DB:           db,
+		Rds:          rds,
+		ListCache:    cache.NewQueryCache(rds, "list", time.Minute),
+		Jobs:         worker.New(rds),
+		HealthChecks: HealthCheckPolicy{TTL: ttl},
+	}, server
+}
+
+func probe(handler http.HandlerFunc, path string) int {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", path, nil))
+	return w.Code
+}
+
+func TestProbeStormSharesOneRoundOfChecks(t *testing.T) {
+	app, db := healthApp(t, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler, path := app.ReadyHandler, "/readyz"
+			if i%2 == 0 {
+				handler, path = app.HealthHandler, "/health"
+			}
+			assert.Equal(t, http.StatusOK, probe(handler, path))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(1), db.pings.Load())
+}
+
+func TestForcedReadinessBypassesTheCache(t *testing.T) {
+	app, db := healthApp(t, time.Hour)
+	require.Equal(t, http.StatusOK, probe(app.ReadyHandler, "/readyz"))
+
+	db.down.Store(true)
+	assert.Equal(t, http.StatusOK, probe(app.ReadyHandler, "/readyz"), "cached")
+	assert.Equal(t, int64(1), db.pings.Load())
+
+	assert.Equal(t, http.StatusServiceUnavailable, probe(app.ReadyHandler, "/readyz?force=true"))
+	assert.Equal(t, int64(2), db.pings.Load())
+	assert.Equal(t, http.StatusServiceUnavailable, probe(app.ReadyHandler, "/readyz"),
+		"the forced checks are cached for the next probes")
+}
+
+func TestHealthChecksUncachedWithoutTTL(t *testing.T) {
+	app, db := healthApp(t, 0)
+	for range 3 {
+		probe(app.ReadyHandler, "/readyz")
+	}
+	assert.Equal(t, int64(3), db.pings.Load())
+}
+
+func TestHealthChecksRefreshInTheBackground(t *testing.T) {
+	app, db := healthApp(t, 40*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go app.RunHealthChecks(ctx)
+
+	require.Eventually(t, func() bool { return db.pings.Load() > 0 }, time.Second, time.Millisecond)
+	db.down.Store(true)
+
+	// Probes see the outage within a TTL or so, found by the background
+	// refresh
+	require.Eventually(t, func() bool {
+		return probe(app.ReadyHandler, "/readyz") == http.StatusServiceUnavailable
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, http.StatusServiceUnavailable, probe(app.ReadyHandler, "/readyz"))
+}
+
+func TestProbesWhileDatabaseIsDown(t *testing.T) {
+	app, db := healthApp(t, 0)
+	db.down.Store(true)
+
+	assert.Equal(t, http.StatusOK, probe(app.LivenessHandler, "/healthz"), "liveness ignores dependencies")
+	assert.Equal(t, http.StatusServiceUnavailable, probe(app.ReadyHandler, "/readyz"))
+
+	w := httptest.NewRecorder()
+	app.HealthHandler(w, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var health types.HealthResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &health))
+	assert.Equal(t, "unhealthy", health.Status)
+	assert.Equal(t, "unhealthy", health.Database)
+	assert.Equal(t, "healthy", health.Cache)
+}
+
+func TestStartupProbeWaitsForMarkStarted(t *testing.T) {
+	app, _ := healthApp(t, 0)
+	assert.Equal(t, http.StatusServiceUnavailable, probe(app.StartupHandler, "/startupz"))
+	app.MarkStarted()
+	assert.Equal(t, http.StatusOK, probe(app.StartupHandler, "/startupz"))
+}
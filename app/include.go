@@ -0,0 +1,82 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/nesymno/run-tests-example/metrics"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+var includeQueries = metrics.NewCounterVec("app_include_queries_total",
+	"Queries run to expand ?include= resources, by include; one per listing when batched.", "include")
+
+// rowIncludes expand listed rows with a related resource. Each loads the
+// resource for every row with one query, so a listing costs the same
+// number of queries whatever its size. Names match the TestData field
+// they fill, which ?fields= can leave out.
+var rowIncludes = map[string]func(app *App, ctx context.Context, rows []types.TestData) error{
+	"comments": (*App).includeComments,
+}
+
+// includeCacheMetadata reports in headers where a listing was served from.
+// It describes the response rather than its rows, so it is not part of
+// the cached body.
+const includeCacheMetadata = "cache-metadata"
+
+// parseIncludes parses a comma-separated ?include= list into the sorted
+// set of row includes and whether cache metadata is wanted.
+func parseIncludes(s string) (rows []string, cacheMetadata bool, err error) {
+	if s == "" {
+		return nil, false, nil
+	}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == includeCacheMetadata:
+			cacheMetadata = true
+		case rowIncludes[name] != nil:
+			rows = append(rows, name)
+		default:
+			available := append(slices.Sorted(maps.Keys(rowIncludes)), includeCacheMetadata)
+			return nil, false, fmt.Errorf("unknown include %q (available: %s)", name, strings.Join(available, ", "))
+		}
+	}
+	slices.Sort(rows)
+	return slices.Compact(rows), cacheMetadata, nil
+}
+
+// expand resolves the filter's row includes for rows.
+func (app *App) expand(ctx context.Context, filter dataFilter, rows []types.TestData) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	for _, name := range filter.Include {
+		if !filter.selects(name) {
+			continue
+		}
+		includeQueries.With(name).Inc()
+		if err := rowIncludes[name](app, ctx, rows); err != nil {
+			return fmt.Errorf("include %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (app *App) includeComments(ctx context.Context, rows []types.TestData) error {
+	ids := make([]int, len(rows))
+	for i, data := range rows {
+		ids[i] = data.ID
+	}
+	comments, err := app.commentsFor(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for i := range rows {
+		rows[i].Comments = comments[rows[i].ID]
+	}
+	return nil
+}
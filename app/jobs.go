@@ -0,0 +1,284 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nesymno/run-tests-example/jsonpolicy"
+	"github.com/nesymno/run-tests-example/types"
+	"github.com/nesymno/run-tests-example/worker"
+)
+
+const insertDataJob = "insert_test_data"
+
+// RegisterJobs installs the handlers for the job types the app enqueues.
+func (app *App) RegisterJobs() {
+	app.Jobs.Register(insertDataJob, func(ctx context.Context, payload json.RawMessage) error {
+		// Payloads are checked when queued, but not those queued before
+		// they were
+		data, err := app.decodeSealedData(payload)
+		if err == nil {
+			err = app.checkData(&data.TestData)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid payload: %v", err)
+		}
+		_, err = app.insertData(ctx, data)
+		return err
+	})
+}
+
+// checkPayload checks the payload of a job about to be queued or
+// scheduled and returns it as the job's handler takes it. Rows to insert
+// are checked like those of POST /api/data, and their secret sealed.
+func (app *App) checkPayload(typ string, payload json.RawMessage) (json.RawMessage, error) {
+	if typ != insertDataJob {
+		return payload, nil
+	}
+	var data types.TestData
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return nil, fmt.Errorf("Invalid payload: %v", err)
+	}
+	if err := app.checkData(&data); err != nil {
+		return nil, err
+	}
+	sealed, err := app.sealData(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(sealed)
+}
+
+// publicJob returns job as the job endpoints show it: rows queued for
+// insertion leave out their secret, sealed or not.
+func publicJob(job *worker.Job) *worker.Job {
+	if job.Type != insertDataJob {
+		return job
+	}
+	var payload map[string]json.RawMessage
+	if json.Unmarshal(job.Payload, &payload) != nil || (payload["secret"] == nil && payload["sealed_secret"] == nil) {
+		return job
+	}
+	delete(payload, "secret")
+	delete(payload, "sealed_secret")
+	public := *job
+	public.Payload, _ = json.Marshal(payload)
+	return &public
+}
+
+// JobsHandler creates a job, optionally delayed until run_at or for
+// delay_seconds.
+func (app *App) JobsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, r, "POST")
+		return
+	}
+
+	var req types.JobRequest
+	if err := app.decodeJSON(r, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if !app.Jobs.HasHandler(req.Type) {
+		http.Error(w, fmt.Sprintf("Unknown job type %q", req.Type), http.StatusBadRequest)
+		return
+	}
+
+	payload, err := app.checkPayload(req.Type, req.Payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var runAt time.Time
+	if req.RunAt != nil {
+		runAt = *req.RunAt
+	} else if req.DelaySeconds > 0 {
+		runAt = time.Now().Add(time.Duration(req.DelaySeconds) * time.Second)
+	}
+
+	job, err := app.Jobs.EnqueueAt(r.Context(), req.Type, payload, runAt)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Enqueue error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	w.Header().Set("Location", "/api/jobs/"+job.ID)
+	app.writeJSONStatus(w, r, http.StatusAccepted, publicJob(job))
+}
+
+// JobHandler returns (GET) or cancels (DELETE) a single job. Only jobs
+// still waiting for their run time can be canceled.
+func (app *App) JobHandler(w http.ResponseWriter, r *http.Request) {
+	var job *worker.Job
+	var err error
+
+	switch r.Method {
+	case "GET":
+		job, err = app.Jobs.Get(r.Context(), r.PathValue("id"))
+	case "DELETE":
+		job, err = app.Jobs.Cancel(r.Context(), r.PathValue("id"))
+	default:
+		methodNotAllowed(w, r, "GET", "DELETE")
+		return
+	}
+
+	if err == worker.ErrNotCancelable {
+		http.Error(w, "Job is not scheduled", http.StatusConflict)
+		return
+	}
+	if err == worker.ErrNotFound {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Job lookup error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	app.writeJSON(w, r, publicJob(job))
+}
+
+// DeadJobsHandler lists (GET) or purges (DELETE) dead-lettered jobs.
+func (app *App) DeadJobsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case "GET":
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 100
+		}
+
+		jobs, err := app.Jobs.DeadJobs(ctx, limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Job lookup error: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		for i, job := range jobs {
+			jobs[i] = publicJob(job)
+		}
+		setJSONContentType(w)
+		app.writeJSON(w, r, jobs)
+	case "DELETE":
+		purged, err := app.Jobs.PurgeDead(ctx)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Purge error: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		setJSONContentType(w)
+		app.writeJSON(w, r, jsonpolicy.Fields{"purged": purged})
+	default:
+		methodNotAllowed(w, r, "GET", "DELETE")
+	}
+}
+
+// RetryDeadJobHandler requeues a single dead-lettered job.
+func (app *App) RetryDeadJobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, r, "POST")
+		return
+	}
+
+	job, err := app.Jobs.RetryDead(r.Context(), r.PathValue("id"))
+	if err == worker.ErrNotFound {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Retry error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	app.writeJSONStatus(w, r, http.StatusAccepted, publicJob(job))
+}
+
+// SchedulesHandler lists (GET) or creates (POST) recurring jobs.
+func (app *App) SchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case "GET":
+		schedules, err := app.Schedules.List(ctx)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		setJSONContentType(w)
+		app.writeJSON(w, r, schedules)
+	case "POST":
+		var req types.ScheduleRequest
+		if err := app.decodeJSON(r, &req); err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+		if !app.Jobs.HasHandler(req.Type) {
+			http.Error(w, fmt.Sprintf("Unknown job type %q", req.Type), http.StatusBadRequest)
+			return
+		}
+		if req.IntervalSeconds <= 0 {
+			http.Error(w, "interval_seconds must be positive", http.StatusBadRequest)
+			return
+		}
+		payload, err := app.checkPayload(req.Type, req.Payload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sched := &worker.Schedule{
+			Name:     req.Name,
+			Type:     req.Type,
+			Payload:  payload,
+			Interval: req.IntervalSeconds,
+		}
+		if req.StartAt != nil {
+			sched.NextRunAt = *req.StartAt
+		}
+		if err := app.Schedules.Create(ctx, sched); err != nil {
+			http.Error(w, fmt.Sprintf("Insert error: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		setJSONContentType(w)
+		app.writeJSONStatus(w, r, http.StatusCreated, sched)
+	default:
+		methodNotAllowed(w, r, "GET", "POST")
+	}
+}
+
+// ScheduleHandler cancels a recurring job.
+func (app *App) ScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		methodNotAllowed(w, r, "DELETE")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid schedule ID", http.StatusBadRequest)
+		return
+	}
+
+	err = app.Schedules.Delete(r.Context(), id)
+	if err == worker.ErrNotFound {
+		http.Error(w, "Schedule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Delete error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/types"
+	"github.com/nesymno/run-tests-example/worker"
+)
+
+func TestInsertJobsAreCheckedWhenQueued(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rds.Close() })
+	app := &App{Rds: rds, Jobs: worker.New(rds)}
+	app.RegisterJobs()
+
+	post := func(handler http.HandlerFunc, path, payload string) *httptest.ResponseRecorder {
+		body := `{"type":"insert_test_data","interval_seconds":60,"payload":` + payload + `}`
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	for _, payload := range []string{
+		`{"name":"a","status":"deleted"}`,
+		`{"name":"a","test_run_id":"not a run id"}`,
+		`{"name":"a","secret":"hunter2"}`,
+		`"a"`,
+	} {
+		w := post(app.JobsHandler, "/api/jobs", payload)
+		assert.Equal(t, http.StatusBadRequest, w.Code, payload)
+		w = post(app.SchedulesHandler, "/api/schedules", payload)
+		assert.Equal(t, http.StatusBadRequest, w.Code, payload)
+	}
+
+	// Rows that leave out tags and status get the defaults, as the columns
+	// take no NULL or empty status
+	w := post(app.JobsHandler, "/api/jobs", `{"name":"a"}`)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var accepted worker.Job
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&accepted))
+	job, err := app.Jobs.Get(context.Background(), accepted.ID)
+	require.NoError(t, err)
+	var data types.TestData
+	require.NoError(t, json.Unmarshal(job.Payload, &data))
+	assert.Equal(t, []string{}, data.Tags)
+	assert.Equal(t, types.StatusActive, data.Status)
+}
+
+func TestJobHandlerDoesNotFindQueueKeys(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rds.Close() })
+	app := &App{Rds: rds, Jobs: worker.New(rds)}
+	_, err := app.Jobs.EnqueueAt(context.Background(), insertDataJob, types.TestData{Name: "a"}, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	for _, id := range []string{"queue", "delayed", "dead"} {
+		req := httptest.NewRequest("GET", "/api/jobs/"+id, nil)
+		req.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		app.JobHandler(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code, id)
+	}
+}
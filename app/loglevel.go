@@ -0,0 +1,127 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/nesymno/run-tests-example/logging"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// LogLevelKey is the Redis key holding the log level set through
+// /admin/loglevel, as "<level>@<release>". Replicas of another release
+// ignore it, so a level survives restarts and rescheduling but not a
+// redeploy.
+const LogLevelKey = "log_level"
+
+// logLevelSyncInterval is how often replicas pick up a stored level.
+const logLevelSyncInterval = 5 * time.Second
+
+// logLevelState is the stored level last applied by this replica.
+type logLevelState struct {
+	mu     sync.Mutex
+	stored string
+}
+
+// LogLevelHandler reports (GET), sets for every replica (PUT) or resets to
+// the configured default (DELETE) the log level.
+func (app *App) LogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	switch r.Method {
+	case "GET":
+	case "PUT":
+		var req types.LogLevel
+		if err := app.decodeJSON(r, &req); err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+		level, err := logging.ParseLevel(req.Level)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stored := logging.Name(level) + "@" + app.Release
+		if err := app.Rds.Set(ctx, LogLevelKey, stored, 0).Err(); err != nil {
+			http.Error(w, fmt.Sprintf("Redis error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		app.applyStoredLogLevel(stored)
+	case "DELETE":
+		if err := app.Rds.Del(ctx, LogLevelKey).Err(); err != nil {
+			http.Error(w, fmt.Sprintf("Redis error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		app.applyStoredLogLevel("")
+	default:
+		methodNotAllowed(w, r, "GET", "PUT", "DELETE")
+		return
+	}
+
+	setJSONContentType(w)
+	app.writeJSON(w, r, types.LogLevel{
+		Level:   logging.Name(logging.Level()),
+		Default: logging.Name(app.DefaultLogLevel),
+	})
+}
+
+// RunLogLevelSync applies the level stored in Redis, now and whenever it
+// changes, until ctx is done.
+func (app *App) RunLogLevelSync(ctx context.Context) {
+	ticker := time.NewTicker(logLevelSyncInterval)
+	defer ticker.Stop()
+	for {
+		stored, err := app.Rds.Get(ctx, LogLevelKey).Result()
+		if err == redis.Nil {
+			stored = ""
+		}
+		if err == nil || err == redis.Nil {
+			app.applyStoredLogLevel(stored)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// applyStoredLogLevel sets the level from a LogLevelKey value when it
+// differs from the last one applied, so a level changed locally, by a
+// signal, is kept until the stored level changes. An empty value, or one
+// of another release, stands for the default level.
+func (app *App) applyStoredLogLevel(stored string) {
+	app.logLevel.mu.Lock()
+	defer app.logLevel.mu.Unlock()
+
+	name, release, _ := strings.Cut(stored, "@")
+	if release != app.Release {
+		stored = ""
+	}
+	if stored == app.logLevel.stored {
+		return
+	}
+	app.logLevel.stored = stored
+
+	level := app.DefaultLogLevel
+	if stored != "" {
+		var err error
+		if level, err = logging.ParseLevel(name); err != nil {
+			log.Printf("Ignoring stored log level: %v", err)
+			level = app.DefaultLogLevel
+		}
+	}
+	if level != logging.Level() {
+		logging.SetLevel(level)
+		// Logged at the new level at least, so the line is not filtered
+		slog.Log(context.Background(), max(level, slog.LevelInfo), "Log level changed", "level", logging.Name(level))
+	}
+}
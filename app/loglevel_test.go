@@ -0,0 +1,63 @@
+package app
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/logging"
+)
+
+func TestLogLevelHandler(t *testing.T) {
+	defer logging.SetLevel(logging.Level())
+	logging.SetLevel(slog.LevelInfo)
+
+	mr := miniredis.RunT(t)
+	app := &App{Rds: redis.NewClient(&redis.Options{Addr: mr.Addr()}), Release: "r1", DefaultLogLevel: slog.LevelInfo}
+	call := func(method, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		app.LogLevelHandler(rec, httptest.NewRequest(method, "/admin/loglevel", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := call("PUT", `{"level":"debug"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"level":"debug","default":"info"}`, rec.Body.String())
+	assert.Equal(t, slog.LevelDebug, logging.Level())
+	stored, err := mr.Get(LogLevelKey)
+	require.NoError(t, err)
+	assert.Equal(t, "debug@r1", stored)
+
+	assert.Equal(t, http.StatusBadRequest, call("PUT", `{"level":"chatty"}`).Code)
+
+	rec = call("DELETE", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, slog.LevelInfo, logging.Level())
+	assert.False(t, mr.Exists(LogLevelKey))
+}
+
+func TestStoredLogLevelIsScopedToRelease(t *testing.T) {
+	defer logging.SetLevel(logging.Level())
+	logging.SetLevel(slog.LevelInfo)
+	app := &App{Release: "r2", DefaultLogLevel: slog.LevelInfo}
+
+	app.applyStoredLogLevel("debug@r1")
+	assert.Equal(t, slog.LevelInfo, logging.Level(), "levels of an earlier release are ignored")
+
+	app.applyStoredLogLevel("warn@r2")
+	assert.Equal(t, slog.LevelWarn, logging.Level())
+
+	// A local change, as by a signal, is kept until the stored level changes
+	logging.SetLevel(slog.LevelDebug)
+	app.applyStoredLogLevel("warn@r2")
+	assert.Equal(t, slog.LevelDebug, logging.Level())
+	app.applyStoredLogLevel("")
+	assert.Equal(t, slog.LevelInfo, logging.Level())
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// MaintenanceKey is the Redis key that switches every replica into
+// maintenance mode. Its value is the Retry-After hint in seconds, so the
+// mode can also be toggled with redis-cli during drills.
+const MaintenanceKey = "maintenance_mode"
+
+const defaultRetryAfter = 30
+
+// SetReadOnly forces this replica into maintenance mode regardless of the
+// shared Redis switch.
+func (app *App) SetReadOnly(readOnly bool) {
+	app.readOnly.Store(readOnly)
+}
+
+// maintenanceStatus reports whether writes are currently rejected and the
+// Retry-After value to send with the 503.
+func (app *App) maintenanceStatus(ctx context.Context) (bool, int) {
+	if app.readOnly.Load() {
+		return true, defaultRetryAfter
+	}
+
+	value, err := app.Rds.Get(ctx, MaintenanceKey).Result()
+	if err != nil {
+		return false, 0
+	}
+
+	retryAfter, err := strconv.Atoi(value)
+	if err != nil || retryAfter <= 0 {
+		retryAfter = defaultRetryAfter
+	}
+	return true, retryAfter
+}
+
+// MaintenanceMiddleware rejects write requests with 503 while maintenance
+// mode is on. Reads, /health and the admin endpoints keep working.
+func (app *App) MaintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isReadMethod(r.Method) || strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if on, retryAfter := app.maintenanceStatus(r.Context()); on {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(w, "Service in maintenance mode", http.StatusServiceUnavailable)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *App) MaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case "POST":
+		// Enable maintenance mode
+		var req struct {
+			RetryAfter int `json:"retry_after"`
+		}
+		if r.ContentLength != 0 {
+			if err := app.decodeJSON(r, &req); err != nil {
+				writeDecodeError(w, err)
+				return
+			}
+		}
+		if req.RetryAfter <= 0 {
+			req.RetryAfter = defaultRetryAfter
+		}
+
+		if err := app.Rds.Set(ctx, MaintenanceKey, req.RetryAfter, 0).Err(); err != nil {
+			http.Error(w, fmt.Sprintf("Cache set error: %v", err), http.StatusInternalServerError)
+			return
+		}
+	case "DELETE":
+		// Disable maintenance mode
+		if err := app.Rds.Del(ctx, MaintenanceKey).Err(); err != nil && err != redis.Nil {
+			http.Error(w, fmt.Sprintf("Cache delete error: %v", err), http.StatusInternalServerError)
+			return
+		}
+	case "GET":
+	default:
+		methodNotAllowed(w, r, "GET", "POST", "DELETE")
+		return
+	}
+
+	on, retryAfter := app.maintenanceStatus(ctx)
+	setJSONContentType(w)
+	app.writeJSON(w, r, types.MaintenanceStatus{
+		Enabled:    on,
+		ReadOnly:   app.readOnly.Load(),
+		RetryAfter: retryAfter,
+		Timestamp:  time.Now(),
+	})
+}
+
+func isReadMethod(method string) bool {
+	return method == "GET" || method == "HEAD" || method == "OPTIONS"
+}
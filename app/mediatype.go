@@ -0,0 +1,61 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+)
+
+// mediaTypeError is returned by decodeJSON for a body that is not declared
+// as JSON, reported with 415.
+type mediaTypeError struct {
+	contentType string
+	reason      string
+}
+
+func (e *mediaTypeError) Error() string {
+	return fmt.Sprintf("Unsupported Content-Type %q: %s", e.contentType, e.reason)
+}
+
+// checkJSONContentType accepts request bodies of application/json or a
+// structured syntax suffix type such as application/merge-patch+json,
+// whose charset, if any, is UTF-8. JSON has no other encoding (RFC 8259).
+// A missing Content-Type is accepted, as clients such as plain curl
+// commands often leave it out.
+func checkJSONContentType(contentType string) error {
+	if contentType == "" {
+		return nil
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return &mediaTypeError{contentType, "malformed media type"}
+	}
+	if mediaType != "application/json" &&
+		!(strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json")) {
+		return &mediaTypeError{contentType, "expected application/json"}
+	}
+	if charset, ok := params["charset"]; ok {
+		switch strings.ToLower(charset) {
+		case "utf-8", "utf8", "us-ascii":
+		default:
+			return &mediaTypeError{contentType, "expected charset utf-8"}
+		}
+	}
+	return nil
+}
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// skipBOM drops a UTF-8 byte order mark from the start of r. RFC 8259
+// forbids sending one but lets parsers ignore it, which encoding/json
+// does not.
+func skipBOM(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	if b, err := br.Peek(len(utf8BOM)); err == nil && bytes.Equal(b, utf8BOM) {
+		br.Discard(len(utf8BOM))
+	}
+	return br
+}
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// MethodOverrideHeader carries the method of a POST request sent by a
+// client that can only send GET and POST, when MethodOverride is set.
+const MethodOverrideHeader = "X-HTTP-Method-Override"
+
+var methodOverrideHeaderKey = http.CanonicalHeaderKey(MethodOverrideHeader)
+
+// overridableMethods are the methods MethodOverrideHeader may ask for.
+var overridableMethods = []string{"PUT", "PATCH", "DELETE"}
+
+// MethodOverrideMiddleware serves POST requests carrying
+// MethodOverrideHeader as requests of that method, when MethodOverride is
+// set. Only PUT, PATCH and DELETE can be asked for, as overriding a POST
+// with a read would let a write through caches and maintenance mode.
+func (app *App) MethodOverrideMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		override := r.Header.Get(methodOverrideHeaderKey)
+		if !app.MethodOverride || override == "" || r.Method != "POST" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		method := strings.ToUpper(override)
+		if !slices.Contains(overridableMethods, method) {
+			http.Error(w, fmt.Sprintf("Invalid %s %q: expected one of %s",
+				MethodOverrideHeader, override, strings.Join(overridableMethods, ", ")), http.StatusBadRequest)
+			return
+		}
+		r = r.Clone(r.Context())
+		r.Method = method
+		r.Header.Del(MethodOverrideHeader)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// methodNotAllowed rejects a method the handler does not serve with 405
+// and an Allow header listing allowed, plus HEAD along with GET and
+// OPTIONS. OPTIONS requests get the same header with a 204.
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+	w.Header().Set("Allow", allowHeader(allowed))
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+func allowHeader(allowed []string) string {
+	methods := make([]string, 0, len(allowed)+2)
+	for _, method := range allowed {
+		methods = append(methods, method)
+		if method == "GET" {
+			methods = append(methods, "HEAD")
+		}
+	}
+	return strings.Join(append(methods, "OPTIONS"), ", ")
+}
@@ -0,0 +1,142 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// PartitionMonthsAhead is how many future monthly partitions are kept
+// ready so inserts never fall through to the default partition.
+const PartitionMonthsAhead = 3
+
+// IsPartitioned reports whether test_data is a partitioned table.
+func IsPartitioned(ctx context.Context, db *sql.DB) (bool, error) {
+	var kind string
+	err := db.QueryRowContext(ctx,
+		"SELECT relkind FROM pg_class WHERE oid = 'test_data'::regclass").Scan(&kind)
+	if err != nil {
+		return false, err
+	}
+	return kind == "p", nil
+}
+
+// PartitionTestData converts test_data into a table partitioned by month of
+// created_at. Existing rows are copied into monthly partitions, with a
+// default partition catching anything outside the prepared ranges. It does
+// nothing if the table is already partitioned.
+func PartitionTestData(ctx context.Context, db *sql.DB) error {
+	partitioned, err := IsPartitioned(ctx, db)
+	if err != nil || partitioned {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldest sql.NullTime
+	err = tx.QueryRowContext(ctx, "SELECT MIN(created_at) FROM test_data").Scan(&oldest)
+	if err != nil {
+		return err
+	}
+
+	// The new table copies the old one's columns and defaults, so it stays
+	// in step with whatever columns initDatabase has added
+	statements := []string{
+		"ALTER TABLE test_data RENAME TO test_data_unpartitioned",
+		"ALTER INDEX IF EXISTS test_data_created_at_idx RENAME TO test_data_unpartitioned_created_at_idx",
+		"ALTER INDEX IF EXISTS test_data_tags_idx RENAME TO test_data_unpartitioned_tags_idx",
+		"UPDATE test_data_unpartitioned SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL",
+		`CREATE TABLE test_data (LIKE test_data_unpartitioned INCLUDING DEFAULTS)
+			PARTITION BY RANGE (created_at)`,
+		"ALTER TABLE test_data ALTER COLUMN created_at SET NOT NULL, ADD PRIMARY KEY (id, created_at)",
+		"ALTER SEQUENCE test_data_id_seq OWNED BY test_data.id",
+		"CREATE INDEX test_data_created_at_idx ON test_data (created_at)",
+		"CREATE INDEX test_data_tags_idx ON test_data USING GIN (tags)",
+		"CREATE TABLE test_data_default PARTITION OF test_data DEFAULT",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%s: %v", stmt, err)
+		}
+	}
+
+	// Partitions must exist before the copy, otherwise rows land in the
+	// default partition and block creating their month's partition later
+	from := time.Now()
+	if oldest.Valid {
+		from = oldest.Time
+	}
+	if err := createPartitions(ctx, tx, from, time.Now().AddDate(0, PartitionMonthsAhead, 0)); err != nil {
+		return err
+	}
+
+	statements = []string{
+		"INSERT INTO test_data SELECT * FROM test_data_unpartitioned",
+		// CASCADE drops foreign keys pointing at the old table
+		"DROP TABLE test_data_unpartitioned CASCADE",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to copy rows into partitions: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// EnsurePartitions creates the partitions for the current month and the
+// next PartitionMonthsAhead months.
+func (app *App) EnsurePartitions(ctx context.Context) error {
+	now := time.Now()
+	return createPartitions(ctx, app.DB, now, now.AddDate(0, PartitionMonthsAhead, 0))
+}
+
+// RunPartitionMaintenance keeps future partitions in place, checking daily,
+// until ctx is done.
+func (app *App) RunPartitionMaintenance(ctx context.Context) {
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		if err := app.EnsurePartitions(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("partitions: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// createPartitions creates one partition per month from the month of from
+// through the month of to.
+func createPartitions(ctx context.Context, db execer, from, to time.Time) error {
+	month := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for !month.After(to) {
+		next := month.AddDate(0, 1, 0)
+		stmt := fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS %s PARTITION OF test_data FOR VALUES FROM ('%s') TO ('%s')",
+			partitionName(month), month.Format("2006-01-02"), next.Format("2006-01-02"))
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to create partition %s: %v", partitionName(month), err)
+		}
+		month = next
+	}
+	return nil
+}
+
+func partitionName(month time.Time) string {
+	return fmt.Sprintf("test_data_y%04dm%02d", month.Year(), int(month.Month()))
+}
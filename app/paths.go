@@ -0,0 +1,115 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Trailing slash policies of PathPolicy.
+const (
+	// TrailingSlashKeep routes paths as sent, so "/api/data/" only matches
+	// the catch-all "/" route.
+	TrailingSlashKeep = "keep"
+	// TrailingSlashRedirect answers 308 Permanent Redirect to the path
+	// without the slash, which keeps the method and body.
+	TrailingSlashRedirect = "redirect"
+	// TrailingSlashRewrite routes the path without the slash.
+	TrailingSlashRewrite = "rewrite"
+)
+
+// Case policies of PathPolicy.
+const (
+	PathCaseSensitive   = "sensitive"
+	PathCaseInsensitive = "insensitive"
+)
+
+// PathPolicy normalizes request paths before routing, for ingresses and
+// clients that add trailing slashes, double slashes or change case on the
+// way. The zero value routes paths as sent.
+type PathPolicy struct {
+	// TrailingSlash is TrailingSlashKeep, TrailingSlashRedirect or
+	// TrailingSlashRewrite, empty for TrailingSlashKeep.
+	TrailingSlash string
+	// CollapseSlashes routes "/api//data" as "/api/data", instead of the
+	// mux redirecting to it, which costs a round trip and which clients
+	// that do not follow redirects report as a failure.
+	CollapseSlashes bool
+	// Case is PathCaseSensitive or PathCaseInsensitive, empty for
+	// PathCaseSensitive. Insensitive paths that only match the catch-all
+	// route are routed in lower case if that matches another route, with
+	// wildcard values such as IDs lowered too.
+	Case string
+}
+
+// ParsePathPolicy validates the settings of a PathPolicy, empty for the
+// defaults.
+func ParsePathPolicy(trailingSlash string, collapseSlashes bool, pathCase string) (PathPolicy, error) {
+	switch trailingSlash {
+	case "", TrailingSlashKeep, TrailingSlashRedirect, TrailingSlashRewrite:
+	default:
+		return PathPolicy{}, fmt.Errorf("unknown trailing slash policy %q (use %s, %s or %s)",
+			trailingSlash, TrailingSlashKeep, TrailingSlashRedirect, TrailingSlashRewrite)
+	}
+	switch pathCase {
+	case "", PathCaseSensitive, PathCaseInsensitive:
+	default:
+		return PathPolicy{}, fmt.Errorf("unknown path case policy %q (use %s or %s)",
+			pathCase, PathCaseSensitive, PathCaseInsensitive)
+	}
+	return PathPolicy{TrailingSlash: trailingSlash, CollapseSlashes: collapseSlashes, Case: pathCase}, nil
+}
+
+// normalizePath applies rt.Paths to r. It returns the request to route,
+// r itself if the path is left as it is, or the URL to redirect to.
+func (rt *Router) normalizePath(r *http.Request) (*http.Request, string) {
+	p := rt.Paths
+	if p == (PathPolicy{}) {
+		return r, ""
+	}
+	u := *r.URL
+	if p.CollapseSlashes {
+		u.Path, u.RawPath = collapseSlashes(u.Path), collapseSlashes(u.RawPath)
+	}
+	if p.TrailingSlash == TrailingSlashRedirect || p.TrailingSlash == TrailingSlashRewrite {
+		if len(u.Path) > 1 && strings.HasSuffix(u.Path, "/") {
+			u.Path, u.RawPath = trimTrailingSlashes(u.Path), trimTrailingSlashes(u.RawPath)
+			if p.TrailingSlash == TrailingSlashRedirect {
+				return r, u.RequestURI()
+			}
+		}
+	}
+	if p.Case == PathCaseInsensitive && !rt.served(r.Method, r.Host, &u) {
+		lower := u
+		lower.Path, lower.RawPath = strings.ToLower(u.Path), strings.ToLower(u.RawPath)
+		if lower.Path != u.Path && rt.served(r.Method, r.Host, &lower) {
+			u = lower
+		}
+	}
+
+	if u.Path == r.URL.Path && u.RawPath == r.URL.RawPath {
+		return r, ""
+	}
+	// As http.StripPrefix does, the rest of the request is shared
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = &u
+	return r2, ""
+}
+
+func collapseSlashes(path string) string {
+	for strings.Contains(path, "//") {
+		path = strings.ReplaceAll(path, "//", "/")
+	}
+	return path
+}
+
+func trimTrailingSlashes(path string) string {
+	if path == "" {
+		return ""
+	}
+	if trimmed := strings.TrimRight(path, "/"); trimmed != "" {
+		return trimmed
+	}
+	return "/"
+}
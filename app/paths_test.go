@@ -0,0 +1,93 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pathRouter serves the routed path, and the name of the route, in the body.
+func pathRouter(t *testing.T, paths PathPolicy) *Router {
+	rt := NewRouter(http.NewServeMux())
+	rt.Paths = paths
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(rt.RouteName(r) + " " + r.URL.Path))
+	}
+	rt.HandleFunc("data", "/api/data", echo)
+	rt.HandleFunc("run", "/api/runs/{id}", echo)
+	rt.HandleFunc("root", "/", echo)
+	require.NoError(t, rt.Err())
+	return rt
+}
+
+func TestPathPolicyDefaultRoutesPathsAsSent(t *testing.T) {
+	rt := pathRouter(t, PathPolicy{})
+	for path, want := range map[string]string{
+		"/api/data":  "data /api/data",
+		"/api/data/": "root /api/data/",
+		"/API/data":  "root /API/data",
+	} {
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, want, w.Body.String(), path)
+	}
+
+	// The mux redirects repeated slashes
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest("GET", "/api//data", nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+}
+
+func TestPathPolicyRewrite(t *testing.T) {
+	rt := pathRouter(t, PathPolicy{TrailingSlash: TrailingSlashRewrite, CollapseSlashes: true, Case: PathCaseInsensitive})
+	for path, want := range map[string]string{
+		"/api/data/":       "data /api/data",
+		"/api/data//":      "data /api/data",
+		"//api///data":     "data /api/data",
+		"/API/Data/":       "data /api/data",
+		"/api/runs/Run-1":  "run /api/runs/Run-1",
+		"/API/runs/Run-1/": "run /api/runs/run-1",
+		"/":                "root /",
+		"/Unknown/":        "root /Unknown",
+	} {
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		assert.Equal(t, want, w.Body.String(), path)
+	}
+}
+
+func TestPathPolicyRedirect(t *testing.T) {
+	rt := pathRouter(t, PathPolicy{TrailingSlash: TrailingSlashRedirect, CollapseSlashes: true})
+
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest("POST", "/api//data/?tag=a%2Fb", nil))
+	assert.Equal(t, http.StatusPermanentRedirect, w.Code)
+	assert.Equal(t, "/api/data?tag=a%2Fb", w.Header().Get("Location"))
+
+	w = httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest("GET", "/api/runs/a%2Fb/", nil))
+	assert.Equal(t, "/api/runs/a%2Fb", w.Header().Get("Location"), "escaped slashes are kept")
+
+	w = httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest("GET", "/api/data", nil))
+	assert.Equal(t, "data /api/data", w.Body.String())
+}
+
+func TestParsePathPolicy(t *testing.T) {
+	p, err := ParsePathPolicy(TrailingSlashRedirect, true, PathCaseInsensitive)
+	require.NoError(t, err)
+	assert.Equal(t, PathPolicy{TrailingSlash: TrailingSlashRedirect, CollapseSlashes: true, Case: PathCaseInsensitive}, p)
+
+	p, err = ParsePathPolicy("", false, "")
+	require.NoError(t, err)
+	assert.Equal(t, PathPolicy{}, p)
+
+	_, err = ParsePathPolicy("strip", false, "")
+	assert.Error(t, err)
+	_, err = ParsePathPolicy("", false, "upper")
+	assert.Error(t, err)
+}
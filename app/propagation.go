@@ -0,0 +1,19 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/nesymno/run-tests-example/httpclient"
+)
+
+// PropagationMiddleware stores the trace headers of each request in its
+// context, so outbound calls made with that context through the shared
+// HTTP client pass them on.
+func (app *App) PropagationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ctx := httpclient.Propagate(r.Context(), r.Header); ctx != r.Context() {
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,43 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/httpclient"
+)
+
+func TestOutboundCallsCarryInboundTrace(t *testing.T) {
+	var downstream http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downstream = r.Header.Clone()
+	}))
+	defer srv.Close()
+
+	opts := httpclient.DefaultOptions()
+	opts.Deny = httpclient.HostRules{}
+	app := &App{HTTPClient: httpclient.New(opts)}
+
+	handler := app.PropagationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := http.NewRequestWithContext(r.Context(), "GET", srv.URL, nil)
+		require.NoError(t, err)
+		resp, err := app.HTTPClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}))
+
+	r := httptest.NewRequest("GET", "/api/data", nil)
+	r.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.Header.Set("X-Request-ID", "req-42")
+	r.Header.Set(TenantKeyHeader, "tk_not-forwarded")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	require.NotNil(t, downstream)
+	assert.Equal(t, r.Header.Get("Traceparent"), downstream.Get("Traceparent"))
+	assert.Equal(t, "req-42", downstream.Get("X-Request-ID"))
+	assert.Empty(t, downstream.Get(TenantKeyHeader))
+}
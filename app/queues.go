@@ -0,0 +1,108 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// QueueLimits are the lag thresholds past which /readyz reports the
+// replica as not ready. Zero values disable a limit.
+type QueueLimits struct {
+	// MaxDepth applies to the job queue and the write-behind buffer.
+	MaxDepth int64
+	// MaxAge applies to the oldest runnable job.
+	MaxAge time.Duration
+}
+
+func (l QueueLimits) check(q *types.QueueStatus) {
+	if l.MaxDepth > 0 && q.Depth > l.MaxDepth {
+		q.Lagging = append(q.Lagging, fmt.Sprintf("depth %d exceeds %d", q.Depth, l.MaxDepth))
+	}
+	if age := time.Duration(q.OldestAgeSeconds * float64(time.Second)); l.MaxAge > 0 && age > l.MaxAge {
+		q.Lagging = append(q.Lagging, fmt.Sprintf("oldest job waited %s, over %s", age.Round(time.Second), l.MaxAge))
+	}
+}
+
+// queueReport reads the state of the job queue and, in write-behind mode,
+// of the write buffer, checked against app.QueueLimits.
+func (app *App) queueReport(ctx context.Context) (types.QueueReport, error) {
+	report := types.QueueReport{
+		MaxDepth:      app.QueueLimits.MaxDepth,
+		MaxAgeSeconds: app.QueueLimits.MaxAge.Seconds(),
+	}
+
+	stats, err := app.Jobs.Stats(ctx)
+	if err != nil {
+		return report, err
+	}
+	report.Queues = append(report.Queues, types.QueueStatus{
+		Name:                "jobs",
+		Depth:               stats.Depth,
+		Delayed:             stats.Delayed,
+		Dead:                stats.Dead,
+		OldestAgeSeconds:    stats.OldestAge.Seconds(),
+		ThroughputPerSecond: stats.Throughput,
+	})
+
+	if app.Batch != nil {
+		pending, err := app.Batch.Pending(ctx)
+		if err != nil {
+			return report, err
+		}
+		report.Queues = append(report.Queues, types.QueueStatus{Name: "write_behind", Depth: pending})
+	}
+
+	for i := range report.Queues {
+		app.QueueLimits.check(&report.Queues[i])
+	}
+	return report, nil
+}
+
+// DebugQueuesHandler reports the depth, lag and throughput of the async
+// subsystems, and which of them exceed their limits.
+func (app *App) DebugQueuesHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := app.queueReport(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Redis error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	setJSONContentType(w)
+	app.writeJSON(w, r, report)
+}
+
+// ReadyHandler reports whether this replica should receive traffic: its
+// database and Redis answer and no queue lags past app.QueueLimits.
+// Unlike /health, it fails with 503. The checks may be cached; see
+// HealthCheckPolicy. ?force=true runs them anew.
+func (app *App) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	checks := app.healthChecks(r.Context(), r.URL.Query().Get("force") == "true")
+
+	readiness := types.Readiness{Status: "ready"}
+	if checks.db != nil {
+		readiness.Reasons = append(readiness.Reasons, fmt.Sprintf("database: %v", checks.db))
+	}
+	if checks.redis != nil {
+		readiness.Reasons = append(readiness.Reasons, fmt.Sprintf("redis: %v", checks.redis))
+	} else if checks.queuesErr != nil {
+		readiness.Reasons = append(readiness.Reasons, fmt.Sprintf("queues: %v", checks.queuesErr))
+	} else {
+		for _, q := range checks.queues.Queues {
+			for _, reason := range q.Lagging {
+				readiness.Reasons = append(readiness.Reasons, q.Name+": "+reason)
+			}
+		}
+	}
+
+	setJSONContentType(w)
+	status := http.StatusOK
+	if len(readiness.Reasons) > 0 {
+		readiness.Status = "not_ready"
+		status = http.StatusServiceUnavailable
+	}
+	app.writeJSONStatus(w, r, status, readiness)
+}
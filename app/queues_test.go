@@ -0,0 +1,51 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/types"
+	"github.com/nesymno/run-tests-example/worker"
+)
+
+func TestQueueReportFlagsLimits(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	app := &App{Rds: rds, Jobs: worker.New(rds), QueueLimits: QueueLimits{MaxDepth: 1}}
+	ctx := context.Background()
+
+	report, err := app.queueReport(ctx)
+	require.NoError(t, err)
+	require.Len(t, report.Queues, 1)
+	assert.Empty(t, report.Queues[0].Lagging)
+
+	for range 2 {
+		_, err := app.Jobs.Enqueue(ctx, insertDataJob, types.TestData{Name: "a"})
+		require.NoError(t, err)
+	}
+	report, err = app.queueReport(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), report.Queues[0].Depth)
+	assert.Equal(t, []string{"depth 2 exceeds 1"}, report.Queues[0].Lagging)
+}
+
+func TestQueueLimitsCheckAge(t *testing.T) {
+	limits := QueueLimits{MaxAge: time.Minute}
+	q := types.QueueStatus{OldestAgeSeconds: 30}
+	limits.check(&q)
+	assert.Empty(t, q.Lagging)
+
+	q.OldestAgeSeconds = 90
+	limits.check(&q)
+	assert.Len(t, q.Lagging, 1)
+
+	q = types.QueueStatus{Depth: 1_000_000, OldestAgeSeconds: 1_000}
+	QueueLimits{}.check(&q)
+	assert.Empty(t, q.Lagging, "zero limits are disabled")
+}
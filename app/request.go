@@ -0,0 +1,84 @@
+package app
+
+import (
+	"crypto/tls"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// redactedHeaders are credentials never echoed back by /debug/request.
+var redactedHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization", "X-Admin-Token"}
+
+// DebugRequestHandler echoes the request as the server received it, after
+// every proxy in front of it, to diagnose ingress configuration. It is
+// disabled unless DebugRequest is set.
+func (app *App) DebugRequestHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.DebugRequest {
+		http.NotFound(w, r)
+		return
+	}
+
+	info := types.RequestInfo{
+		Method:         r.Method,
+		URL:            r.URL.String(),
+		Proto:          r.Proto,
+		Host:           r.Host,
+		RemoteAddr:     r.RemoteAddr,
+		Headers:        r.Header.Clone(),
+		ForwardedProto: r.Header.Get("X-Forwarded-Proto"),
+		Trace:          traceInfo(r.Header),
+		Timestamp:      time.Now(),
+	}
+	if ip := ClientIP(r.Context()); ip.IsValid() {
+		info.ClientIP = ip.String()
+	}
+	for _, name := range redactedHeaders {
+		if _, ok := info.Headers[name]; ok {
+			info.Headers[name] = []string{"[redacted]"}
+		}
+	}
+	if r.TLS != nil {
+		info.TLS = &types.TLSInfo{
+			Version:     tls.VersionName(r.TLS.Version),
+			CipherSuite: tls.CipherSuiteName(r.TLS.CipherSuite),
+			ServerName:  r.TLS.ServerName,
+			Protocol:    r.TLS.NegotiatedProtocol,
+			PeerCerts:   len(r.TLS.PeerCertificates),
+		}
+	}
+
+	setJSONContentType(w)
+	app.writeJSON(w, r, info)
+}
+
+// traceInfo extracts trace identifiers from W3C traceparent, B3 or AWS
+// X-Ray headers, in that order of preference, and the request ID.
+func traceInfo(h http.Header) types.TraceInfo {
+	info := types.TraceInfo{RequestID: h.Get("X-Request-ID")}
+
+	// traceparent: version-traceid-spanid-flags
+	if parts := strings.Split(h.Get("Traceparent"), "-"); len(parts) == 4 && len(parts[1]) == 32 && len(parts[2]) == 16 {
+		info.TraceID, info.SpanID = parts[1], parts[2]
+		return info
+	}
+
+	if id := h.Get("X-B3-TraceId"); id != "" {
+		info.TraceID, info.SpanID = id, h.Get("X-B3-SpanId")
+		return info
+	}
+	if b3 := strings.Split(h.Get("B3"), "-"); len(b3) >= 2 {
+		info.TraceID, info.SpanID = b3[0], b3[1]
+		return info
+	}
+
+	// X-Amzn-Trace-Id: Root=1-5759e988-bd862e3fe1be46a994272793;Parent=...
+	for _, field := range strings.Split(h.Get("X-Amzn-Trace-Id"), ";") {
+		if root, ok := strings.CutPrefix(field, "Root="); ok {
+			info.TraceID = root
+		}
+	}
+	return info
+}
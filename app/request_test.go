@@ -0,0 +1,79 @@
+package app
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/jsonpolicy"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+func TestDebugRequestDisabledByDefault(t *testing.T) {
+	rec := httptest.NewRecorder()
+	(&App{}).DebugRequestHandler(rec, httptest.NewRequest("GET", "/debug/request", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestDebugRequestEchoesRequest(t *testing.T) {
+	app := &App{DebugRequest: true}
+	r := httptest.NewRequest("GET", "/debug/request?x=1", nil)
+	r.RemoteAddr = "203.0.113.9:5000"
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.TLS = &tls.ConnectionState{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256}
+
+	rec := httptest.NewRecorder()
+	app.ClientIPMiddleware(http.HandlerFunc(app.DebugRequestHandler)).ServeHTTP(rec, r)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var info types.RequestInfo
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&info))
+	assert.Equal(t, "GET", info.Method)
+	assert.Equal(t, "/debug/request?x=1", info.URL)
+	assert.Equal(t, "203.0.113.9", info.ClientIP)
+	assert.Equal(t, []string{"[redacted]"}, info.Headers["Authorization"])
+	assert.Equal(t, "https", info.ForwardedProto)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", info.Trace.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", info.Trace.SpanID)
+	require.NotNil(t, info.TLS)
+	assert.Equal(t, "TLS 1.3", info.TLS.Version)
+}
+
+func TestDebugRequestFollowsJSONPolicy(t *testing.T) {
+	app := &App{DebugRequest: true, JSON: jsonpolicy.Policy{Naming: jsonpolicy.CamelCase}}
+	r := httptest.NewRequest("GET", "/debug/request", nil)
+	r.Header["X_custom_header"] = []string{"1"}
+
+	rec := httptest.NewRecorder()
+	app.DebugRequestHandler(rec, r)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"remoteAddr":`)
+	assert.Contains(t, rec.Body.String(), `"X_custom_header":`, "header names are data, not fields")
+	assert.NotContains(t, rec.Body.String(), `"tls":`)
+
+	r.Header.Set(jsonpolicy.NamingHeader, jsonpolicy.SnakeCase)
+	rec = httptest.NewRecorder()
+	app.DebugRequestHandler(rec, r)
+	assert.Contains(t, rec.Body.String(), `"remote_addr":`)
+}
+
+func TestTraceInfoFallbacks(t *testing.T) {
+	h := http.Header{}
+	h.Set("X-B3-TraceId", "80f198ee56343ba864fe8b2a57d3eff7")
+	h.Set("X-B3-SpanId", "e457b5a2e4d86bd1")
+	h.Set("X-Request-ID", "req-1")
+	assert.Equal(t, types.TraceInfo{TraceID: "80f198ee56343ba864fe8b2a57d3eff7", SpanID: "e457b5a2e4d86bd1", RequestID: "req-1"}, traceInfo(h))
+
+	h = http.Header{}
+	h.Set("X-Amzn-Trace-Id", "Root=1-5759e988-bd862e3fe1be46a994272793;Sampled=1")
+	assert.Equal(t, "1-5759e988-bd862e3fe1be46a994272793", traceInfo(h).TraceID)
+
+	assert.Equal(t, types.TraceInfo{}, traceInfo(http.Header{}))
+}
@@ -0,0 +1,33 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// resetTables are emptied by /test/reset. Sequences restart too, so ids
+// are predictable after a reset.
+const resetTables = "test_data_comments, test_data_archive, test_data, recurring_jobs"
+
+// ResetHandler deletes every row the API manages and invalidates the list
+// cache, so each test suite starts from an empty database. It is disabled
+// unless EnableReset is set, which only the test profile does by default.
+func (app *App) ResetHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.EnableReset {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		methodNotAllowed(w, r, "POST")
+		return
+	}
+
+	ctx := r.Context()
+	if _, err := app.DB.ExecContext(ctx, "TRUNCATE "+resetTables+" RESTART IDENTITY CASCADE"); err != nil {
+		http.Error(w, fmt.Sprintf("Reset error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	app.invalidateList(ctx)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/cache"
+	"github.com/nesymno/run-tests-example/faults"
+)
+
+// listingDB answers every query with the same two test_data rows, in the
+// columns of listDataQuery.
+type listingDB struct {
+	queries atomic.Int64
+}
+
+func (d *listingDB) Connect(context.Context) (driver.Conn, error) { return listingConn{d}, nil }
+func (d *listingDB) Driver() driver.Driver                        { return nil }
+
+type listingConn struct{ db *listingDB }
+
+func (listingConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (listingConn) Close() error                        { return nil }
+func (listingConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+func (c listingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.queries.Add(1)
+	return &listingRows{}, nil
+}
+
+type listingRows struct{ n int }
+
+func (r *listingRows) Columns() []string {
+	return []string{"id", "name", "data", "tags", "status", "created_at", "updated_at", "secret", "test_run_id"}
+}
+func (r *listingRows) Close() error { return nil }
+func (r *listingRows) Next(dest []driver.Value) error {
+	if r.n == 2 {
+		return io.EOF
+	}
+	r.n++
+	created := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	copy(dest, []driver.Value{int64(r.n), "row", "d", []byte("{smoke}"), "active", created, created, nil, ""})
+	return nil
+}
+
+const listingJSON = `[
+	{"id":1,"name":"row","data":"d","tags":["smoke"],"status":"active","created_at":"2024-03-10T12:00:00Z","updated_at":"2024-03-10T12:00:00Z"},
+	{"id":2,"name":"row","data":"d","tags":["smoke"],"status":"active","created_at":"2024-03-10T12:00:00Z","updated_at":"2024-03-10T12:00:00Z"}]`
+
+// newFaultyApp returns an app whose database and Redis go through dbFaults
+// and redisFaults.
+func newFaultyApp(t *testing.T, dbFaults, redisFaults *faults.Injector) (*App, *listingDB) {
+	mr := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr(), MinRetryBackoff: time.Millisecond, MaxRetryBackoff: time.Millisecond})
+	rds.AddHook(faults.RedisHook(redisFaults))
+	listing := &listingDB{}
+	db := sql.OpenDB(faults.Connector(listing, dbFaults))
+	t.Cleanup(func() { db.Close() })
+	return &App{DB: db, Rds: rds, ListCache: cache.NewQueryCache(rds, "list", time.Minute)}, listing
+}
+
+func TestListingRidesOutBrokenRedisConnections(t *testing.T) {
+	redisFaults := new(faults.Injector).FailEvery(5, nil)
+	app, listing := newFaultyApp(t, new(faults.Injector), redisFaults)
+
+	hits := 0
+	for range 20 {
+		result, err := app.listData(context.Background(), dataFilter{}, listOptions{})
+		require.NoError(t, err)
+		assert.JSONEq(t, listingJSON, string(result.body))
+		if result.cache == "HIT" {
+			hits++
+		}
+	}
+	assert.Positive(t, redisFaults.Failures())
+	// go-redis retried the failed commands, so the cache kept working
+	assert.Equal(t, 19, hits)
+	assert.Equal(t, int64(1), listing.queries.Load())
+}
+
+func TestListingFallsBackToDatabaseWithoutRedis(t *testing.T) {
+	redisFaults := new(faults.Injector).FailEvery(1, nil)
+	app, listing := newFaultyApp(t, new(faults.Injector), redisFaults)
+
+	for range 3 {
+		result, err := app.listData(context.Background(), dataFilter{}, listOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "BYPASS", result.cache)
+		assert.JSONEq(t, listingJSON, string(result.body))
+	}
+	assert.Equal(t, int64(3), listing.queries.Load())
+}
+
+func TestListingRetriesBrokenDatabaseConnections(t *testing.T) {
+	dbFaults := new(faults.Injector).FailEvery(2, driver.ErrBadConn)
+	app, listing := newFaultyApp(t, dbFaults, new(faults.Injector))
+
+	for range 10 {
+		result, err := app.listData(context.Background(), dataFilter{}, listOptions{Refresh: true})
+		require.NoError(t, err)
+		assert.JSONEq(t, listingJSON, string(result.body))
+	}
+	assert.Positive(t, dbFaults.Failures())
+	assert.Equal(t, int64(10), listing.queries.Load())
+
+	// Other failures are not retried and fail the listing
+	dbFaults.FailEvery(1, nil)
+	_, err := app.listData(context.Background(), dataFilter{}, listOptions{Refresh: true})
+	assert.ErrorContains(t, err, faults.ErrInjected.Error())
+}
+
+func TestListingHonorsDeadlineDuringLatencySpike(t *testing.T) {
+	dbFaults := new(faults.Injector).SlowEvery(1, time.Minute)
+	app, _ := newFaultyApp(t, dbFaults, new(faults.Injector))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := app.listData(ctx, dataFilter{}, listOptions{Refresh: true})
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, 1, dbFaults.Delays())
+}
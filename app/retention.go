@@ -0,0 +1,236 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/nesymno/run-tests-example/jsonpolicy"
+	"github.com/nesymno/run-tests-example/metrics"
+	"github.com/nesymno/run-tests-example/types"
+	"github.com/nesymno/run-tests-example/worker"
+)
+
+var retentionPurgedRows = metrics.NewCounter("app_retention_purged_rows_total",
+	"test_data rows deleted by the retention policy.")
+
+const retentionLockKey = "retention:lock"
+
+// RetentionPolicy controls the expiry of old test_data rows. Rows are
+// deleted in batches of BatchSize so no single statement holds locks for
+// long, and at most MaxBatches are deleted per run. With Archive set, each
+// batch is moved to test_data_archive instead of being dropped.
+type RetentionPolicy struct {
+	Days       int
+	BatchSize  int
+	MaxBatches int
+	Interval   time.Duration
+	Archive    bool
+}
+
+const expiredBatch = `
+	SELECT id FROM test_data
+	WHERE created_at < NOW() - $1 * INTERVAL '1 day'
+	ORDER BY id
+	LIMIT $2`
+
+type retentionState struct {
+	mu   sync.Mutex
+	last *types.RetentionReport
+}
+
+// RunRetention enforces the retention policy every Interval until ctx is
+// done. It does nothing when Days is not positive.
+func (app *App) RunRetention(ctx context.Context) {
+	if app.Retention.Days <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(app.Retention.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := app.PurgeExpired(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("retention: %v", err)
+			}
+		}
+	}
+}
+
+// PurgeExpired deletes rows older than the retention period.
+func (app *App) PurgeExpired(ctx context.Context) (*types.RetentionReport, error) {
+	// Only one replica purges at a time
+	unlock, err := worker.Lock(ctx, app.Rds, retentionLockKey, 10*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if unlock == nil {
+		return nil, fmt.Errorf("purge already in progress")
+	}
+	defer unlock()
+
+	report := &types.RetentionReport{
+		Days:      app.Retention.Days,
+		Archived:  app.Retention.Archive,
+		StartedAt: time.Now(),
+	}
+
+	query := "DELETE FROM test_data WHERE id IN (" + expiredBatch + ")"
+	if app.Retention.Archive {
+		// Move the batch in a single statement so a row is never lost
+		// between the copy and the delete
+		query = `
+			WITH moved AS (
+				DELETE FROM test_data WHERE id IN (` + expiredBatch + `)
+				RETURNING id, name, data, tags, status, created_at, updated_at, tenant_id, secret, test_run_id
+			)
+			INSERT INTO test_data_archive (id, name, data, tags, status, created_at, updated_at, tenant_id, secret, test_run_id)
+			SELECT id, name, data, tags, status, created_at, updated_at, tenant_id, secret, test_run_id FROM moved`
+	}
+
+	for report.Batches < app.Retention.MaxBatches {
+		res, err := app.DB.ExecContext(ctx, query, app.Retention.Days, app.Retention.BatchSize)
+		if err != nil {
+			return nil, fmt.Errorf("purge error: %v", err)
+		}
+
+		n, _ := res.RowsAffected()
+		report.Batches++
+		report.Purged += n
+		retentionPurgedRows.Add(uint64(n))
+
+		if n < int64(app.Retention.BatchSize) {
+			break
+		}
+	}
+
+	if report.Purged > 0 {
+		app.invalidateList(ctx)
+	}
+
+	report.FinishedAt = time.Now()
+	app.retention.mu.Lock()
+	app.retention.last = report
+	app.retention.mu.Unlock()
+
+	return report, nil
+}
+
+// RetentionHandler reports the last purge (GET) or runs one now (POST).
+func (app *App) RetentionHandler(w http.ResponseWriter, r *http.Request) {
+	var report *types.RetentionReport
+
+	switch r.Method {
+	case "GET":
+		app.retention.mu.Lock()
+		report = app.retention.last
+		app.retention.mu.Unlock()
+	case "POST":
+		if app.Retention.Days <= 0 {
+			http.Error(w, "Retention policy is disabled", http.StatusConflict)
+			return
+		}
+
+		var err error
+		report, err = app.PurgeExpired(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		methodNotAllowed(w, r, "GET", "POST")
+		return
+	}
+
+	setJSONContentType(w)
+	app.writeJSON(w, r, jsonpolicy.Fields{
+		"days":         app.Retention.Days,
+		"total_purged": retentionPurgedRows.Value(),
+		"last_run":     report,
+	})
+}
+
+// ArchiveHandler reports the archive size (GET) or restores archived rows
+// back into test_data (POST). A restore takes either explicit row IDs or
+// "all": true.
+func (app *App) ArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case "GET":
+		var count int64
+		var oldest, newest sql.NullTime
+		err := app.DB.QueryRowContext(ctx,
+			"SELECT COUNT(*), MIN(archived_at), MAX(archived_at) FROM test_data_archive",
+		).Scan(&count, &oldest, &newest)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		summary := map[string]any{"rows": count}
+		if oldest.Valid {
+			summary["oldest_archived_at"] = oldest.Time
+			summary["newest_archived_at"] = newest.Time
+		}
+		setJSONContentType(w)
+		app.writeJSON(w, r, summary)
+	case "POST":
+		var req struct {
+			IDs []int64 `json:"ids"`
+			All bool    `json:"all"`
+		}
+		if err := app.decodeJSON(r, &req); err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+		if len(req.IDs) == 0 && !req.All {
+			http.Error(w, "Specify ids or all", http.StatusBadRequest)
+			return
+		}
+
+		// Rows are only removed from the archive once restored; those whose
+		// id or name is taken again stay archived and are counted as
+		// conflicts
+		var restored, conflicts int64
+		err := app.DB.QueryRowContext(ctx, `
+			WITH selected AS (
+				SELECT id, name, data, tags, status, created_at, updated_at, tenant_id, secret, test_run_id
+				FROM test_data_archive WHERE $1 OR id = ANY($2)
+				FOR UPDATE
+			), restored AS (
+				INSERT INTO test_data (id, name, data, tags, status, created_at, updated_at, tenant_id, secret, test_run_id)
+				SELECT * FROM selected
+				ON CONFLICT DO NOTHING
+				RETURNING id
+			), removed AS (
+				DELETE FROM test_data_archive WHERE id IN (SELECT id FROM restored)
+				RETURNING id
+			)
+			SELECT (SELECT COUNT(*) FROM removed), (SELECT COUNT(*) FROM selected) - (SELECT COUNT(*) FROM removed)`,
+			req.All, pq.Array(req.IDs)).Scan(&restored, &conflicts)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Restore error: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if restored > 0 {
+			app.invalidateList(ctx)
+		}
+
+		setJSONContentType(w)
+		app.writeJSON(w, r, jsonpolicy.Fields{"restored": restored, "conflicts": conflicts})
+	default:
+		methodNotAllowed(w, r, "GET", "POST")
+	}
+}
@@ -0,0 +1,306 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nesymno/run-tests-example/jsonpolicy"
+	"github.com/nesymno/run-tests-example/metrics"
+	"github.com/nesymno/run-tests-example/redact"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec("app_http_requests_total",
+		"HTTP requests by method, route and status code.", "method", "route", "code")
+	httpDuration = metrics.NewHistogramVec("app_http_request_duration_seconds",
+		"HTTP request latency by method and route.",
+		[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "method", "route")
+)
+
+// UnmatchedRoute labels requests that matched no registered route.
+const UnmatchedRoute = "unmatched"
+
+// AnyMethod is reported for routes whose pattern matches every method.
+const AnyMethod = "ANY"
+
+// Middleware wraps the handler of a single route, like RequireAdmin.
+type Middleware func(http.HandlerFunc) http.HandlerFunc
+
+// Router registers handlers on a ServeMux under route names and reports
+// request metrics per route. Metrics are labeled with the route name, never
+// the raw path, so the number of series is bounded by the routes registered
+// rather than by the paths clients make up.
+type Router struct {
+	// LogRequests logs every request once it has been served.
+	LogRequests bool
+	// Redactor masks sensitive query parameters in logged URLs.
+	Redactor *redact.Redactor
+	// JSON is the response policy of RoutesHandler.
+	JSON jsonpolicy.Policy
+	// Paths normalizes request paths before routing.
+	Paths PathPolicy
+	// SLOs counts every request against the SLOs of its route.
+	SLOs *SLOTracker
+
+	mux    *http.ServeMux
+	routes []types.Route
+	names  map[string]string
+	errs   []error
+}
+
+func NewRouter(mux *http.ServeMux) *Router {
+	return &Router{mux: mux, names: map[string]string{}}
+}
+
+// HandleFunc registers handler for pattern under name, wrapped in
+// middleware with the first one outermost. A registration that reuses a
+// name or conflicts with an earlier pattern is skipped and reported by Err,
+// so every conflict surfaces at once rather than the mux panicking on the
+// first.
+func (rt *Router) HandleFunc(name, pattern string, handler http.HandlerFunc, middleware ...Middleware) {
+	if err := rt.register(name, pattern, handler, middleware); err != nil {
+		rt.errs = append(rt.errs, err)
+	}
+}
+
+func (rt *Router) register(name, pattern string, handler http.HandlerFunc, middleware []Middleware) (err error) {
+	for _, route := range rt.routes {
+		if route.Name == name {
+			return fmt.Errorf("route %s: name already used for %s", name, route.Pattern)
+		}
+	}
+	if other, ok := rt.names[pattern]; ok {
+		return fmt.Errorf("route %s: pattern %q already registered as %s", name, pattern, other)
+	}
+
+	wrapped := handler
+	for i := len(middleware) - 1; i >= 0; i-- {
+		wrapped = middleware[i](wrapped)
+	}
+	if pattern == "/" {
+		wrapped = rt.otherMethodsOr(wrapped)
+	}
+
+	// The mux panics on patterns that overlap without one being more
+	// specific, like "GET /a/{x}" and "/a/b"
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("route %s: %v", name, p)
+		}
+	}()
+	rt.mux.HandleFunc(pattern, wrapped)
+
+	route := types.Route{Name: name, Method: AnyMethod, Pattern: pattern, Handler: funcName(handler)}
+	if method, path, ok := strings.Cut(pattern, " "); ok {
+		route.Method, route.Pattern = method, path
+	}
+	route.Middleware = make([]string, len(middleware))
+	for i, mw := range middleware {
+		route.Middleware[i] = funcName(mw)
+	}
+	rt.routes = append(rt.routes, route)
+	rt.names[pattern] = name
+	return nil
+}
+
+// Err reports every registration HandleFunc rejected.
+func (rt *Router) Err() error {
+	return errors.Join(rt.errs...)
+}
+
+// Routes returns the registered routes in registration order.
+func (rt *Router) Routes() []types.Route {
+	return append([]types.Route(nil), rt.routes...)
+}
+
+// RoutesHandler lists the registered routes so clients can discover the
+// API surface.
+func (rt *Router) RoutesHandler(w http.ResponseWriter, r *http.Request) {
+	setJSONContentType(w)
+	jsonpolicy.Encode(w, rt.Routes(), jsonpolicy.ForRequest(r, rt.JSON))
+}
+
+// RouteName returns the name of the route r was dispatched to. It is only
+// known once the mux has matched r.
+func (rt *Router) RouteName(r *http.Request) string {
+	if name, ok := rt.names[r.Pattern]; ok {
+		return name
+	}
+	return UnmatchedRoute
+}
+
+// recorders recycles the statusRecorder of every request, which handlers
+// must not use once ServeHTTP returns.
+var recorders = sync.Pool{New: func() any { return new(statusRecorder) }}
+
+// statusCodes holds the label values of the status codes, so labeling a
+// request does not format its status.
+var statusCodes = func() (codes [600]string) {
+	for status := range codes {
+		codes[status] = strconv.Itoa(status)
+	}
+	return codes
+}()
+
+func statusCode(status int) string {
+	if status >= 0 && status < len(statusCodes) {
+		return statusCodes[status]
+	}
+	return strconv.Itoa(status)
+}
+
+// ServeHTTP serves r and records its metrics. A request to a route
+// allocates nothing beyond what the mux and the handler do, which
+// TestHotPathAllocations holds it to.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rec := recorders.Get().(*statusRecorder)
+	*rec = statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		*rec = statusRecorder{}
+		recorders.Put(rec)
+	}()
+	dispatched := rt.dispatch(rec, r)
+
+	method := metricMethod(r.Method)
+	route := rt.RouteName(dispatched)
+	elapsed := time.Since(start)
+	httpRequests.With(method, route, statusCode(rec.status)).Inc()
+	httpDuration.With(method, route).Observe(elapsed.Seconds())
+	if rt.SLOs != nil {
+		rt.SLOs.Observe(route, rec.status, elapsed)
+	}
+
+	// At debug level every request is logged, as with LogRequests
+	if rt.LogRequests || slog.Default().Enabled(r.Context(), slog.LevelDebug) {
+		uri := r.URL.RequestURI()
+		if rt.Redactor != nil {
+			uri = rt.Redactor.URL(r.URL)
+		}
+		line := fmt.Sprintf("%s %s %d %s route=%s", r.Method, uri, rec.status, elapsed, route)
+		if run := testRunFrom(r.Context()); run != "" {
+			line += " run=" + run
+		}
+		log.Print(line)
+	}
+}
+
+// dispatch serves r and returns the request the mux matched. Paths are
+// normalized by rt.Paths first. HEAD requests are served by the GET handler
+// of their route, so handlers that switch on the method need not know
+// about HEAD, and the body is dropped.
+func (rt *Router) dispatch(rec *statusRecorder, r *http.Request) *http.Request {
+	dispatched, target := rt.normalizePath(r)
+	if target != "" {
+		http.Redirect(rec, r, target, http.StatusPermanentRedirect)
+		return r
+	}
+	if r.Method == "HEAD" {
+		// A shallow copy, as http.StripPrefix makes, as cloning the
+		// headers costs more than serving most requests
+		get := new(http.Request)
+		*get = *dispatched
+		get.Method = "GET"
+		dispatched = get
+		rec.ResponseWriter = headResponseWriter{rec.ResponseWriter}
+	}
+	rt.mux.ServeHTTP(rec, dispatched)
+	return dispatched
+}
+
+// otherMethodsOr wraps the catch-all "/" route. Methods only it matches,
+// on paths other routes serve with other methods, get 405 with an Allow
+// header, or 204 with it for OPTIONS, as the mux would without the
+// catch-all. The other routes are only probed once the mux has fallen
+// back to the catch-all, so requests to them pay nothing for it.
+func (rt *Router) otherMethodsOr(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowed := rt.otherMethods(r); allowed != nil {
+			methodNotAllowed(w, r, allowed...)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// served reports whether a route other than the catch-all "/" serves
+// method requests for u.
+func (rt *Router) served(method, host string, u *url.URL) bool {
+	_, pattern := rt.mux.Handler(&http.Request{Method: method, Host: host, URL: u})
+	return pattern != "" && pattern != "/"
+}
+
+// probedMethods are the methods otherMethods looks for routes of.
+var probedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+
+// otherMethods returns the methods routes other than the catch-all "/"
+// serve the path of r, which the catch-all matched, with.
+func (rt *Router) otherMethods(r *http.Request) []string {
+	if r.URL.Path == "/" {
+		return nil
+	}
+	var allowed []string
+	for _, method := range probedMethods {
+		if rt.served(method, r.Host, r.URL) {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+// funcName names a function by its package and identifier, such as
+// "app.(*App).HealthHandler".
+func funcName(fn any) string {
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	name = strings.TrimSuffix(name, "-fm")
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// metricMethod folds non-standard methods into one label value.
+func metricMethod(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS":
+		return method
+	}
+	return "other"
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// headResponseWriter drops the body a GET handler writes in response to a
+// HEAD request.
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w headResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w headResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,255 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime/debug"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/redact"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+func okHandler(w http.ResponseWriter, r *http.Request) {}
+
+func TestRouterReportsEveryConflict(t *testing.T) {
+	rt := NewRouter(http.NewServeMux())
+	rt.HandleFunc("items", "/items/{id}", okHandler)
+	rt.HandleFunc("items", "/other", okHandler)
+	rt.HandleFunc("item_again", "/items/{id}", okHandler)
+	rt.HandleFunc("item_get", "GET /items/latest", okHandler)
+	rt.HandleFunc("item_edit", "/items/{id}/edit", okHandler)
+	rt.HandleFunc("overlap", "POST /{kind}/latest", okHandler)
+
+	err := rt.Err()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "route items: name already used")
+	assert.Contains(t, err.Error(), "route item_again: pattern")
+	assert.Contains(t, err.Error(), "route overlap:")
+
+	var names []string
+	for _, route := range rt.Routes() {
+		names = append(names, route.Name)
+	}
+	assert.Equal(t, []string{"items", "item_get", "item_edit"}, names, "conflicting routes are not registered")
+}
+
+func TestRoutesHandlerListsRoutes(t *testing.T) {
+	app := &App{AdminToken: "secret"}
+	rt := NewRouter(http.NewServeMux())
+	rt.HandleFunc("health", "/health", app.HealthHandler)
+	rt.HandleFunc("admin_maintenance", "/admin/maintenance", app.MaintenanceHandler, app.RequireAdmin)
+	rt.HandleFunc("routes", "GET /debug/routes", rt.RoutesHandler)
+	require.NoError(t, rt.Err())
+
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest("GET", "/debug/routes", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var routes []types.Route
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &routes))
+	assert.Equal(t, []types.Route{
+		{Name: "health", Method: AnyMethod, Pattern: "/health", Middleware: []string{}, Handler: "app.(*App).HealthHandler"},
+		{Name: "admin_maintenance", Method: AnyMethod, Pattern: "/admin/maintenance", Middleware: []string{"app.(*App).RequireAdmin"}, Handler: "app.(*App).MaintenanceHandler"},
+		{Name: "routes", Method: "GET", Pattern: "/debug/routes", Middleware: []string{}, Handler: "app.(*Router).RoutesHandler"},
+	}, routes)
+}
+
+func TestRouterServesHeadWithGetHandler(t *testing.T) {
+	rt := NewRouter(http.NewServeMux())
+	rt.HandleFunc("items", "/items", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			methodNotAllowed(w, r, "GET")
+			return
+		}
+		w.Header().Set("X-Items", "3")
+		w.Write([]byte("[1,2,3]"))
+	})
+
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest("HEAD", "/items", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "3", w.Header().Get("X-Items"))
+	assert.Empty(t, w.Body.String())
+}
+
+func TestRouterAnswersMethodsShadowedByCatchAll(t *testing.T) {
+	rt := NewRouter(http.NewServeMux())
+	rt.HandleFunc("item_get", "GET /items/{id}", okHandler)
+	rt.HandleFunc("item_delete", "DELETE /items/{id}", okHandler)
+	rt.HandleFunc("root", "/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("root"))
+	})
+
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest("POST", "/items/1", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "GET, HEAD, DELETE, OPTIONS", w.Header().Get("Allow"))
+
+	w = httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/items/1", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "GET, HEAD, DELETE, OPTIONS", w.Header().Get("Allow"))
+
+	// Paths no other route serves are left to the catch-all
+	for _, path := range []string{"/", "/other"} {
+		w = httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		assert.Equal(t, "root", w.Body.String(), path)
+	}
+}
+
+func TestMethodOverride(t *testing.T) {
+	var got string
+	record := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Method + " " + r.Header.Get(MethodOverrideHeader)
+	})
+	serve := func(app *App, method, override string) int {
+		got = ""
+		r := httptest.NewRequest(method, "/items/1", nil)
+		r.Header.Set(MethodOverrideHeader, override)
+		w := httptest.NewRecorder()
+		app.MethodOverrideMiddleware(record).ServeHTTP(w, r)
+		return w.Code
+	}
+
+	serve(&App{}, "POST", "DELETE")
+	assert.Equal(t, "POST DELETE", got, "ignored unless enabled")
+
+	app := &App{MethodOverride: true}
+	serve(app, "POST", "patch")
+	assert.Equal(t, "PATCH ", got)
+	serve(app, "GET", "DELETE")
+	assert.Equal(t, "GET DELETE", got, "only POST is overridden")
+
+	assert.Equal(t, http.StatusBadRequest, serve(app, "POST", "GET"))
+	assert.Empty(t, got)
+}
+
+func TestRouterAppliesMiddleware(t *testing.T) {
+	app := &App{AdminToken: "secret"}
+	rt := NewRouter(http.NewServeMux())
+	rt.HandleFunc("admin", "/admin/x", okHandler, app.RequireAdmin)
+
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest("GET", "/admin/x", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestRouterRedactsLoggedURLs(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	rt := NewRouter(http.NewServeMux())
+	rt.LogRequests = true
+	redactor, err := redact.New(redact.DefaultPatterns, redact.DefaultParams)
+	require.NoError(t, err)
+	rt.Redactor = redactor
+	rt.HandleFunc("items", "/items", okHandler)
+
+	rt.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/items?tag=a&token=s3cr3t&owner=bob%40example.com", nil))
+	assert.Contains(t, logs.String(), "/items?tag=a&token=%5Bredacted%5D")
+	assert.NotContains(t, logs.String(), "s3cr3t")
+	assert.NotContains(t, logs.String(), "bob")
+}
+
+// discardWriter is a ResponseWriter that allocates nothing, unlike a
+// ResponseRecorder, so that allocation budgets count the code under test.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+// raceEnabled reports whether the tests run with the race detector, under
+// which sync.Pool drops items at random and allocation counts vary.
+func raceEnabled() bool {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return false
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "-race" {
+			return setting.Value == "true"
+		}
+	}
+	return false
+}
+
+// hotPath is the router and the server middleware of main's serverHandler
+// in front of handlers that allocate nothing.
+func hotPath() (*Router, http.Handler) {
+	app := &App{}
+	rt := NewRouter(http.NewServeMux())
+	rt.HandleFunc("data", "GET /api/data", okHandler)
+	rt.HandleFunc("data_item", "GET /api/data/{id}", okHandler)
+	rt.HandleFunc("root", "/", okHandler)
+	return rt, app.ServerTimingMiddleware(app.ClientIPMiddleware(app.DeadlineMiddleware(app.ConcurrencyMiddleware(
+		app.MethodOverrideMiddleware(app.PropagationMiddleware(app.TestRunMiddleware(app.MaintenanceMiddleware(rt))))))))
+}
+
+// TestHotPathAllocations holds requests to the allocations they cost on
+// their way to a handler. Every request pays each of them, so a budget is
+// only raised for a reason.
+func TestHotPathAllocations(t *testing.T) {
+	if raceEnabled() {
+		t.Skip("allocation counts vary under the race detector")
+	}
+	rt, server := hotPath()
+	for _, tc := range []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		// router is the budget of the router alone, server that of the
+		// router behind the server middleware
+		router, server float64
+	}{
+		// The server middleware adds the context of requestValues and
+		// the request carrying it
+		{name: "static", method: "GET", path: "/api/data", router: 0, server: 2},
+		// The mux allocates the values of path wildcards
+		{name: "wildcard", method: "GET", path: "/api/data/7", router: 1, server: 3},
+		// HEAD copies the request as GET and wraps the writer
+		{name: "head", method: "HEAD", path: "/api/data", router: 2, server: 4},
+		// The run is passed on to outbound calls in a header of their own
+		{name: "test_run", method: "GET", path: "/api/data", headers: map[string]string{
+			TestRunHeader: "run-1",
+		}, router: 0, server: 7},
+		{name: "traced", method: "GET", path: "/api/data", headers: map[string]string{
+			TestRunHeader: "run-1",
+			"Traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		}, router: 0, server: 13},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.path, nil)
+			for name, value := range tc.headers {
+				r.Header.Set(name, value)
+			}
+			w := &discardWriter{header: http.Header{}}
+			assert.LessOrEqual(t, testing.AllocsPerRun(100, func() { rt.ServeHTTP(w, r) }), tc.router, "router")
+			assert.LessOrEqual(t, testing.AllocsPerRun(100, func() { server.ServeHTTP(w, r) }), tc.server, "server")
+		})
+	}
+}
+
+func BenchmarkHotPath(b *testing.B) {
+	_, server := hotPath()
+	r := httptest.NewRequest("GET", "/api/data/7", nil)
+	r.Header.Set(TestRunHeader, "run-1")
+	w := &discardWriter{header: http.Header{}}
+	b.ReportAllocs()
+	for b.Loop() {
+		server.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/nesymno/run-tests-example/metrics"
+)
+
+var serializedWaits = metrics.NewCounter("app_serialized_waits_total",
+	"Requests that waited for another request on the same row in serialized mode.")
+
+// keyedLock is a set of mutexes by key, each existing only while it is held
+// or waited for. The zero value is ready to use.
+type keyedLock struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+// keyLock is held by whoever has put a value in ch.
+type keyLock struct {
+	ch    chan struct{}
+	users int
+}
+
+// Lock blocks until it holds key or ctx is done; on success the returned
+// function releases key.
+func (l *keyedLock) Lock(ctx context.Context, key string) (func(), error) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*keyLock)
+	}
+	k, ok := l.locks[key]
+	if !ok {
+		k = &keyLock{ch: make(chan struct{}, 1)}
+		l.locks[key] = k
+	}
+	k.users++
+	l.mu.Unlock()
+
+	release := func() {
+		l.mu.Lock()
+		if k.users--; k.users == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+
+	select {
+	case k.ch <- struct{}{}:
+	default:
+		serializedWaits.Inc()
+		select {
+		case k.ch <- struct{}{}:
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return func() {
+		<-k.ch
+		release()
+	}, nil
+}
+
+// SerializeByID runs the requests on one test_data row, named by the {id}
+// path value, one at a time in arrival order when SerializeRequests is
+// set, so tests racing requests on a row get the same outcome on every
+// run. Requests on different rows still run concurrently.
+func (app *App) SerializeByID(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if !app.SerializeRequests || id == "" {
+			next(w, r)
+			return
+		}
+		unlock, err := app.rowLocks.Lock(r.Context(), "test_data:"+id)
+		if err != nil {
+			// The client is gone
+			return
+		}
+		defer unlock()
+		next(w, r)
+	}
+}
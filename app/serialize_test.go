@@ -0,0 +1,76 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyedLock(t *testing.T) {
+	var l keyedLock
+	unlock, err := l.Lock(context.Background(), "a")
+	require.NoError(t, err)
+
+	// Other keys are not blocked
+	unlockB, err := l.Lock(context.Background(), "b")
+	require.NoError(t, err)
+	unlockB()
+
+	// The same key is, until ctx gives up
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = l.Lock(ctx, "a")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	acquired := make(chan func())
+	go func() {
+		unlock, _ := l.Lock(context.Background(), "a")
+		acquired <- unlock
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("lock acquired while held")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlock()
+	(<-acquired)()
+
+	assert.Empty(t, l.locks, "released locks are kept")
+}
+
+func TestSerializeByID(t *testing.T) {
+	var running, peak atomic.Int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		n := running.Add(1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+	}
+	run := func(app *App, ids ...string) int32 {
+		peak.Store(0)
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/data/{id}/comments", app.SerializeByID(handler))
+		var wg sync.WaitGroup
+		for _, id := range ids {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/data/"+id+"/comments", nil))
+			}()
+		}
+		wg.Wait()
+		return peak.Load()
+	}
+
+	assert.Equal(t, int32(1), run(&App{SerializeRequests: true}, "1", "1", "1", "1"))
+	assert.Greater(t, run(&App{SerializeRequests: true}, "1", "2", "3", "4"), int32(1))
+	assert.Greater(t, run(&App{}, "1", "1", "1", "1"), int32(1))
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/nesymno/run-tests-example/servertiming"
+)
+
+// serverTimingHeaderKey is the canonical key of the header, so setting it
+// needs no canonicalization.
+const serverTimingHeaderKey = "Server-Timing"
+
+// ServerTimingMiddleware adds a Server-Timing header to every response
+// while ServerTiming is set, telling the time the request spent in
+// PostgreSQL (db), in Redis (cache) and in the app until the response was
+// written, so that tests can assert on where the time goes without
+// tracing. The clients must be instrumented, see servertiming.
+func (app *App) ServerTimingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.ServerTiming {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, timings := servertiming.WithTimings(r.Context())
+		tw := &timingWriter{ResponseWriter: w, timings: timings}
+		next.ServeHTTP(tw, r.WithContext(ctx))
+		// A handler that wrote nothing has its headers written on return
+		tw.setHeader()
+	})
+}
+
+// timingWriter sets the Server-Timing header when the response headers
+// are written.
+type timingWriter struct {
+	http.ResponseWriter
+	timings *servertiming.Timings
+	wrote   bool
+}
+
+func (w *timingWriter) setHeader() {
+	if !w.wrote {
+		w.wrote = true
+		w.Header()[serverTimingHeaderKey] = []string{w.timings.Header()}
+	}
+}
+
+func (w *timingWriter) WriteHeader(status int) {
+	// Informational responses leave the final headers to come
+	if status >= 200 {
+		w.setHeader()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timingWriter) Write(b []byte) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/servertiming"
+)
+
+func TestServerTimingHeader(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rds.Close()
+	rds.AddHook(servertiming.RedisHook())
+
+	app := &App{Rds: rds, ServerTiming: true}
+	handler := app.ServerTimingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, app.Rds.Set(r.Context(), "k", "v", 0).Err())
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+		// Time after the headers are written is not reported
+		time.Sleep(50 * time.Millisecond)
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/cache", nil))
+
+	durations, err := servertiming.Parse(w.Header().Get("Server-Timing"))
+	require.NoError(t, err)
+	assert.Positive(t, durations[servertiming.Cache])
+	assert.Zero(t, durations[servertiming.DB])
+	assert.GreaterOrEqual(t, durations[servertiming.App], 19*time.Millisecond, "the sleep, give or take rounding")
+	assert.Less(t, durations[servertiming.App], 70*time.Millisecond)
+}
+
+func TestServerTimingHeaderWithoutBody(t *testing.T) {
+	app := &App{ServerTiming: true}
+	handler := app.ServerTimingMiddleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Regexp(t, `^db;dur=0\.0, cache;dur=0\.0, app;dur=\d+\.\d$`, w.Header().Get("Server-Timing"))
+}
+
+func TestServerTimingDisabled(t *testing.T) {
+	app := &App{}
+	w := httptest.NewRecorder()
+	app.ServerTimingMiddleware(http.HandlerFunc(okHandler)).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Empty(t, w.Header().Values("Server-Timing"))
+}
@@ -0,0 +1,56 @@
+package app
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/nesymno/run-tests-example/metrics"
+	"github.com/nesymno/run-tests-example/satoken"
+)
+
+var serviceAuthChecks = metrics.NewCounterVec("app_service_auth_checks_total",
+	"Service account token checks by result: ok, missing, invalid, forbidden or error.", "result")
+
+// RequireServiceAccount requires a Kubernetes service account token as a
+// bearer token, issued to a service account on the ServiceAccounts
+// allowlist. It lets every request through while ServiceAuth is nil.
+func (app *App) RequireServiceAccount(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.ServiceAuth == nil {
+			next(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			serviceAuthChecks.With("missing").Inc()
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Service account token required", http.StatusUnauthorized)
+			return
+		}
+
+		id, err := app.ServiceAuth.Verify(r.Context(), token)
+		if errors.Is(err, satoken.ErrInvalidToken) {
+			serviceAuthChecks.With("invalid").Inc()
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Invalid service account token", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			serviceAuthChecks.With("error").Inc()
+			log.Printf("Service account token check failed: %v", err)
+			http.Error(w, "Service account token could not be verified", http.StatusServiceUnavailable)
+			return
+		}
+		if !app.ServiceAccounts.Allows(id) {
+			serviceAuthChecks.With("forbidden").Inc()
+			http.Error(w, "Service account "+id.Subject()+" is not allowed", http.StatusForbidden)
+			return
+		}
+
+		serviceAuthChecks.With("ok").Inc()
+		next(w, r)
+	}
+}
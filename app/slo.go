@@ -0,0 +1,275 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nesymno/run-tests-example/metrics"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// Kinds of SLO.
+const (
+	// SLOAvailability counts requests answered without a 5xx as good.
+	SLOAvailability = "availability"
+	// SLOLatency counts requests answered within the SLO's latency as good.
+	SLOLatency = "latency"
+)
+
+// sloBurnWindow is the short lookback of burn rates, next to the whole
+// window, as in multiwindow burn rate alerts.
+const sloBurnWindow = 5 * time.Minute
+
+var (
+	sloRequests = metrics.NewCounterVec("app_slo_requests_total",
+		"Requests counted by each SLO, good or bad.", "route", "kind", "result")
+	sloObjective = metrics.NewGaugeVec("app_slo_objective",
+		"Share of good requests each SLO asks for.", "route", "kind")
+	sloBurnRate = metrics.NewGaugeVec("app_slo_burn_rate",
+		"Rate at which each SLO spends its error budget over a lookback window; 1 spends it exactly over the SLO window.",
+		"route", "kind", "window")
+	sloBudgetRemaining = metrics.NewGaugeVec("app_slo_error_budget_remaining",
+		"Share of each SLO's error budget left over the SLO window; negative once overspent.", "route", "kind")
+)
+
+// SLO is an objective of one route: the share of its requests that must
+// be good.
+type SLO struct {
+	Route string
+	Kind  string
+	// Objective is the share of good requests, such as 0.999.
+	Objective float64
+	// Latency is the time within which a request is good, for
+	// SLOLatency.
+	Latency time.Duration
+}
+
+// ParseSLOs parses a comma-separated list of route:availability:percent
+// and route:latency:duration:percent objectives, such as
+// "data:availability:99.9,data:latency:250ms:99".
+func ParseSLOs(list string) ([]SLO, error) {
+	var slos []SLO
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		slo := SLO{Route: parts[0]}
+		if len(parts) > 1 {
+			slo.Kind = parts[1]
+		}
+		var percent string
+		switch {
+		case slo.Kind == SLOAvailability && len(parts) == 3:
+			percent = parts[2]
+		case slo.Kind == SLOLatency && len(parts) == 4:
+			latency, err := time.ParseDuration(parts[2])
+			if err != nil || latency <= 0 {
+				return nil, fmt.Errorf("invalid SLO %q: latency must be a positive duration", entry)
+			}
+			slo.Latency, percent = latency, parts[3]
+		default:
+			return nil, fmt.Errorf("invalid SLO %q, want route:availability:percent or route:latency:duration:percent", entry)
+		}
+		objective, err := strconv.ParseFloat(percent, 64)
+		if err != nil || objective <= 0 || objective >= 100 {
+			return nil, fmt.Errorf("invalid SLO %q: percent must be between 0 and 100, exclusive", entry)
+		}
+		slo.Objective = objective / 100
+
+		for _, other := range slos {
+			if other.Route == slo.Route && other.Kind == slo.Kind {
+				return nil, fmt.Errorf("invalid SLO %q: route %s already has a %s SLO", entry, slo.Route, slo.Kind)
+			}
+		}
+		slos = append(slos, slo)
+	}
+	return slos, nil
+}
+
+// SLOTracker tracks the compliance of SLOs over a sliding window, counted
+// in minutes. It is safe for concurrent use.
+type SLOTracker struct {
+	window  time.Duration
+	slos    []*sloState
+	byRoute map[string][]*sloState
+}
+
+// sloBucket counts the requests of one minute.
+type sloBucket struct {
+	good, bad int64
+}
+
+func (b sloBucket) burnRate(objective float64) float64 {
+	if b.good+b.bad == 0 {
+		return 0
+	}
+	return float64(b.bad) / float64(b.good+b.bad) / (1 - objective)
+}
+
+type sloState struct {
+	SLO
+
+	mu      sync.Mutex
+	buckets []sloBucket
+	// minute is the Unix minute of the newest bucket
+	minute int64
+	// total sums the buckets
+	total sloBucket
+}
+
+// NewSLOTracker tracks slos over window, rounded up to whole minutes.
+func NewSLOTracker(slos []SLO, window time.Duration) *SLOTracker {
+	minutes := max(int((window+time.Minute-1)/time.Minute), 1)
+	t := &SLOTracker{window: time.Duration(minutes) * time.Minute, byRoute: map[string][]*sloState{}}
+	for _, slo := range slos {
+		s := &sloState{SLO: slo, buckets: make([]sloBucket, minutes)}
+		t.slos = append(t.slos, s)
+		t.byRoute[slo.Route] = append(t.byRoute[slo.Route], s)
+		sloObjective.With(slo.Route, slo.Kind).Set(slo.Objective)
+	}
+	return t
+}
+
+// Validate reports SLOs of routes that are not registered.
+func (t *SLOTracker) Validate(routes []types.Route) error {
+	for _, s := range t.slos {
+		if !slices.ContainsFunc(routes, func(r types.Route) bool { return r.Name == s.Route }) {
+			return fmt.Errorf("SLO of unknown route %s", s.Route)
+		}
+	}
+	return nil
+}
+
+// Observe counts a request to route against its SLOs.
+func (t *SLOTracker) Observe(route string, status int, elapsed time.Duration) {
+	t.observe(route, status, elapsed, time.Now())
+}
+
+func (t *SLOTracker) observe(route string, status int, elapsed time.Duration, now time.Time) {
+	for _, s := range t.byRoute[route] {
+		good := status < 500
+		if s.Kind == SLOLatency {
+			good = elapsed <= s.Latency
+		}
+		result := "bad"
+		if good {
+			result = "good"
+		}
+		sloRequests.With(s.Route, s.Kind, result).Inc()
+
+		s.mu.Lock()
+		s.advance(now)
+		bucket := &s.buckets[s.minute%int64(len(s.buckets))]
+		if good {
+			bucket.good++
+			s.total.good++
+		} else {
+			bucket.bad++
+			s.total.bad++
+		}
+		current := s.status(t.window)
+		s.mu.Unlock()
+		publishSLOStatus(current)
+	}
+}
+
+// advance moves the newest bucket to now's minute, dropping the buckets
+// that fall out of the window.
+func (s *sloState) advance(now time.Time) {
+	minute := now.Unix() / 60
+	if s.minute == 0 || minute-s.minute >= int64(len(s.buckets)) {
+		clear(s.buckets)
+		s.total = sloBucket{}
+		s.minute = minute
+		return
+	}
+	for s.minute < minute {
+		s.minute++
+		expired := &s.buckets[s.minute%int64(len(s.buckets))]
+		s.total.good -= expired.good
+		s.total.bad -= expired.bad
+		*expired = sloBucket{}
+	}
+}
+
+// last sums the buckets of the last n minutes.
+func (s *sloState) last(n int) sloBucket {
+	var sum sloBucket
+	for i := range min(n, len(s.buckets)) {
+		b := s.buckets[(s.minute-int64(i))%int64(len(s.buckets))]
+		sum.good += b.good
+		sum.bad += b.bad
+	}
+	return sum
+}
+
+func (s *sloState) status(window time.Duration) types.SLOStatus {
+	status := types.SLOStatus{
+		Route:         s.Route,
+		Kind:          s.Kind,
+		Objective:     s.Objective,
+		LatencyMillis: s.Latency.Milliseconds(),
+		Requests:      s.total.good + s.total.bad,
+		Good:          s.total.good,
+		BurnRates: map[string]float64{
+			formatMinutes(sloBurnWindow): s.last(int(sloBurnWindow / time.Minute)).burnRate(s.Objective),
+			formatMinutes(window):        s.total.burnRate(s.Objective),
+		},
+	}
+	status.ErrorBudgetRemaining = 1 - status.BurnRates[formatMinutes(window)]
+	status.Met = status.ErrorBudgetRemaining >= 0
+	if status.Requests > 0 {
+		compliance := float64(status.Good) / float64(status.Requests)
+		status.Compliance = &compliance
+	}
+	return status
+}
+
+func publishSLOStatus(status types.SLOStatus) {
+	for window, rate := range status.BurnRates {
+		sloBurnRate.With(status.Route, status.Kind, window).Set(rate)
+	}
+	sloBudgetRemaining.With(status.Route, status.Kind).Set(status.ErrorBudgetRemaining)
+}
+
+func formatMinutes(d time.Duration) string {
+	return strconv.Itoa(int(d/time.Minute)) + "m"
+}
+
+// Report returns the compliance of every SLO, and refreshes their
+// metrics, which are otherwise only updated as requests are served.
+func (t *SLOTracker) Report() *types.SLOReport {
+	return t.report(time.Now())
+}
+
+func (t *SLOTracker) report(now time.Time) *types.SLOReport {
+	report := &types.SLOReport{WindowSeconds: int64(t.window.Seconds()), SLOs: []types.SLOStatus{}}
+	for _, s := range t.slos {
+		s.mu.Lock()
+		s.advance(now)
+		status := s.status(t.window)
+		s.mu.Unlock()
+		publishSLOStatus(status)
+		report.SLOs = append(report.SLOs, status)
+	}
+	return report
+}
+
+// DebugSLOHandler reports the compliance, error budget and burn rates of
+// every SLO.
+func (app *App) DebugSLOHandler(w http.ResponseWriter, r *http.Request) {
+	report := &types.SLOReport{SLOs: []types.SLOStatus{}}
+	if app.SLOs != nil {
+		report = app.SLOs.Report()
+	}
+	setJSONContentType(w)
+	app.writeJSON(w, r, report)
+}
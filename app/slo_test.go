@@ -0,0 +1,107 @@
+package app
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+func TestParseSLOs(t *testing.T) {
+	slos, err := ParseSLOs(" data:availability:99.9, data:latency:250ms:99 ,,jobs:availability:95")
+	require.NoError(t, err)
+	want := []SLO{
+		{Route: "data", Kind: SLOAvailability, Objective: 0.999},
+		{Route: "data", Kind: SLOLatency, Objective: 0.99, Latency: 250 * time.Millisecond},
+		{Route: "jobs", Kind: SLOAvailability, Objective: 0.95},
+	}
+	require.Len(t, slos, len(want))
+	for i, slo := range slos {
+		assert.InDelta(t, want[i].Objective, slo.Objective, 1e-9)
+		slo.Objective = want[i].Objective
+		assert.Equal(t, want[i], slo)
+	}
+
+	for _, list := range []string{
+		"data",
+		"data:availability",
+		"data:availability:100",
+		"data:availability:0",
+		"data:availability:high",
+		"data:latency:99",
+		"data:latency:fast:99",
+		"data:latency:-1s:99",
+		"data:errors:1",
+		"data:availability:99,data:availability:99.9",
+	} {
+		_, err := ParseSLOs(list)
+		assert.Error(t, err, list)
+	}
+}
+
+func TestSLOTrackerBurnRates(t *testing.T) {
+	tracker := NewSLOTracker([]SLO{
+		{Route: "data", Kind: SLOAvailability, Objective: 0.9},
+		{Route: "data", Kind: SLOLatency, Objective: 0.5, Latency: 100 * time.Millisecond},
+	}, 30*time.Minute)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// 20 minutes ago: 10 good requests, 10 slow ones
+	for i := range 20 {
+		tracker.observe("data", http.StatusOK, time.Duration(i+1)*10*time.Millisecond, start)
+	}
+	// Now: 8 good requests and 2 failures, all fast
+	now := start.Add(20 * time.Minute)
+	for i := range 10 {
+		status := http.StatusOK
+		if i < 2 {
+			status = http.StatusServiceUnavailable
+		}
+		tracker.observe("data", status, time.Millisecond, now)
+	}
+	tracker.observe("other", http.StatusInternalServerError, time.Second, now)
+
+	report := tracker.report(now)
+	assert.Equal(t, int64(30*60), report.WindowSeconds)
+	require.Len(t, report.SLOs, 2)
+
+	availability := report.SLOs[0]
+	assert.Equal(t, int64(30), availability.Requests)
+	assert.Equal(t, int64(28), availability.Good)
+	require.NotNil(t, availability.Compliance)
+	assert.InDelta(t, 28.0/30, *availability.Compliance, 1e-9)
+	assert.InDelta(t, 2.0, availability.BurnRates["5m"], 1e-9, "2 bad of 10 against a budget of 10%")
+	assert.InDelta(t, 2.0/3, availability.BurnRates["30m"], 1e-9)
+	assert.InDelta(t, 1.0/3, availability.ErrorBudgetRemaining, 1e-9)
+	assert.True(t, availability.Met)
+
+	latency := report.SLOs[1]
+	assert.Equal(t, int64(100), latency.LatencyMillis)
+	assert.Equal(t, int64(20), latency.Good)
+	assert.Zero(t, latency.BurnRates["5m"])
+	assert.InDelta(t, 1.0/3/0.5, latency.BurnRates["30m"], 1e-9)
+	assert.True(t, latency.Met)
+
+	// Once the first requests leave the window, only the failures remain
+	report = tracker.report(start.Add(31 * time.Minute))
+	assert.Equal(t, int64(10), report.SLOs[0].Requests)
+	assert.Zero(t, report.SLOs[0].BurnRates["5m"])
+	assert.InDelta(t, -1.0, report.SLOs[0].ErrorBudgetRemaining, 1e-9)
+	assert.False(t, report.SLOs[0].Met)
+
+	// And nothing is left after a whole idle window
+	report = tracker.report(start.Add(2 * time.Hour))
+	assert.Zero(t, report.SLOs[0].Requests)
+	assert.Nil(t, report.SLOs[0].Compliance)
+	assert.Equal(t, 1.0, report.SLOs[0].ErrorBudgetRemaining)
+}
+
+func TestSLOTrackerValidate(t *testing.T) {
+	tracker := NewSLOTracker([]SLO{{Route: "data", Kind: SLOAvailability, Objective: 0.99}}, time.Hour)
+	assert.NoError(t, tracker.Validate([]types.Route{{Name: "health"}, {Name: "data"}}))
+	assert.ErrorContains(t, tracker.Validate([]types.Route{{Name: "health"}}), "unknown route data")
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// processStart is when this replica started, for the snapshot uptime.
+var processStart = time.Now()
+
+// SnapshotPolicy controls the periodic snapshots written for soak tests.
+type SnapshotPolicy struct {
+	// Dir receives one snapshots-<hostname>.jsonl file per replica; empty
+	// disables periodic snapshots.
+	Dir      string
+	Interval time.Duration
+}
+
+// snapshot collects the internal state of this replica in one value.
+func (app *App) snapshot(ctx context.Context) types.Snapshot {
+	db := app.DB.Stats()
+	snap := types.Snapshot{
+		Timestamp:     time.Now().UTC(),
+		UptimeSeconds: time.Since(processStart).Seconds(),
+		Runtime:       readGCStats(),
+		DB: types.DBPoolStats{
+			MaxOpen:      db.MaxOpenConnections,
+			Open:         db.OpenConnections,
+			InUse:        db.InUse,
+			Idle:         db.Idle,
+			WaitCount:    db.WaitCount,
+			WaitMillis:   db.WaitDuration.Milliseconds(),
+			ClosedIdle:   db.MaxIdleClosed + db.MaxIdleTimeClosed,
+			ClosedMaxAge: db.MaxLifetimeClosed,
+		},
+		Cache: app.cacheReport(ctx),
+	}
+	if rds := app.Rds.PoolStats(); rds != nil {
+		snap.Redis = types.RedisPoolStats{
+			Hits:       rds.Hits,
+			Misses:     rds.Misses,
+			Timeouts:   rds.Timeouts,
+			TotalConns: rds.TotalConns,
+			IdleConns:  rds.IdleConns,
+			StaleConns: rds.StaleConns,
+		}
+	}
+	return snap
+}
+
+// DebugSnapshotHandler returns heap and GC statistics, goroutines, the
+// database and Redis pool statistics, the cache report and the uptime in
+// one JSON document, for soak tests without a metrics stack.
+func (app *App) DebugSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	setJSONContentType(w)
+	app.writeJSON(w, r, app.snapshot(r.Context()))
+}
+
+// RunSnapshots appends a snapshot to this replica's file in
+// app.Snapshots.Dir every interval until ctx is done.
+func (app *App) RunSnapshots(ctx context.Context) {
+	if app.Snapshots.Dir == "" {
+		return
+	}
+
+	ticker := time.NewTicker(app.Snapshots.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := app.writeSnapshot(ctx); err != nil {
+				log.Printf("snapshot: %v", err)
+			}
+		}
+	}
+}
+
+func (app *App) writeSnapshot(ctx context.Context) error {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	path := filepath.Join(app.Snapshots.Dir, fmt.Sprintf("snapshots-%s.jsonl", host))
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	// One line per snapshot, so the file can be read while it grows
+	if err := json.NewEncoder(f).Encode(app.snapshot(ctx)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+func TestWriteSnapshotAppendsLines(t *testing.T) {
+	mr := miniredis.RunT(t)
+	db, err := sql.Open("postgres", "host=127.0.0.1 port=1 sslmode=disable")
+	require.NoError(t, err)
+	defer db.Close()
+	app := &App{DB: db, Rds: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+	app.Snapshots.Dir = t.TempDir()
+
+	ctx := context.Background()
+	require.NoError(t, app.writeSnapshot(ctx))
+	require.NoError(t, app.writeSnapshot(ctx))
+
+	files, err := filepath.Glob(filepath.Join(app.Snapshots.Dir, "snapshots-*.jsonl"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	f, err := os.Open(files[0])
+	require.NoError(t, err)
+	defer f.Close()
+
+	var snaps []types.Snapshot
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var snap types.Snapshot
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &snap))
+		snaps = append(snaps, snap)
+	}
+	require.Len(t, snaps, 2)
+	assert.Positive(t, snaps[0].Runtime.Goroutines)
+	assert.Positive(t, snaps[0].Runtime.HeapAlloc)
+	assert.LessOrEqual(t, snaps[0].UptimeSeconds, snaps[1].UptimeSeconds)
+}
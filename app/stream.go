@@ -0,0 +1,116 @@
+package app
+
+import (
+	"bufio"
+	"log"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/nesymno/run-tests-example/jsonpolicy"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// Streamed listings are flushed after the first row, so clients see data
+// as soon as the query returns any, and then every streamFlushRows rows or
+// streamFlushInterval, whichever comes first.
+const (
+	streamFlushRows     = 500
+	streamFlushInterval = 250 * time.Millisecond
+)
+
+// Stream formats of ?stream=.
+const (
+	streamArray  = "true"
+	streamNDJSON = "ndjson"
+)
+
+// validStream reports whether s is a ?stream= value, empty for none.
+func validStream(s string) bool {
+	return s == "" || s == streamArray || s == streamNDJSON
+}
+
+// writeDataStream writes the rows each yields as they arrive instead of
+// rendering the listing first, as a JSON array or as NDJSON. Streamed
+// listings bypass the cache. An error after the first byte can no longer
+// change the status, so it aborts the response, leaving the client a
+// truncated body rather than a listing that looks complete.
+func (app *App) writeDataStream(w http.ResponseWriter, r *http.Request, filter dataFilter, format string, each func(fn func(types.TestData) error) error) {
+	policy := jsonpolicy.ForRequest(r, app.JSON)
+	rc := http.NewResponseController(w)
+	bw := bufio.NewWriter(w)
+
+	if format == streamNDJSON {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		setJSONContentType(w)
+	}
+	w.Header().Set("X-Cache", "BYPASS")
+
+	started := false
+	n := 0
+	pending := 0
+	lastFlush := time.Now()
+	flush := func() error {
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		pending = 0
+		lastFlush = time.Now()
+		// Writers that cannot flush still get the whole stream
+		if err := rc.Flush(); err != nil && err != http.ErrNotSupported {
+			return err
+		}
+		return nil
+	}
+
+	err := each(func(data types.TestData) error {
+		var row any = data
+		if filter.Fields != nil {
+			row = projectData([]types.TestData{data}, filter.Fields)[0]
+		}
+		b, err := jsonpolicy.Marshal(row, jsonpolicy.Policy{})
+		if err != nil {
+			return err
+		}
+		if b, err = jsonpolicy.Rewrite(b, reflect.TypeFor[types.TestData](), policy); err != nil {
+			return err
+		}
+
+		switch {
+		case format == streamNDJSON:
+		case n == 0:
+			bw.WriteByte('[')
+		default:
+			bw.WriteByte(',')
+		}
+		bw.Write(b)
+		if format == streamNDJSON {
+			bw.WriteByte('\n')
+		}
+		started = true
+		n++
+		pending++
+
+		if n == 1 || pending >= streamFlushRows || time.Since(lastFlush) >= streamFlushInterval {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		if !started {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("List stream aborted after %d rows: %v", n, err)
+		panic(http.ErrAbortHandler)
+	}
+
+	if format != streamNDJSON {
+		if n == 0 {
+			bw.WriteByte('[')
+		}
+		bw.WriteString("]\n")
+	}
+	bw.Flush()
+}
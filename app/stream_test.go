@@ -0,0 +1,112 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// streamServer serves writeDataStream over rows, waiting for release
+// after the first row as a slow query would.
+func streamServer(t *testing.T, format string, filter dataFilter, release <-chan struct{}, fail error) *httptest.Server {
+	rows := []types.TestData{
+		{ID: 1, Name: "first", Status: types.StatusActive, Tags: []string{}},
+		{ID: 2, Name: "second", Status: types.StatusActive, Tags: []string{}},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		(&App{}).writeDataStream(w, r, filter, format, func(fn func(types.TestData) error) error {
+			for i, row := range rows {
+				if i == 1 {
+					select {
+					case <-release:
+					case <-r.Context().Done():
+						return r.Context().Err()
+					}
+				}
+				if err := fn(row); err != nil {
+					return err
+				}
+			}
+			return fail
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestStreamSendsRowsBeforeQueryFinishes(t *testing.T) {
+	release := make(chan struct{})
+	srv := streamServer(t, streamNDJSON, dataFilter{}, release, nil)
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	// The first row arrives while the source is still blocked
+	lines := bufio.NewReader(resp.Body)
+	first := make(chan string, 1)
+	go func() {
+		line, _ := lines.ReadString('\n')
+		first <- line
+	}()
+	select {
+	case line := <-first:
+		var row types.TestData
+		require.NoError(t, json.Unmarshal([]byte(line), &row))
+		assert.Equal(t, "first", row.Name)
+	case <-time.After(5 * time.Second):
+		t.Fatal("first row not received before the query finished")
+	}
+
+	close(release)
+	rest, err := io.ReadAll(lines)
+	require.NoError(t, err)
+	var row types.TestData
+	require.NoError(t, json.Unmarshal(rest, &row))
+	assert.Equal(t, "second", row.Name)
+}
+
+func TestStreamWritesJSONArray(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	srv := streamServer(t, streamArray, dataFilter{Fields: []string{"id", "name"}}, release, nil)
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.JSONEq(t, `[{"id":1,"name":"first"},{"id":2,"name":"second"}]`, string(body))
+}
+
+func TestStreamAbortsOnLateError(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	srv := streamServer(t, streamArray, dataFilter{}, release, errors.New("connection reset"))
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_, err = io.ReadAll(resp.Body)
+	assert.Error(t, err, "a failed stream must not end like a complete listing")
+}
+
+func TestStreamReportsEarlyError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	(&App{}).writeDataStream(rec, httptest.NewRequest("GET", "/api/data?stream=true", nil), dataFilter{}, streamArray,
+		func(fn func(types.TestData) error) error { return errors.New("Database error: down") })
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
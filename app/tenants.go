@@ -0,0 +1,411 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/lib/pq"
+
+	"github.com/nesymno/run-tests-example/httpclient"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// TenantKeyHeader carries a tenant's API key. Requests to the data
+// endpoints with it only see and change that tenant's rows.
+const TenantKeyHeader = "X-Tenant-Key"
+
+// TenantIDHeader tells downstream services which tenant an outbound call
+// is made for. The key itself is never passed on.
+const TenantIDHeader = "X-Tenant-ID"
+
+type tenantKey struct{}
+
+type tenantConnKey struct{}
+
+// dbtx is what the tenant-scoped handlers need from the database, so they
+// can run either on the pool or on a connection pinned to a tenant's
+// schema.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// db returns the connection for the caller's data: a connection whose
+// search_path points at the tenant's schema for schema-isolated tenants,
+// the pool otherwise.
+func (app *App) db(ctx context.Context) dbtx {
+	if conn, ok := ctx.Value(tenantConnKey{}).(*sql.Conn); ok {
+		return conn
+	}
+	return app.DB
+}
+
+// beginTx starts a transaction on the connection db returns.
+func (app *App) beginTx(ctx context.Context) (*sql.Tx, error) {
+	if conn, ok := ctx.Value(tenantConnKey{}).(*sql.Conn); ok {
+		return conn.BeginTx(ctx, nil)
+	}
+	return app.DB.BeginTx(ctx, nil)
+}
+
+// tenantFrom returns the tenant authenticated by WithTenant, nil for
+// requests without a tenant key.
+func tenantFrom(ctx context.Context) *types.Tenant {
+	tenant, _ := ctx.Value(tenantKey{}).(*types.Tenant)
+	return tenant
+}
+
+// tenantColumn is the test_data.tenant_id of the caller's rows: the
+// tenant's ID under row isolation and NULL otherwise, as rows in a tenant
+// schema are isolated by the schema itself.
+func tenantColumn(tenant *types.Tenant) any {
+	if tenant == nil || tenant.Isolation != types.IsolationRow {
+		return nil
+	}
+	return tenant.ID
+}
+
+// WithTenant scopes a request to the tenant whose key it carries. Requests
+// without a key are served from the shared tables as before; an unknown
+// key is rejected.
+func (app *App) WithTenant(next http.HandlerFunc) http.HandlerFunc {
+	return app.withTenant(next, true)
+}
+
+// WithTenantIdentity authenticates the tenant like WithTenant but does not
+// pin a connection to its schema, for long-lived requests that never query
+// the tenant's tables.
+func (app *App) WithTenantIdentity(next http.HandlerFunc) http.HandlerFunc {
+	return app.withTenant(next, false)
+}
+
+func (app *App) withTenant(next http.HandlerFunc, pinSchema bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(TenantKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		var tenant types.Tenant
+		err := app.DB.QueryRowContext(ctx, `
+			SELECT id, name, isolation, COALESCE(schema_name, ''), created_at
+			FROM tenants WHERE api_key_hash = $1`, hashAPIKey(key),
+		).Scan(&tenant.ID, &tenant.Name, &tenant.Isolation, &tenant.Schema, &tenant.CreatedAt)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Invalid tenant key", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		ctx = context.WithValue(ctx, tenantKey{}, &tenant)
+		ctx = httpclient.WithHeader(ctx, TenantIDHeader, strconv.Itoa(tenant.ID))
+
+		if tenant.Schema != "" && pinSchema {
+			conn, err := app.schemaConn(ctx, tenant.Schema)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+				return
+			}
+			defer releaseSchemaConn(conn)
+			ctx = context.WithValue(ctx, tenantConnKey{}, conn)
+		}
+
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// schemaConn takes a connection from the pool and points its search_path
+// at schema first, so unqualified table names resolve to the tenant's
+// tables and everything else to public.
+func (app *App) schemaConn(ctx context.Context, schema string) (*sql.Conn, error) {
+	conn, err := app.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.ExecContext(ctx, "SELECT set_config('search_path', $1, false)", pq.QuoteIdentifier(schema)+", public")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// releaseSchemaConn resets the search_path before the connection goes back
+// to the pool, discarding the connection if that fails so no later request
+// runs in the tenant's schema.
+func releaseSchemaConn(conn *sql.Conn) {
+	if _, err := conn.ExecContext(context.Background(), "RESET search_path"); err != nil {
+		log.Printf("Discarding tenant connection: %v", err)
+		conn.Raw(func(any) error { return driver.ErrBadConn })
+	}
+	conn.Close()
+}
+
+// TenantsHandler lists (GET) or provisions (POST) tenants.
+func (app *App) TenantsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		tenants, err := app.listTenants(r.Context())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		setJSONContentType(w)
+		app.writeJSON(w, r, tenants)
+	case "POST":
+		var req types.TenantRequest
+		if err := app.decodeJSON(r, &req); err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+		if req.Name == "" {
+			http.Error(w, "Tenant name is required", http.StatusBadRequest)
+			return
+		}
+		if req.Isolation == "" {
+			req.Isolation = types.IsolationRow
+		}
+		if req.Isolation != types.IsolationRow && req.Isolation != types.IsolationSchema {
+			http.Error(w, fmt.Sprintf("Invalid isolation %q", req.Isolation), http.StatusBadRequest)
+			return
+		}
+
+		creds, err := app.provisionTenant(r.Context(), req)
+		if err != nil {
+			writeDBError(w, "Provisioning error", err)
+			return
+		}
+
+		setJSONContentType(w)
+		w.Header().Set("Location", "/admin/tenants/"+strconv.Itoa(creds.ID))
+		app.writeJSONStatus(w, r, http.StatusCreated, creds)
+	default:
+		methodNotAllowed(w, r, "GET", "POST")
+	}
+}
+
+// TenantHandler deprovisions (DELETE) a tenant, deleting all its data.
+func (app *App) TenantHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		methodNotAllowed(w, r, "DELETE")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid tenant ID", http.StatusBadRequest)
+		return
+	}
+
+	err = app.deprovisionTenant(r.Context(), id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeDBError(w, "Deprovisioning error", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *App) listTenants(ctx context.Context) ([]types.Tenant, error) {
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT id, name, isolation, COALESCE(schema_name, ''), created_at
+		FROM tenants ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := []types.Tenant{}
+	for rows.Next() {
+		var t types.Tenant
+		if err := rows.Scan(&t.ID, &t.Name, &t.Isolation, &t.Schema, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		t.CreatedAt = t.CreatedAt.UTC()
+		tenants = append(tenants, t)
+	}
+	return tenants, rows.Err()
+}
+
+// provisionTenant creates a tenant and its API key and, for schema
+// isolation, the tenant's schema, all in one transaction.
+func (app *App) provisionTenant(ctx context.Context, req types.TenantRequest) (*types.TenantCredentials, error) {
+	key, err := newAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := app.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	creds := &types.TenantCredentials{
+		Tenant: types.Tenant{Name: req.Name, Isolation: req.Isolation},
+		APIKey: key,
+	}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO tenants (name, isolation, api_key_hash) VALUES ($1, $2, $3)
+		RETURNING id, created_at`, req.Name, req.Isolation, hashAPIKey(key),
+	).Scan(&creds.ID, &creds.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	creds.CreatedAt = creds.CreatedAt.UTC()
+
+	if req.Isolation == types.IsolationSchema {
+		creds.Schema = tenantSchema(creds.ID)
+		if _, err := tx.ExecContext(ctx, "UPDATE tenants SET schema_name = $1 WHERE id = $2", creds.Schema, creds.ID); err != nil {
+			return nil, err
+		}
+		for _, stmt := range tenantSchemaStatements(creds.Schema) {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return nil, fmt.Errorf("%s: %w", stmt, err)
+			}
+		}
+	}
+
+	return creds, tx.Commit()
+}
+
+// deprovisionTenant deletes a tenant with all its rows, or its whole
+// schema. It returns sql.ErrNoRows for unknown tenants.
+func (app *App) deprovisionTenant(ctx context.Context, id int) error {
+	tx, err := app.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var schema string
+	err = tx.QueryRowContext(ctx,
+		"SELECT COALESCE(schema_name, '') FROM tenants WHERE id = $1 FOR UPDATE", id).Scan(&schema)
+	if err != nil {
+		return err
+	}
+
+	if schema != "" {
+		if _, err := tx.ExecContext(ctx, "DROP SCHEMA IF EXISTS "+pq.QuoteIdentifier(schema)+" CASCADE"); err != nil {
+			return err
+		}
+	} else {
+		// Comments are deleted explicitly, as a partitioned test_data has
+		// no foreign key to cascade from
+		for _, stmt := range []string{
+			"DELETE FROM test_data_comments WHERE data_id IN (SELECT id FROM test_data WHERE tenant_id = $1)",
+			"DELETE FROM test_data WHERE tenant_id = $1",
+			"DELETE FROM test_data_archive WHERE tenant_id = $1",
+		} {
+			if _, err := tx.ExecContext(ctx, stmt, id); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM tenants WHERE id = $1", id); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	app.invalidateList(ctx)
+	return nil
+}
+
+// tenantSchema names the dedicated schema of a tenant.
+func tenantSchema(id int) string {
+	return fmt.Sprintf("tenant_%d", id)
+}
+
+// tenantSchemaStatements create a tenant's schema with its own copy of
+// the data tables, following whatever columns, defaults and indexes
+// initDatabase has given the shared ones. Ids still come from the shared
+// sequences, so they stay unique across tenants.
+func tenantSchemaStatements(schema string) []string {
+	s := pq.QuoteIdentifier(schema)
+	return []string{
+		"CREATE SCHEMA " + s,
+		"CREATE TABLE " + s + ".test_data (LIKE public.test_data INCLUDING ALL)",
+		"CREATE TABLE " + s + ".test_data_comments (LIKE public.test_data_comments INCLUDING ALL)",
+	}
+}
+
+// tenantSchemaUpgrades add the test_data columns introduced after a tenant
+// schema may have been created, as LIKE only copies the columns of the
+// time.
+func tenantSchemaUpgrades(schema string) []string {
+	s := pq.QuoteIdentifier(schema)
+	return []string{
+		"ALTER TABLE " + s + ".test_data ADD COLUMN IF NOT EXISTS secret BYTEA, ADD COLUMN IF NOT EXISTS test_run_id VARCHAR(64)",
+		"CREATE INDEX IF NOT EXISTS test_data_test_run_id_idx ON " + s + ".test_data (test_run_id)",
+	}
+}
+
+// UpgradeTenantSchemas brings the tables of existing tenant schemas up to
+// date with the shared ones.
+func UpgradeTenantSchemas(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, "SELECT schema_name FROM tenants WHERE schema_name IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	var schemas []string
+	for rows.Next() {
+		var schema string
+		if err := rows.Scan(&schema); err != nil {
+			rows.Close()
+			return err
+		}
+		schemas = append(schemas, schema)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, schema := range schemas {
+		for _, stmt := range tenantSchemaUpgrades(schema) {
+			if _, err := db.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("upgrading tenant schema %s: %w", schema, err)
+			}
+		}
+	}
+	return nil
+}
+
+// newAPIKey returns a random tenant API key.
+func newAPIKey() (string, error) {
+	return randomKey("tk_")
+}
+
+// randomKey returns a random key with the given prefix. Keys carry 256
+// bits, so storing an unsalted SHA-256 of them is enough.
+func randomKey(prefix string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
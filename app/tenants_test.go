@@ -0,0 +1,60 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+func TestDataFilterScopesToTenant(t *testing.T) {
+	row := &types.Tenant{ID: 7, Isolation: types.IsolationRow}
+	schema := &types.Tenant{ID: 8, Isolation: types.IsolationSchema, Schema: "tenant_8"}
+
+	assert.Equal(t, []any{"a", "", nil, ""}, dataFilter{Tag: "a"}.args())
+	assert.Equal(t, []any{"a", "", 7, ""}, dataFilter{Tag: "a", Tenant: row}.args())
+	assert.Equal(t, []any{"a", "", nil, ""}, dataFilter{Tag: "a", Tenant: schema}.args(),
+		"schema tenants are isolated by their schema")
+
+	assert.Equal(t, "tag=a", dataFilter{Tag: "a"}.normalized())
+	assert.Equal(t, "tag=a&tenant=7", dataFilter{Tag: "a", Tenant: row}.normalized())
+	assert.Equal(t, "tenant=8", dataFilter{Tenant: schema}.normalized())
+}
+
+func TestAPIKeysAreRandomAndHashed(t *testing.T) {
+	a, err := newAPIKey()
+	require.NoError(t, err)
+	b, err := newAPIKey()
+	require.NoError(t, err)
+
+	assert.NotEqual(t, a, b)
+	assert.True(t, strings.HasPrefix(a, "tk_"))
+	assert.Len(t, hashAPIKey(a), 64)
+	assert.Equal(t, hashAPIKey(a), hashAPIKey(a))
+	assert.NotEqual(t, hashAPIKey(a), hashAPIKey(b))
+}
+
+func TestTenantSchemaStatementsQuoteSchema(t *testing.T) {
+	for _, stmt := range tenantSchemaStatements(tenantSchema(3)) {
+		assert.Contains(t, stmt, `"tenant_3"`)
+	}
+	for _, stmt := range tenantSchemaUpgrades(tenantSchema(3)) {
+		assert.Contains(t, stmt, `"tenant_3"`)
+	}
+}
+
+func TestWithTenantPassesRequestsWithoutKey(t *testing.T) {
+	called := false
+	handler := (&App{}).WithTenant(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		assert.Nil(t, tenantFrom(r.Context()))
+	})
+
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/data", nil))
+	assert.True(t, called)
+}
@@ -0,0 +1,135 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/nesymno/run-tests-example/httpclient"
+	"github.com/nesymno/run-tests-example/metrics"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// TestRunHeader tags a request with the test run that sent it. Rows the
+// request creates carry the run, so a run can list and delete everything
+// it created.
+const TestRunHeader = "X-Test-Run-ID"
+
+// testRunHeaderKey is TestRunHeader in canonical form, which looks it up
+// without canonicalizing it on every request.
+var testRunHeaderKey = http.CanonicalHeaderKey(TestRunHeader)
+
+// maxTestRunSeries bounds the run label of app_test_run_requests_total;
+// runs past it are counted under the overflow series.
+const maxTestRunSeries = 50
+
+var testRunPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+var testRunRequests = metrics.NewCounterVec("app_test_run_requests_total",
+	"Requests tagged with X-Test-Run-ID, by run.", "run")
+
+func init() {
+	testRunRequests.SetMaxSeries(maxTestRunSeries)
+}
+
+func validTestRunID(id string) bool {
+	return testRunPattern.MatchString(id)
+}
+
+// testRunFrom returns the run a request is tagged with, empty if none.
+func testRunFrom(ctx context.Context) string {
+	if v := requestValuesFrom(ctx); v != nil {
+		return v.testRun
+	}
+	return ""
+}
+
+// TestRunMiddleware stores the X-Test-Run-ID of a request in its context,
+// for the request log and new rows, and passes it on to outbound calls.
+// Malformed IDs are rejected, as they end up in logs and labels.
+func (app *App) TestRunMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		run := r.Header.Get(testRunHeaderKey)
+		if run == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !validTestRunID(run) {
+			http.Error(w, fmt.Sprintf("Invalid %s: use up to 64 letters, digits, '.', '_', ':' or '-'", TestRunHeader), http.StatusBadRequest)
+			return
+		}
+
+		testRunRequests.With(run).Inc()
+		r, v := withRequestValues(r)
+		v.testRun = run
+		next.ServeHTTP(w, r.WithContext(httpclient.WithHeader(r.Context(), testRunHeaderKey, run)))
+	})
+}
+
+// TestRunHandler deletes (DELETE) every row a test run created, with their
+// comments and archived copies, within the caller's tenant. Rows still in
+// the write-behind buffer are not deleted; flush it first.
+func (app *App) TestRunHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		methodNotAllowed(w, r, "DELETE")
+		return
+	}
+
+	run := r.PathValue("id")
+	if !validTestRunID(run) {
+		http.Error(w, "Invalid test run ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	cleanup, err := app.deleteTestRun(ctx, run)
+	if err != nil {
+		writeDBError(w, "Cleanup error", err)
+		return
+	}
+	if cleanup.Rows > 0 || cleanup.Archived > 0 {
+		app.invalidateList(ctx)
+	}
+
+	setJSONContentType(w)
+	app.writeJSON(w, r, cleanup)
+}
+
+func (app *App) deleteTestRun(ctx context.Context, run string) (*types.TestRunCleanup, error) {
+	tenant := tenantFrom(ctx)
+	tx, err := app.beginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	cleanup := &types.TestRunCleanup{TestRunID: run}
+	// Comments are deleted explicitly, as a partitioned test_data has no
+	// foreign key to cascade from
+	counts := []struct {
+		n     *int64
+		query string
+	}{
+		{&cleanup.Comments, `DELETE FROM test_data_comments WHERE data_id IN
+			(SELECT id FROM test_data WHERE test_run_id = $1 AND tenant_id IS NOT DISTINCT FROM $2)`},
+		{&cleanup.Rows, "DELETE FROM test_data WHERE test_run_id = $1 AND tenant_id IS NOT DISTINCT FROM $2"},
+	}
+	// The archive only exists in the public schema, where a schema
+	// tenant's NULL tenant_id would match the shared rows
+	if tenant == nil || tenant.Isolation == types.IsolationRow {
+		counts = append(counts, struct {
+			n     *int64
+			query string
+		}{&cleanup.Archived, "DELETE FROM test_data_archive WHERE test_run_id = $1 AND tenant_id IS NOT DISTINCT FROM $2"})
+	}
+
+	for _, c := range counts {
+		res, err := tx.ExecContext(ctx, c.query, run, tenantColumn(tenant))
+		if err != nil {
+			return nil, err
+		}
+		*c.n, _ = res.RowsAffected()
+	}
+	return cleanup, tx.Commit()
+}
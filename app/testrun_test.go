@@ -0,0 +1,46 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTestRunMiddleware(t *testing.T) {
+	app := &App{}
+	var run string
+	handler := app.TestRunMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		run = testRunFrom(r.Context())
+	}))
+	serve := func(id string) int {
+		run = ""
+		r := httptest.NewRequest("GET", "/api/data", nil)
+		if id != "" {
+			r.Header.Set(TestRunHeader, id)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("ci-1234:shard.2_a"))
+	assert.Equal(t, "ci-1234:shard.2_a", run)
+
+	assert.Equal(t, http.StatusOK, serve(""))
+	assert.Empty(t, run, "untagged requests pass through")
+
+	for _, id := range []string{"run 1", "run\n1", "run=1", strings.Repeat("a", 65)} {
+		assert.Equal(t, http.StatusBadRequest, serve(id), id)
+	}
+}
+
+func TestDataFilterByTestRun(t *testing.T) {
+	filter, err := parseFilters("test_run_id:ci-7")
+	assert.NoError(t, err)
+	assert.Equal(t, dataFilter{TestRun: "ci-7"}, filter)
+	assert.Equal(t, []any{"", "", nil, "ci-7"}, filter.args())
+	assert.Equal(t, "test_run_id=ci-7", filter.normalized())
+}
@@ -0,0 +1,41 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+)
+
+type requestValuesKey struct{}
+
+// requestValues holds what the server middleware resolves about a request,
+// in a single context for all of them, as a context.WithValue and a
+// request copy each would cost every request several allocations.
+type requestValues struct {
+	context.Context
+	clientIP netip.Addr
+	testRun  string
+}
+
+func (v *requestValues) Value(key any) any {
+	if key == (requestValuesKey{}) {
+		return v
+	}
+	return v.Context.Value(key)
+}
+
+// withRequestValues returns the values of r's context, and r with them,
+// adding them to a copy of r for the first middleware to set one. Later
+// middleware set theirs in place, before the handler runs.
+func withRequestValues(r *http.Request) (*http.Request, *requestValues) {
+	if v := requestValuesFrom(r.Context()); v != nil {
+		return r, v
+	}
+	v := &requestValues{Context: r.Context()}
+	return r.WithContext(v), v
+}
+
+func requestValuesFrom(ctx context.Context) *requestValues {
+	v, _ := ctx.Value(requestValuesKey{}).(*requestValues)
+	return v
+}
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/nesymno/run-tests-example/metrics"
+)
+
+var warmPings = metrics.NewCounterVec("app_warm_pings_total",
+	"Keepalive pings of pooled connections by pool and result.", "pool", "result")
+
+// WarmPolicy keeps connections to PostgreSQL and Redis established through
+// idle periods, so the first requests after one do not pay for connecting.
+// Every Interval, MinIdle connections of each pool are taken at once and
+// pinged, which keeps them from expiring as idle, and replaces those that
+// broke, such as ones a NAT or the server dropped, before a request finds
+// out.
+type WarmPolicy struct {
+	// MinIdle is the number of connections kept warm in each pool, 0 to
+	// disable keepalives.
+	MinIdle  int
+	Interval time.Duration
+}
+
+// RunWarmConnections warms the pools at once and then every Interval until
+// ctx is done. It does nothing when MinIdle is not positive.
+func (app *App) RunWarmConnections(ctx context.Context) {
+	if app.Warm.MinIdle <= 0 {
+		return
+	}
+
+	app.warmConnections(ctx)
+	ticker := time.NewTicker(app.Warm.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.warmConnections(ctx)
+		}
+	}
+}
+
+func (app *App) warmConnections(ctx context.Context) {
+	// A ping that takes longer than the interval has found a dead
+	// connection
+	pingCtx, cancel := context.WithTimeout(ctx, app.Warm.Interval)
+	defer cancel()
+
+	pools := []struct {
+		name    string
+		acquire func(context.Context) (func(), error)
+	}{
+		{"postgres", func(ctx context.Context) (func(), error) {
+			conn, err := app.DB.Conn(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if err := conn.PingContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return func() { conn.Close() }, nil
+		}},
+		{"redis", func(ctx context.Context) (func(), error) {
+			// Unlike database/sql, a connection of its own is not retried
+			// on another when it turns out broken
+			var err error
+			for range app.Warm.MinIdle + 1 {
+				conn := app.Rds.Conn()
+				if err = conn.Ping(ctx).Err(); err == nil {
+					return func() { conn.Close() }, nil
+				}
+				conn.Close()
+			}
+			return nil, err
+		}},
+	}
+	for _, pool := range pools {
+		failed, err := pingConns(pingCtx, app.Warm.MinIdle, pool.acquire)
+		warmPings.With(pool.name, "ok").Add(uint64(app.Warm.MinIdle - failed))
+		warmPings.With(pool.name, "error").Add(uint64(failed))
+		if err != nil && ctx.Err() == nil {
+			log.Printf("warm connections: %d of %d %s pings failed: %v", failed, app.Warm.MinIdle, pool.name, err)
+		}
+	}
+}
+
+// pingConns takes n connections of a pool with acquire, which pings one
+// and returns the function putting it back. They are all held until the
+// last is taken, so that the pings reach n distinct connections rather than
+// one reused n times, and the pool dials those it lacks.
+func pingConns(ctx context.Context, n int, acquire func(context.Context) (func(), error)) (failed int, err error) {
+	type result struct {
+		release func()
+		err     error
+	}
+	results := make(chan result, n)
+	for range n {
+		go func() {
+			release, err := acquire(ctx)
+			results <- result{release, err}
+		}()
+	}
+	for range n {
+		r := <-results
+		if r.err != nil {
+			failed++
+			err = r.err
+			continue
+		}
+		defer r.release()
+	}
+	return failed, err
+}
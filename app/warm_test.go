@@ -0,0 +1,194 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// idleServer plays a server, or a NAT in front of one, that drops
+// connections left idle for longer than timeout. It counts dials.
+type idleServer struct {
+	timeout time.Duration
+	dials   atomic.Int64
+}
+
+func (s *idleServer) dial() *idleConn {
+	s.dials.Add(1)
+	return &idleConn{server: s, used: time.Now()}
+}
+
+type idleConn struct {
+	server *idleServer
+	mu     sync.Mutex
+	used   time.Time
+}
+
+// use fails if the server dropped the connection, and marks it used.
+func (c *idleConn) use() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.used) > c.server.timeout {
+		return driver.ErrBadConn
+	}
+	c.used = time.Now()
+	return nil
+}
+
+// idleConnector connects to an idleServer as PostgreSQL.
+type idleConnector struct{ *idleServer }
+
+func (c idleConnector) Connect(context.Context) (driver.Conn, error) {
+	return &idlePGConn{c.dial()}, nil
+}
+
+func (idleConnector) Driver() driver.Driver { return nil }
+
+type idlePGConn struct{ *idleConn }
+
+func (c *idlePGConn) ResetSession(context.Context) error  { return c.use() }
+func (c *idlePGConn) Ping(context.Context) error          { return c.use() }
+func (c *idlePGConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c *idlePGConn) Close() error                        { return nil }
+func (c *idlePGConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+// idleNetConn is a Redis connection through an idleServer.
+type idleNetConn struct {
+	net.Conn
+	*idleConn
+}
+
+func (c *idleNetConn) Write(b []byte) (int, error) {
+	if err := c.use(); err != nil {
+		return 0, io.EOF
+	}
+	return c.Conn.Write(b)
+}
+
+// warmPools returns an App whose pools lose connections idle for 50ms, and
+// a function counting the dials to PostgreSQL and Redis so far.
+func warmPools(t *testing.T, minIdle int) (*App, func() (int, int)) {
+	pg := &idleServer{timeout: 50 * time.Millisecond}
+	db := sql.OpenDB(idleConnector{pg})
+	db.SetMaxIdleConns(minIdle)
+	t.Cleanup(func() { db.Close() })
+
+	mr := miniredis.RunT(t)
+	rs := &idleServer{timeout: 50 * time.Millisecond}
+	rds := redis.NewClient(&redis.Options{
+		Addr:            mr.Addr(),
+		MaxRetries:      10,
+		MinRetryBackoff: -1,
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := new(net.Dialer).DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return &idleNetConn{conn, rs.dial()}, nil
+		},
+	})
+	t.Cleanup(func() { rds.Close() })
+
+	app := &App{DB: db, Rds: rds, Warm: WarmPolicy{MinIdle: minIdle, Interval: 20 * time.Millisecond}}
+	return app, func() (int, int) {
+		return int(pg.dials.Load()), int(rs.dials.Load())
+	}
+}
+
+// burst runs n requests to each pool at once.
+func burst(t *testing.T, app *App, n int) {
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, app.DB.PingContext(ctx))
+			assert.NoError(t, app.Rds.Ping(ctx).Err())
+		}()
+	}
+	wg.Wait()
+}
+
+func TestIdleThenBurstRedialsWithoutKeepalives(t *testing.T) {
+	app, dials := warmPools(t, 4)
+	burst(t, app, 4)
+	pgBefore, redisBefore := dials()
+
+	time.Sleep(100 * time.Millisecond)
+	burst(t, app, 4)
+
+	pg, rds := dials()
+	assert.Greater(t, pg, pgBefore, "the burst after the idle period dials PostgreSQL again")
+	assert.Greater(t, rds, redisBefore, "the burst after the idle period dials Redis again")
+}
+
+func TestIdleThenBurstFindsWarmConnections(t *testing.T) {
+	app, dials := warmPools(t, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		app.RunWarmConnections(ctx)
+		close(done)
+	}()
+
+	// The connections are established before the first request
+	require.Eventually(t, func() bool {
+		pg, rds := dials()
+		return pg == 4 && rds == 4
+	}, time.Second, 5*time.Millisecond)
+
+	// Keepalives run through the idle period, then stop for the burst, so
+	// that they do not hold the connections it needs
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	<-done
+	pgBefore, redisBefore := dials()
+
+	for range 3 {
+		burst(t, app, 4)
+	}
+
+	pg, rds := dials()
+	assert.Equal(t, pgBefore, pg, "no PostgreSQL dial after the idle period")
+	assert.Equal(t, redisBefore, rds, "no Redis dial after the idle period")
+}
+
+func TestWarmConnectionsReplaceDroppedOnes(t *testing.T) {
+	app, dials := warmPools(t, 2)
+	app.warmConnections(context.Background())
+	pgBefore, redisBefore := dials()
+
+	// A pause longer than the server keeps idle connections, such as a
+	// stalled keepalive loop, costs a round of dials at the next
+	// keepalive, not at the next request
+	time.Sleep(100 * time.Millisecond)
+	app.warmConnections(context.Background())
+	pg, rds := dials()
+	assert.Greater(t, pg, pgBefore)
+	assert.Greater(t, rds, redisBefore)
+
+	burst(t, app, 2)
+	pgAfter, redisAfter := dials()
+	assert.Equal(t, pg, pgAfter)
+	assert.Equal(t, rds, redisAfter)
+}
+
+func TestWarmConnectionsDisabled(t *testing.T) {
+	app, dials := warmPools(t, 0)
+	app.RunWarmConnections(context.Background())
+	pg, rds := dials()
+	assert.Zero(t, pg)
+	assert.Zero(t, rds)
+}
@@ -0,0 +1,554 @@
+// Package apptest provides an in-memory stand-in for the app, for unit
+// tests of code built on the client package.
+package apptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// MockServer serves the app's data API from memory, without PostgreSQL or
+// Redis: health, listing and creating rows, test run cleanup, comments and
+// the event feed. Status codes, headers and bodies follow the app, but
+// authentication, tenants and admin routes are left out, and listings are
+// consistent right away, so X-Cache only tells whether the listing changed
+// since the same query was last served.
+type MockServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	rows      []types.TestData
+	comments  []types.Comment
+	nextID    int
+	version   int64
+	served    map[string]bool
+	events    []mockEvent
+	published chan struct{}
+}
+
+type mockEvent struct {
+	id   int64
+	typ  string
+	data json.RawMessage
+}
+
+// NewMockServer starts a MockServer. Close it when done.
+func NewMockServer() *MockServer {
+	m := &MockServer{served: map[string]bool{}, published: make(chan struct{})}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", m.health)
+	mux.HandleFunc("GET /api/data", m.listData)
+	mux.HandleFunc("POST /api/data", m.createData)
+	mux.HandleFunc("GET /api/events", m.eventFeed)
+	mux.HandleFunc("GET /api/data/{id}", m.getData)
+	mux.HandleFunc("PUT /api/data/{id}", m.updateData)
+	mux.HandleFunc("PATCH /api/data/{id}", m.updateData)
+	mux.HandleFunc("DELETE /api/data/{id}", m.deleteData)
+	mux.HandleFunc("DELETE /api/runs/{id}", m.deleteTestRun)
+	mux.HandleFunc("GET /api/data/{id}/comments", m.listComments)
+	mux.HandleFunc("POST /api/data/{id}/comments", m.addComment)
+	mux.HandleFunc("DELETE /api/data/{id}/comments/{comment_id}", m.deleteComment)
+	m.Server = httptest.NewServer(mux)
+	return m
+}
+
+// Rows returns a copy of the stored rows, in ID order.
+func (m *MockServer) Rows() []types.TestData {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.rows)
+}
+
+var testRunPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// dataFields is the set of JSON names ?fields= accepts.
+var dataFields = func() map[string]bool {
+	t := reflect.TypeFor[types.TestData]()
+	fields := map[string]bool{}
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}()
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (m *MockServer) health(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, types.HealthResponse{
+		Status:    "healthy",
+		Timestamp: time.Now(),
+		Version:   "1.0.0",
+		Database:  "healthy",
+		Cache:     "healthy",
+		Profile:   "mock",
+	})
+}
+
+// publish records an event; the caller holds m.mu.
+func (m *MockServer) publish(typ string, data any) {
+	b, _ := json.Marshal(data)
+	m.events = append(m.events, mockEvent{id: int64(len(m.events) + 1), typ: typ, data: b})
+	close(m.published)
+	m.published = make(chan struct{})
+}
+
+// changed records a write to rows or comments, which listings may
+// include; the caller holds m.mu.
+func (m *MockServer) changed() {
+	m.version++
+	clear(m.served)
+}
+
+func (m *MockServer) createData(w http.ResponseWriter, r *http.Request) {
+	var data types.TestData
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if data.Tags == nil {
+		data.Tags = []string{}
+	}
+	if data.Status == "" {
+		data.Status = types.StatusActive
+	}
+	if data.Status != types.StatusActive && data.Status != types.StatusArchived {
+		http.Error(w, fmt.Sprintf("Invalid status %q", data.Status), http.StatusBadRequest)
+		return
+	}
+	if run := r.Header.Get("X-Test-Run-ID"); run != "" {
+		data.TestRunID = run
+	}
+	if data.TestRunID != "" && !testRunPattern.MatchString(data.TestRunID) {
+		http.Error(w, fmt.Sprintf("Invalid test_run_id %q", data.TestRunID), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	if data.CreatedAt.IsZero() {
+		data.CreatedAt = now
+	}
+	if data.UpdatedAt.IsZero() {
+		data.UpdatedAt = data.CreatedAt
+	}
+	data.CreatedAt, data.UpdatedAt = data.CreatedAt.UTC(), data.UpdatedAt.UTC()
+	if data.UpdatedAt.Before(data.CreatedAt) {
+		http.Error(w, "updated_at is before created_at", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	m.nextID++
+	data.ID = m.nextID
+	data.Comments = nil
+	m.rows = append(m.rows, data)
+	m.changed()
+	version := m.version
+	announced := data
+	announced.Secret = ""
+	m.publish("data.created", announced)
+	m.mu.Unlock()
+
+	w.Header().Set("X-Consistency-Token", strconv.FormatInt(version, 10))
+	writeJSON(w, http.StatusCreated, map[string]string{"status": "created"})
+}
+
+func (m *MockServer) listData(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	status := q.Get("status")
+	if status != "" && status != types.StatusActive && status != types.StatusArchived {
+		http.Error(w, fmt.Sprintf("Invalid status %q", status), http.StatusBadRequest)
+		return
+	}
+	var fields []string
+	if s := q.Get("fields"); s != "" {
+		for _, name := range strings.Split(s, ",") {
+			name = strings.TrimSpace(name)
+			if !dataFields[name] {
+				http.Error(w, fmt.Sprintf("unknown field %q", name), http.StatusBadRequest)
+				return
+			}
+			fields = append(fields, name)
+		}
+	}
+	comments := false
+	if s := q.Get("include"); s != "" {
+		for _, name := range strings.Split(s, ",") {
+			switch strings.TrimSpace(name) {
+			case "comments":
+				comments = true
+			case "cache-metadata":
+			default:
+				http.Error(w, fmt.Sprintf("unknown include %q (available: comments, cache-metadata)", strings.TrimSpace(name)), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	stream := q.Get("stream")
+	if stream != "" && stream != "true" && stream != "ndjson" {
+		http.Error(w, fmt.Sprintf("Invalid stream %q (use true or ndjson)", stream), http.StatusBadRequest)
+		return
+	}
+	if stream != "" && comments {
+		http.Error(w, "include cannot be combined with stream", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	rows := []types.TestData{}
+	for _, row := range m.rows {
+		if q.Get("tag") != "" && !slices.Contains(row.Tags, q.Get("tag")) ||
+			status != "" && row.Status != status ||
+			q.Get("test_run_id") != "" && row.TestRunID != q.Get("test_run_id") {
+			continue
+		}
+		if comments {
+			for _, c := range m.comments {
+				if c.DataID == row.ID {
+					row.Comments = append(row.Comments, c)
+				}
+			}
+		}
+		rows = append(rows, row)
+	}
+	key := q.Encode()
+	hit := m.served[key]
+	m.served[key] = true
+	m.mu.Unlock()
+
+	items := make([]any, len(rows))
+	for i, row := range rows {
+		items[i] = project(row, fields)
+	}
+
+	switch {
+	case stream == "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("X-Cache", "BYPASS")
+		enc := json.NewEncoder(w)
+		for _, item := range items {
+			enc.Encode(item)
+		}
+	case stream != "":
+		w.Header().Set("X-Cache", "BYPASS")
+		writeJSON(w, http.StatusOK, items)
+	default:
+		cache := "MISS"
+		if hit {
+			cache = "HIT"
+		}
+		w.Header().Set("X-Cache", cache)
+		writeJSON(w, http.StatusOK, items)
+	}
+}
+
+// project reduces row to the selected fields, all of them when fields is
+// empty.
+func project(row types.TestData, fields []string) any {
+	if len(fields) == 0 {
+		return row
+	}
+	b, _ := json.Marshal(row)
+	var all map[string]json.RawMessage
+	json.Unmarshal(b, &all)
+	selected := map[string]json.RawMessage{}
+	for _, name := range fields {
+		if v, ok := all[name]; ok {
+			selected[name] = v
+		}
+	}
+	return selected
+}
+
+func (m *MockServer) deleteTestRun(w http.ResponseWriter, r *http.Request) {
+	run := r.PathValue("id")
+	if !testRunPattern.MatchString(run) {
+		http.Error(w, "Invalid test run ID", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	cleanup := types.TestRunCleanup{TestRunID: run}
+	deleted := map[int]bool{}
+	m.rows = slices.DeleteFunc(m.rows, func(row types.TestData) bool {
+		if row.TestRunID != run {
+			return false
+		}
+		deleted[row.ID] = true
+		cleanup.Rows++
+		return true
+	})
+	m.comments = slices.DeleteFunc(m.comments, func(c types.Comment) bool {
+		if !deleted[c.DataID] {
+			return false
+		}
+		cleanup.Comments++
+		return true
+	})
+	if cleanup.Rows > 0 || cleanup.Comments > 0 {
+		m.changed()
+	}
+	m.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, cleanup)
+}
+
+// findRow returns the index of a row, -1 if there is none; the caller
+// holds m.mu.
+func (m *MockServer) findRow(id int) int {
+	return slices.IndexFunc(m.rows, func(row types.TestData) bool { return row.ID == id })
+}
+
+func (m *MockServer) getData(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid data ID", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.findRow(id)
+	if i < 0 {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, m.rows[i])
+}
+
+// updateData replaces a row (PUT) or the fields a patch sets (PATCH).
+func (m *MockServer) updateData(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid data ID", http.StatusBadRequest)
+		return
+	}
+	var patch types.DataPatch
+	if r.Method == "PUT" {
+		var data types.TestData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		if data.Tags == nil {
+			data.Tags = []string{}
+		}
+		if data.Status == "" {
+			data.Status = types.StatusActive
+		}
+		patch = types.DataPatch{Name: &data.Name, Data: &data.Data, Tags: &data.Tags, Status: &data.Status, Secret: &data.Secret}
+	} else {
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		if patch == (types.DataPatch{}) {
+			http.Error(w, "Nothing to update", http.StatusBadRequest)
+			return
+		}
+	}
+	if patch.Status != nil && *patch.Status != types.StatusActive && *patch.Status != types.StatusArchived {
+		http.Error(w, fmt.Sprintf("Invalid status %q", *patch.Status), http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.findRow(id)
+	if i < 0 {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+	row := &m.rows[i]
+	if patch.Name != nil {
+		row.Name = *patch.Name
+	}
+	if patch.Data != nil {
+		row.Data = *patch.Data
+	}
+	if patch.Tags != nil {
+		row.Tags = *patch.Tags
+	}
+	if patch.Status != nil {
+		row.Status = *patch.Status
+	}
+	if patch.Secret != nil {
+		row.Secret = *patch.Secret
+	}
+	row.UpdatedAt = time.Now().UTC()
+	m.changed()
+	announced := *row
+	announced.Secret = ""
+	m.publish("data.updated", announced)
+	w.Header().Set("X-Consistency-Token", strconv.FormatInt(m.version, 10))
+	writeJSON(w, http.StatusOK, *row)
+}
+
+func (m *MockServer) deleteData(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid data ID", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.findRow(id)
+	if i < 0 {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+	m.rows = slices.Delete(m.rows, i, i+1)
+	m.comments = slices.DeleteFunc(m.comments, func(c types.Comment) bool { return c.DataID == id })
+	m.changed()
+	m.publish("data.deleted", map[string]int{"id": id})
+	w.Header().Set("X-Consistency-Token", strconv.FormatInt(m.version, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *MockServer) listComments(w http.ResponseWriter, r *http.Request) {
+	dataID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid data ID", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.findRow(dataID) < 0 {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+	list := []types.Comment{}
+	for _, c := range m.comments {
+		if c.DataID == dataID {
+			list = append(list, c)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (m *MockServer) addComment(w http.ResponseWriter, r *http.Request) {
+	dataID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid data ID", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Body string `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Body == "" {
+		http.Error(w, "Comment body is required", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.findRow(dataID) < 0 {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+	m.nextID++
+	comment := types.Comment{ID: m.nextID, DataID: dataID, Body: req.Body, CreatedAt: time.Now().UTC()}
+	m.comments = append(m.comments, comment)
+	m.changed()
+	m.publish("comment.created", comment)
+	w.Header().Set("X-Consistency-Token", strconv.FormatInt(m.version, 10))
+	writeJSON(w, http.StatusCreated, comment)
+}
+
+func (m *MockServer) deleteComment(w http.ResponseWriter, r *http.Request) {
+	dataID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid data ID", http.StatusBadRequest)
+		return
+	}
+	commentID, err := strconv.Atoi(r.PathValue("comment_id"))
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.comments, func(c types.Comment) bool { return c.ID == commentID && c.DataID == dataID })
+	if i < 0 {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	m.comments = slices.Delete(m.comments, i, i+1)
+	m.changed()
+	m.publish("comment.deleted", map[string]int{"id": commentID, "data_id": dataID})
+	w.Header().Set("X-Consistency-Token", strconv.FormatInt(m.version, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// eventFeed streams events as server-sent events, resuming after
+// Last-Event-ID or ?last_event_id=. The mock keeps every event, so a
+// resume never reports a gap.
+func (m *MockServer) eventFeed(w http.ResponseWriter, r *http.Request) {
+	resume := r.Header.Get("Last-Event-ID")
+	if v := r.URL.Query().Get("last_event_id"); v != "" {
+		resume = v
+	}
+
+	m.mu.Lock()
+	sent := int64(len(m.events))
+	m.mu.Unlock()
+	if resume != "" {
+		n, err := strconv.ParseInt(resume, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("Invalid last event ID %q", resume), http.StatusBadRequest)
+			return
+		}
+		sent = min(n, sent)
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	for {
+		m.mu.Lock()
+		pending := slices.Clone(m.events[sent:])
+		published := m.published
+		m.mu.Unlock()
+
+		for _, e := range pending {
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.id, e.typ, e.data)
+			sent = e.id
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-published:
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package apptest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/client"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+func newClient(t *testing.T, m *MockServer, run string) *client.Client {
+	opts := client.DefaultOptions()
+	opts.TestRunID = run
+	c, err := client.New(m.URL, opts)
+	require.NoError(t, err)
+	return c
+}
+
+func TestMockServerServesTheClient(t *testing.T) {
+	m := NewMockServer()
+	defer m.Close()
+	c := newClient(t, m, "run-1")
+	ctx := context.Background()
+
+	health, err := c.Health(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "healthy", health.Status)
+
+	require.NoError(t, c.CreateData(ctx, types.TestData{Name: "a", Tags: []string{"smoke"}}))
+	require.NoError(t, c.CreateData(ctx, types.TestData{Name: "b"}))
+
+	rows, err := c.ListData(ctx, client.ListOptions{Tag: "smoke"})
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Equal(t, "a", rows[0].Name)
+	assert.Equal(t, "run-1", rows[0].TestRunID)
+	assert.Equal(t, types.StatusActive, rows[0].Status)
+
+	comment, err := c.AddComment(ctx, rows[0].ID, "looks good")
+	require.NoError(t, err)
+	comments, err := c.Comments(ctx, rows[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, []types.Comment{*comment}, comments)
+
+	rows, err = c.ListData(ctx, client.ListOptions{Fields: []string{"id", "name"}, Include: []string{"comments"}})
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Empty(t, rows[0].Tags, "left out by fields")
+
+	_, err = c.Comments(ctx, 999)
+	assert.Equal(t, http.StatusNotFound, client.StatusCode(err))
+	_, err = c.ListData(ctx, client.ListOptions{Fields: []string{"password"}})
+	assert.Equal(t, http.StatusBadRequest, client.StatusCode(err))
+
+	cleanup, err := c.DeleteTestRun(ctx, "run-1")
+	require.NoError(t, err)
+	assert.Equal(t, types.TestRunCleanup{TestRunID: "run-1", Rows: 2, Comments: 1}, *cleanup)
+	assert.Empty(t, m.Rows())
+}
+
+func TestMockServerUpdatesRows(t *testing.T) {
+	m := NewMockServer()
+	defer m.Close()
+	c := newClient(t, m, "")
+	ctx := context.Background()
+
+	require.NoError(t, c.CreateData(ctx, types.TestData{Name: "a", Data: "x", Tags: []string{"smoke"}}))
+	_, err := c.AddComment(ctx, 1, "note")
+	require.NoError(t, err)
+
+	status := types.StatusArchived
+	row, err := c.PatchData(ctx, 1, types.DataPatch{Status: &status})
+	require.NoError(t, err)
+	assert.Equal(t, "a", row.Name)
+	assert.Equal(t, []string{"smoke"}, row.Tags, "left as is")
+	assert.Equal(t, types.StatusArchived, row.Status)
+
+	row, err = c.UpdateData(ctx, 1, types.TestData{Name: "b"})
+	require.NoError(t, err)
+	assert.Equal(t, "", row.Data, "replaced")
+	assert.Equal(t, []string{}, row.Tags)
+	assert.Equal(t, types.StatusActive, row.Status)
+
+	got, err := c.GetData(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, row, got)
+
+	require.NoError(t, c.DeleteData(ctx, 1))
+	_, err = c.GetData(ctx, 1)
+	assert.Equal(t, http.StatusNotFound, client.StatusCode(err))
+	_, err = c.Comments(ctx, 1)
+	assert.Equal(t, http.StatusNotFound, client.StatusCode(err))
+	err = c.DeleteData(ctx, 1)
+	assert.Equal(t, http.StatusNotFound, client.StatusCode(err))
+	_, err = c.PatchData(ctx, 1, types.DataPatch{})
+	assert.Equal(t, http.StatusBadRequest, client.StatusCode(err))
+}
+
+func TestMockServerReportsCacheHits(t *testing.T) {
+	m := NewMockServer()
+	defer m.Close()
+
+	get := func() string {
+		resp, err := http.Get(m.URL + "/api/data?tag=smoke")
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.Header.Get("X-Cache")
+	}
+	assert.Equal(t, "MISS", get())
+	assert.Equal(t, "HIT", get())
+
+	require.NoError(t, newClient(t, m, "").CreateData(context.Background(), types.TestData{Name: "n"}))
+	assert.Equal(t, "MISS", get())
+}
+
+func TestMockServerStreamsListings(t *testing.T) {
+	m := NewMockServer()
+	defer m.Close()
+	c := newClient(t, m, "")
+	ctx := context.Background()
+	for range 3 {
+		require.NoError(t, c.CreateData(ctx, types.TestData{Name: "n"}))
+	}
+
+	pager := c.ListDataPager(ctx, client.ListOptions{}, 2)
+	defer pager.Close()
+	n := 0
+	for pager.Next() {
+		n += len(pager.Page())
+	}
+	require.NoError(t, pager.Err())
+	assert.Equal(t, 3, n)
+}
+
+func TestMockServerEventFeed(t *testing.T) {
+	m := NewMockServer()
+	defer m.Close()
+	c := newClient(t, m, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	require.NoError(t, c.CreateData(ctx, types.TestData{Name: "before"}))
+
+	// Resuming from the start replays the earlier event
+	sub := c.SubscribeEvents(ctx, client.SubscribeOptions{LastEventID: 0})
+	defer sub.Close()
+	require.True(t, sub.Next())
+	assert.Equal(t, int64(1), sub.Event().ID)
+	assert.Equal(t, "data.created", sub.Event().Type)
+
+	require.NoError(t, c.CreateData(ctx, types.TestData{Name: "after"}))
+	require.True(t, sub.Next())
+	assert.Equal(t, int64(2), sub.Event().ID)
+	assert.Contains(t, string(sub.Event().Data), `"name":"after"`)
+}
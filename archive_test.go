@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/app"
+	"github.com/nesymno/run-tests-example/cache"
+)
+
+// TestArchiveRestoreKeepsConflictingRows restores the archive of a scratch
+// schema in the POSTGRES_* database while one archived row's name is
+// taken again, and checks that the row stays archived.
+func TestArchiveRestoreKeepsConflictingRows(t *testing.T) {
+	if os.Getenv("POSTGRES_HOST") == "" {
+		t.Skip("POSTGRES_HOST is not set")
+	}
+
+	db := scratchSchema(t)
+	require.NoError(t, initDatabase(db))
+	_, err := db.Exec(`INSERT INTO test_data_archive (id, name, data) VALUES (1, 'taken', 'old'), (2, 'free', 'old')`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO test_data (id, name, data) VALUES (100, 'taken', 'new')`)
+	require.NoError(t, err)
+
+	rds := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	a := &app.App{DB: db, Rds: rds, ListCache: cache.NewQueryCache(rds, "list", time.Minute)}
+	rec := httptest.NewRecorder()
+	a.ArchiveHandler(rec, httptest.NewRequest("POST", "/admin/archive", strings.NewReader(`{"all": true}`)))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var result struct {
+		Restored  int64 `json:"restored"`
+		Conflicts int64 `json:"conflicts"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+	assert.Equal(t, int64(1), result.Restored)
+	assert.Equal(t, int64(1), result.Conflicts)
+
+	assert.Equal(t, []string{"taken"}, queryStrings(t, db, "SELECT name FROM test_data_archive"), "the conflicting row stays archived")
+	assert.Equal(t, []string{"free old", "taken new"}, queryStrings(t, db, "SELECT name || ' ' || data FROM test_data ORDER BY name"))
+}
@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache is the key-value store a QueryCache keeps its entries and epoch
+// in: Redis, see NewRedis, or memcached, see NewMemcached.
+type Cache interface {
+	// Get returns the value of key; ok is false when there is none.
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	// GetMulti returns the values of keys in order, nil for missing ones.
+	GetMulti(ctx context.Context, keys []string) ([][]byte, error)
+	// SetMulti stores entries expiring after ttl, and adds 1 to the counter
+	// at countKey, which expires with them. A reader never sees an entry
+	// without the entries before it.
+	SetMulti(ctx context.Context, entries []Entry, countKey string, ttl time.Duration) error
+	// Bump adds 1 to the counter at key, wrapping around to 1 past max,
+	// and returns the new value. The counter does not expire.
+	Bump(ctx context.Context, key string, max int64) (n int64, wrapped bool, err error)
+	// Raise sets the counter at key to n unless it is already higher, and
+	// returns its value.
+	Raise(ctx context.Context, key string, n int64) (int64, error)
+	// Ping checks that the store can be reached.
+	Ping(ctx context.Context) error
+}
+
+// Entry is a value to store under Key.
+type Entry struct {
+	Key   string
+	Value []byte
+}
+
+// Redis is a Cache on a Redis server.
+type Redis struct {
+	rds *redis.Client
+}
+
+// NewRedis returns a Cache on rds.
+func NewRedis(rds *redis.Client) *Redis {
+	return &Redis{rds: rds}
+}
+
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := r.rds.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (r *Redis) GetMulti(ctx context.Context, keys []string) ([][]byte, error) {
+	values, err := r.rds.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([][]byte, len(values))
+	for i, v := range values {
+		if s, ok := v.(string); ok {
+			out[i] = []byte(s)
+		}
+	}
+	return out, nil
+}
+
+// SetMulti writes everything in one transaction.
+func (r *Redis) SetMulti(ctx context.Context, entries []Entry, countKey string, ttl time.Duration) error {
+	pipe := r.rds.TxPipeline()
+	for _, e := range entries {
+		pipe.Set(ctx, e.Key, e.Value, ttl)
+	}
+	pipe.Incr(ctx, countKey)
+	pipe.Expire(ctx, countKey, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// bumpCounter adds 1 to KEYS[1], wrapping to 1 past ARGV[1]. It returns
+// the new value and whether it wrapped.
+var bumpCounter = redis.NewScript(`
+local next = tonumber(redis.call('GET', KEYS[1]) or '0') + 1
+local wrapped = 0
+if next > tonumber(ARGV[1]) then
+	next = 1
+	wrapped = 1
+end
+redis.call('SET', KEYS[1], next)
+return {next, wrapped}
+`)
+
+func (r *Redis) Bump(ctx context.Context, key string, max int64) (int64, bool, error) {
+	res, err := bumpCounter.Run(ctx, r.rds, []string{key}, max).Int64Slice()
+	if err != nil {
+		return 0, false, err
+	}
+	return res[0], res[1] == 1, nil
+}
+
+// raiseCounter sets KEYS[1] to ARGV[1] unless it is already higher.
+var raiseCounter = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+local target = tonumber(ARGV[1])
+if current < target then
+	redis.call('SET', KEYS[1], target)
+	return target
+end
+return current
+`)
+
+func (r *Redis) Raise(ctx context.Context, key string, n int64) (int64, error) {
+	return raiseCounter.Run(ctx, r.rds, []string{key}, n).Int64()
+}
+
+func (r *Redis) Ping(ctx context.Context) error {
+	return r.rds.Ping(ctx).Err()
+}
@@ -0,0 +1,175 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+
+	"github.com/nesymno/run-tests-example/metrics"
+)
+
+var (
+	chunkedWrites = metrics.NewCounter("app_cache_chunked_writes_total",
+		"Cache values split into chunks because they exceeded the chunk size.")
+	chunkIntegrityFailures = metrics.NewCounter("app_cache_chunk_integrity_failures_total",
+		"Chunked cache values dropped on read because chunks were missing or corrupt.")
+)
+
+// DefaultChunkSize is the largest value stored under a single key; larger
+// ones are split. It stays well below the 512MB Redis limit, the 1MB
+// default item size of memcached and the limits of common proxies.
+const DefaultChunkSize = 512 << 10
+
+// Stored values start with a header byte saying how to read the rest. A
+// chunked value's manifest has its own header; the joined chunks hold an
+// encoded value with one of the others.
+const (
+	headerRaw     byte = 0x01
+	headerChunked byte = 0x02
+	headerSealed  byte = 0x04
+)
+
+// Sealer encrypts cached values, like encryption.Keyring. Values are sealed
+// with their key as associated data, so one cannot be served under another
+// key.
+type Sealer interface {
+	Encrypt(plaintext, aad []byte) ([]byte, error)
+	Decrypt(ciphertext, aad []byte) ([]byte, error)
+}
+
+// manifest describes a chunked value: how many chunks it was split into,
+// its total length and its SHA-256, checked when the chunks are joined.
+type manifest struct {
+	chunks int
+	length int
+	sum    [sha256.Size]byte
+}
+
+func (m manifest) encode() []byte {
+	return append([]byte{headerChunked}, fmt.Sprintf("%d:%d:%x", m.chunks, m.length, m.sum)...)
+}
+
+func decodeManifest(b []byte) (manifest, error) {
+	var m manifest
+	var sum []byte
+	if _, err := fmt.Sscanf(string(b), "%d:%d:%x", &m.chunks, &m.length, &sum); err != nil {
+		return m, err
+	}
+	if len(sum) != sha256.Size || m.chunks < 1 {
+		return m, fmt.Errorf("malformed chunk manifest %q", b)
+	}
+	copy(m.sum[:], sum)
+	return m, nil
+}
+
+func chunkKey(key string, i int) string {
+	return fmt.Sprintf("%s:c%d", key, i)
+}
+
+// entries returns the entries storing value under key. The value is
+// encoded and sealed, then split into chunks when it exceeds ChunkSize.
+// The manifest comes after its chunks, so readers never see it before
+// them. A value that fails to seal gets no entries at all.
+func (c *QueryCache) entries(key string, value []byte) []Entry {
+	payload := c.encode(value)
+	if c.Sealer != nil {
+		sealed, err := c.Sealer.Encrypt(payload, []byte(key))
+		if err != nil {
+			log.Printf("Not caching %s: %v", key, err)
+			return nil
+		}
+		payload = append([]byte{headerSealed}, sealed...)
+	}
+	if c.ChunkSize <= 0 || len(payload) <= c.ChunkSize {
+		return []Entry{{Key: key, Value: payload}}
+	}
+
+	m := manifest{length: len(payload), sum: sha256.Sum256(payload)}
+	var entries []Entry
+	for start := 0; start < len(payload); start += c.ChunkSize {
+		end := min(start+c.ChunkSize, len(payload))
+		entries = append(entries, Entry{Key: chunkKey(key, m.chunks), Value: payload[start:end]})
+		m.chunks++
+	}
+	chunkedWrites.Inc()
+	return append(entries, Entry{Key: key, Value: m.encode()})
+}
+
+// readValue decodes a value stored by writeValue. A value whose chunks have
+// been evicted or do not match the manifest, or that fails to open or
+// decode, is reported as a miss.
+func (c *QueryCache) readValue(ctx context.Context, key string, stored []byte) ([]byte, bool, error) {
+	payload := stored
+	if len(stored) > 0 && stored[0] == headerChunked {
+		var ok bool
+		var err error
+		payload, ok, err = c.joinChunks(ctx, key, stored[1:])
+		if !ok || err != nil {
+			return nil, ok, err
+		}
+	}
+
+	if len(payload) > 0 && payload[0] == headerSealed {
+		opened, err := c.open(key, payload[1:])
+		if err != nil {
+			chunkIntegrityFailures.Inc()
+			log.Printf("Dropping cached value %s: %v", key, err)
+			return nil, false, nil
+		}
+		payload = opened
+	}
+
+	value, err := decode(payload)
+	if err != nil {
+		chunkIntegrityFailures.Inc()
+		log.Printf("Dropping cached value %s: %v", key, err)
+		return nil, false, nil
+	}
+	return value, true, nil
+}
+
+func (c *QueryCache) open(key string, sealed []byte) ([]byte, error) {
+	if c.Sealer == nil {
+		return nil, fmt.Errorf("value is sealed but the cache has no sealer")
+	}
+	return c.Sealer.Decrypt(sealed, []byte(key))
+}
+
+// joinChunks loads and verifies the chunks listed in a manifest.
+func (c *QueryCache) joinChunks(ctx context.Context, key string, encoded []byte) ([]byte, bool, error) {
+	m, err := decodeManifest(encoded)
+	if err != nil {
+		chunkIntegrityFailures.Inc()
+		log.Printf("Dropping cached value %s: %v", key, err)
+		return nil, false, nil
+	}
+
+	keys := make([]string, m.chunks)
+	for i := range keys {
+		keys[i] = chunkKey(key, i)
+	}
+	chunks, err := c.backend.GetMulti(ctx, keys)
+	if err != nil {
+		return nil, false, c.check(err)
+	}
+
+	var payload bytes.Buffer
+	payload.Grow(m.length)
+	for _, chunk := range chunks {
+		if chunk == nil {
+			chunkIntegrityFailures.Inc()
+			log.Printf("Dropping cached value %s: chunk missing", key)
+			return nil, false, nil
+		}
+		payload.Write(chunk)
+	}
+
+	if payload.Len() != m.length || sha256.Sum256(payload.Bytes()) != m.sum {
+		chunkIntegrityFailures.Inc()
+		log.Printf("Dropping cached value %s: checksum mismatch", key)
+		return nil, false, nil
+	}
+	return payload.Bytes(), true, nil
+}
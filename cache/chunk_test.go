@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/encryption"
+)
+
+func TestLargeValuesAreChunkedTransparently(t *testing.T) {
+	ctx := context.Background()
+	c, mr := newTestQueryCache(t)
+	c.ChunkSize = 4
+
+	require.NoError(t, c.Set(ctx, "", []byte("0123456789")))
+	assert.True(t, mr.Exists(chunkKey(c.key(0, ""), 2)), "10 bytes and a header in 4 byte chunks need 3 chunks")
+	assert.False(t, mr.Exists(chunkKey(c.key(0, ""), 3)))
+
+	value, ok, err := c.Get(ctx, "")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "0123456789", string(value))
+
+	// Small values stay in one key
+	require.NoError(t, c.Set(ctx, "tag=a", []byte("012")))
+	assert.False(t, mr.Exists(chunkKey(c.key(0, "tag=a"), 0)))
+	value, ok, err = c.Get(ctx, "tag=a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "012", string(value))
+}
+
+func TestChunkedValueWithMissingOrCorruptChunkIsAMiss(t *testing.T) {
+	ctx := context.Background()
+	c, mr := newTestQueryCache(t)
+	c.ChunkSize = 4
+	key := c.key(0, "")
+
+	require.NoError(t, c.Set(ctx, "", []byte("0123456789")))
+	before := chunkIntegrityFailures.Value()
+	mr.Set(chunkKey(key, 1), "4X67")
+	_, ok, err := c.Get(ctx, "")
+	require.NoError(t, err)
+	assert.False(t, ok, "corrupt chunk must fail the checksum")
+
+	require.NoError(t, c.Set(ctx, "", []byte("0123456789")))
+	mr.Del(chunkKey(key, 2))
+	_, ok, err = c.Get(ctx, "")
+	require.NoError(t, err)
+	assert.False(t, ok, "evicted chunk must not yield a truncated value")
+
+	assert.Equal(t, uint64(2), chunkIntegrityFailures.Value()-before)
+}
+
+func TestValuesWithoutHeaderAreReadAsIs(t *testing.T) {
+	ctx := context.Background()
+	c, mr := newTestQueryCache(t)
+
+	mr.Set(c.key(0, ""), `[{"id":1}]`)
+	value, ok, err := c.Get(ctx, "")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, `[{"id":1}]`, string(value))
+}
+
+func TestSealedValuesRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	c, mr := newTestQueryCache(t)
+	keyring, err := encryption.NewKeyring([]encryption.Key{{ID: "k1", Secret: bytes.Repeat([]byte{1}, encryption.KeySize)}})
+	require.NoError(t, err)
+	c.Sealer = keyring
+	c.ChunkSize = 256
+	value := listPayload(20)
+
+	require.NoError(t, c.Set(ctx, "", value))
+	stored, err := mr.Get(c.key(0, ""))
+	require.NoError(t, err)
+	assert.Equal(t, headerChunked, stored[0], "sealed values are chunked like any other")
+	first, err := mr.Get(chunkKey(c.key(0, ""), 0))
+	require.NoError(t, err)
+	assert.Equal(t, headerSealed, first[0])
+	assert.NotContains(t, first, "payload")
+
+	got, ok, err := c.Get(ctx, "")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, value, got)
+
+	// A value moved under another key fails to open and reads as a miss
+	require.NoError(t, c.Set(ctx, "tag=a", []byte(`[]`)))
+	sealed, err := mr.Get(c.key(0, "tag=a"))
+	require.NoError(t, err)
+	mr.Set(c.key(0, "tag=b"), sealed)
+	_, ok, err = c.Get(ctx, "tag=b")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	c.Sealer = nil
+	_, ok, err = c.Get(ctx, "tag=a")
+	require.NoError(t, err)
+	assert.False(t, ok, "sealed values are misses without the sealer")
+}
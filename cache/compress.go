@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/nesymno/run-tests-example/metrics"
+)
+
+var (
+	compressionRatio = metrics.NewHistogram("app_cache_compression_ratio",
+		"Compressed size of cache values as a fraction of their original size.",
+		[]float64{0.05, 0.1, 0.2, 0.3, 0.5, 0.75, 1})
+	compressedBytesIn = metrics.NewCounter("app_cache_compressed_bytes_in_total",
+		"Bytes of cache values passed to the compressor.")
+	compressedBytesOut = metrics.NewCounter("app_cache_compressed_bytes_out_total",
+		"Bytes of compressed cache values stored.")
+)
+
+// Compression codecs for QueryCache.Compression.
+const (
+	CodecNone = ""
+	CodecGzip = "gzip"
+)
+
+// DefaultCompressMinBytes is the smallest value worth compressing; below
+// it the codec overhead outweighs the savings.
+const DefaultCompressMinBytes = 1024
+
+// headerGzip marks a gzip-compressed value.
+const headerGzip byte = 0x03
+
+// ValidCodec reports whether codec names a supported compression codec.
+func ValidCodec(codec string) bool {
+	return codec == CodecNone || codec == CodecGzip
+}
+
+// encode frames value with a header byte, compressing it first when the
+// cache is configured to and it is large enough. Values that do not shrink
+// are stored uncompressed.
+func (c *QueryCache) encode(value []byte) []byte {
+	if c.Compression == CodecGzip && len(value) >= c.CompressMinBytes {
+		var buf bytes.Buffer
+		buf.WriteByte(headerGzip)
+		zw := gzip.NewWriter(&buf)
+		zw.Write(value)
+		if err := zw.Close(); err == nil && buf.Len() < len(value)+1 {
+			compressedBytesIn.Add(uint64(len(value)))
+			compressedBytesOut.Add(uint64(buf.Len()))
+			compressionRatio.Observe(float64(buf.Len()) / float64(len(value)))
+			return buf.Bytes()
+		}
+	}
+	return append([]byte{headerRaw}, value...)
+}
+
+// decode reverses encode. Values written before headers existed start with
+// JSON and are returned as they are.
+func decode(payload []byte) ([]byte, error) {
+	if len(payload) == 0 {
+		return payload, nil
+	}
+
+	switch payload[0] {
+	case headerRaw:
+		return payload[1:], nil
+	case headerGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(payload[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %v", err)
+		}
+		value, err := io.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %v", err)
+		}
+		return value, nil
+	default:
+		return payload, nil
+	}
+}
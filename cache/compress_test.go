@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listPayload renders n rows shaped like a GET /api/data response.
+func listPayload(n int) []byte {
+	type row struct {
+		ID     int      `json:"id"`
+		Name   string   `json:"name"`
+		Data   string   `json:"data"`
+		Tags   []string `json:"tags"`
+		Status string   `json:"status"`
+	}
+	rows := make([]row, n)
+	for i := range rows {
+		rows[i] = row{ID: i + 1, Name: fmt.Sprintf("gen-1-%07d", i), Data: fmt.Sprintf("payload %d", i*7919), Tags: []string{"alpha"}, Status: "active"}
+	}
+	b, _ := json.Marshal(rows)
+	return b
+}
+
+func TestCompressedValuesRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	c, mr := newTestQueryCache(t)
+	c.Compression = CodecGzip
+	value := listPayload(100)
+
+	require.NoError(t, c.Set(ctx, "", value))
+	stored, err := mr.Get(c.key(0, ""))
+	require.NoError(t, err)
+	assert.Equal(t, headerGzip, stored[0])
+	assert.Less(t, len(stored), len(value)/2)
+
+	got, ok, err := c.Get(ctx, "")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, value, got)
+}
+
+func TestSmallOrIncompressibleValuesAreStoredRaw(t *testing.T) {
+	c := NewQueryCache(nil, "list", 0)
+	c.Compression = CodecGzip
+
+	assert.Equal(t, headerRaw, c.encode([]byte(`[]`))[0], "below CompressMinBytes")
+
+	c.CompressMinBytes = 1
+	assert.Equal(t, headerRaw, c.encode([]byte(`[{"id":1}]`))[0], "gzip would make it larger")
+}
+
+func TestCompressedValuesAreChunkedAfterCompression(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestQueryCache(t)
+	c.Compression = CodecGzip
+	c.ChunkSize = 256
+	value := listPayload(1000)
+
+	require.NoError(t, c.Set(ctx, "", value))
+	got, ok, err := c.Get(ctx, "")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, value, got)
+}
+
+func TestCorruptCompressedValueIsAMiss(t *testing.T) {
+	ctx := context.Background()
+	c, mr := newTestQueryCache(t)
+
+	mr.Set(c.key(0, ""), string([]byte{headerGzip, 'x'}))
+	_, ok, err := c.Get(ctx, "")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func benchmarkEncode(b *testing.B, codec string) {
+	c := NewQueryCache(nil, "list", 0)
+	c.Compression = codec
+	value := listPayload(1000)
+
+	b.SetBytes(int64(len(value)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	var size int
+	for i := 0; i < b.N; i++ {
+		size = len(c.encode(value))
+	}
+	b.ReportMetric(float64(size)/float64(len(value)), "ratio")
+}
+
+func BenchmarkEncodeNone(b *testing.B) { benchmarkEncode(b, CodecNone) }
+func BenchmarkEncodeGzip(b *testing.B) { benchmarkEncode(b, CodecGzip) }
+
+func benchmarkDecode(b *testing.B, codec string) {
+	c := NewQueryCache(nil, "list", 0)
+	c.Compression = codec
+	payload := c.encode(listPayload(1000))
+
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := decode(payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeNone(b *testing.B) { benchmarkDecode(b, CodecNone) }
+func BenchmarkDecodeGzip(b *testing.B) { benchmarkDecode(b, CodecGzip) }
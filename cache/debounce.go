@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nesymno/run-tests-example/metrics"
+)
+
+// ErrInvalidationDeferred is returned by Invalidate when an invalidation
+// already ran within InvalidateDebounce. The deferred one runs at the end
+// of the window, and until then Available reports false on this replica.
+var ErrInvalidationDeferred = errors.New("cache invalidation deferred")
+
+var deferredInvalidations = metrics.NewCounter("app_cache_invalidations_deferred_total",
+	"Query cache invalidations folded into a single one at the end of the debounce window.")
+
+// deferredTimeout bounds the trailing invalidation, which runs without a
+// request to take a context from.
+const deferredTimeout = 5 * time.Second
+
+// debouncer lets one invalidation through per window and folds the ones
+// arriving within it into a single trailing invalidation.
+type debouncer struct {
+	mu    sync.Mutex
+	last  time.Time
+	timer *time.Timer
+}
+
+// admit reports whether an invalidation may run at now. If not, it makes
+// sure fire runs once when the window that started at the last admitted
+// invalidation ends.
+func (d *debouncer) admit(now time.Time, window time.Duration, fire func()) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.last.IsZero() || now.Sub(d.last) >= window {
+		// An invalidation now also covers a trailing one still due
+		if d.timer != nil && d.timer.Stop() {
+			d.timer = nil
+		}
+		d.last = now
+		return true
+	}
+
+	if d.timer == nil {
+		d.timer = time.AfterFunc(d.last.Add(window).Sub(now), func() {
+			d.mu.Lock()
+			d.timer = nil
+			d.last = time.Now()
+			d.mu.Unlock()
+			fire()
+		})
+	}
+	return false
+}
+
+// pending reports whether a trailing invalidation is due.
+func (d *debouncer) pending() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.timer != nil
+}
+
+// invalidateDeferred is the trailing invalidation of a debounce window. If
+// it fails, the invalidation stays owed and Available retries it.
+func (c *QueryCache) invalidateDeferred() {
+	ctx, cancel := context.WithTimeout(context.Background(), deferredTimeout)
+	defer cancel()
+	if _, err := c.invalidateNow(ctx); err != nil {
+		log.Printf("Deferred cache invalidation failed: %v", err)
+	}
+}
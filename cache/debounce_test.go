@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebouncerAdmitsOncePerWindow(t *testing.T) {
+	var d debouncer
+	var fired atomic.Int32
+	fire := func() { fired.Add(1) }
+	start := time.Now()
+
+	assert.True(t, d.admit(start, time.Hour, fire), "the first invalidation runs at once")
+	assert.False(t, d.admit(start.Add(time.Minute), time.Hour, fire))
+	assert.False(t, d.admit(start.Add(59*time.Minute), time.Hour, fire))
+	assert.True(t, d.pending(), "a trailing invalidation is due")
+
+	// Past the window the next one runs at once and replaces the trailing one
+	assert.True(t, d.admit(start.Add(time.Hour), time.Hour, fire))
+	assert.False(t, d.pending())
+	assert.Equal(t, int32(0), fired.Load())
+}
+
+func TestDebouncedInvalidationsFoldIntoOne(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestQueryCache(t)
+	c.InvalidateDebounce = 50 * time.Millisecond
+
+	epoch, err := c.Invalidate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), epoch)
+
+	require.NoError(t, c.Set(ctx, "tag=a", []byte("stale")))
+	for range 100 {
+		_, err := c.Invalidate(ctx)
+		assert.ErrorIs(t, err, ErrInvalidationDeferred)
+	}
+	current, err := c.Epoch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), current, "deferred invalidations do not bump the epoch")
+	assert.False(t, c.Available(ctx), "this replica bypasses the cache while an invalidation is owed")
+
+	require.Eventually(t, func() bool {
+		current, err := c.Epoch(ctx)
+		return err == nil && current == 2
+	}, time.Second, 5*time.Millisecond, "one trailing invalidation at the end of the window")
+	assert.True(t, c.Available(ctx))
+	_, ok, err := c.Get(ctx, "tag=a")
+	require.NoError(t, err)
+	assert.False(t, ok, "entries written within the window are dropped by the trailing invalidation")
+
+	// The trailing invalidation opened a new window, and nothing else is
+	// due in it
+	time.Sleep(2 * c.InvalidateDebounce)
+	current, err = c.Epoch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), current)
+
+	epoch, err = c.Invalidate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), epoch, "after a quiet window invalidation runs at once")
+}
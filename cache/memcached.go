@@ -0,0 +1,379 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMemcachedTimeout bounds each memcached command whose context has
+// no earlier deadline.
+const DefaultMemcachedTimeout = 500 * time.Millisecond
+
+// memcachedMaxIdle is how many idle connections are kept per server.
+const memcachedMaxIdle = 8
+
+// memcachedRelativeLimit is the longest expiry memcached takes as relative
+// seconds; larger values are read as a Unix time.
+const memcachedRelativeLimit = 30 * 24 * time.Hour
+
+// MemcachedError is an error reply from a memcached server, such as
+// "SERVER_ERROR out of memory storing object".
+type MemcachedError string
+
+func (e MemcachedError) Error() string { return "memcached: " + string(e) }
+
+// Memcached is a Cache on memcached servers, spoken to in the text
+// protocol. Keys are spread over the servers by hash, so changing the
+// server list moves most keys, which then miss once. Unlike Redis,
+// memcached evicts under memory pressure whatever the settings, so a
+// chunked value may lose a chunk, which reads as a miss, and writing
+// chunks and their manifest is not atomic.
+type Memcached struct {
+	// Timeout bounds each command whose context has no earlier deadline.
+	Timeout time.Duration
+
+	servers []*memcachedServer
+}
+
+type memcachedServer struct {
+	addr string
+	idle chan *memcachedConn
+}
+
+type memcachedConn struct {
+	net.Conn
+	rw *bufio.ReadWriter
+}
+
+// NewMemcached returns a Cache on the memcached servers at addrs, given
+// as host:port.
+func NewMemcached(addrs ...string) *Memcached {
+	m := &Memcached{Timeout: DefaultMemcachedTimeout}
+	for _, addr := range addrs {
+		m.servers = append(m.servers, &memcachedServer{addr: addr, idle: make(chan *memcachedConn, memcachedMaxIdle)})
+	}
+	return m
+}
+
+// Close closes the idle connections.
+func (m *Memcached) Close() error {
+	for _, s := range m.servers {
+	drain:
+		for {
+			select {
+			case c := <-s.idle:
+				c.Close()
+			default:
+				break drain
+			}
+		}
+	}
+	return nil
+}
+
+// memcachedKey maps key to a valid memcached key: at most 250 bytes
+// without spaces or control characters. Other keys are replaced by their
+// hash.
+func memcachedKey(key string) string {
+	valid := len(key) <= 250
+	for i := 0; valid && i < len(key); i++ {
+		valid = key[i] > ' ' && key[i] != 0x7f
+	}
+	if valid {
+		return key
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// memcachedExpiry converts ttl to a memcached expiry: 0 never expires,
+// and TTLs past 30 days must be given as a Unix time. Partial seconds are
+// rounded up, as a TTL rounded down to 0 would never expire.
+func memcachedExpiry(ttl time.Duration, now time.Time) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	secs := int64((ttl + time.Second - 1) / time.Second)
+	if ttl > memcachedRelativeLimit {
+		return now.Unix() + secs
+	}
+	return secs
+}
+
+func (m *Memcached) server(key string) *memcachedServer {
+	return m.servers[crc32.ChecksumIEEE([]byte(key))%uint32(len(m.servers))]
+}
+
+// do runs fn on a connection to s. Connections are reused unless fn
+// failed for another reason than an error reply, which leaves the
+// connection in an unknown state.
+func (m *Memcached) do(ctx context.Context, s *memcachedServer, fn func(*memcachedConn) error) error {
+	deadline := time.Now().Add(m.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	var c *memcachedConn
+	select {
+	case c = <-s.idle:
+	default:
+		dialer := net.Dialer{Deadline: deadline}
+		conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+		if err != nil {
+			return err
+		}
+		c = &memcachedConn{Conn: conn, rw: bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))}
+	}
+	c.SetDeadline(deadline)
+
+	err := fn(c)
+	var reply MemcachedError
+	if err != nil && !errors.As(err, &reply) {
+		c.Close()
+		return err
+	}
+	select {
+	case s.idle <- c:
+	default:
+		c.Close()
+	}
+	return err
+}
+
+// command sends a command line, an optional data block, and returns the
+// reply line.
+func (c *memcachedConn) command(line string, data []byte) (string, error) {
+	c.rw.WriteString(line + "\r\n")
+	if data != nil {
+		c.rw.Write(data)
+		c.rw.WriteString("\r\n")
+	}
+	if err := c.rw.Flush(); err != nil {
+		return "", err
+	}
+	return c.readLine()
+}
+
+func (c *memcachedConn) readLine() (string, error) {
+	line, err := c.rw.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	if line == "ERROR" || strings.HasPrefix(line, "CLIENT_ERROR ") || strings.HasPrefix(line, "SERVER_ERROR ") {
+		return "", MemcachedError(line)
+	}
+	return line, nil
+}
+
+// memcachedItem is a value read by get or gets.
+type memcachedItem struct {
+	value []byte
+	cas   uint64
+}
+
+// get reads keys, all on one server, with their CAS values.
+func (c *memcachedConn) get(keys []string) (map[string]memcachedItem, error) {
+	line, err := c.command("gets "+strings.Join(keys, " "), nil)
+	items := map[string]memcachedItem{}
+	for ; err == nil && line != "END"; line, err = c.readLine() {
+		// VALUE <key> <flags> <bytes> <cas unique>
+		f := strings.Fields(line)
+		if len(f) != 5 || f[0] != "VALUE" {
+			return nil, fmt.Errorf("memcached: unexpected reply %q", line)
+		}
+		size, err := strconv.Atoi(f[3])
+		if err != nil {
+			return nil, fmt.Errorf("memcached: unexpected reply %q", line)
+		}
+		cas, _ := strconv.ParseUint(f[4], 10, 64)
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.rw, data); err != nil {
+			return nil, err
+		}
+		items[f[1]] = memcachedItem{value: data[:size], cas: cas}
+	}
+	return items, err
+}
+
+// store runs a storage command (set, add or cas) and returns the reply,
+// such as STORED or NOT_STORED.
+func (c *memcachedConn) store(cmd, key string, value []byte, expiry int64, cas uint64) (string, error) {
+	line := fmt.Sprintf("%s %s 0 %d %d", cmd, key, expiry, len(value))
+	if cmd == "cas" {
+		line += " " + strconv.FormatUint(cas, 10)
+	}
+	return c.command(line, value)
+}
+
+func (m *Memcached) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	values, err := m.GetMulti(ctx, []string{key})
+	if err != nil {
+		return nil, false, err
+	}
+	return values[0], values[0] != nil, nil
+}
+
+// GetMulti sends one command per server holding any of keys.
+func (m *Memcached) GetMulti(ctx context.Context, keys []string) ([][]byte, error) {
+	byServer := map[*memcachedServer][]string{}
+	for _, key := range keys {
+		k := memcachedKey(key)
+		byServer[m.server(k)] = append(byServer[m.server(k)], k)
+	}
+
+	found := map[string][]byte{}
+	for s, ks := range byServer {
+		err := m.do(ctx, s, func(c *memcachedConn) error {
+			items, err := c.get(ks)
+			for k, item := range items {
+				found[k] = item.value
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i] = found[memcachedKey(key)]
+	}
+	return values, nil
+}
+
+// SetMulti writes the entries one after the other, then the counter.
+func (m *Memcached) SetMulti(ctx context.Context, entries []Entry, countKey string, ttl time.Duration) error {
+	expiry := memcachedExpiry(ttl, time.Now())
+	for _, e := range entries {
+		k := memcachedKey(e.Key)
+		err := m.do(ctx, m.server(k), func(c *memcachedConn) error {
+			reply, err := c.store("set", k, e.Value, expiry, 0)
+			if err == nil && reply != "STORED" {
+				err = fmt.Errorf("memcached: set %s: %s", k, reply)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := m.incr(ctx, memcachedKey(countKey), expiry)
+	return err
+}
+
+// incr adds 1 to the counter at key, creating it with expiry, and returns
+// the new value. As incr leaves the expiry alone, it is set again, so the
+// counter expires with the entries it counts.
+func (m *Memcached) incr(ctx context.Context, key string, expiry int64) (int64, error) {
+	var n int64
+	err := m.do(ctx, m.server(key), func(c *memcachedConn) error {
+		for {
+			reply, err := c.command("incr "+key+" 1", nil)
+			if err != nil {
+				return err
+			}
+			if reply != "NOT_FOUND" {
+				if n, err = strconv.ParseInt(reply, 10, 64); err != nil {
+					return fmt.Errorf("memcached: unexpected reply %q", reply)
+				}
+				if expiry > 0 {
+					_, err = c.command(fmt.Sprintf("touch %s %d", key, expiry), nil)
+				}
+				return err
+			}
+			// Created by someone else in between when not stored
+			if reply, err = c.store("add", key, []byte("1"), expiry, 0); err != nil || reply == "STORED" {
+				n = 1
+				return err
+			}
+		}
+	})
+	return n, err
+}
+
+// Bump wraps with a second write, so another replica may see the counter
+// past max for a moment.
+func (m *Memcached) Bump(ctx context.Context, key string, max int64) (int64, bool, error) {
+	k := memcachedKey(key)
+	n, err := m.incr(ctx, k, 0)
+	if err != nil || n <= max {
+		return n, false, err
+	}
+	err = m.do(ctx, m.server(k), func(c *memcachedConn) error {
+		reply, err := c.store("set", k, []byte("1"), 0, 0)
+		if err == nil && reply != "STORED" {
+			err = fmt.Errorf("memcached: set %s: %s", k, reply)
+		}
+		return err
+	})
+	return 1, true, err
+}
+
+// Raise compares and swaps, retrying while other writers get in between.
+func (m *Memcached) Raise(ctx context.Context, key string, n int64) (int64, error) {
+	k := memcachedKey(key)
+	value := []byte(strconv.FormatInt(n, 10))
+	var current int64
+	err := m.do(ctx, m.server(k), func(c *memcachedConn) error {
+		for ctx.Err() == nil {
+			items, err := c.get([]string{k})
+			if err != nil {
+				return err
+			}
+			item, ok := items[k]
+			var reply string
+			if !ok {
+				reply, err = c.store("add", k, value, 0, 0)
+			} else {
+				current, err = strconv.ParseInt(strings.TrimSpace(string(item.value)), 10, 64)
+				if err != nil {
+					return fmt.Errorf("memcached: %s is not a counter: %q", k, item.value)
+				}
+				if current >= n {
+					return nil
+				}
+				reply, err = c.store("cas", k, value, 0, item.cas)
+			}
+			if err != nil || reply == "STORED" {
+				current = n
+				return err
+			}
+		}
+		return ctx.Err()
+	})
+	return current, err
+}
+
+// Ping asks every server for its version.
+func (m *Memcached) Ping(ctx context.Context) error {
+	if len(m.servers) == 0 {
+		return errors.New("memcached: no servers")
+	}
+	var errs []error
+	for _, s := range m.servers {
+		err := m.do(ctx, s, func(c *memcachedConn) error {
+			reply, err := c.command("version", nil)
+			if err == nil && !strings.HasPrefix(reply, "VERSION ") {
+				err = fmt.Errorf("memcached: unexpected reply %q", reply)
+			}
+			return err
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.addr, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,322 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMemcached serves the memcached commands Memcached sends, from memory.
+type fakeMemcached struct {
+	ln net.Listener
+
+	mu     sync.Mutex
+	items  map[string]fakeItem
+	cas    uint64
+	offset time.Duration
+	oom    bool
+}
+
+type fakeItem struct {
+	value   []byte
+	cas     uint64
+	expires time.Time
+}
+
+func runFakeMemcached(t *testing.T) *fakeMemcached {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f := &fakeMemcached{ln: ln, items: map[string]fakeItem{}}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeMemcached) Addr() string { return f.ln.Addr().String() }
+
+// FastForward moves the server's clock.
+func (f *fakeMemcached) FastForward(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.offset += d
+}
+
+// SetOOM makes writes fail as on a full memcached started with -M.
+func (f *fakeMemcached) SetOOM(oom bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.oom = oom
+}
+
+func (f *fakeMemcached) Exists(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.lookup(key)
+	return ok
+}
+
+// lookup returns a live item; the caller holds f.mu.
+func (f *fakeMemcached) lookup(key string) (fakeItem, bool) {
+	item, ok := f.items[key]
+	if ok && !item.expires.IsZero() && !time.Now().Add(f.offset).Before(item.expires) {
+		delete(f.items, key)
+		return item, false
+	}
+	return item, ok
+}
+
+func (f *fakeMemcached) expires(exptime int64) time.Time {
+	now := time.Now().Add(f.offset)
+	switch {
+	case exptime == 0:
+		return time.Time{}
+	case exptime > int64(memcachedRelativeLimit/time.Second):
+		return time.Unix(exptime, 0)
+	default:
+		return now.Add(time.Duration(exptime) * time.Second)
+	}
+}
+
+func (f *fakeMemcached) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			fmt.Fprint(conn, "ERROR\r\n")
+			continue
+		}
+		var data []byte
+		if cmd := args[0]; cmd == "set" || cmd == "add" || cmd == "cas" {
+			size, _ := strconv.Atoi(args[4])
+			data = make([]byte, size+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			data = data[:size]
+		}
+		fmt.Fprint(conn, f.reply(args, data))
+	}
+}
+
+func (f *fakeMemcached) reply(args []string, data []byte) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch args[0] {
+	case "version":
+		return "VERSION 1.6.0-fake\r\n"
+	case "gets":
+		var b strings.Builder
+		for _, key := range args[1:] {
+			if item, ok := f.lookup(key); ok {
+				fmt.Fprintf(&b, "VALUE %s 0 %d %d\r\n%s\r\n", key, len(item.value), item.cas, item.value)
+			}
+		}
+		return b.String() + "END\r\n"
+	case "set", "add", "cas":
+		if f.oom {
+			return "SERVER_ERROR out of memory storing object\r\n"
+		}
+		key := args[1]
+		exptime, _ := strconv.ParseInt(args[3], 10, 64)
+		item, exists := f.lookup(key)
+		switch {
+		case args[0] == "add" && exists:
+			return "NOT_STORED\r\n"
+		case args[0] == "cas" && !exists:
+			return "NOT_FOUND\r\n"
+		case args[0] == "cas" && args[5] != strconv.FormatUint(item.cas, 10):
+			return "EXISTS\r\n"
+		}
+		f.cas++
+		f.items[key] = fakeItem{value: data, cas: f.cas, expires: f.expires(exptime)}
+		return "STORED\r\n"
+	case "incr":
+		item, ok := f.lookup(args[1])
+		if !ok {
+			return "NOT_FOUND\r\n"
+		}
+		n, err := strconv.ParseInt(string(item.value), 10, 64)
+		if err != nil {
+			return "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"
+		}
+		delta, _ := strconv.ParseInt(args[2], 10, 64)
+		f.cas++
+		item.value, item.cas = []byte(strconv.FormatInt(n+delta, 10)), f.cas
+		f.items[args[1]] = item
+		return string(item.value) + "\r\n"
+	case "touch":
+		item, ok := f.lookup(args[1])
+		if !ok {
+			return "NOT_FOUND\r\n"
+		}
+		exptime, _ := strconv.ParseInt(args[2], 10, 64)
+		item.expires = f.expires(exptime)
+		f.items[args[1]] = item
+		return "TOUCHED\r\n"
+	}
+	return "ERROR\r\n"
+}
+
+func newMemcachedQueryCache(t *testing.T, servers int) (*QueryCache, []*fakeMemcached) {
+	var fakes []*fakeMemcached
+	var addrs []string
+	for range servers {
+		f := runFakeMemcached(t)
+		fakes = append(fakes, f)
+		addrs = append(addrs, f.Addr())
+	}
+	backend := NewMemcached(addrs...)
+	t.Cleanup(func() { backend.Close() })
+	return NewQueryCacheOn(backend, "list", time.Minute), fakes
+}
+
+func TestMemcachedExpiry(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	assert.Equal(t, int64(0), memcachedExpiry(0, now), "no TTL never expires")
+	assert.Equal(t, int64(1), memcachedExpiry(time.Millisecond, now), "partial seconds round up, not to 0")
+	assert.Equal(t, int64(90), memcachedExpiry(90*time.Second, now))
+	assert.Equal(t, int64(30*24*3600), memcachedExpiry(30*24*time.Hour, now))
+	assert.Equal(t, now.Unix()+31*24*3600, memcachedExpiry(31*24*time.Hour, now), "long TTLs are a Unix time")
+}
+
+func TestMemcachedKey(t *testing.T) {
+	assert.Equal(t, "list:v3:0123abcd", memcachedKey("list:v3:0123abcd"))
+	for _, key := range []string{"with space", "new\nline", strings.Repeat("k", 251)} {
+		mapped := memcachedKey(key)
+		assert.Regexp(t, `^sha256:[0-9a-f]{64}$`, mapped)
+		assert.NotEqual(t, memcachedKey(key+"x"), mapped)
+	}
+}
+
+func TestQueryCacheOnMemcached(t *testing.T) {
+	ctx := context.Background()
+	c, fakes := newMemcachedQueryCache(t, 1)
+	require.NoError(t, c.Ping(ctx))
+
+	require.NoError(t, c.Set(ctx, "tag=a", []byte("cached")))
+	value, ok, err := c.Get(ctx, "tag=a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "cached", string(value))
+
+	before := orphanedKeys.Value()
+	epoch, err := c.Invalidate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), epoch)
+	assert.Equal(t, uint64(1), orphanedKeys.Value()-before)
+	_, ok, err = c.Get(ctx, "tag=a")
+	require.NoError(t, err)
+	assert.False(t, ok, "entry from the previous epoch must not be served")
+
+	// Entries expire with the TTL, the epoch never does
+	require.NoError(t, c.Set(ctx, "tag=a", []byte("cached")))
+	fakes[0].FastForward(2 * time.Minute)
+	_, ok, err = c.Get(ctx, "tag=a")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.False(t, fakes[0].Exists(c.countKey(1)))
+	epoch, err = c.Epoch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), epoch)
+}
+
+func TestMemcachedChunksAcrossServers(t *testing.T) {
+	ctx := context.Background()
+	c, fakes := newMemcachedQueryCache(t, 3)
+	c.ChunkSize = 8
+
+	value := []byte(strings.Repeat("0123456789", 10))
+	require.NoError(t, c.Set(ctx, "tag=a", value))
+	cached, ok, err := c.Get(ctx, "tag=a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, value, cached)
+
+	used := 0
+	for _, f := range fakes {
+		f.mu.Lock()
+		if len(f.items) > 0 {
+			used++
+		}
+		f.mu.Unlock()
+	}
+	assert.Greater(t, used, 1, "chunks should be spread over the servers")
+}
+
+func TestMemcachedCounters(t *testing.T) {
+	ctx := context.Background()
+	f := runFakeMemcached(t)
+	m := NewMemcached(f.Addr())
+	defer m.Close()
+
+	var got []int64
+	for range 4 {
+		n, _, err := m.Bump(ctx, "epoch", 3)
+		require.NoError(t, err)
+		got = append(got, n)
+	}
+	assert.Equal(t, []int64{1, 2, 3, 1}, got)
+
+	n, err := m.Raise(ctx, "raised", 5)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n, "a missing counter is created")
+	n, err = m.Raise(ctx, "raised", 4)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n, "a higher counter is kept")
+	n, err = m.Raise(ctx, "raised", 9)
+	require.NoError(t, err)
+	assert.Equal(t, int64(9), n)
+}
+
+func TestMemcachedOutOfMemoryDegradesCache(t *testing.T) {
+	ctx := context.Background()
+	c, fakes := newMemcachedQueryCache(t, 1)
+	fakes[0].SetOOM(true)
+
+	err := c.Set(ctx, "tag=a", []byte("cached"))
+	require.Error(t, err)
+	assert.True(t, IsOOM(err))
+	assert.True(t, c.Degraded())
+
+	// The connection survives an error reply
+	fakes[0].SetOOM(false)
+	require.NoError(t, c.Ping(ctx))
+}
+
+func TestMemcachedPingReportsUnreachableServers(t *testing.T) {
+	f := runFakeMemcached(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	down := ln.Addr().String()
+	ln.Close()
+
+	m := NewMemcached(f.Addr(), down)
+	defer m.Close()
+	err = m.Ping(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), down)
+	assert.NotContains(t, err.Error(), f.Addr())
+}
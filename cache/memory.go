@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/nesymno/run-tests-example/metrics"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+var redisOOMErrors = metrics.NewCounter("app_cache_redis_oom_total",
+	"Cache commands rejected because Redis reached maxmemory, or memcached ran out of memory.")
+
+// DefaultOOMCooldown is how long a QueryCache is bypassed after its backend
+// rejects a command for being out of memory.
+const DefaultOOMCooldown = 30 * time.Second
+
+// IsOOM reports whether err is Redis refusing a write at maxmemory, which
+// happens under the noeviction policy or when nothing is left to evict, or
+// memcached refusing one, which happens when it runs with -M.
+func IsOOM(err error) bool {
+	var redisErr redis.Error
+	var memcachedErr MemcachedError
+	switch {
+	case errors.As(err, &redisErr):
+		return strings.HasPrefix(redisErr.Error(), "OOM ")
+	case errors.As(err, &memcachedErr):
+		return strings.HasPrefix(string(memcachedErr), "SERVER_ERROR out of memory")
+	}
+	return false
+}
+
+// check records an OOM error and starts the cooldown. It returns err
+// unchanged.
+func (c *QueryCache) check(err error) error {
+	if IsOOM(err) {
+		redisOOMErrors.Inc()
+		if !c.Degraded() {
+			log.Printf("Cache out of memory, bypassing %s cache for %v: %v", c.prefix, c.OOMCooldown, err)
+		}
+		c.degradedUntil.Store(time.Now().Add(c.OOMCooldown).UnixNano())
+	}
+	return err
+}
+
+// Degraded reports whether the cache is in its OOM cooldown.
+func (c *QueryCache) Degraded() bool {
+	return time.Now().UnixNano() < c.degradedUntil.Load()
+}
+
+// Available reports whether the cache may be read and written. It is not
+// during the OOM cooldown, nor while an invalidation is owed: when one
+// fails, it is retried here before the cache is used again, so entries from
+// before the missed write are never served by this replica. A debounced
+// invalidation is owed until its window ends.
+func (c *QueryCache) Available(ctx context.Context) bool {
+	if c.Degraded() {
+		return false
+	}
+	if c.invalidationOwed.Load() {
+		if c.debounce.pending() {
+			return false
+		}
+		if _, err := c.Invalidate(ctx); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// MemoryStats reads Redis memory usage and eviction stats.
+func MemoryStats(ctx context.Context, rds *redis.Client) (types.CacheMemory, error) {
+	info, err := rds.Info(ctx, "memory", "stats").Result()
+	if err != nil {
+		return types.CacheMemory{}, err
+	}
+	return parseMemoryInfo(info), nil
+}
+
+// parseMemoryInfo picks the memory fields out of an INFO reply.
+func parseMemoryInfo(info string) types.CacheMemory {
+	var stats types.CacheMemory
+	scanner := bufio.NewScanner(strings.NewReader(info))
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok {
+			continue
+		}
+
+		switch name {
+		case "used_memory":
+			stats.UsedBytes, _ = strconv.ParseInt(value, 10, 64)
+		case "maxmemory":
+			stats.MaxBytes, _ = strconv.ParseInt(value, 10, 64)
+		case "maxmemory_policy":
+			stats.Policy = value
+		case "evicted_keys":
+			stats.EvictedKeys, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return stats
+}
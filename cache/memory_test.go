@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// oomError mimics the reply Redis sends for writes past maxmemory.
+type oomError string
+
+func (e oomError) Error() string { return string(e) }
+func (oomError) RedisError()     {}
+
+const oomReply = oomError("OOM command not allowed when used memory > 'maxmemory'.")
+
+func TestIsOOM(t *testing.T) {
+	assert.True(t, IsOOM(oomReply))
+	assert.True(t, IsOOM(fmt.Errorf("set: %w", oomReply)))
+	assert.False(t, IsOOM(oomError("ERR unknown command")))
+	assert.False(t, IsOOM(errors.New("OOM but not from redis")))
+	assert.False(t, IsOOM(nil))
+}
+
+func TestOOMDegradesCacheForCooldown(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestQueryCache(t)
+	c.OOMCooldown = 50 * time.Millisecond
+
+	before := redisOOMErrors.Value()
+	assert.ErrorIs(t, c.check(oomReply), oomReply)
+	assert.Equal(t, uint64(1), redisOOMErrors.Value()-before)
+	assert.True(t, c.Degraded())
+	assert.False(t, c.Available(ctx))
+
+	assert.Eventually(t, func() bool { return c.Available(ctx) }, time.Second, 10*time.Millisecond)
+}
+
+func TestFailedInvalidationIsRetriedBeforeReuse(t *testing.T) {
+	ctx := context.Background()
+	c, mr := newTestQueryCache(t)
+	require.NoError(t, c.Set(ctx, "", []byte("before write")))
+
+	mr.SetError("OOM command not allowed when used memory > 'maxmemory'.")
+	_, err := c.Invalidate(ctx)
+	require.Error(t, err)
+	mr.SetError("")
+
+	c.degradedUntil.Store(0)
+	require.True(t, c.Available(ctx), "the owed invalidation should succeed now")
+
+	_, ok, err := c.Get(ctx, "")
+	require.NoError(t, err)
+	assert.False(t, ok, "entries from before the missed invalidation must not be served")
+}
+
+func TestParseMemoryInfo(t *testing.T) {
+	info := "# Memory\r\nused_memory:1048576\r\nused_memory_human:1.00M\r\nmaxmemory:2097152\r\n" +
+		"maxmemory_policy:allkeys-lru\r\n\r\n# Stats\r\nevicted_keys:17\r\n"
+
+	stats := parseMemoryInfo(info)
+	assert.Equal(t, int64(1048576), stats.UsedBytes)
+	assert.Equal(t, int64(2097152), stats.MaxBytes)
+	assert.Equal(t, "allkeys-lru", stats.Policy)
+	assert.Equal(t, int64(17), stats.EvictedKeys)
+}
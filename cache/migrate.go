@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"log"
+
+	"github.com/nesymno/run-tests-example/metrics"
+)
+
+var (
+	migrationFallbackHits = metrics.NewCounter("app_cache_migration_fallback_hits_total",
+		"Reads that missed the new cache backend and were served from the old one.")
+	migrationOldMisses = metrics.NewCounter("app_cache_migration_old_misses_total",
+		"Reads that hit the new cache backend but missed the old one.")
+	migrationDivergences = metrics.NewCounter("app_cache_migration_divergences_total",
+		"Reads where the new and old cache backends held different values.")
+	migrationOldErrors = metrics.NewCounter("app_cache_migration_old_errors_total",
+		"Failed reads, writes or invalidations against the old cache backend.")
+)
+
+// MigrateFrom puts c in dual-read mode while moving off old: reads try c
+// first and fall back to old, copying what they find into c; writes and
+// invalidations go to both. Both backends key entries by the same epoch,
+// the highest of the two, so replicas still using only old keep
+// invalidating the entries these replicas read, and switching back to old
+// alone serves nothing stale. It must be called before c is used.
+func (c *QueryCache) MigrateFrom(old *QueryCache) {
+	c.old = old
+}
+
+// dualEpoch returns the higher of the two backends' epochs.
+func (c *QueryCache) dualEpoch(ctx context.Context) (int64, error) {
+	epoch, err := c.ownEpoch(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	oldEpoch, err := c.old.ownEpoch(ctx)
+	if err != nil {
+		// Without the old epoch an invalidation made through it may be
+		// missed, so refuse to serve rather than guess
+		migrationOldErrors.Inc()
+		return 0, err
+	}
+	return max(epoch, oldEpoch), nil
+}
+
+// dualGetAt reads both backends and reports how they differ.
+func (c *QueryCache) dualGetAt(ctx context.Context, epoch int64, query string) ([]byte, bool, error) {
+	value, ok, err := c.getAt(ctx, epoch, query)
+	oldValue, oldOk, oldErr := c.old.getAt(ctx, epoch, query)
+	if oldErr != nil {
+		migrationOldErrors.Inc()
+		oldOk = false
+	}
+
+	switch {
+	case err != nil:
+		if oldOk {
+			migrationFallbackHits.Inc()
+			return oldValue, true, nil
+		}
+		return nil, false, err
+	case ok && oldOk:
+		if !bytes.Equal(value, oldValue) {
+			migrationDivergences.Inc()
+		}
+	case ok:
+		migrationOldMisses.Inc()
+	case oldOk:
+		migrationFallbackHits.Inc()
+		if err := c.setAt(ctx, epoch, query, oldValue); err != nil {
+			log.Printf("Cache migration backfill failed: %v", err)
+		}
+		return oldValue, true, nil
+	}
+	return value, ok, nil
+}
+
+// dualInvalidate moves both backends to a new epoch above either one's
+// current epoch. The new backend moves first, as that alone already hides
+// every cached entry from dual-read replicas.
+func (c *QueryCache) dualInvalidate(ctx context.Context) (int64, error) {
+	oldEpoch, err := c.old.ownEpoch(ctx)
+	if err == nil {
+		_, err = c.backend.Raise(ctx, c.epochKey(), oldEpoch)
+	}
+	if err != nil {
+		c.invalidationOwed.Store(true)
+		return 0, c.check(err)
+	}
+
+	epoch, err := c.invalidate(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := c.old.backend.Raise(ctx, c.old.epochKey(), epoch); err != nil {
+		migrationOldErrors.Inc()
+		return epoch, err
+	}
+	return epoch, nil
+}
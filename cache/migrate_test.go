@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMigratingCache(t *testing.T) (next, old *QueryCache) {
+	next, _ = newTestQueryCache(t)
+	old, _ = newTestQueryCache(t)
+	// A separate handle on the same backend, as a replica not migrating yet
+	// would have
+	migrating := NewQueryCacheOn(next.backend, next.prefix, next.ttl)
+	migrating.MigrateFrom(NewQueryCacheOn(old.backend, old.prefix, old.ttl))
+	return migrating, old
+}
+
+func TestDualReadFallsBackToOldAndBackfills(t *testing.T) {
+	ctx := context.Background()
+	c, old := newMigratingCache(t)
+	require.NoError(t, old.Set(ctx, "", []byte("from old")))
+
+	before := migrationFallbackHits.Value()
+	value, ok, err := c.Get(ctx, "")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "from old", string(value))
+	assert.Equal(t, uint64(1), migrationFallbackHits.Value()-before)
+
+	value, ok, err = c.getAt(ctx, 0, "")
+	require.NoError(t, err)
+	require.True(t, ok, "the fallback hit should be copied into the new backend")
+	assert.Equal(t, "from old", string(value))
+}
+
+func TestDualWriteAndDivergence(t *testing.T) {
+	ctx := context.Background()
+	c, old := newMigratingCache(t)
+
+	require.NoError(t, c.Set(ctx, "", []byte("both")))
+	value, ok, err := old.Get(ctx, "")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "both", string(value))
+
+	require.NoError(t, old.Set(ctx, "", []byte("changed")))
+	before := migrationDivergences.Value()
+	value, ok, err = c.Get(ctx, "")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "both", string(value), "the new backend wins")
+	assert.Equal(t, uint64(1), migrationDivergences.Value()-before)
+}
+
+func TestDualInvalidationCoversBothBackends(t *testing.T) {
+	ctx := context.Background()
+	c, old := newMigratingCache(t)
+
+	// Invalidations made by replicas still using only the old backend
+	require.NoError(t, c.Set(ctx, "", []byte("stale")))
+	_, err := old.Invalidate(ctx)
+	require.NoError(t, err)
+	_, ok, err := c.Get(ctx, "")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Invalidations made in dual mode, seen after switching back
+	require.NoError(t, c.Set(ctx, "", []byte("stale")))
+	epoch, err := c.Invalidate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), epoch)
+
+	oldEpoch, err := old.Epoch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, epoch, oldEpoch)
+	_, ok, err = old.Get(ctx, "")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var jsonMarshaler = reflect.TypeFor[json.Marshaler]()
+
+// Namespace names the cache entries holding responses of one API version
+// and response type. Caches with different namespaces share an epoch, so a
+// write invalidates every version, but never read each other's entries:
+// a version with a different response shape, or a build that added a
+// field, misses instead of serving a payload of the wrong shape.
+func Namespace(version string, response any) string {
+	return version + "." + SchemaHash(reflect.TypeOf(response))
+}
+
+// SchemaHash hashes the JSON shape of t: the encoded name and type of every
+// field, recursively. Renaming, adding or retyping a field changes the hash;
+// unexported and `json:"-"` fields do not.
+func SchemaHash(t reflect.Type) string {
+	var b strings.Builder
+	writeSchema(&b, t, map[reflect.Type]bool{})
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:4])
+}
+
+func writeSchema(b *strings.Builder, t reflect.Type, seen map[reflect.Type]bool) {
+	switch t.Kind() {
+	case reflect.Pointer:
+		b.WriteString("*")
+		writeSchema(b, t.Elem(), seen)
+	case reflect.Slice, reflect.Array:
+		b.WriteString("[]")
+		writeSchema(b, t.Elem(), seen)
+	case reflect.Map:
+		fmt.Fprintf(b, "map[%s]", t.Key().Kind())
+		writeSchema(b, t.Elem(), seen)
+	case reflect.Struct:
+		// Types that encode themselves, like time.Time, are opaque
+		if t.PkgPath() != "" && (t.Implements(jsonMarshaler) || reflect.PointerTo(t).Implements(jsonMarshaler)) {
+			b.WriteString(t.String())
+			return
+		}
+		if seen[t] {
+			b.WriteString(t.String())
+			return
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		b.WriteString("{")
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			fmt.Fprintf(b, "%s,%s:", name, opts)
+			writeSchema(b, field.Type, seen)
+			b.WriteString(";")
+		}
+		b.WriteString("}")
+	default:
+		b.WriteString(t.Kind().String())
+	}
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type itemV1 struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type itemV1Copy struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	notes string
+	Debug string `json:"-"`
+}
+
+type itemV2 struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type itemRenamed struct {
+	ID   int    `json:"id"`
+	Name string `json:"title"`
+}
+
+type itemTree struct {
+	Name     string      `json:"name"`
+	Children []*itemTree `json:"children"`
+}
+
+func TestSchemaHash(t *testing.T) {
+	v1 := SchemaHash(reflect.TypeOf(itemV1{}))
+
+	assert.Equal(t, v1, SchemaHash(reflect.TypeOf(itemV1Copy{})), "unexported and skipped fields are not part of the shape")
+	assert.NotEqual(t, v1, SchemaHash(reflect.TypeOf(itemV2{})), "an added field changes the shape")
+	assert.NotEqual(t, v1, SchemaHash(reflect.TypeOf(itemRenamed{})), "a renamed field changes the shape")
+	assert.NotEqual(t, v1, SchemaHash(reflect.TypeOf([]itemV1{})), "a list is not a single item")
+	assert.NotEmpty(t, SchemaHash(reflect.TypeOf(itemTree{})), "recursive types terminate")
+}
+
+func TestNamespacesIsolateEntriesButShareEpoch(t *testing.T) {
+	ctx := context.Background()
+	v1, mr := newTestQueryCache(t)
+	v1.Namespace = Namespace("v1", []itemV1{})
+
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rds.Close() })
+	v2 := NewQueryCache(rds, "list", time.Minute)
+	v2.Namespace = Namespace("v2", []itemV2{})
+	require.NotEqual(t, v1.Namespace, v2.Namespace)
+
+	require.NoError(t, v1.Set(ctx, "tag=a", []byte(`[{"id":1,"name":"a"}]`)))
+	_, ok, err := v2.Get(ctx, "tag=a")
+	require.NoError(t, err)
+	assert.False(t, ok, "another version must not read v1's payload")
+
+	require.NoError(t, v2.Set(ctx, "tag=a", []byte(`[{"id":1,"name":"a","created_at":"2024-01-01T00:00:00Z"}]`)))
+	value, ok, err := v1.Get(ctx, "tag=a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, `[{"id":1,"name":"a"}]`, string(value), "v2's write must not overwrite v1's entry")
+
+	_, err = v2.Invalidate(ctx)
+	require.NoError(t, err)
+	_, ok, err = v1.Get(ctx, "tag=a")
+	require.NoError(t, err)
+	assert.False(t, ok, "an invalidation through one version drops every version")
+}
+
+func TestNamespacedKey(t *testing.T) {
+	c := NewQueryCache(nil, "list", 0)
+	c.Namespace = "v1.0a1b2c3d"
+	assert.Regexp(t, `^list:v3:v1\.0a1b2c3d:[0-9a-f]{16}$`, c.key(3, "tag=a"))
+}
@@ -0,0 +1,270 @@
+// Package cache holds the caching layers used by the app, kept in Redis or
+// memcached.
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/nesymno/run-tests-example/metrics"
+)
+
+var (
+	epochInvalidations = metrics.NewCounter("app_cache_epoch_invalidations_total",
+		"Query cache invalidations performed by bumping the epoch.")
+	epochRollovers = metrics.NewCounter("app_cache_epoch_rollovers_total",
+		"Query cache epochs that wrapped around to 1.")
+	orphanedKeys = metrics.NewCounter("app_cache_orphaned_keys_total",
+		"Query cache entries left behind by an invalidation to expire by TTL.")
+)
+
+// Write strategies decide what a write does to the cached queries.
+const (
+	// StrategyInvalidate drops every cached query; the next read of each
+	// one pays for a miss.
+	StrategyInvalidate = "invalidate"
+	// StrategyWriteThrough drops every cached query and immediately stores
+	// fresh results for the hot ones, so readers keep hitting.
+	StrategyWriteThrough = "write-through"
+)
+
+// ValidStrategy reports whether s names a known write strategy.
+func ValidStrategy(s string) bool {
+	return s == StrategyInvalidate || s == StrategyWriteThrough
+}
+
+// DefaultMaxEpoch is the largest epoch before the counter wraps to 1. Redis
+// bumps epochs in a Lua script, where numbers are doubles, so the counter
+// stays within the range they represent exactly.
+const DefaultMaxEpoch = 1<<53 - 1
+
+// QueryCache caches query results keyed by a hash of the normalized query.
+// Every key embeds the current epoch, so bumping the epoch invalidates all
+// cached queries at once without scanning for keys; entries from earlier
+// epochs are never read again and simply expire.
+type QueryCache struct {
+	// MaxEpoch is where the epoch wraps around. Wrapping is only safe when
+	// entries from the epochs being reused have long expired.
+	MaxEpoch int64
+	// OOMCooldown is how long the cache is bypassed after Redis runs out
+	// of memory.
+	OOMCooldown time.Duration
+	// ChunkSize is the largest value stored under one key; larger values
+	// are split into chunks. 0 disables chunking.
+	ChunkSize int
+	// Compression is the codec applied to values of at least
+	// CompressMinBytes, CodecNone to store them as they are.
+	Compression      string
+	CompressMinBytes int
+	// Namespace is embedded in every entry's key, see Namespace. The epoch
+	// is shared by all namespaces under the same prefix.
+	Namespace string
+	// Sealer, when set, encrypts every value before it is stored, for
+	// caches holding decrypted sensitive fields.
+	Sealer Sealer
+	// InvalidateDebounce lets at most one invalidation through per window,
+	// so bulk writes bump the epoch once instead of once per row. 0
+	// invalidates on every call.
+	InvalidateDebounce time.Duration
+
+	backend Cache
+	prefix  string
+	ttl     time.Duration
+
+	degradedUntil    atomic.Int64
+	invalidationOwed atomic.Bool
+	debounce         debouncer
+
+	// old is the backend being migrated away from, see MigrateFrom
+	old *QueryCache
+}
+
+// NewQueryCache returns a QueryCache on rds.
+func NewQueryCache(rds *redis.Client, prefix string, ttl time.Duration) *QueryCache {
+	return NewQueryCacheOn(NewRedis(rds), prefix, ttl)
+}
+
+// NewQueryCacheOn returns a QueryCache on any backend.
+func NewQueryCacheOn(backend Cache, prefix string, ttl time.Duration) *QueryCache {
+	return &QueryCache{
+		MaxEpoch:         DefaultMaxEpoch,
+		OOMCooldown:      DefaultOOMCooldown,
+		ChunkSize:        DefaultChunkSize,
+		CompressMinBytes: DefaultCompressMinBytes,
+		backend:          backend,
+		prefix:           prefix,
+		ttl:              ttl,
+	}
+}
+
+// Ping checks that the backend can be reached.
+func (c *QueryCache) Ping(ctx context.Context) error {
+	return c.backend.Ping(ctx)
+}
+
+func (c *QueryCache) epochKey() string {
+	return c.prefix + ":epoch"
+}
+
+// Epoch returns the current epoch, 0 if nothing has been invalidated yet.
+func (c *QueryCache) Epoch(ctx context.Context) (int64, error) {
+	if c.old != nil {
+		return c.dualEpoch(ctx)
+	}
+	return c.ownEpoch(ctx)
+}
+
+func (c *QueryCache) ownEpoch(ctx context.Context) (int64, error) {
+	return c.counter(ctx, c.epochKey())
+}
+
+// counter reads a counter the backend keeps as a decimal string, 0 if it
+// does not exist.
+func (c *QueryCache) counter(ctx context.Context, key string) (int64, error) {
+	value, ok, err := c.backend.Get(ctx, key)
+	if err != nil || !ok {
+		return 0, err
+	}
+	// memcached pads counters that got shorter with spaces
+	return strconv.ParseInt(strings.TrimSpace(string(value)), 10, 64)
+}
+
+// key builds the cache key for a normalized query in the given epoch.
+func (c *QueryCache) key(epoch int64, query string) string {
+	sum := sha256.Sum256([]byte(query))
+	if c.Namespace != "" {
+		return fmt.Sprintf("%s:v%d:%s:%s", c.prefix, epoch, c.Namespace, hex.EncodeToString(sum[:8]))
+	}
+	return fmt.Sprintf("%s:v%d:%s", c.prefix, epoch, hex.EncodeToString(sum[:8]))
+}
+
+// Get returns the cached result for a normalized query. ok is false on a
+// miss.
+func (c *QueryCache) Get(ctx context.Context, query string) (value []byte, ok bool, err error) {
+	epoch, err := c.Epoch(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	return c.GetAt(ctx, epoch, query)
+}
+
+// GetAt is Get for an epoch the caller has already read.
+func (c *QueryCache) GetAt(ctx context.Context, epoch int64, query string) (value []byte, ok bool, err error) {
+	if c.old != nil {
+		return c.dualGetAt(ctx, epoch, query)
+	}
+	return c.getAt(ctx, epoch, query)
+}
+
+func (c *QueryCache) getAt(ctx context.Context, epoch int64, query string) (value []byte, ok bool, err error) {
+	value, ok, err = c.backend.Get(ctx, c.key(epoch, query))
+	if err != nil || !ok {
+		return nil, false, c.check(err)
+	}
+	return c.readValue(ctx, c.key(epoch, query), value)
+}
+
+// countKey counts the entries written in an epoch, so an invalidation can
+// report how many it orphaned.
+func (c *QueryCache) countKey(epoch int64) string {
+	return fmt.Sprintf("%s:v%d:count", c.prefix, epoch)
+}
+
+// Set caches the result of a normalized query under the current epoch.
+func (c *QueryCache) Set(ctx context.Context, query string, value []byte) error {
+	epoch, err := c.Epoch(ctx)
+	if err != nil {
+		return err
+	}
+	return c.SetAt(ctx, epoch, query, value)
+}
+
+// SetAt caches a result under the epoch that was current before it was
+// loaded. A result loaded while a write invalidated the cache then lands in
+// the old epoch and is never served, where Set would store it in the new
+// one.
+func (c *QueryCache) SetAt(ctx context.Context, epoch int64, query string, value []byte) error {
+	if c.old != nil {
+		return errors.Join(c.setAt(ctx, epoch, query, value), c.old.setAt(ctx, epoch, query, value))
+	}
+	return c.setAt(ctx, epoch, query, value)
+}
+
+func (c *QueryCache) setAt(ctx context.Context, epoch int64, query string, value []byte) error {
+	entries := c.entries(c.key(epoch, query), value)
+	if entries == nil {
+		return nil
+	}
+	return c.check(c.backend.SetMulti(ctx, entries, c.countKey(epoch), c.ttl))
+}
+
+// Invalidate drops every cached query by moving to a new epoch and returns
+// the new epoch. If it fails, Available reports false until a later
+// invalidation succeeds.
+//
+// Within InvalidateDebounce of the previous invalidation it returns
+// ErrInvalidationDeferred instead, and other replicas may serve entries
+// from before the write until the window ends.
+func (c *QueryCache) Invalidate(ctx context.Context) (int64, error) {
+	if c.InvalidateDebounce > 0 && !c.debounce.admit(time.Now(), c.InvalidateDebounce, c.invalidateDeferred) {
+		c.invalidationOwed.Store(true)
+		deferredInvalidations.Inc()
+		return 0, ErrInvalidationDeferred
+	}
+	return c.invalidateNow(ctx)
+}
+
+func (c *QueryCache) invalidateNow(ctx context.Context) (int64, error) {
+	if c.old != nil {
+		return c.dualInvalidate(ctx)
+	}
+	return c.invalidate(ctx)
+}
+
+func (c *QueryCache) invalidate(ctx context.Context) (int64, error) {
+	epoch, wrapped, err := c.backend.Bump(ctx, c.epochKey(), c.MaxEpoch)
+	if err != nil {
+		c.invalidationOwed.Store(true)
+		return 0, c.check(err)
+	}
+	c.invalidationOwed.Store(false)
+
+	epochInvalidations.Inc()
+	previous := epoch - 1
+	if wrapped {
+		previous = c.MaxEpoch
+		epochRollovers.Inc()
+	}
+	// Only a metric, so entries written since the bump may be included
+	if orphaned, err := c.counter(ctx, c.countKey(previous)); err == nil {
+		orphanedKeys.Add(uint64(orphaned))
+	}
+	return epoch, nil
+}
+
+// Refresh invalidates every cached query, stores the freshly loaded result
+// for query in the new epoch and returns that epoch. The result is written
+// under the epoch this call created, so if another write bumps the epoch
+// while load runs, the possibly stale result is orphaned rather than served.
+// The epoch is returned even if load fails, as the invalidation stands.
+func (c *QueryCache) Refresh(ctx context.Context, query string, load func(context.Context) ([]byte, error)) (int64, error) {
+	epoch, err := c.Invalidate(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := load(ctx)
+	if err != nil {
+		return epoch, err
+	}
+	return epoch, c.SetAt(ctx, epoch, query, value)
+}
@@ -0,0 +1,220 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestQueryCache(t *testing.T) (*QueryCache, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rds.Close() })
+	return NewQueryCache(rds, "list", time.Minute), mr
+}
+
+func TestQueryKeyEmbedsEpochAndQueryHash(t *testing.T) {
+	c := NewQueryCache(nil, "list", 0)
+
+	assert.Equal(t, c.key(3, "tag=a"), c.key(3, "tag=a"))
+	assert.NotEqual(t, c.key(3, "tag=a"), c.key(4, "tag=a"), "new epoch must change the key")
+	assert.NotEqual(t, c.key(3, "tag=a"), c.key(3, "tag=b"), "different queries must not share a key")
+	assert.Regexp(t, `^list:v3:[0-9a-f]{16}$`, c.key(3, "tag=a"))
+}
+
+func TestInvalidateHidesEntriesFromEarlierEpochs(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestQueryCache(t)
+
+	require.NoError(t, c.Set(ctx, "tag=a", []byte("cached")))
+	value, ok, err := c.Get(ctx, "tag=a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "cached", string(value))
+
+	epoch, err := c.Invalidate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), epoch)
+
+	_, ok, err = c.Get(ctx, "tag=a")
+	require.NoError(t, err)
+	assert.False(t, ok, "entry from the previous epoch must not be served")
+}
+
+func TestInvalidateCountsOrphanedEntries(t *testing.T) {
+	ctx := context.Background()
+	c, mr := newTestQueryCache(t)
+
+	require.NoError(t, c.Set(ctx, "tag=a", []byte("a")))
+	require.NoError(t, c.Set(ctx, "tag=b", []byte("b")))
+
+	before := orphanedKeys.Value()
+	_, err := c.Invalidate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), orphanedKeys.Value()-before)
+
+	// Orphaned entries are left to expire rather than deleted
+	assert.True(t, mr.Exists(c.key(0, "tag=a")))
+	mr.FastForward(2 * time.Minute)
+	assert.False(t, mr.Exists(c.key(0, "tag=a")))
+}
+
+func TestEpochRollsOverToOne(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestQueryCache(t)
+	c.MaxEpoch = 3
+
+	before := epochRollovers.Value()
+	var epochs []int64
+	for i := 0; i < 5; i++ {
+		epoch, err := c.Invalidate(ctx)
+		require.NoError(t, err)
+		epochs = append(epochs, epoch)
+	}
+
+	assert.Equal(t, []int64{1, 2, 3, 1, 2}, epochs)
+	assert.Equal(t, uint64(1), epochRollovers.Value()-before)
+
+	current, err := c.Epoch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), current)
+}
+
+func TestEpochRolloverStillInvalidates(t *testing.T) {
+	ctx := context.Background()
+	c, mr := newTestQueryCache(t)
+	c.MaxEpoch = 2
+
+	mr.Set(c.epochKey(), "2")
+	require.NoError(t, c.Set(ctx, "tag=a", []byte("stale")))
+
+	epoch, err := c.Invalidate(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), epoch)
+
+	_, ok, err := c.Get(ctx, "tag=a")
+	require.NoError(t, err)
+	assert.False(t, ok, "entry written before the rollover must not be served")
+}
+
+// strategyStore is a stand-in for the database behind a QueryCache: writes
+// go through apply, which updates the cache the way the strategy says.
+type strategyStore struct {
+	cache    *QueryCache
+	strategy string
+	rows     string
+	loads    int
+}
+
+func (s *strategyStore) load(context.Context) ([]byte, error) {
+	s.loads++
+	return []byte(s.rows), nil
+}
+
+func (s *strategyStore) write(ctx context.Context, t *testing.T, rows string) {
+	s.rows = rows
+	if s.strategy == StrategyWriteThrough {
+		_, err := s.cache.Refresh(ctx, "", s.load)
+		require.NoError(t, err)
+		return
+	}
+	_, err := s.cache.Invalidate(ctx)
+	require.NoError(t, err)
+}
+
+// read serves a query the way the list handler does: cache first, then the
+// store, caching what it loaded.
+func (s *strategyStore) read(ctx context.Context, t *testing.T, query string) (string, bool) {
+	if value, ok, err := s.cache.Get(ctx, query); err == nil && ok {
+		return string(value), true
+	}
+	value, err := s.load(ctx)
+	require.NoError(t, err)
+	require.NoError(t, s.cache.Set(ctx, query, value))
+	return string(value), false
+}
+
+func TestWriteStrategiesServeTheLatestWrite(t *testing.T) {
+	for _, strategy := range []string{StrategyInvalidate, StrategyWriteThrough} {
+		t.Run(strategy, func(t *testing.T) {
+			ctx := context.Background()
+			c, _ := newTestQueryCache(t)
+			s := &strategyStore{cache: c, strategy: strategy, rows: "v1"}
+
+			s.read(ctx, t, "")
+			s.write(ctx, t, "v2")
+
+			value, hit := s.read(ctx, t, "")
+			assert.Equal(t, "v2", value, "no strategy may serve data from before the write")
+			assert.Equal(t, strategy == StrategyWriteThrough, hit,
+				"only write-through should have the fresh result cached already")
+		})
+	}
+}
+
+func TestWriteThroughOnlyRefreshesTheHotQuery(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestQueryCache(t)
+	s := &strategyStore{cache: c, strategy: StrategyWriteThrough, rows: "v1"}
+
+	s.read(ctx, t, "tag=a")
+	s.write(ctx, t, "v2")
+
+	value, hit := s.read(ctx, t, "tag=a")
+	assert.Equal(t, "v2", value)
+	assert.False(t, hit, "filtered queries are invalidated, not refreshed")
+}
+
+func TestRefreshDropsResultOvertakenByAnotherWrite(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestQueryCache(t)
+
+	// A second write lands while the first refresh is still loading
+	_, err := c.Refresh(ctx, "", func(ctx context.Context) ([]byte, error) {
+		_, err := c.Invalidate(ctx)
+		return []byte("stale"), err
+	})
+	require.NoError(t, err)
+
+	_, ok, err := c.Get(ctx, "")
+	require.NoError(t, err)
+	assert.False(t, ok, "a refresh must not publish its result into a newer epoch")
+}
+
+func TestRefreshLeavesCacheInvalidatedWhenLoadFails(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestQueryCache(t)
+	require.NoError(t, c.Set(ctx, "", []byte("old")))
+
+	epoch, err := c.Refresh(ctx, "", func(context.Context) ([]byte, error) {
+		return nil, assert.AnError
+	})
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Equal(t, int64(1), epoch)
+
+	_, ok, err := c.Get(ctx, "")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestSetAtKeepsResultLoadedDuringInvalidationOutOfNewEpoch(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestQueryCache(t)
+
+	// A reader misses at epoch 0, then a write invalidates before the
+	// reader stores what it loaded
+	epoch, err := c.Epoch(ctx)
+	require.NoError(t, err)
+	_, err = c.Invalidate(ctx)
+	require.NoError(t, err)
+	require.NoError(t, c.SetAt(ctx, epoch, "", []byte("stale")))
+
+	_, ok, err := c.Get(ctx, "")
+	require.NoError(t, err)
+	assert.False(t, ok, "a result loaded before the write must not be served after it")
+}
@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nesymno/run-tests-example/metrics"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+var (
+	areaLookups = metrics.NewCounterVec("app_cache_area_lookups_total",
+		"Cache lookups by logical area and result: hit or miss.", "area", "result")
+	areaHitRatio = metrics.NewGaugeVec("app_cache_area_hit_ratio",
+		"Share of lookups served from the cache since start, by logical area.", "area")
+	areaFillDuration = metrics.NewHistogramVec("app_cache_area_fill_duration_seconds",
+		"Time taken to load a missed value from its source, by logical area.",
+		[]float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}, "area")
+	areaValueBytes = metrics.NewHistogramVec("app_cache_area_value_bytes",
+		"Size of the values stored in the cache, by logical area.",
+		[]float64{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304}, "area")
+)
+
+// Area is a logical group of cached values. The cache effectiveness
+// metrics and the cache report are broken down by area rather than by
+// key, so they stay few however many keys are cached.
+type Area string
+
+const (
+	// AreaList holds GET /api/data listings.
+	AreaList Area = "list"
+	// AreaRecord holds single records looked up by key, such as API keys.
+	AreaRecord Area = "record"
+)
+
+type areaStats struct {
+	hits, misses       atomic.Uint64
+	fills, fillNanos   atomic.Uint64
+	valueBytes, maxLen atomic.Uint64
+}
+
+var areas sync.Map // Area -> *areaStats
+
+func (a Area) stats() *areaStats {
+	if s, ok := areas.Load(a); ok {
+		return s.(*areaStats)
+	}
+	s, _ := areas.LoadOrStore(a, &areaStats{})
+	return s.(*areaStats)
+}
+
+// Hit records a lookup served from the cache.
+func (a Area) Hit() {
+	s := a.stats()
+	s.hits.Add(1)
+	areaLookups.With(string(a), "hit").Inc()
+	areaHitRatio.With(string(a)).Set(s.hitRatio())
+}
+
+// Miss records a lookup the cache could not serve.
+func (a Area) Miss() {
+	s := a.stats()
+	s.misses.Add(1)
+	areaLookups.With(string(a), "miss").Inc()
+	areaHitRatio.With(string(a)).Set(s.hitRatio())
+}
+
+// Fill records a missed value loaded in d and stored with size bytes.
+func (a Area) Fill(d time.Duration, size int) {
+	s := a.stats()
+	s.fills.Add(1)
+	s.fillNanos.Add(uint64(d))
+	s.valueBytes.Add(uint64(size))
+	for {
+		max := s.maxLen.Load()
+		if uint64(size) <= max || s.maxLen.CompareAndSwap(max, uint64(size)) {
+			break
+		}
+	}
+	areaFillDuration.With(string(a)).Observe(d.Seconds())
+	areaValueBytes.With(string(a)).Observe(float64(size))
+}
+
+func (s *areaStats) hitRatio() float64 {
+	hits, misses := s.hits.Load(), s.misses.Load()
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// Report summarizes every area used so far, ordered by name.
+func Report() []types.CacheAreaReport {
+	report := []types.CacheAreaReport{}
+	areas.Range(func(key, value any) bool {
+		s := value.(*areaStats)
+		r := types.CacheAreaReport{
+			Area:          string(key.(Area)),
+			Hits:          s.hits.Load(),
+			Misses:        s.misses.Load(),
+			HitRatio:      s.hitRatio(),
+			Fills:         s.fills.Load(),
+			MaxValueBytes: s.maxLen.Load(),
+		}
+		if r.Fills > 0 {
+			r.AvgFillMS = float64(s.fillNanos.Load()) / float64(r.Fills) / float64(time.Millisecond)
+			r.AvgValueBytes = float64(s.valueBytes.Load()) / float64(r.Fills)
+		}
+		report = append(report, r)
+		return true
+	})
+	sort.Slice(report, func(i, j int) bool { return report[i].Area < report[j].Area })
+	return report
+}
@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+func reportFor(t *testing.T, area Area) types.CacheAreaReport {
+	t.Helper()
+	for _, r := range Report() {
+		if r.Area == string(area) {
+			return r
+		}
+	}
+	require.FailNow(t, "area missing from report", area)
+	return types.CacheAreaReport{}
+}
+
+func TestAreaReport(t *testing.T) {
+	area := Area("test_report")
+	area.Miss()
+	area.Fill(4*time.Millisecond, 100)
+	area.Miss()
+	area.Fill(2*time.Millisecond, 300)
+	area.Hit()
+	area.Hit()
+
+	r := reportFor(t, area)
+	assert.Equal(t, uint64(2), r.Hits)
+	assert.Equal(t, uint64(2), r.Misses)
+	assert.Equal(t, 0.5, r.HitRatio)
+	assert.Equal(t, uint64(2), r.Fills)
+	assert.InDelta(t, 3.0, r.AvgFillMS, 0.001)
+	assert.Equal(t, 200.0, r.AvgValueBytes)
+	assert.Equal(t, uint64(300), r.MaxValueBytes)
+
+	assert.Equal(t, 0.5, areaHitRatio.With(string(area)).Value())
+}
+
+func TestAreasWithoutFills(t *testing.T) {
+	area := Area("test_unfilled")
+	area.Hit()
+
+	r := reportFor(t, area)
+	assert.Equal(t, 1.0, r.HitRatio)
+	assert.Zero(t, r.AvgFillMS)
+	assert.Zero(t, r.AvgValueBytes)
+}
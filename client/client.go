@@ -0,0 +1,367 @@
+// Package client is a typed client for the HTTP API of this app, for test
+// suites and tools that drive a deployed instance. Requests go through an
+// httpclient transport, so they get its retries with jittered exponential
+// backoff, retry budgets and per-host circuit breaking. Every call takes a
+// context that bounds all of its attempts.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nesymno/run-tests-example/httpclient"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// Options configures a Client.
+type Options struct {
+	// HTTP tunes the transport: timeouts, retries, backoff and circuit
+	// breaking. Retries only apply to idempotent requests unless
+	// RetryWrites is set.
+	HTTP httpclient.Options
+
+	// RetryWrites makes POST requests retryable by sending them with an
+	// Idempotency-Key. The app does not deduplicate requests by key, so a
+	// retried create whose first attempt did land fails with a conflict.
+	RetryWrites bool
+
+	// AdminToken authorizes the /admin endpoints.
+	AdminToken string
+	// ServiceToken is sent as a bearer token to apps requiring service
+	// account authentication.
+	ServiceToken string
+	// APIKey is sent to apps requiring API keys.
+	APIKey string
+	// TenantKey scopes every request to a tenant.
+	TenantKey string
+	// TestRunID tags every request, and the rows it creates, with a run.
+	TestRunID string
+	// SendDeadline passes the deadline of each call's context on to the
+	// app, which then gives up when the caller does and answers 504.
+	SendDeadline bool
+}
+
+// DefaultOptions returns httpclient's defaults without its deny list, as a
+// test app usually runs on a loopback or private address.
+func DefaultOptions() Options {
+	opts := httpclient.DefaultOptions()
+	opts.Deny = httpclient.HostRules{}
+	return Options{HTTP: opts}
+}
+
+// Client calls one app instance. It is safe for concurrent use.
+type Client struct {
+	base *url.URL
+	hc   *http.Client
+	opts Options
+
+	mu          sync.Mutex
+	consistency string
+}
+
+// New returns a client for the app at baseURL.
+func New(baseURL string, opts Options) (*Client, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("base URL %q must be http or https", baseURL)
+	}
+	return &Client{base: base, hc: httpclient.New(opts.HTTP), opts: opts}, nil
+}
+
+// Error is returned for responses with a status of 400 or above.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// StatusCode returns the status of an *Error in err's chain, 0 if there is
+// none.
+func StatusCode(err error) int {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// request builds a request to path, relative to the base URL.
+func (c *Client) request(ctx context.Context, method, path string, query url.Values, body any) (*http.Request, error) {
+	u := c.base.JoinPath(path)
+	u.RawQuery = query.Encode()
+
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if method == "POST" && c.opts.RetryWrites {
+		req.Header.Set(httpclient.IdempotencyKeyHeader, newIdempotencyKey())
+	}
+	// Absolute, so that retries share the deadline of the call
+	if deadline, ok := ctx.Deadline(); ok && c.opts.SendDeadline {
+		req.Header.Set(httpclient.DeadlineHeader, deadline.UTC().Format(time.RFC3339Nano))
+	}
+
+	headers := map[string]string{
+		"X-Admin-Token": c.opts.AdminToken,
+		"X-API-Key":     c.opts.APIKey,
+		"X-Tenant-Key":  c.opts.TenantKey,
+		"X-Test-Run-ID": c.opts.TestRunID,
+	}
+	for name, value := range headers {
+		if value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+	if c.opts.ServiceToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.opts.ServiceToken)
+	}
+	return req, nil
+}
+
+// do sends req and decodes a JSON response into out, unless out is nil.
+func (c *Client) do(req *http.Request, out any) (*http.Response, error) {
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return resp, &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp, fmt.Errorf("decoding %s %s: %w", req.Method, req.URL.Path, err)
+		}
+	}
+	return resp, nil
+}
+
+func (c *Client) call(ctx context.Context, method, path string, query url.Values, body, out any) (*http.Response, error) {
+	req, err := c.request(ctx, method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req, out)
+}
+
+// Health returns the app's health report.
+func (c *Client) Health(ctx context.Context) (*types.HealthResponse, error) {
+	var health types.HealthResponse
+	if _, err := c.call(ctx, "GET", "/health", nil, nil, &health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// ServerTime returns the clocks of the replica that answered.
+func (c *Client) ServerTime(ctx context.Context) (*types.ServerTime, error) {
+	var st types.ServerTime
+	if _, err := c.call(ctx, "GET", "/api/time", nil, nil, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// ClockOffset estimates how far the app's clock is ahead of the local
+// one, assuming the request and the response took equally long. Tests
+// comparing timestamps the app wrote can shift their expectations by it.
+func (c *Client) ClockOffset(ctx context.Context) (time.Duration, error) {
+	sent := time.Now()
+	st, err := c.ServerTime(ctx)
+	if err != nil {
+		return 0, err
+	}
+	received := time.Now()
+	midpoint := sent.Add(received.Sub(sent) / 2)
+	return st.Time.Sub(midpoint), nil
+}
+
+// ListOptions filters ListData. Empty fields match all rows.
+type ListOptions struct {
+	Tag       string
+	Status    string
+	TestRunID string
+	// Fields limits the fields returned for each row
+	Fields []string
+	// Include expands related resources, e.g. "comments"
+	Include []string
+}
+
+func (o ListOptions) query() url.Values {
+	q := url.Values{}
+	if o.Tag != "" {
+		q.Set("tag", o.Tag)
+	}
+	if o.Status != "" {
+		q.Set("status", o.Status)
+	}
+	if o.TestRunID != "" {
+		q.Set("test_run_id", o.TestRunID)
+	}
+	if len(o.Fields) > 0 {
+		q.Set("fields", strings.Join(o.Fields, ","))
+	}
+	if len(o.Include) > 0 {
+		q.Set("include", strings.Join(o.Include, ","))
+	}
+	return q
+}
+
+// ListData lists rows. It sees every row this client created before, even
+// while the app's cache still holds an older listing.
+func (c *Client) ListData(ctx context.Context, opts ListOptions) ([]types.TestData, error) {
+	req, err := c.request(ctx, "GET", "/api/data", opts.query(), nil)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if c.consistency != "" {
+		req.Header.Set("X-Consistency-Token", c.consistency)
+	}
+	c.mu.Unlock()
+
+	var rows []types.TestData
+	if _, err := c.do(req, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// Checksum returns the checksum of the rows ListData would return, to
+// compare the data of two environments. Fields and Include are ignored.
+func (c *Client) Checksum(ctx context.Context, opts ListOptions) (*types.DataChecksum, error) {
+	q := opts.query()
+	q.Del("fields")
+	q.Del("include")
+	var sum types.DataChecksum
+	if _, err := c.call(ctx, "GET", "/api/data/checksum", q, nil, &sum); err != nil {
+		return nil, err
+	}
+	return &sum, nil
+}
+
+// CreateData inserts a row.
+func (c *Client) CreateData(ctx context.Context, data types.TestData) error {
+	resp, err := c.call(ctx, "POST", "/api/data", nil, data, nil)
+	if err != nil {
+		return err
+	}
+	c.remember(resp)
+	return nil
+}
+
+// GetData returns a row.
+func (c *Client) GetData(ctx context.Context, id int) (*types.TestData, error) {
+	var data types.TestData
+	if _, err := c.call(ctx, "GET", fmt.Sprintf("/api/data/%d", id), nil, nil, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// UpdateData replaces a row, returning it as stored.
+func (c *Client) UpdateData(ctx context.Context, id int, data types.TestData) (*types.TestData, error) {
+	return c.writeData(ctx, "PUT", id, data)
+}
+
+// PatchData changes the fields of a row that patch sets, returning the
+// row as stored.
+func (c *Client) PatchData(ctx context.Context, id int, patch types.DataPatch) (*types.TestData, error) {
+	return c.writeData(ctx, "PATCH", id, patch)
+}
+
+func (c *Client) writeData(ctx context.Context, method string, id int, body any) (*types.TestData, error) {
+	var data types.TestData
+	resp, err := c.call(ctx, method, fmt.Sprintf("/api/data/%d", id), nil, body, &data)
+	if err != nil {
+		return nil, err
+	}
+	c.remember(resp)
+	return &data, nil
+}
+
+// DeleteData deletes a row and its comments.
+func (c *Client) DeleteData(ctx context.Context, id int) error {
+	resp, err := c.call(ctx, "DELETE", fmt.Sprintf("/api/data/%d", id), nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	c.remember(resp)
+	return nil
+}
+
+// remember keeps the consistency token of a write, for ListData to send.
+func (c *Client) remember(resp *http.Response) {
+	if token := resp.Header.Get("X-Consistency-Token"); token != "" {
+		c.mu.Lock()
+		c.consistency = token
+		c.mu.Unlock()
+	}
+}
+
+// DeleteTestRun deletes every row created by a test run.
+func (c *Client) DeleteTestRun(ctx context.Context, id string) (*types.TestRunCleanup, error) {
+	var cleanup types.TestRunCleanup
+	if _, err := c.call(ctx, "DELETE", "/api/runs/"+url.PathEscape(id), nil, nil, &cleanup); err != nil {
+		return nil, err
+	}
+	return &cleanup, nil
+}
+
+// Comments lists the comments on a row.
+func (c *Client) Comments(ctx context.Context, dataID int) ([]types.Comment, error) {
+	var comments []types.Comment
+	if _, err := c.call(ctx, "GET", fmt.Sprintf("/api/data/%d/comments", dataID), nil, nil, &comments); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// AddComment adds a comment to a row.
+func (c *Client) AddComment(ctx context.Context, dataID int, body string) (*types.Comment, error) {
+	var comment types.Comment
+	req := map[string]string{"body": body}
+	resp, err := c.call(ctx, "POST", fmt.Sprintf("/api/data/%d/comments", dataID), nil, req, &comment)
+	if err != nil {
+		return nil, err
+	}
+	c.remember(resp)
+	return &comment, nil
+}
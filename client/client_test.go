@@ -0,0 +1,208 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/httpclient"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+func testOptions() Options {
+	opts := DefaultOptions()
+	opts.HTTP.RetryBackoff = time.Millisecond
+	return opts
+}
+
+// flaky fails the first n requests with 503.
+func flaky(n int32, calls *atomic.Int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= n {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("X-Consistency-Token", "v1")
+		w.Write([]byte(`[]`))
+	}
+}
+
+func TestReadsAreRetried(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(flaky(2, &calls))
+	defer srv.Close()
+
+	c, err := New(srv.URL, testOptions())
+	require.NoError(t, err)
+	rows, err := c.ListData(context.Background(), ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, rows)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestWritesAreOnlyRetriedWhenEnabled(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(flaky(1, &calls))
+	defer srv.Close()
+
+	c, err := New(srv.URL, testOptions())
+	require.NoError(t, err)
+	err = c.CreateData(context.Background(), types.TestData{Name: "n"})
+	assert.Equal(t, http.StatusServiceUnavailable, StatusCode(err))
+	assert.Equal(t, int32(1), calls.Load())
+
+	opts := testOptions()
+	opts.RetryWrites = true
+	c, err = New(srv.URL, opts)
+	require.NoError(t, err)
+	calls.Store(0)
+	require.NoError(t, c.CreateData(context.Background(), types.TestData{Name: "n"}))
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestCircuitOpensPerHost(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(flaky(100, &calls))
+	defer srv.Close()
+
+	opts := testOptions()
+	opts.HTTP.MaxRetries = 0
+	opts.HTTP.BreakerThreshold = 2
+	opts.HTTP.BreakerCooldown = time.Minute
+	c, err := New(srv.URL, opts)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for range 2 {
+		_, err = c.ListData(ctx, ListOptions{})
+		assert.Equal(t, http.StatusServiceUnavailable, StatusCode(err))
+	}
+	_, err = c.ListData(ctx, ListOptions{})
+	assert.True(t, errors.Is(err, httpclient.ErrCircuitOpen), err)
+	assert.Equal(t, int32(2), calls.Load(), "an open circuit fails fast")
+}
+
+func TestContextBoundsRetries(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(flaky(100, &calls))
+	defer srv.Close()
+
+	opts := testOptions()
+	opts.HTTP.MaxRetries = 100
+	opts.HTTP.RetryBudgetBurst = 100
+	opts.HTTP.RetryBackoff = 50 * time.Millisecond
+	c, err := New(srv.URL, opts)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = c.ListData(ctx, ListOptions{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRequestsCarryCredentialsAndConsistency(t *testing.T) {
+	var got http.Header
+	var query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, query = r.Header.Clone(), r.URL.RawQuery
+		if r.Method == "POST" {
+			w.Header().Set("X-Consistency-Token", "v7")
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.Write([]byte(`[{"id":1,"name":"n"}]`))
+	}))
+	defer srv.Close()
+
+	opts := testOptions()
+	opts.TenantKey = "tenant"
+	opts.TestRunID = "run-1"
+	opts.ServiceToken = "sa"
+	c, err := New(srv.URL, opts)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, c.CreateData(ctx, types.TestData{Name: "n"}))
+	assert.Equal(t, "tenant", got.Get("X-Tenant-Key"))
+	assert.Equal(t, "run-1", got.Get("X-Test-Run-ID"))
+	assert.Equal(t, "Bearer sa", got.Get("Authorization"))
+	assert.Empty(t, got.Get(httpclient.IdempotencyKeyHeader))
+
+	rows, err := c.ListData(ctx, ListOptions{Tag: "smoke", Fields: []string{"id", "name"}})
+	require.NoError(t, err)
+	assert.Equal(t, []types.TestData{{ID: 1, Name: "n"}}, rows)
+	assert.Equal(t, "v7", got.Get("X-Consistency-Token"), "reads see the client's own writes")
+	assert.Equal(t, "fields=id%2Cname&tag=smoke", query)
+}
+
+func TestSendDeadline(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Write([]byte(`{"status":"healthy"}`))
+	}))
+	defer srv.Close()
+
+	deadline := time.Now().Add(time.Minute)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	c, err := New(srv.URL, testOptions())
+	require.NoError(t, err)
+	_, err = c.Health(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, got.Get(httpclient.DeadlineHeader), "only sent when enabled")
+
+	opts := testOptions()
+	opts.SendDeadline = true
+	c, err = New(srv.URL, opts)
+	require.NoError(t, err)
+	_, err = c.Health(ctx)
+	require.NoError(t, err)
+	sent, err := time.Parse(time.RFC3339Nano, got.Get(httpclient.DeadlineHeader))
+	require.NoError(t, err)
+	assert.True(t, deadline.Equal(sent))
+}
+
+func TestNewRejectsInvalidBaseURL(t *testing.T) {
+	_, err := New("localhost:8080", DefaultOptions())
+	assert.Error(t, err)
+}
+
+func TestChecksumSendsListFilters(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/data/checksum", r.URL.Path)
+		assert.Equal(t, "status=active&tag=a", r.URL.RawQuery)
+		w.Write([]byte(`{"algorithm":"sha256","checksum":"abc","rows":2}`))
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, testOptions())
+	require.NoError(t, err)
+	sum, err := c.Checksum(context.Background(), ListOptions{Tag: "a", Status: "active", Fields: []string{"id"}})
+	require.NoError(t, err)
+	assert.Equal(t, &types.DataChecksum{Algorithm: "sha256", Checksum: "abc", Rows: 2}, sum)
+}
+
+func TestClockOffset(t *testing.T) {
+	ahead := 90 * time.Second
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/time", r.URL.Path)
+		json.NewEncoder(w).Encode(types.ServerTime{Time: time.Now().Add(ahead)})
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, testOptions())
+	require.NoError(t, err)
+	offset, err := c.ClockOffset(context.Background())
+	require.NoError(t, err)
+	assert.InDelta(t, ahead, offset, float64(time.Second))
+}
@@ -0,0 +1,345 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// streamClient returns a client for long-lived responses: it shares the
+// transport, but not the timeout meant for whole ordinary requests.
+func (c *Client) streamClient() *http.Client {
+	return &http.Client{Transport: c.hc.Transport}
+}
+
+// ListDataPager reads a listing in pages of a fixed size. It streams the
+// listing with ?stream=ndjson, so the app never renders it whole and the
+// first page is available while the rest is still being read.
+//
+//	pager := c.ListDataPager(ctx, client.ListOptions{}, 500)
+//	defer pager.Close()
+//	for pager.Next() {
+//		process(pager.Page())
+//	}
+//	err := pager.Err()
+type ListDataPager struct {
+	open func() (io.ReadCloser, error)
+	size int
+
+	body    io.ReadCloser
+	dec     *json.Decoder
+	page    []types.TestData
+	err     error
+	started bool
+	done    bool
+}
+
+// ListDataPager returns a pager over the rows matching opts. Fields work
+// as with ListData; Include is not supported when streaming.
+func (c *Client) ListDataPager(ctx context.Context, opts ListOptions, size int) *ListDataPager {
+	if size < 1 {
+		size = 100
+	}
+	return &ListDataPager{size: size, open: func() (io.ReadCloser, error) {
+		query := opts.query()
+		query.Set("stream", "ndjson")
+		req, err := c.request(ctx, "GET", "/api/data", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.streamClient().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 400 {
+			defer resp.Body.Close()
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+			return nil, &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+		}
+		return resp.Body, nil
+	}}
+}
+
+// Next reads the next page, reporting false at the end of the listing or
+// on an error.
+func (p *ListDataPager) Next() bool {
+	if p.done {
+		return false
+	}
+	if !p.started {
+		p.started = true
+		if p.body, p.err = p.open(); p.err != nil {
+			p.done = true
+			return false
+		}
+		p.dec = json.NewDecoder(p.body)
+	}
+
+	p.page = make([]types.TestData, 0, p.size)
+	for len(p.page) < p.size {
+		var row types.TestData
+		err := p.dec.Decode(&row)
+		if err == io.EOF {
+			p.done = true
+			break
+		}
+		if err != nil {
+			// A listing that fails midway ends without its last line, so
+			// a truncated stream is an error rather than the end
+			p.err, p.done = fmt.Errorf("reading listing: %w", err), true
+			return false
+		}
+		p.page = append(p.page, row)
+	}
+	return len(p.page) > 0
+}
+
+// Page returns the page read by the last call to Next.
+func (p *ListDataPager) Page() []types.TestData {
+	return p.page
+}
+
+// Err returns the error that ended the listing, nil at its end.
+func (p *ListDataPager) Err() error {
+	return p.err
+}
+
+// Close releases the connection of a listing not read to its end.
+func (p *ListDataPager) Close() error {
+	p.done = true
+	if p.body != nil {
+		return p.body.Close()
+	}
+	return nil
+}
+
+// Event is a change event from /api/events. Besides the app's change
+// events, Type is "gap" when a reconnect could not replay every missed
+// event and "dropped" when the client read too slowly to receive them all.
+type Event struct {
+	ID   int64
+	Type string
+	Data json.RawMessage
+}
+
+// SubscribeOptions configures SubscribeEvents.
+type SubscribeOptions struct {
+	// LastEventID resumes after an event seen before, -1 to start with new
+	// events.
+	LastEventID int64
+	// MinBackoff and MaxBackoff bound the jittered exponential delay
+	// between reconnects, 100ms and 10s when zero.
+	MinBackoff, MaxBackoff time.Duration
+}
+
+// EventSubscription reads the event feed, reconnecting whenever the
+// connection is lost or closed by the app, and resuming after the last
+// event received so none are skipped. It gives up on responses that
+// another attempt would not change, such as 401 or 404.
+//
+//	sub := c.SubscribeEvents(ctx, client.SubscribeOptions{LastEventID: -1})
+//	defer sub.Close()
+//	for sub.Next() {
+//		handle(sub.Event())
+//	}
+//	err := sub.Err()
+type EventSubscription struct {
+	ctx  context.Context
+	c    *Client
+	opts SubscribeOptions
+
+	body     io.ReadCloser
+	lines    *bufio.Reader
+	event    Event
+	err      error
+	attempts int
+	closed   bool
+}
+
+// SubscribeEvents subscribes to the event feed until ctx is done.
+func (c *Client) SubscribeEvents(ctx context.Context, opts SubscribeOptions) *EventSubscription {
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = 100 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 10 * time.Second
+	}
+	return &EventSubscription{ctx: ctx, c: c, opts: opts}
+}
+
+// Next waits for the next event, reporting false once the subscription
+// ends.
+func (s *EventSubscription) Next() bool {
+	for !s.closed {
+		if s.body == nil {
+			if err := s.connect(); err != nil {
+				if s.ctx.Err() != nil || StatusCode(err) != 0 && !retryableStatus(StatusCode(err)) {
+					s.err = err
+					s.Close()
+					return false
+				}
+				if !s.backoff() {
+					return false
+				}
+				continue
+			}
+		}
+
+		e, err := readSSE(s.lines)
+		if err != nil {
+			s.body.Close()
+			s.body = nil
+			if s.ctx.Err() != nil {
+				s.err = s.ctx.Err()
+				s.Close()
+				return false
+			}
+			if !s.backoff() {
+				return false
+			}
+			continue
+		}
+		s.attempts = 0
+		if e.Type == "disconnect" {
+			// The app ended the connection, e.g. as idle; pick up where
+			// it left off
+			s.body.Close()
+			s.body = nil
+			continue
+		}
+		if e.ID > 0 {
+			s.opts.LastEventID = e.ID
+		}
+		s.event = e
+		return true
+	}
+	return false
+}
+
+func (s *EventSubscription) connect() error {
+	req, err := s.c.request(s.ctx, "GET", "/api/events", nil, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if s.opts.LastEventID >= 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatInt(s.opts.LastEventID, 10))
+	}
+	resp, err := s.c.streamClient().Do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	s.body = resp.Body
+	s.lines = bufio.NewReader(resp.Body)
+	return nil
+}
+
+// backoff waits before the next reconnect, reporting false if the
+// subscription ended meanwhile.
+func (s *EventSubscription) backoff() bool {
+	d := s.opts.MinBackoff << min(s.attempts, 16)
+	if d <= 0 || d > s.opts.MaxBackoff {
+		d = s.opts.MaxBackoff
+	}
+	s.attempts++
+	d = d/2 + rand.N(d/2+1)
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-s.ctx.Done():
+		s.err = s.ctx.Err()
+		s.Close()
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// Event returns the event read by the last call to Next.
+func (s *EventSubscription) Event() Event {
+	return s.event
+}
+
+// LastEventID returns the ID to resume after, -1 if no event was seen.
+func (s *EventSubscription) LastEventID() int64 {
+	return s.opts.LastEventID
+}
+
+// Err returns the error that ended the subscription.
+func (s *EventSubscription) Err() error {
+	return s.err
+}
+
+// Close ends the subscription.
+func (s *EventSubscription) Close() error {
+	s.closed = true
+	if s.body != nil {
+		err := s.body.Close()
+		s.body = nil
+		return err
+	}
+	return nil
+}
+
+// retryableStatus reports whether a failed connection attempt may succeed
+// when repeated.
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// readSSE reads the next event of a server-sent event stream, skipping
+// comments such as heartbeats.
+func readSSE(lines *bufio.Reader) (Event, error) {
+	var e Event
+	var data []string
+	for {
+		line, err := lines.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return Event{}, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if data == nil && e.Type == "" {
+				continue
+			}
+			if e.Type == "" {
+				e.Type = "message"
+			}
+			e.Data = json.RawMessage(strings.Join(data, "\n"))
+			return e, nil
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				e.ID = n
+			}
+		case "event":
+			e.Type = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+}
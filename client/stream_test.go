@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListDataPager(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "ndjson", r.URL.Query().Get("stream"))
+		assert.Equal(t, "smoke", r.URL.Query().Get("tag"))
+		for i := 1; i <= 5; i++ {
+			fmt.Fprintf(w, "{\"id\":%d,\"name\":\"n%d\"}\n", i, i)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, testOptions())
+	require.NoError(t, err)
+	pager := c.ListDataPager(context.Background(), ListOptions{Tag: "smoke"}, 2)
+	defer pager.Close()
+
+	var sizes []int
+	var ids []int
+	for pager.Next() {
+		sizes = append(sizes, len(pager.Page()))
+		for _, row := range pager.Page() {
+			ids = append(ids, row.ID)
+		}
+	}
+	require.NoError(t, pager.Err())
+	assert.Equal(t, []int{2, 2, 1}, sizes)
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, ids)
+}
+
+func TestListDataPagerReportsTruncatedListing(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "{\"id\":1}\n{\"id\":")
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, testOptions())
+	require.NoError(t, err)
+	pager := c.ListDataPager(context.Background(), ListOptions{}, 10)
+	defer pager.Close()
+
+	assert.False(t, pager.Next())
+	assert.Error(t, pager.Err())
+}
+
+func TestSubscribeEventsResumesAfterReconnect(t *testing.T) {
+	var conns atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		switch conns.Add(1) {
+		case 1:
+			assert.Empty(t, r.Header.Get("Last-Event-ID"))
+			fmt.Fprint(w, "id: 1\nevent: data.created\ndata: {\"id\":1}\n\n")
+			fmt.Fprint(w, "id: 2\nevent: data.created\ndata: {\"id\":2}\n\n")
+			// The connection drops
+		case 2:
+			assert.Equal(t, "2", r.Header.Get("Last-Event-ID"))
+			fmt.Fprint(w, ": heartbeat\n\n")
+			fmt.Fprint(w, "event: gap\ndata: {\"last_event_id\":2,\"missed\":3}\n\n")
+			fmt.Fprint(w, "id: 6\nevent: comment.created\ndata: {\"id\":6}\n\n")
+			fmt.Fprint(w, "event: disconnect\ndata: {\"reason\":\"idle\"}\n\n")
+		default:
+			assert.Equal(t, "6", r.Header.Get("Last-Event-ID"))
+			fmt.Fprint(w, "id: 7\nevent: comment.deleted\ndata: {\"id\":7}\n\n")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, testOptions())
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	sub := c.SubscribeEvents(ctx, SubscribeOptions{LastEventID: -1, MinBackoff: time.Millisecond})
+	defer sub.Close()
+
+	var got []string
+	for len(got) < 5 && sub.Next() {
+		e := sub.Event()
+		got = append(got, fmt.Sprintf("%d %s %s", e.ID, e.Type, e.Data))
+	}
+	require.NoError(t, sub.Err())
+	assert.Equal(t, []string{
+		`1 data.created {"id":1}`,
+		`2 data.created {"id":2}`,
+		`0 gap {"last_event_id":2,"missed":3}`,
+		`6 comment.created {"id":6}`,
+		`7 comment.deleted {"id":7}`,
+	}, got)
+	assert.Equal(t, int64(7), sub.LastEventID())
+}
+
+func TestSubscribeEventsStopsOnClientErrors(t *testing.T) {
+	var conns atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conns.Add(1)
+		http.Error(w, "Event feed is disabled", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, testOptions())
+	require.NoError(t, err)
+	sub := c.SubscribeEvents(context.Background(), SubscribeOptions{LastEventID: -1, MinBackoff: time.Millisecond})
+	defer sub.Close()
+
+	assert.False(t, sub.Next())
+	assert.Equal(t, http.StatusNotFound, StatusCode(sub.Err()))
+	assert.Equal(t, int32(1), conns.Load())
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nesymno/run-tests-example/config"
+	"github.com/nesymno/run-tests-example/generator"
+	"github.com/nesymno/run-tests-example/migrations"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// runCommand runs a CLI subcommand instead of the server.
+func runCommand(name string, args []string) error {
+	switch name {
+	case "seed":
+		return runSeed(args)
+	case "waitfor":
+		return runWaitFor(args)
+	case "config":
+		return runConfig(args)
+	case "migrate":
+		return runMigrate(args)
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
+
+// runSeed loads a deterministic dataset: app seed -profile=medium -seed=42
+func runSeed(args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	profileName := fs.String("profile", "small", "dataset profile: "+strings.Join(generator.ProfileNames(), ", "))
+	seed := fs.Uint64("seed", 1, "random seed; the same seed always produces the same rows")
+	fs.Parse(args)
+
+	profile, err := generator.Lookup(*profileName)
+	if err != nil {
+		return err
+	}
+
+	cfg, settings, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	// Unlike the server, seeding gives up if the dependencies do not
+	// answer soon
+	ctx, cancel := context.WithTimeout(context.Background(), attemptTimeout)
+	defer cancel()
+	a, err := initApp(ctx, cfg, settings)
+	if err != nil {
+		return err
+	}
+	defer a.DB.Close()
+	defer a.Rds.Close()
+
+	result, err := a.Seed(context.Background(), profile, *seed)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Seeded profile %s with seed %d: %d of %d rows inserted in %s (checksum %s)",
+		result.Profile, result.Seed, result.Inserted, result.Rows, result.Duration, result.Checksum)
+	return nil
+}
+
+// runConfig inspects the configuration: app config check [-json]
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return fmt.Errorf("usage: config check [-json]")
+	}
+
+	fs := flag.NewFlagSet("config check", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Parse(args[1:])
+
+	cfg, settings, err := config.Load()
+	if settings == nil {
+		return err
+	}
+	conflict := cfg.Check()
+	unknown := config.Unrecognized(os.Environ())
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(types.EnvReport{Settings: settings, Unrecognized: unknown})
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE\tSTATUS")
+		for _, s := range settings {
+			status := "ok"
+			if s.Error != "" {
+				status = "invalid: " + s.Error
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.Name, s.Value, s.Source, status)
+		}
+		tw.Flush()
+		for _, name := range unknown {
+			fmt.Printf("warning: %s is not a recognized setting\n", name)
+		}
+	}
+	if conflict != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", conflict)
+	}
+
+	if err != nil || conflict != nil {
+		return fmt.Errorf("configuration is invalid")
+	}
+	return nil
+}
+
+// runMigrate applies, reverts or lists the schema migrations:
+// app migrate up|down [-steps=1]|status
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down [-steps=1]|status")
+	}
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+	steps := fs.Int("steps", 1, "number of migrations down reverts")
+	fs.Parse(args[1:])
+
+	cfg, _, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open("postgres", cfg.Postgres.DSN())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Like seeding, migrating gives up if PostgreSQL does not answer soon
+	ctx, cancel := context.WithTimeout(context.Background(), attemptTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("postgres not ready: %v", err)
+	}
+
+	switch args[0] {
+	case "up":
+		if err := initDatabase(db); err != nil {
+			return err
+		}
+		log.Printf("Schema is at version %d", migrations.Latest())
+		return nil
+	case "down":
+		if *steps < 1 {
+			return fmt.Errorf("-steps must be at least 1")
+		}
+		reverted, err := migrations.Down(context.Background(), db, *steps)
+		for _, m := range reverted {
+			log.Printf("Reverted migration %s", m)
+		}
+		return err
+	case "status":
+		applied, err := migrations.Applied(context.Background(), db)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, m := range migrations.All() {
+			status := "pending"
+			if at, ok := applied[m.Version]; ok {
+				status = at.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", m.Version, m.Name, status)
+		}
+		tw.Flush()
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
@@ -0,0 +1,199 @@
+// Package config describes every setting the app reads from its
+// environment. The Config struct is the single list of settings: the env
+// var, default, description and validation rule of each are struct tags,
+// and the loader and the `config check` report are driven from them.
+//
+// A profile tag overrides the default under an APP_ENV profile, so what
+// each profile changes is listed here too: dev turns on the debug
+// endpoints, request logging and lenient decoding; prod requires an admin
+// token, sets server timeouts and rejects unknown JSON fields; test turns
+// on the reset endpoint.
+package config
+
+import (
+	"fmt"
+	"net"
+)
+
+// Config holds the settings read from the environment.
+type Config struct {
+	Env  string `env:"APP_ENV" validate:"oneof=|dev|test|prod" desc:"Profile whose defaults apply: dev, test or prod"`
+	Port string `env:"PORT" default:"8080" desc:"HTTP server port"`
+
+	Postgres PostgresConfig
+	Redis    RedisConfig
+
+	AdminToken        string `env:"ADMIN_TOKEN" secret:"true" desc:"Token required by the /admin endpoints; admin endpoints are disabled when empty"`
+	EncryptionKeys    string `env:"FIELD_ENCRYPTION_KEYS" secret:"true" desc:"Comma-separated id:base64 AES-256 keys encrypting test_data secrets, primary first"`
+	RequireAdminToken bool   `env:"REQUIRE_ADMIN_TOKEN" default:"false" profile:"prod=true" desc:"Refuse to start without ADMIN_TOKEN"`
+	APIKeyAuth        bool   `env:"API_KEY_AUTH" default:"false" desc:"Require an X-API-Key managed through /admin/apikeys on the /api routes"`
+	APIKeyCacheSecs   int    `env:"API_KEY_CACHE_SECONDS" default:"60" validate:"min=1" desc:"How long a validated API key is cached in Redis"`
+	TrustedProxies    string `env:"TRUSTED_PROXIES" desc:"Comma-separated CIDRs of proxies whose forwarding headers are believed"`
+	DebugRequest      bool   `env:"DEBUG_REQUEST" default:"false" profile:"dev=true" desc:"Enable the /debug/request echo endpoint"`
+	SerializeRequests bool   `env:"SERIALIZE_REQUESTS" default:"false" desc:"Run requests on the same test_data row one at a time, for deterministic tests; refused under the prod profile"`
+	EnableReset       bool   `env:"ENABLE_RESET" default:"false" profile:"test=true" desc:"Enable POST /test/reset, which deletes all data"`
+	LogLevel          string `env:"LOG_LEVEL" default:"info" validate:"oneof=debug|info|warn|error" desc:"Initial log level; /admin/loglevel and SIGUSR1/SIGUSR2 change it at runtime"`
+	Release           string `env:"APP_RELEASE" desc:"Identifies the deployed build; a log level set at runtime is dropped when it changes. Defaults to a hash of the executable"`
+	LogRequests       bool   `env:"LOG_REQUESTS" default:"false" profile:"dev=true" desc:"Log every HTTP request"`
+	RedactPatterns    string `env:"REDACT_PATTERNS" default:"email,bearer,jwt,api_key" desc:"Comma-separated built-in pattern names or regular expressions masked in logs"`
+	RedactParams      string `env:"REDACT_PARAMS" default:"token,access_token,refresh_token,id_token,password,secret,api_key,key,code" desc:"Comma-separated query parameters whose values are masked in logged URLs"`
+	StrictJSON        bool   `env:"STRICT_JSON" default:"false" profile:"prod=true" desc:"Reject request bodies with unknown JSON fields"`
+	MethodOverride    bool   `env:"METHOD_OVERRIDE" default:"false" desc:"Serve POST requests as the PUT, PATCH or DELETE named by X-HTTP-Method-Override"`
+	ServerTiming      bool   `env:"SERVER_TIMING" default:"false" desc:"Add a Server-Timing header telling the time of each request in PostgreSQL, Redis and the app"`
+
+	PathTrailingSlash   string `env:"PATH_TRAILING_SLASH" default:"keep" validate:"oneof=keep|redirect|rewrite" desc:"Whether a trailing slash is kept, redirected away with 308 or dropped before routing"`
+	PathCollapseSlashes bool   `env:"PATH_COLLAPSE_SLASHES" default:"false" desc:"Route repeated slashes in paths as one instead of redirecting"`
+	PathCase            string `env:"PATH_CASE" default:"sensitive" validate:"oneof=sensitive|insensitive" desc:"Whether paths matching no route are retried in lower case"`
+
+	HTTPReadTimeoutSeconds  int `env:"HTTP_READ_TIMEOUT_SECONDS" default:"0" validate:"min=0" profile:"prod=10" desc:"Time allowed to read a request, 0 for no limit"`
+	HTTPWriteTimeoutSeconds int `env:"HTTP_WRITE_TIMEOUT_SECONDS" default:"0" validate:"min=0" profile:"prod=30" desc:"Time allowed to write a response, 0 for no limit"`
+	HTTPIdleTimeoutSeconds  int `env:"HTTP_IDLE_TIMEOUT_SECONDS" default:"0" validate:"min=0" profile:"prod=120" desc:"Time an idle keep-alive connection is kept, 0 for no limit"`
+	ShutdownTimeoutSeconds  int `env:"SHUTDOWN_TIMEOUT_SECONDS" default:"25" validate:"min=1" desc:"Time requests in flight are given to finish on SIGTERM or SIGINT"`
+
+	PoolMinIdleConns     int `env:"POOL_MIN_IDLE_CONNS" default:"0" validate:"min=0" desc:"Connections to PostgreSQL and Redis kept established and pinged through idle periods, 0 to disable keepalives"`
+	PoolKeepaliveSeconds int `env:"POOL_KEEPALIVE_SECONDS" default:"30" validate:"min=1" desc:"Interval between keepalive pings of the idle PostgreSQL and Redis connections"`
+
+	HealthCacheMS int `env:"HEALTH_CACHE_MS" default:"2000" validate:"min=0" desc:"How long the dependency checks of /health and /readyz answer probes before being run again, 0 to run them on every probe"`
+
+	ConnectivityTargets string `env:"CONNECTIVITY_TARGETS" desc:"Extra name=host:port dependencies checked by /debug/connectivity"`
+
+	WorkerConcurrency int `env:"WORKER_CONCURRENCY" default:"2" validate:"min=1" desc:"Number of background job workers"`
+	JobMaxAttempts    int `env:"JOB_MAX_ATTEMPTS" default:"5" validate:"min=1" desc:"Attempts before a failing job is dead-lettered"`
+	JobRetryBackoffMS int `env:"JOB_RETRY_BACKOFF_MS" default:"1000" validate:"min=1" desc:"Delay before the first job retry"`
+
+	QueueMaxDepth      int `env:"QUEUE_MAX_DEPTH" default:"0" validate:"min=0" desc:"Queued jobs or buffered writes past which /readyz fails, 0 for no limit"`
+	QueueMaxAgeSeconds int `env:"QUEUE_MAX_AGE_SECONDS" default:"0" validate:"min=0" desc:"Wait of the oldest runnable job past which /readyz fails, 0 for no limit"`
+
+	ConcurrencyLimitMax        int `env:"CONCURRENCY_LIMIT_MAX" default:"0" validate:"min=0" desc:"Most /api requests served at once while PostgreSQL and Redis are fast, 0 to disable adaptive concurrency limiting"`
+	ConcurrencyLimitMin        int `env:"CONCURRENCY_LIMIT_MIN" default:"4" validate:"min=1" desc:"Floor the adaptive concurrency limit never drops below"`
+	ConcurrencyLatencyTargetMS int `env:"CONCURRENCY_LATENCY_TARGET_MS" default:"50" validate:"min=1" desc:"Dependency ping latency past which the concurrency limit is lowered"`
+	ConcurrencyProbeIntervalMS int `env:"CONCURRENCY_PROBE_INTERVAL_MS" default:"1000" validate:"min=1" desc:"Interval between dependency pings adapting the concurrency limit"`
+
+	SLOs             string `env:"SLOS" desc:"Comma-separated route:availability:percent and route:latency:duration:percent objectives reported by /debug/slo"`
+	SLOWindowMinutes int    `env:"SLO_WINDOW_MINUTES" default:"60" validate:"min=1" desc:"Sliding window over which SLO compliance and error budgets are computed"`
+
+	SnapshotDir             string `env:"SNAPSHOT_DIR" desc:"Directory receiving periodic /debug/snapshot lines, empty to disable"`
+	SnapshotIntervalSeconds int    `env:"SNAPSHOT_INTERVAL_SECONDS" default:"60" validate:"min=1" desc:"Interval between periodic snapshots"`
+
+	CoverDir string `env:"GOCOVERDIR" desc:"Directory a binary built with -cover writes coverage data to, also by POST /admin/coverage/flush"`
+
+	JSONNaming string `env:"JSON_NAMING" default:"snake_case" validate:"oneof=snake_case|camelCase" desc:"Field naming of JSON responses; X-JSON-Naming overrides it per request"`
+	JSONNulls  string `env:"JSON_NULLS" default:"keep" validate:"oneof=keep|omit" desc:"Whether null fields are kept in or omitted from JSON responses; X-JSON-Nulls overrides it per request"`
+
+	EventsEnabled    bool   `env:"EVENTS_ENABLED" default:"true" desc:"Serve the /api/events change feed"`
+	EventsBuffer     int    `env:"EVENTS_BUFFER" default:"64" validate:"min=1" desc:"Events buffered per feed connection before the slow consumer policy applies"`
+	EventsSlowPolicy string `env:"EVENTS_SLOW_POLICY" default:"drop-oldest" validate:"oneof=drop-oldest|disconnect" desc:"What a full feed connection buffer does: drop its oldest event or disconnect the client"`
+
+	EventsMaxConnections     int `env:"EVENTS_MAX_CONNECTIONS" default:"0" validate:"min=0" desc:"Feed connections per replica past which /api/events returns 503, 0 for no limit"`
+	EventsHeartbeatSeconds   int `env:"EVENTS_HEARTBEAT_SECONDS" default:"15" validate:"min=0" desc:"Interval of keepalive frames on feed connections, 0 for none"`
+	EventsHistory            int `env:"EVENTS_HISTORY" default:"1000" validate:"min=1" desc:"Recent events kept in Redis for reconnecting feed clients to resume from"`
+	EventsIdleTimeoutSeconds int `env:"EVENTS_IDLE_TIMEOUT_SECONDS" default:"0" validate:"min=0" desc:"Time after which a feed connection without events is closed, 0 to keep it open"`
+
+	Cache       CacheConfig
+	HTTPClient  HTTPClientConfig
+	ServiceAuth ServiceAuthConfig
+
+	WriteBehind          bool `env:"WRITE_BEHIND" default:"false" desc:"Buffer POST /api/data writes in Redis and insert them in batches"`
+	BatchFlushIntervalMS int  `env:"BATCH_FLUSH_INTERVAL_MS" default:"500" validate:"min=1" desc:"Write-behind flush interval"`
+	BatchMaxItems        int  `env:"BATCH_MAX_ITEMS" default:"100" validate:"min=1" desc:"Write-behind batch size that triggers an early flush"`
+
+	Retention RetentionConfig
+
+	PartitionTestData bool `env:"PARTITION_TEST_DATA" default:"false" desc:"Partition test_data by month of created_at"`
+	ReusePort         bool `env:"REUSE_PORT" default:"false" desc:"Bind the listening socket with SO_REUSEPORT"`
+
+	GCPercent       string `env:"GC_PERCENT" validate:"int" desc:"GC target percentage, like GOGC"`
+	MemoryLimitMB   string `env:"MEMORY_LIMIT_MB" validate:"int,min=1" desc:"Soft memory limit in MiB, like GOMEMLIMIT"`
+	MemoryBallastMB string `env:"MEMORY_BALLAST_MB" validate:"int,min=0" desc:"Size of the heap ballast in MiB"`
+
+	ClockSkewMS int `env:"CLOCK_SKEW_MS" default:"60000" validate:"min=0" desc:"Clock difference tolerated with clients and dependencies, for token and expiry checks"`
+
+	MigrateOnStart bool   `env:"MIGRATE_ON_START" default:"true" desc:"Apply pending migrations at startup; when false, app migrate up applies them and the schema check decides whether a replica starts"`
+	SchemaCompat   string `env:"SCHEMA_COMPAT" default:"strict" validate:"oneof=strict|forward|off" desc:"Schema version check mode"`
+	SchemaMismatch string `env:"SCHEMA_MISMATCH" default:"fail" validate:"oneof=fail|readonly" desc:"What to do when the schema check fails"`
+}
+
+type PostgresConfig struct {
+	Host     string `env:"POSTGRES_HOST" default:"postgres" desc:"PostgreSQL host"`
+	Port     string `env:"POSTGRES_PORT" default:"5432" validate:"int,min=1" desc:"PostgreSQL port"`
+	User     string `env:"POSTGRES_USER" default:"postgres" desc:"PostgreSQL user"`
+	Password string `env:"POSTGRES_PASSWORD" default:"postgres" secret:"true" desc:"PostgreSQL password"`
+	DB       string `env:"POSTGRES_DB" default:"testdb" desc:"PostgreSQL database"`
+}
+
+type RedisConfig struct {
+	Host string `env:"REDIS_HOST" default:"redis" desc:"Redis host"`
+	Port string `env:"REDIS_PORT" default:"6379" validate:"int,min=1" desc:"Redis port"`
+}
+
+// DSN returns the lib/pq connection string for the database.
+func (c PostgresConfig) DSN() string {
+	// Timestamps are stored without a time zone, so the session is pinned
+	// to UTC for CURRENT_TIMESTAMP defaults to be UTC as well
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable timezone=UTC",
+		c.Host, c.Port, c.User, c.Password, c.DB)
+}
+
+// Addr returns the host:port of the database.
+func (c PostgresConfig) Addr() string {
+	return net.JoinHostPort(c.Host, c.Port)
+}
+
+// Addr returns the host:port of Redis.
+func (c RedisConfig) Addr() string {
+	return net.JoinHostPort(c.Host, c.Port)
+}
+
+type CacheConfig struct {
+	Backend            string `env:"CACHE_BACKEND" default:"redis" validate:"oneof=redis|memcached" desc:"Where the list cache is kept: redis, or memcached at MEMCACHED_SERVERS"`
+	MemcachedServers   string `env:"MEMCACHED_SERVERS" desc:"Comma-separated host:port of the memcached servers holding the list cache"`
+	MemcachedTimeoutMS int    `env:"MEMCACHED_TIMEOUT_MS" default:"500" validate:"min=1" desc:"Time allowed for each memcached command"`
+	Strategy           string `env:"CACHE_STRATEGY" default:"invalidate" validate:"oneof=invalidate|write-through" desc:"How writes update the list cache"`
+	ChunkBytes         int    `env:"CACHE_CHUNK_BYTES" default:"524288" validate:"min=1" desc:"Largest cached list stored under a single cache key"`
+	Compression        string `env:"CACHE_COMPRESSION" validate:"oneof=|gzip" desc:"Codec for cached lists, empty for none"`
+	CompressMinBytes   int    `env:"CACHE_COMPRESS_MIN_BYTES" default:"1024" validate:"min=1" desc:"Smallest cached list worth compressing"`
+	DualRead           bool   `env:"CACHE_DUAL_READ" default:"false" desc:"Migrate the list cache to CACHE_NEW_REDIS_ADDR"`
+	NewRedisAddr       string `env:"CACHE_NEW_REDIS_ADDR" desc:"host:port of the Redis the list cache is migrating to"`
+	NewRedisPassword   string `env:"CACHE_NEW_REDIS_PASSWORD" secret:"true" desc:"Password for the Redis the list cache is migrating to"`
+
+	VerifyIntervalSeconds int  `env:"CACHE_VERIFY_INTERVAL_SECONDS" default:"0" validate:"min=0" desc:"How often sampled rows are compared with the cached list, 0 to disable"`
+	VerifySample          int  `env:"CACHE_VERIFY_SAMPLE" default:"20" validate:"min=1" desc:"Rows sampled from PostgreSQL, and again from the cached list, per cache verification"`
+	VerifyHeal            bool `env:"CACHE_VERIFY_HEAL" default:"false" desc:"Refresh the list cache when a cache verification finds a divergence"`
+
+	InvalidateDebounceMS int `env:"CACHE_INVALIDATE_DEBOUNCE_MS" default:"0" validate:"min=0" desc:"Window in which list cache invalidations are folded into one, 0 to invalidate on every write"`
+}
+
+type HTTPClientConfig struct {
+	TimeoutMS           int    `env:"HTTP_CLIENT_TIMEOUT_MS" default:"10000" validate:"min=1" desc:"Time allowed for a whole outbound HTTP request"`
+	MaxIdleConnsPerHost int    `env:"HTTP_CLIENT_MAX_IDLE_CONNS_PER_HOST" default:"16" validate:"min=1" desc:"Keep-alive connections kept per downstream host"`
+	MaxConnsPerHost     int    `env:"HTTP_CLIENT_MAX_CONNS_PER_HOST" default:"0" validate:"min=0" desc:"Connections allowed per downstream host, 0 for no limit"`
+	BreakerThreshold    int    `env:"HTTP_CLIENT_BREAKER_THRESHOLD" default:"5" validate:"min=1" desc:"Consecutive failures that open a downstream host's circuit"`
+	BreakerCooldownMS   int    `env:"HTTP_CLIENT_BREAKER_COOLDOWN_MS" default:"30000" validate:"min=1" desc:"How long an open circuit fails fast before a trial request"`
+	CAFile              string `env:"HTTP_CLIENT_CA_FILE" desc:"PEM file of CAs trusted for outbound TLS instead of the system roots"`
+	MaxRetries          int    `env:"HTTP_CLIENT_MAX_RETRIES" default:"2" validate:"min=0" desc:"Retries of a failed idempotent outbound request, 0 to disable"`
+	RetryBackoffMS      int    `env:"HTTP_CLIENT_RETRY_BACKOFF_MS" default:"100" validate:"min=1" desc:"Base delay between outbound retries"`
+	RetryBudgetPercent  int    `env:"HTTP_CLIENT_RETRY_BUDGET_PERCENT" default:"10" validate:"min=1" desc:"Retries allowed per downstream host as a percentage of its requests"`
+	RetryBudgetBurst    int    `env:"HTTP_CLIENT_RETRY_BUDGET_BURST" default:"10" validate:"min=1" desc:"Retries a downstream host's budget can save up"`
+	Allow               string `env:"HTTP_CLIENT_ALLOW" desc:"Comma-separated IPs, CIDRs and host names outbound connections are limited to, empty for any"`
+	Deny                string `env:"HTTP_CLIENT_DENY" default:"127.0.0.0/8,::1/128,169.254.0.0/16,fe80::/10,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,fc00::/7,0.0.0.0/8,::/128" desc:"Comma-separated IPs, CIDRs and host names outbound connections may never reach"`
+}
+
+type ServiceAuthConfig struct {
+	Mode            string `env:"SA_TOKEN_AUTH" default:"off" validate:"oneof=off|jwks|tokenreview" desc:"How /api routes verify Kubernetes service account tokens: off, jwks or tokenreview"`
+	Issuer          string `env:"SA_TOKEN_ISSUER" desc:"Issuer service account tokens must have in jwks mode, empty for any"`
+	Audience        string `env:"SA_TOKEN_AUDIENCE" desc:"Audience service account tokens must have been issued for, empty for any"`
+	JWKSFile        string `env:"SA_TOKEN_JWKS_FILE" desc:"JWKS file to verify tokens with in jwks mode instead of fetching the API server's"`
+	APIServer       string `env:"SA_TOKEN_API_SERVER" default:"https://kubernetes.default.svc" desc:"Kubernetes API server for JWKS fetches and token reviews"`
+	CAFile          string `env:"SA_TOKEN_CA_FILE" default:"/var/run/secrets/kubernetes.io/serviceaccount/ca.crt" desc:"PEM file of CAs trusted for the API server"`
+	CredentialsFile string `env:"SA_TOKEN_CREDENTIALS_FILE" default:"/var/run/secrets/kubernetes.io/serviceaccount/token" desc:"Token the app authenticates to the API server with"`
+	CacheSeconds    int    `env:"SA_TOKEN_CACHE_SECONDS" default:"60" validate:"min=1" desc:"How long a token review verdict is cached"`
+	Allowed         string `env:"SA_TOKEN_ALLOWED" desc:"Comma-separated namespace/name service accounts allowed, namespace/* for a whole namespace, empty for any"`
+}
+
+type RetentionConfig struct {
+	Days            int  `env:"RETENTION_DAYS" default:"0" validate:"min=0" desc:"Delete test_data rows older than this many days, 0 to disable"`
+	BatchSize       int  `env:"RETENTION_BATCH_SIZE" default:"1000" validate:"min=1" desc:"Rows deleted per statement by the retention task"`
+	MaxBatches      int  `env:"RETENTION_MAX_BATCHES" default:"100" validate:"min=1" desc:"Maximum batches deleted per retention run"`
+	IntervalSeconds int  `env:"RETENTION_INTERVAL_SECONDS" default:"3600" validate:"min=1" desc:"How often the retention task runs"`
+	Archive         bool `env:"RETENTION_ARCHIVE" default:"false" desc:"Move expired rows to test_data_archive instead of deleting them"`
+}
@@ -0,0 +1,338 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FileEnv names an optional file of KEY=VALUE lines. Settings missing from
+// the environment are read from it before falling back to defaults.
+const FileEnv = "CONFIG_FILE"
+
+// ProfileEnv names the active profile, which replaces the defaults of the
+// settings with a profile tag.
+const ProfileEnv = "APP_ENV"
+
+// Profiles are the values ProfileEnv may take; empty selects none.
+const (
+	ProfileDev  = "dev"
+	ProfileTest = "test"
+	ProfileProd = "prod"
+)
+
+// Where a setting's value came from.
+const (
+	SourceEnv     = "env"
+	SourceFile    = "file"
+	SourceProfile = "profile"
+	SourceDefault = "default"
+	SourceUnset   = "unset"
+)
+
+// Setting reports one setting: its value, where the value came from and
+// whether it is valid. Secret values are redacted.
+type Setting struct {
+	Name        string `json:"name"`
+	Value       string `json:"value"`
+	Default     string `json:"default,omitempty"`
+	Source      string `json:"source"`
+	Description string `json:"description"`
+	Secret      bool   `json:"secret,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// fromFile and fromProfile record the variables Apply copied from
+// CONFIG_FILE and the active profile, so they are still reported with
+// those as their source.
+var (
+	fromFile    = map[string]bool{}
+	fromProfile = map[string]bool{}
+)
+
+// Apply copies the values in CONFIG_FILE, then the defaults of the active
+// profile, into the process environment, unless already set there, so code
+// reading the environment directly sees them too. It must run before
+// anything reads the environment.
+func Apply() error {
+	file, err := readFile(os.Getenv(FileEnv))
+	if err != nil {
+		return err
+	}
+	for name, value := range file {
+		if os.Getenv(name) == "" {
+			os.Setenv(name, value)
+			fromFile[name] = true
+		}
+	}
+
+	profile := os.Getenv(ProfileEnv)
+	walk(reflect.ValueOf(&Config{}).Elem(), func(field reflect.StructField, _ reflect.Value) {
+		name := field.Tag.Get("env")
+		if value, ok := profileDefault(field, profile); ok && os.Getenv(name) == "" {
+			os.Setenv(name, value)
+			fromProfile[name] = true
+		}
+	})
+	return nil
+}
+
+// Profile returns the active profile, empty if none is selected.
+func Profile() string {
+	return os.Getenv(ProfileEnv)
+}
+
+// Load reads every setting from the environment, then CONFIG_FILE, then
+// the active profile's default, then its default. It returns the
+// configuration, a report of every setting and an error listing the
+// invalid ones; invalid settings are left at their defaults. Settings that
+// conflict with each other are reported by Check.
+func Load() (*Config, []Setting, error) {
+	file, err := readFile(os.Getenv(FileEnv))
+	if err != nil {
+		return nil, nil, err
+	}
+	return load(os.LookupEnv, file)
+}
+
+func load(lookupEnv func(string) (string, bool), file map[string]string) (*Config, []Setting, error) {
+	var c Config
+	var settings []Setting
+	var errs []error
+
+	profile, _ := lookupEnv(ProfileEnv)
+	if profile == "" {
+		profile = file[ProfileEnv]
+	}
+
+	walk(reflect.ValueOf(&c).Elem(), func(field reflect.StructField, v reflect.Value) {
+		s := Setting{
+			Name:        field.Tag.Get("env"),
+			Default:     field.Tag.Get("default"),
+			Description: field.Tag.Get("desc"),
+			Secret:      field.Tag.Get("secret") == "true",
+			Source:      SourceUnset,
+		}
+		if value, ok := profileDefault(field, profile); ok {
+			s.Default = value
+		}
+
+		raw := ""
+		if value, _ := lookupEnv(s.Name); value != "" {
+			raw, s.Source = value, SourceEnv
+			if fromFile[s.Name] {
+				s.Source = SourceFile
+			} else if fromProfile[s.Name] {
+				s.Source = SourceProfile
+			}
+		} else if value := file[s.Name]; value != "" {
+			raw, s.Source = value, SourceFile
+		} else if value, ok := profileDefault(field, profile); ok {
+			raw, s.Source = value, SourceProfile
+		} else if s.Default != "" {
+			raw, s.Source = s.Default, SourceDefault
+		}
+
+		err := validate(raw, field.Tag.Get("validate"))
+		if err == nil {
+			err = set(v, raw)
+		}
+		if err != nil {
+			s.Error = err.Error()
+			errs = append(errs, fmt.Errorf("invalid %s %q: %v", s.Name, raw, err))
+			set(v, s.Default)
+		}
+
+		s.Value = raw
+		if s.Secret && raw != "" {
+			s.Value = "[redacted]"
+		}
+		settings = append(settings, s)
+	})
+
+	return &c, settings, errors.Join(errs...)
+}
+
+// Check reports the settings that are valid alone but not together. Load
+// leaves them to the caller, as `config check` reports each setting on
+// its own.
+func (c *Config) Check() error {
+	var errs []error
+	if c.RequireAdminToken && c.AdminToken == "" {
+		errs = append(errs, errors.New("REQUIRE_ADMIN_TOKEN is set but ADMIN_TOKEN is empty"))
+	}
+	if c.SerializeRequests && c.Env == ProfileProd {
+		errs = append(errs, errors.New("SERIALIZE_REQUESTS is for tests and not allowed under the prod profile"))
+	}
+	if c.Cache.DualRead && c.Cache.NewRedisAddr == "" {
+		errs = append(errs, errors.New("CACHE_DUAL_READ requires CACHE_NEW_REDIS_ADDR"))
+	}
+	if c.Cache.Backend == "memcached" && c.Cache.MemcachedServers == "" {
+		errs = append(errs, errors.New("CACHE_BACKEND=memcached requires MEMCACHED_SERVERS"))
+	}
+	return errors.Join(errs...)
+}
+
+// walk calls fn for every field with an env tag, descending into nested
+// structs.
+func walk(v reflect.Value, fn func(reflect.StructField, reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Type.Kind() == reflect.Struct && field.Tag.Get("env") == "" {
+			walk(v.Field(i), fn)
+			continue
+		}
+		if field.Tag.Get("env") != "" {
+			fn(field, v.Field(i))
+		}
+	}
+}
+
+// profileDefault returns the default a field's profile tag gives it under
+// profile. The tag is a comma-separated list of profile=value pairs.
+func profileDefault(field reflect.StructField, profile string) (string, bool) {
+	if profile == "" {
+		return "", false
+	}
+	for _, pair := range strings.Split(field.Tag.Get("profile"), ",") {
+		if name, value, ok := strings.Cut(pair, "="); ok && name == profile {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// set parses raw into v according to its kind. An empty raw leaves v at
+// its zero value.
+func set(v reflect.Value, raw string) error {
+	if raw == "" {
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return errors.New("not an integer")
+		}
+		v.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return errors.New("not a boolean")
+		}
+		v.SetBool(b)
+	default:
+		panic(fmt.Sprintf("config: unsupported field kind %s", v.Kind()))
+	}
+	return nil
+}
+
+// validate checks raw against a comma-separated list of rules: int,
+// min=N and oneof=a|b. Empty values only have to pass oneof.
+func validate(raw, rules string) error {
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg, _ := strings.Cut(rule, "=")
+		switch name {
+		case "":
+		case "int":
+			if _, err := strconv.Atoi(raw); raw != "" && err != nil {
+				return errors.New("not an integer")
+			}
+		case "min":
+			n, err := strconv.Atoi(raw)
+			limit, _ := strconv.Atoi(arg)
+			if raw != "" && err == nil && n < limit {
+				return fmt.Errorf("must be at least %d", limit)
+			}
+		case "oneof":
+			options := strings.Split(arg, "|")
+			if !contains(options, raw) {
+				return fmt.Errorf("must be one of %q", options)
+			}
+		default:
+			panic(fmt.Sprintf("config: unknown validation rule %q", rule))
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// readFile parses a file of KEY=VALUE lines. Blank lines and # comments
+// are skipped, and an "export " prefix and quotes around values are
+// allowed, so shell env files work as they are. An empty path reads
+// nothing.
+func readFile(path string) (map[string]string, error) {
+	values := map[string]string{}
+	if path == "" {
+		return values, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", FileEnv, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(strings.TrimPrefix(text, "export "), "=")
+		if !ok {
+			return nil, fmt.Errorf("%s line %d: expected KEY=VALUE", path, line)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[strings.TrimSpace(name)] = value
+	}
+	return values, scanner.Err()
+}
+
+// Unrecognized returns the variables in environ that look like settings,
+// sharing the first word of a known name, but are not one. These are
+// usually typos that would otherwise be ignored silently.
+func Unrecognized(environ []string) []string {
+	// APP_HOST and APP_PORT locate the app for the waitfor subcommand and
+	// the tests rather than configure it
+	known := map[string]bool{FileEnv: true, "APP_HOST": true, "APP_PORT": true}
+	prefixes := map[string]bool{}
+	walk(reflect.ValueOf(&Config{}).Elem(), func(field reflect.StructField, _ reflect.Value) {
+		name := field.Tag.Get("env")
+		known[name] = true
+		prefix, _, _ := strings.Cut(name, "_")
+		prefixes[prefix] = true
+	})
+
+	var unknown []string
+	for _, entry := range environ {
+		name, _, _ := strings.Cut(entry, "=")
+		prefix, _, _ := strings.Cut(name, "_")
+		if !known[name] && prefixes[prefix] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func lookup(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func setting(t *testing.T, settings []Setting, name string) Setting {
+	for _, s := range settings {
+		if s.Name == name {
+			return s
+		}
+	}
+	t.Fatalf("setting %s not reported", name)
+	return Setting{}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	c, settings, err := load(lookup(nil), nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "8080", c.Port)
+	assert.Equal(t, "postgres", c.Postgres.Host)
+	assert.Equal(t, 2, c.WorkerConcurrency)
+	assert.Equal(t, "invalidate", c.Cache.Strategy)
+	assert.False(t, c.WriteBehind)
+
+	assert.Equal(t, SourceDefault, setting(t, settings, "PORT").Source)
+	assert.Equal(t, SourceUnset, setting(t, settings, "ADMIN_TOKEN").Source)
+}
+
+func TestLoadPrecedenceAndRedaction(t *testing.T) {
+	env := map[string]string{"PORT": "9090", "ADMIN_TOKEN": "secret", "REDIS_HOST": ""}
+	file := map[string]string{"PORT": "7070", "REDIS_HOST": "cache.internal", "WRITE_BEHIND": "true"}
+	c, settings, err := load(lookup(env), file)
+	require.NoError(t, err)
+
+	assert.Equal(t, "9090", c.Port, "environment wins over the file")
+	assert.Equal(t, "cache.internal", c.Redis.Host, "empty variables count as unset")
+	assert.True(t, c.WriteBehind)
+	assert.Equal(t, "secret", c.AdminToken)
+
+	assert.Equal(t, SourceEnv, setting(t, settings, "PORT").Source)
+	assert.Equal(t, SourceFile, setting(t, settings, "REDIS_HOST").Source)
+	admin := setting(t, settings, "ADMIN_TOKEN")
+	assert.Equal(t, "[redacted]", admin.Value)
+	assert.True(t, admin.Secret)
+}
+
+func TestLoadReportsInvalidSettings(t *testing.T) {
+	env := map[string]string{
+		"WORKER_CONCURRENCY": "0",
+		"CACHE_STRATEGY":     "write-around",
+		"WRITE_BEHIND":       "maybe",
+		"MEMORY_LIMIT_MB":    "lots",
+	}
+	c, settings, err := load(lookup(env), nil)
+	require.Error(t, err)
+	for name := range env {
+		assert.Contains(t, err.Error(), name)
+		assert.NotEmpty(t, setting(t, settings, name).Error, name)
+	}
+
+	assert.Equal(t, 2, c.WorkerConcurrency, "invalid settings fall back to their default")
+	assert.Equal(t, "invalidate", c.Cache.Strategy)
+	assert.Empty(t, setting(t, settings, "PORT").Error)
+}
+
+func TestLoadProfileDefaults(t *testing.T) {
+	c, settings, err := load(lookup(map[string]string{"APP_ENV": "prod", "HTTP_WRITE_TIMEOUT_SECONDS": "5"}), nil)
+	require.NoError(t, err)
+
+	assert.True(t, c.RequireAdminToken)
+	assert.True(t, c.StrictJSON)
+	assert.Equal(t, 10, c.HTTPReadTimeoutSeconds)
+	assert.Equal(t, 5, c.HTTPWriteTimeoutSeconds, "the environment wins over the profile")
+	assert.False(t, c.DebugRequest, "settings the profile does not mention keep their default")
+
+	read := setting(t, settings, "HTTP_READ_TIMEOUT_SECONDS")
+	assert.Equal(t, SourceProfile, read.Source)
+	assert.Equal(t, "10", read.Default)
+	assert.Equal(t, SourceEnv, setting(t, settings, "HTTP_WRITE_TIMEOUT_SECONDS").Source)
+	assert.Equal(t, SourceDefault, setting(t, settings, "DEBUG_REQUEST").Source)
+}
+
+func TestLoadProfileFromFile(t *testing.T) {
+	c, settings, err := load(lookup(nil), map[string]string{"APP_ENV": "test"})
+	require.NoError(t, err)
+
+	assert.True(t, c.EnableReset)
+	assert.Equal(t, SourceProfile, setting(t, settings, "ENABLE_RESET").Source)
+}
+
+func TestLoadRejectsUnknownProfile(t *testing.T) {
+	_, settings, err := load(lookup(map[string]string{"APP_ENV": "staging"}), nil)
+	require.Error(t, err)
+	assert.NotEmpty(t, setting(t, settings, "APP_ENV").Error)
+}
+
+func TestCheckReportsConflictingSettings(t *testing.T) {
+	c, _, err := load(lookup(nil), nil)
+	require.NoError(t, err)
+	assert.NoError(t, c.Check())
+
+	env := map[string]string{
+		"APP_ENV":            "prod",
+		"SERIALIZE_REQUESTS": "true",
+		"CACHE_DUAL_READ":    "true",
+		"CACHE_BACKEND":      "memcached",
+	}
+	c, _, err = load(lookup(env), nil)
+	require.NoError(t, err, "each setting is valid on its own")
+	err = c.Check()
+	require.Error(t, err)
+	for _, name := range []string{"ADMIN_TOKEN", "SERIALIZE_REQUESTS", "CACHE_NEW_REDIS_ADDR", "MEMCACHED_SERVERS"} {
+		assert.Contains(t, err.Error(), name)
+	}
+}
+
+func TestEverySettingIsDocumented(t *testing.T) {
+	_, settings, err := load(lookup(nil), nil)
+	require.NoError(t, err)
+
+	seen := map[string]bool{}
+	for _, s := range settings {
+		assert.NotEmpty(t, s.Description, s.Name)
+		assert.False(t, seen[s.Name], "%s declared twice", s.Name)
+		seen[s.Name] = true
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.env")
+	require.NoError(t, os.WriteFile(path, []byte("# comment\n\nexport PORT=9000\nADMIN_TOKEN=\"a b\"\n"), 0o600))
+
+	values, err := readFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"PORT": "9000", "ADMIN_TOKEN": "a b"}, values)
+
+	require.NoError(t, os.WriteFile(path, []byte("PORT\n"), 0o600))
+	_, err = readFile(path)
+	assert.Error(t, err)
+}
+
+func TestUnrecognized(t *testing.T) {
+	unknown := Unrecognized([]string{"CACHE_STRATEGI=x", "CACHE_STRATEGY=invalidate", "HOME=/root", "POSTGRES_HOSTNAME=db", "CONFIG_FILE=a", "APP_HOST=app", "APP_ENVIRONMENT=prod"})
+	assert.Equal(t, []string{"APP_ENVIRONMENT", "CACHE_STRATEGI", "POSTGRES_HOSTNAME"}, unknown)
+}
+
+func TestApplyExportsFileValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.env")
+	require.NoError(t, os.WriteFile(path, []byte("REDIS_HOST=from-file\nREDIS_PORT=7000\n"), 0o600))
+	t.Setenv(FileEnv, path)
+	t.Setenv("REDIS_HOST", "")
+	t.Setenv("REDIS_PORT", "6380")
+	t.Cleanup(func() { fromFile = map[string]bool{} })
+
+	require.NoError(t, Apply())
+	assert.Equal(t, "from-file", os.Getenv("REDIS_HOST"))
+	assert.Equal(t, "6380", os.Getenv("REDIS_PORT"), "the environment wins over the file")
+
+	_, settings, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, SourceFile, setting(t, settings, "REDIS_HOST").Source)
+	assert.Equal(t, SourceEnv, setting(t, settings, "REDIS_PORT").Source)
+}
+
+func TestApplyExportsProfileDefaults(t *testing.T) {
+	t.Setenv(FileEnv, "")
+	t.Setenv(ProfileEnv, ProfileDev)
+	t.Setenv("DEBUG_REQUEST", "")
+	t.Setenv("LOG_REQUESTS", "false")
+	t.Cleanup(func() { fromProfile = map[string]bool{} })
+
+	require.NoError(t, Apply())
+	assert.Equal(t, "true", os.Getenv("DEBUG_REQUEST"))
+	assert.Equal(t, "false", os.Getenv("LOG_REQUESTS"), "the environment wins over the profile")
+
+	_, settings, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, SourceProfile, setting(t, settings, "DEBUG_REQUEST").Source)
+	assert.Equal(t, SourceEnv, setting(t, settings, "LOG_REQUESTS").Source)
+}
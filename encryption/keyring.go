@@ -0,0 +1,157 @@
+// Package encryption seals sensitive values with AES-256-GCM before they
+// are stored. Every ciphertext starts with the ID of the key that sealed
+// it, so keys can be rotated: new values are sealed with the primary key,
+// while values sealed with an older key still decrypt for as long as that
+// key stays in the keyring.
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeySize is the length of every key: AES-256.
+const KeySize = 32
+
+// ErrUnknownKey is returned for ciphertexts sealed with a key the keyring
+// does not hold, such as one retired too early.
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// Key is a named data key.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// KeySource supplies the keys of a keyring, primary first. EnvKeys reads
+// them from a setting; a KMS-backed source would return data keys after
+// having the KMS unwrap them.
+type KeySource interface {
+	Keys(ctx context.Context) ([]Key, error)
+}
+
+// EnvKeys is a key list as set in an environment variable:
+// comma-separated "<id>:<base64 key>" pairs, primary first.
+type EnvKeys string
+
+func (s EnvKeys) Keys(context.Context) ([]Key, error) {
+	var keys []Key
+	for _, entry := range strings.Split(string(s), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid key %q, want id:base64", id)
+		}
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %v", id, err)
+		}
+		keys = append(keys, Key{ID: id, Secret: secret})
+	}
+	return keys, nil
+}
+
+// Keyring seals values with its primary key and opens values sealed with
+// any of its keys.
+type Keyring struct {
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+// Load builds a keyring from the keys of src.
+func Load(ctx context.Context, src KeySource) (*Keyring, error) {
+	keys, err := src.Keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyring(keys)
+}
+
+// NewKeyring builds a keyring whose primary key is the first of keys.
+func NewKeyring(keys []Key) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption keys")
+	}
+
+	k := &Keyring{primary: keys[0].ID, aeads: map[string]cipher.AEAD{}}
+	for _, key := range keys {
+		if key.ID == "" || strings.ContainsAny(key.ID, ":, ") {
+			return nil, fmt.Errorf("invalid key ID %q", key.ID)
+		}
+		if _, ok := k.aeads[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key ID %q", key.ID)
+		}
+		if len(key.Secret) != KeySize {
+			return nil, fmt.Errorf("key %s is %d bytes, want %d", key.ID, len(key.Secret), KeySize)
+		}
+		block, err := aes.NewCipher(key.Secret)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.aeads[key.ID] = aead
+	}
+	return k, nil
+}
+
+// Primary returns the ID of the key new values are sealed with.
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// Encrypt seals plaintext with the primary key as "<key id>:<nonce><sealed>".
+// aad is authenticated but not stored; Decrypt must be given the same, so
+// a ciphertext copied to where a different aad applies fails to open.
+func (k *Keyring) Encrypt(plaintext, aad []byte) ([]byte, error) {
+	aead := k.aeads[k.primary]
+	out := make([]byte, 0, len(k.primary)+1+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	out = append(out, k.primary...)
+	out = append(out, ':')
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plaintext, aad), nil
+}
+
+// Decrypt opens a ciphertext sealed by Encrypt with any key of the keyring.
+func (k *Keyring) Decrypt(ciphertext, aad []byte) ([]byte, error) {
+	id, sealed, ok := bytes.Cut(ciphertext, []byte{':'})
+	if !ok {
+		return nil, errors.New("malformed ciphertext")
+	}
+	aead, ok := k.aeads[string(id)]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("malformed ciphertext")
+	}
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, aad)
+	if err != nil {
+		return nil, fmt.Errorf("ciphertext sealed with key %s does not open: %v", id, err)
+	}
+	return plaintext, nil
+}
+
+// KeyID returns the ID of the key a ciphertext was sealed with.
+func KeyID(ciphertext []byte) string {
+	id, _, _ := bytes.Cut(ciphertext, []byte{':'})
+	return string(id)
+}
@@ -0,0 +1,82 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(id string, fill byte) Key {
+	return Key{ID: id, Secret: bytes.Repeat([]byte{fill}, KeySize)}
+}
+
+func TestRoundTrip(t *testing.T) {
+	k, err := NewKeyring([]Key{testKey("k1", 1)})
+	require.NoError(t, err)
+
+	sealed, err := k.Encrypt([]byte("4111 1111 1111 1111"), []byte("test_data.secret"))
+	require.NoError(t, err)
+	assert.Equal(t, "k1", KeyID(sealed))
+	assert.NotContains(t, string(sealed), "4111")
+
+	again, err := k.Encrypt([]byte("4111 1111 1111 1111"), []byte("test_data.secret"))
+	require.NoError(t, err)
+	assert.NotEqual(t, sealed, again, "every value gets a fresh nonce")
+
+	plain, err := k.Decrypt(sealed, []byte("test_data.secret"))
+	require.NoError(t, err)
+	assert.Equal(t, "4111 1111 1111 1111", string(plain))
+
+	_, err = k.Decrypt(sealed, []byte("other.field"))
+	assert.Error(t, err, "the aad is authenticated")
+
+	sealed[len(sealed)-1] ^= 1
+	_, err = k.Decrypt(sealed, []byte("test_data.secret"))
+	assert.Error(t, err, "tampering is detected")
+}
+
+func TestDecryptAfterRotation(t *testing.T) {
+	old, err := NewKeyring([]Key{testKey("k1", 1)})
+	require.NoError(t, err)
+	sealed, err := old.Encrypt([]byte("before"), nil)
+	require.NoError(t, err)
+
+	// k2 becomes primary; k1 stays to open existing values
+	rotated, err := NewKeyring([]Key{testKey("k2", 2), testKey("k1", 1)})
+	require.NoError(t, err)
+	assert.Equal(t, "k2", rotated.Primary())
+
+	plain, err := rotated.Decrypt(sealed, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "before", string(plain))
+
+	resealed, err := rotated.Encrypt(plain, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "k2", KeyID(resealed))
+
+	// Once every value is resealed, k1 can be retired
+	retired, err := NewKeyring([]Key{testKey("k2", 2)})
+	require.NoError(t, err)
+	_, err = retired.Decrypt(sealed, nil)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	plain, err = retired.Decrypt(resealed, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "before", string(plain))
+}
+
+func TestEnvKeys(t *testing.T) {
+	k2 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, KeySize))
+	k1 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, KeySize))
+	k, err := Load(context.Background(), EnvKeys("k2:"+k2+", k1:"+k1))
+	require.NoError(t, err)
+	assert.Equal(t, "k2", k.Primary())
+
+	for _, bad := range []string{"", "k1", "k1:not-base64!", "k1:" + base64.StdEncoding.EncodeToString([]byte("short")), "k1:" + k1 + ",k1:" + k2} {
+		_, err := Load(context.Background(), EnvKeys(bad))
+		assert.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,187 @@
+// Package events fans change events out to the subscribers of the event
+// feed. Each subscriber has a bounded buffer, so one slow client can never
+// hold up the others or grow memory without limit; what happens when its
+// buffer is full is the broadcaster's Policy.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nesymno/run-tests-example/metrics"
+)
+
+// Policies for subscribers whose buffer is full.
+const (
+	// DropOldest discards the oldest buffered event to make room.
+	DropOldest = "drop-oldest"
+	// Disconnect drops the subscriber, which has to reconnect.
+	Disconnect = "disconnect"
+)
+
+// ValidPolicy reports whether p is a slow subscriber policy.
+func ValidPolicy(p string) bool {
+	return p == DropOldest || p == Disconnect
+}
+
+// Event is a change to application data.
+type Event struct {
+	// ID numbers events consecutively in publishing order, so a client
+	// can resume after the last one it saw and tell whether any are lost.
+	ID   int64  `json:"-"`
+	Type string `json:"type"`
+	// Tenant owns the changed row, 0 for shared rows. Subscribers only
+	// receive the events of their own tenant.
+	Tenant int             `json:"tenant,omitempty"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// ErrTooManySubscribers is returned by Subscribe when MaxSubscribers are
+// already connected.
+var ErrTooManySubscribers = errors.New("too many subscribers")
+
+var (
+	publishedEvents = metrics.NewCounter("app_events_published_total",
+		"Events broadcast to the feed subscribers of this replica.")
+	droppedEvents = metrics.NewCounterVec("app_events_dropped_total",
+		"Events a slow subscriber did not receive, by policy (drop-oldest, disconnect).", "policy")
+	slowDisconnects = metrics.NewCounter("app_events_slow_disconnects_total",
+		"Subscribers disconnected because their buffer was full.")
+	rejectedSubscribers = metrics.NewCounter("app_events_rejected_total",
+		"Subscriptions refused because MaxSubscribers were connected.")
+)
+
+// Broadcaster delivers published events to its subscribers.
+type Broadcaster struct {
+	// Buffer is the default number of events buffered per subscriber.
+	Buffer int
+	// Policy is the default policy for full buffers.
+	Policy string
+	// MaxSubscribers limits the subscribers connected at once, 0 for no
+	// limit.
+	MaxSubscribers int
+
+	mu   sync.Mutex
+	subs map[*Subscriber]struct{}
+}
+
+func NewBroadcaster(buffer int, policy string) *Broadcaster {
+	return &Broadcaster{Buffer: buffer, Policy: policy, subs: map[*Subscriber]struct{}{}}
+}
+
+// Subscriber receives the events accepted by its filter.
+type Subscriber struct {
+	policy string
+	filter func(Event) bool
+	ch     chan Event
+	done   chan struct{}
+
+	dropped atomic.Uint64
+}
+
+// Events delivers the subscriber's events in publishing order.
+func (s *Subscriber) Events() <-chan Event {
+	return s.ch
+}
+
+// Done is closed when the subscriber is disconnected for falling behind.
+func (s *Subscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+// TakeDropped returns how many events were dropped since the last call.
+func (s *Subscriber) TakeDropped() uint64 {
+	return s.dropped.Swap(0)
+}
+
+// Subscribe adds a subscriber buffering up to buffer events, handled by
+// policy when full. Zero values take the broadcaster's defaults.
+func (b *Broadcaster) Subscribe(buffer int, policy string, filter func(Event) bool) (*Subscriber, error) {
+	if buffer == 0 {
+		buffer = b.Buffer
+	}
+	if policy == "" {
+		policy = b.Policy
+	}
+	if buffer < 1 {
+		return nil, fmt.Errorf("buffer must be positive")
+	}
+	if !ValidPolicy(policy) {
+		return nil, fmt.Errorf("unknown policy %q (use %s or %s)", policy, DropOldest, Disconnect)
+	}
+
+	s := &Subscriber{
+		policy: policy,
+		filter: filter,
+		ch:     make(chan Event, buffer),
+		done:   make(chan struct{}),
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.MaxSubscribers > 0 && len(b.subs) >= b.MaxSubscribers {
+		rejectedSubscribers.Inc()
+		return nil, ErrTooManySubscribers
+	}
+	b.subs[s] = struct{}{}
+	return s, nil
+}
+
+// Unsubscribe removes s. It is safe to call more than once.
+func (b *Broadcaster) Unsubscribe(s *Subscriber) {
+	b.mu.Lock()
+	delete(b.subs, s)
+	b.mu.Unlock()
+}
+
+// Len returns the number of subscribers.
+func (b *Broadcaster) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// Publish delivers e to every interested subscriber without blocking.
+func (b *Broadcaster) Publish(e Event) {
+	publishedEvents.Inc()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		if s.filter != nil && !s.filter(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+			continue
+		default:
+		}
+
+		switch s.policy {
+		case DropOldest:
+			// Only Publish sends, under b.mu, so after taking one event
+			// there is room even if the subscriber took none meanwhile
+			select {
+			case <-s.ch:
+			default:
+			}
+			s.ch <- e
+			s.dropped.Add(1)
+			droppedEvents.With(DropOldest).Inc()
+		case Disconnect:
+			delete(b.subs, s)
+			close(s.done)
+			droppedEvents.With(Disconnect).Inc()
+			slowDisconnects.Inc()
+		}
+	}
+}
+
+// RegisterMetrics exports the number of subscribers as a gauge read at
+// scrape time.
+func (b *Broadcaster) RegisterMetrics() {
+	metrics.NewGaugeFunc("app_events_subscribers", "Event feed subscribers connected to this replica.",
+		func() float64 { return float64(b.Len()) })
+}
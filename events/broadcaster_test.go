@@ -0,0 +1,103 @@
+package events
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func event(n int) Event {
+	return Event{Type: "test", Data: json.RawMessage(strconv.Itoa(n))}
+}
+
+func drain(s *Subscriber) []string {
+	var got []string
+	for {
+		select {
+		case e := <-s.Events():
+			got = append(got, string(e.Data))
+		default:
+			return got
+		}
+	}
+}
+
+func TestDropOldestKeepsNewestEvents(t *testing.T) {
+	b := NewBroadcaster(2, DropOldest)
+	slow, err := b.Subscribe(0, "", nil)
+	require.NoError(t, err)
+	fast, err := b.Subscribe(10, "", nil)
+	require.NoError(t, err)
+	dropped := droppedEvents.With(DropOldest).Value()
+
+	for i := 1; i <= 5; i++ {
+		b.Publish(event(i))
+	}
+
+	assert.Equal(t, []string{"4", "5"}, drain(slow))
+	assert.Equal(t, uint64(3), slow.TakeDropped())
+	assert.Equal(t, uint64(0), slow.TakeDropped())
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, drain(fast), "a slow subscriber does not hold up the others")
+	assert.Equal(t, dropped+3, droppedEvents.With(DropOldest).Value())
+}
+
+func TestDisconnectDropsSlowSubscriber(t *testing.T) {
+	b := NewBroadcaster(1, Disconnect)
+	s, err := b.Subscribe(0, "", nil)
+	require.NoError(t, err)
+
+	b.Publish(event(1))
+	select {
+	case <-s.Done():
+		t.Fatal("disconnected before its buffer was full")
+	default:
+	}
+
+	b.Publish(event(2))
+	<-s.Done()
+	assert.Equal(t, 0, b.Len())
+
+	b.Publish(event(3))
+	assert.Equal(t, []string{"1"}, drain(s), "nothing is delivered after the disconnect")
+	b.Unsubscribe(s)
+}
+
+func TestSubscribeFilters(t *testing.T) {
+	b := NewBroadcaster(4, DropOldest)
+	s, err := b.Subscribe(0, "", func(e Event) bool { return e.Tenant == 7 })
+	require.NoError(t, err)
+
+	b.Publish(Event{Type: "a", Data: json.RawMessage("1")})
+	b.Publish(Event{Type: "b", Tenant: 7, Data: json.RawMessage("2")})
+	assert.Equal(t, []string{"2"}, drain(s))
+}
+
+func TestSubscribeValidates(t *testing.T) {
+	b := NewBroadcaster(4, DropOldest)
+	_, err := b.Subscribe(-1, "", nil)
+	assert.Error(t, err)
+	_, err = b.Subscribe(0, "block", nil)
+	assert.Error(t, err)
+
+	s, err := b.Subscribe(1, Disconnect, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, cap(s.ch))
+	assert.Equal(t, Disconnect, s.policy)
+}
+
+func TestSubscribeRespectsMaxSubscribers(t *testing.T) {
+	b := NewBroadcaster(4, DropOldest)
+	b.MaxSubscribers = 1
+	s, err := b.Subscribe(0, "", nil)
+	require.NoError(t, err)
+
+	_, err = b.Subscribe(0, "", nil)
+	assert.ErrorIs(t, err, ErrTooManySubscribers)
+
+	b.Unsubscribe(s)
+	_, err = b.Subscribe(0, "", nil)
+	assert.NoError(t, err, "a slot frees up when a subscriber leaves")
+}
@@ -1,12 +1,9 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"testing"
@@ -17,39 +14,16 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/nesymno/run-tests-example/config"
+	"github.com/nesymno/run-tests-example/scenarios"
 	"github.com/nesymno/run-tests-example/types"
 )
 
-type PostgresConfig struct {
-	Host string
-	Port string
-	User string
-	Pass string
-	DB   string
-}
-
-type RedisConfig struct {
-	Host string
-	Port string
-	DB   int
-}
-
 func TestApp(t *testing.T) {
 	ctx := context.Background()
 
-	postgresConfig := PostgresConfig{
-		Host: os.Getenv("POSTGRES_HOST"),
-		Port: os.Getenv("POSTGRES_PORT"),
-		User: os.Getenv("POSTGRES_USER"),
-		Pass: os.Getenv("POSTGRES_PASSWORD"),
-		DB:   os.Getenv("POSTGRES_DB"),
-	}
-
-	redisConfig := RedisConfig{
-		Host: os.Getenv("REDIS_HOST"),
-		Port: os.Getenv("REDIS_PORT"),
-		DB:   0,
-	}
+	cfg, _, err := config.Load()
+	require.NoError(t, err)
 
 	appHost := os.Getenv("APP_HOST")
 	if appHost == "" {
@@ -61,134 +35,41 @@ func TestApp(t *testing.T) {
 		appPort = "8080"
 	}
 
+	// Subtests run in parallel, against an app and databases other suites
+	// may share, so every subtest only touches keys and rows carrying this
+	// run's ID, and removes them itself
+	run := fmt.Sprintf("%d", time.Now().UnixNano())
+
 	t.Run("PostgreSQL Tests", func(t *testing.T) {
-		t.Log("=== STARTING POSTGRESQL TEST ===")
-		t.Log("About to call cleanupTestData...")
-		defer func() {
-			if r := recover(); r != nil {
-				t.Logf("Cleanup function panicked: %v", r)
-			}
-		}()
-		cleanupTestData(t, ctx, postgresConfig, redisConfig)
-		t.Log("=== CLEANUP COMPLETED, STARTING TEST ===")
-		testPGWithConfig(t, ctx, postgresConfig)
+		t.Parallel()
+		testPGWithConfig(t, ctx, cfg.Postgres, "pg-"+run+"-")
 	})
 
 	t.Run("Redis Tests", func(t *testing.T) {
-		t.Log("=== STARTING REDIS TEST ===")
-		cleanupTestData(t, ctx, postgresConfig, redisConfig)
-		t.Log("=== CLEANUP COMPLETED, STARTING TEST ===")
-		testRedisWithConfig(t, ctx, redisConfig)
+		t.Parallel()
+		testRedisWithConfig(t, ctx, cfg.Redis, "redis-"+run+":")
 	})
 
 	t.Run("Application Integration Tests", func(t *testing.T) {
-		testAppIntegration(t, ctx, fmt.Sprintf("http://%s:%s", appHost, appPort))
-	})
-}
-
-// cleanupTestData cleans up any existing test data from previous runs
-func cleanupTestData(t *testing.T, ctx context.Context, postgresConfig PostgresConfig, redisConfig RedisConfig) {
-	t.Log("=== CLEANUP FUNCTION CALLED ===")
-	t.Log("Starting test data cleanup...")
-
-	// Simple test to see if we can log
-	t.Log("Cleanup function is executing...")
-
-	// Clean up PostgreSQL
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		postgresConfig.Host, postgresConfig.Port, postgresConfig.User, postgresConfig.Pass, postgresConfig.DB)
-
-	t.Logf("Connecting to PostgreSQL at %s:%s", postgresConfig.Host, postgresConfig.Port)
-	db, err := sql.Open("postgres", dsn)
-	if err != nil {
-		t.Logf("Error: Could not open PostgreSQL connection: %v", err)
-		return
-	}
-	defer db.Close()
-
-	// Test connection with retry
-	var pingErr error
-	for i := 0; i < 5; i++ {
-		pingErr = db.Ping()
-		if pingErr == nil {
-			break
-		}
-		t.Logf("PostgreSQL ping attempt %d failed: %v", i+1, pingErr)
-		time.Sleep(time.Second)
-	}
-
-	if pingErr != nil {
-		t.Logf("Error: Could not ping PostgreSQL after 5 attempts: %v", pingErr)
-		return
-	}
-
-	t.Log("PostgreSQL connection successful")
-
-	// Clear test data table
-	result, err := db.ExecContext(ctx, "DELETE FROM test_data")
-	if err != nil {
-		t.Logf("Error: Could not clear PostgreSQL test data: %v", err)
-	} else {
-		rowsAffected, _ := result.RowsAffected()
-		t.Logf("Cleared %d rows from PostgreSQL test_data table", rowsAffected)
-	}
-
-	// Clean up Redis
-	t.Logf("Connecting to Redis at %s:%s", redisConfig.Host, redisConfig.Port)
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%s", redisConfig.Host, redisConfig.Port),
-		Password: "",
-		DB:       redisConfig.DB,
+		t.Parallel()
+		testAppIntegration(t, ctx, fmt.Sprintf("http://%s:%s", appHost, appPort), "app-"+run)
 	})
-	defer rdb.Close()
-
-	// Test Redis connection with retry
-	var redisPingErr error
-	for i := 0; i < 5; i++ {
-		redisPingErr = rdb.Ping(ctx).Err()
-		if redisPingErr == nil {
-			break
-		}
-		t.Logf("Redis ping attempt %d failed: %v", i+1, redisPingErr)
-		time.Sleep(time.Second)
-	}
-
-	if redisPingErr != nil {
-		t.Logf("Error: Could not ping Redis after 5 attempts: %v", redisPingErr)
-		return
-	}
-
-	t.Log("Redis connection successful")
-
-	// Clear all test keys
-	keys := []string{"key1", "key2", "key3", "test_list", "test_hash", "test_data_cache", "test_key"}
-	clearedCount := 0
-	for _, key := range keys {
-		if rdb.Del(ctx, key).Val() > 0 {
-			clearedCount++
-		}
-	}
-	t.Logf("Cleared %d keys from Redis", clearedCount)
-
-	t.Log("Test data cleanup completed")
 }
 
-// testPGWithConfig tests PostgreSQL functionality using PostgresConfig
-func testPGWithConfig(t *testing.T, ctx context.Context, config PostgresConfig) {
-	require.NotEmpty(t, config.Host, "postgresql host should be set")
-	require.NotEmpty(t, config.Port, "postgresql port should be set")
-	require.NotEmpty(t, config.User, "postgresql user should be set")
-	require.NotEmpty(t, config.Pass, "postgresql password should be set")
-	require.NotEmpty(t, config.DB, "postgresql database should be set")
-
-	t.Logf("postgresql connection: %s:%s", config.Host, config.Port)
+// testPGWithConfig tests PostgreSQL functionality using the app's
+// PostgresConfig, with rows whose names start with prefix
+func testPGWithConfig(t *testing.T, ctx context.Context, pg config.PostgresConfig, prefix string) {
+	require.NotEmpty(t, pg.Host, "postgresql host should be set")
+	require.NotEmpty(t, pg.Port, "postgresql port should be set")
+	require.NotEmpty(t, pg.User, "postgresql user should be set")
+	require.NotEmpty(t, pg.Password, "postgresql password should be set")
+	require.NotEmpty(t, pg.DB, "postgresql database should be set")
 
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		config.Host, config.Port, config.User, config.Pass, config.DB)
+	t.Logf("postgresql connection: %s", pg.Addr())
 
-	db, err := sql.Open("postgres", dsn)
+	db, err := sql.Open("postgres", pg.DSN())
 	require.NoError(t, err)
-	defer db.Close()
+	t.Cleanup(func() { db.Close() })
 
 	err = db.Ping()
 	require.NoError(t, err, "failed to ping postgresql")
@@ -203,10 +84,15 @@ func testPGWithConfig(t *testing.T, ctx context.Context, config PostgresConfig)
 	`)
 	require.NoError(t, err, "failed to create test table")
 
+	t.Cleanup(func() {
+		_, err := db.ExecContext(context.Background(), "DELETE FROM test_data WHERE name LIKE $1 || '%'", prefix)
+		assert.NoError(t, err, "failed to delete test data")
+	})
+
 	testData := []types.TestData{
-		{Name: "test1", Data: "data1"},
-		{Name: "test2", Data: "data2"},
-		{Name: "test3", Data: "data3"},
+		{Name: prefix + "test1", Data: "data1"},
+		{Name: prefix + "test2", Data: "data2"},
+		{Name: prefix + "test3", Data: "data3"},
 	}
 
 	for _, data := range testData {
@@ -216,7 +102,7 @@ func testPGWithConfig(t *testing.T, ctx context.Context, config PostgresConfig)
 		require.NoError(t, err, "failed to insert test data")
 	}
 
-	rows, err := db.QueryContext(ctx, "SELECT id, name, data FROM test_data ORDER BY id")
+	rows, err := db.QueryContext(ctx, "SELECT id, name, data FROM test_data WHERE name LIKE $1 || '%' ORDER BY id", prefix)
 	require.NoError(t, err, "failed to query test data")
 	defer rows.Close()
 
@@ -230,32 +116,40 @@ func testPGWithConfig(t *testing.T, ctx context.Context, config PostgresConfig)
 
 	require.NoError(t, rows.Err())
 	assert.Len(t, results, 3, "expected 3 test records")
-	assert.Equal(t, "test1", results[0].Name)
+	assert.Equal(t, prefix+"test1", results[0].Name)
 	assert.Equal(t, "data1", results[0].Data)
 
 	t.Logf("postgresql test completed successfully - found %d records", len(results))
 }
 
-// testRedisWithConfig tests Redis functionality using RedisConfig
-func testRedisWithConfig(t *testing.T, ctx context.Context, config RedisConfig) {
-	require.NotEmpty(t, config.Host, "redis host should be set")
-	require.NotEmpty(t, config.Port, "redis port should be set")
+// testRedisWithConfig tests Redis functionality using the app's
+// RedisConfig, with keys starting with prefix
+func testRedisWithConfig(t *testing.T, ctx context.Context, rds config.RedisConfig, prefix string) {
+	require.NotEmpty(t, rds.Host, "redis host should be set")
+	require.NotEmpty(t, rds.Port, "redis port should be set")
 
 	rdb := redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%s", config.Host, config.Port),
+		Addr:     rds.Addr(),
 		Password: "",
-		DB:       config.DB,
+		DB:       0,
 	})
-	defer rdb.Close()
+	t.Cleanup(func() { rdb.Close() })
 
 	_, err := rdb.Ping(ctx).Result()
 	require.NoError(t, err, "failed to ping redis")
 
 	testData := map[string]string{
-		"key1": "value1",
-		"key2": "value2",
-		"key3": "value3",
-	}
+		prefix + "key1": "value1",
+		prefix + "key2": "value2",
+		prefix + "key3": "value3",
+	}
+	t.Cleanup(func() {
+		keys := []string{prefix + "test_list", prefix + "test_hash"}
+		for key := range testData {
+			keys = append(keys, key)
+		}
+		assert.NoError(t, rdb.Del(context.Background(), keys...).Err(), "failed to delete redis keys")
+	})
 
 	for key, value := range testData {
 		err = rdb.Set(ctx, key, value, 0).Err()
@@ -268,118 +162,58 @@ func testRedisWithConfig(t *testing.T, ctx context.Context, config RedisConfig)
 		assert.Equal(t, expectedValue, value)
 	}
 
-	err = rdb.LPush(ctx, "test_list", "item1", "item2", "item3").Err()
+	err = rdb.LPush(ctx, prefix+"test_list", "item1", "item2", "item3").Err()
 	require.NoError(t, err, "failed to push to redis list")
 
-	listLength, err := rdb.LLen(ctx, "test_list").Result()
+	listLength, err := rdb.LLen(ctx, prefix+"test_list").Result()
 	require.NoError(t, err, "failed to get list length")
 	assert.Equal(t, int64(3), listLength)
 
-	err = rdb.HSet(ctx, "test_hash", map[string]interface{}{
+	err = rdb.HSet(ctx, prefix+"test_hash", map[string]interface{}{
 		"field1": "value1",
 		"field2": "value2",
 	}).Err()
 	require.NoError(t, err, "failed to set redis hash")
 
-	hashValue, err := rdb.HGet(ctx, "test_hash", "field1").Result()
+	hashValue, err := rdb.HGet(ctx, prefix+"test_hash", "field1").Result()
 	require.NoError(t, err, "failed to get redis hash field")
 	assert.Equal(t, "value1", hashValue)
 
 	t.Logf("redis test completed successfully")
 }
 
-// testAppIntegration tests the application's HTTP endpoints and integration
-func testAppIntegration(t *testing.T, ctx context.Context, baseURL string) {
-	client := &http.Client{Timeout: 10 * time.Second}
+// testAppIntegration tests the application's HTTP endpoints and
+// integration, creating data as test run run
+func testAppIntegration(t *testing.T, ctx context.Context, baseURL, run string) {
+	opts := scenarios.Options{Client: &http.Client{Timeout: 10 * time.Second}}
 
 	t.Run("Health Check", func(t *testing.T) {
-		resp, err := client.Get(baseURL + "/health")
-		require.NoError(t, err)
-		defer resp.Body.Close()
-
-		assert.Equal(t, http.StatusOK, resp.StatusCode)
-
-		var health types.HealthResponse
-		err = json.NewDecoder(resp.Body).Decode(&health)
-		require.NoError(t, err)
-
-		assert.Equal(t, "healthy", health.Status)
-		assert.Equal(t, "healthy", health.Database)
-		assert.Equal(t, "healthy", health.Cache)
-		assert.Equal(t, "1.0.0", health.Version)
-
-		t.Logf("health check passed - database: %s, cache: %s", health.Database, health.Cache)
+		t.Parallel()
+		scenarios.Health().Test(t, baseURL, opts)
 	})
 
 	t.Run("Root Endpoint", func(t *testing.T) {
-		resp, err := client.Get(baseURL + "/")
-		require.NoError(t, err)
-		defer resp.Body.Close()
-
-		assert.Equal(t, http.StatusOK, resp.StatusCode)
-		body, err := io.ReadAll(resp.Body)
-		require.NoError(t, err)
-		assert.Contains(t, string(body), "KubeRLy Test App")
+		t.Parallel()
+		scenarios.Root().Test(t, baseURL, opts)
 	})
 
 	t.Run("Data CRUD Operations", func(t *testing.T) {
-		// Test POST - Create new data
-		newData := types.TestData{Name: "integration_test", Data: "test_data"}
-		jsonData, err := json.Marshal(newData)
-		require.NoError(t, err)
-
-		resp, err := client.Post(baseURL+"/api/data", "application/json", bytes.NewBuffer(jsonData))
-		require.NoError(t, err)
-		defer resp.Body.Close()
-
-		assert.Equal(t, http.StatusCreated, resp.StatusCode)
-
-		// Test GET - Retrieve data (should show cache miss first time)
-		resp, err = client.Get(baseURL + "/api/data")
-		require.NoError(t, err)
-		defer resp.Body.Close()
-
-		assert.Equal(t, http.StatusOK, resp.StatusCode)
-		assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
-
-		// Test GET again - should show cache hit
-		resp, err = client.Get(baseURL + "/api/data")
-		require.NoError(t, err)
-		defer resp.Body.Close()
-
-		assert.Equal(t, http.StatusOK, resp.StatusCode)
-		assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+		t.Parallel()
+		scenarios.DataLifecycle(run).Test(t, baseURL, opts)
 	})
 
-	t.Run("Cache Operations", func(t *testing.T) {
-		// Test POST - Set cache value
-		cacheData := map[string]interface{}{
-			"key":   "test_key",
-			"value": "test_value",
-			"ttl":   60,
+	// Not parallel: its writes invalidate the listing cache, which would
+	// turn the cache hit Data CRUD Operations expects into a miss. It runs
+	// to completion before the parallel subtests start.
+	t.Run("Random Walks", func(t *testing.T) {
+		for seed := range uint64(5) {
+			scenarios.RandomWalk(fmt.Sprintf("%s-walk%d", run, seed), seed, 60).Test(t, baseURL, opts)
 		}
-		jsonData, err := json.Marshal(cacheData)
-		require.NoError(t, err)
-
-		resp, err := client.Post(baseURL+"/api/cache", "application/json", bytes.NewBuffer(jsonData))
-		require.NoError(t, err)
-		defer resp.Body.Close()
-
-		assert.Equal(t, http.StatusCreated, resp.StatusCode)
-
-		// Test GET - Retrieve cache value
-		resp, err = client.Get(baseURL + "/api/cache?key=test_key")
-		require.NoError(t, err)
-		defer resp.Body.Close()
-
-		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
 
-		var result map[string]string
-		err = json.NewDecoder(resp.Body).Decode(&result)
-		require.NoError(t, err)
-		assert.Equal(t, "test_key", result["key"])
-		assert.Equal(t, "test_value", result["value"])
+	t.Run("Cache Operations", func(t *testing.T) {
+		t.Parallel()
+		scenarios.CacheOperations(run+":test_key").Test(t, baseURL, opts)
 	})
 
-	t.Logf("application integration tests completed successfully")
 }
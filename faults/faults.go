@@ -0,0 +1,108 @@
+// Package faults injects failures and latency into the app's dependencies
+// for resilience tests. An Injector decides, call by call, whether to
+// delay or fail; Connector and RedisHook apply it to database/sql and
+// go-redis below their own retry logic, so tests see whether those layers
+// actually absorb the faults.
+package faults
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrInjected is the failure of Injector calls with no error configured.
+var ErrInjected = errors.New("faults: injected failure")
+
+// Injector schedules faults by call count. The zero value injects none and
+// is safe for concurrent use.
+type Injector struct {
+	mu        sync.Mutex
+	failEvery int
+	err       error
+	slowEvery int
+	latency   time.Duration
+	calls     int
+	failures  int
+	delays    int
+}
+
+// FailEvery makes every nth call fail with err, ErrInjected when nil. 1
+// fails every call, 0 none.
+func (i *Injector) FailEvery(n int, err error) *Injector {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err == nil {
+		err = ErrInjected
+	}
+	i.failEvery, i.err = n, err
+	return i
+}
+
+// SlowEvery delays every nth call by d before it proceeds or fails. 0
+// delays none.
+func (i *Injector) SlowEvery(n int, d time.Duration) *Injector {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.slowEvery, i.latency = n, d
+	return i
+}
+
+// Reset stops injecting faults and clears the counts.
+func (i *Injector) Reset() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.failEvery, i.err, i.slowEvery, i.latency = 0, nil, 0, 0
+	i.calls, i.failures, i.delays = 0, 0, 0
+}
+
+// Calls returns how many calls went through the injector.
+func (i *Injector) Calls() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.calls
+}
+
+// Failures returns how many calls were failed.
+func (i *Injector) Failures() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.failures
+}
+
+// Delays returns how many calls were delayed.
+func (i *Injector) Delays() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.delays
+}
+
+// Call counts a call, applies its delay and returns its failure, if any. A
+// delay ends early with ctx's error when ctx is done first.
+func (i *Injector) Call(ctx context.Context) error {
+	i.mu.Lock()
+	i.calls++
+	var delay time.Duration
+	if i.slowEvery > 0 && i.calls%i.slowEvery == 0 {
+		delay = i.latency
+		i.delays++
+	}
+	var err error
+	if i.failEvery > 0 && i.calls%i.failEvery == 0 {
+		err = i.err
+		i.failures++
+	}
+	i.mu.Unlock()
+
+	if delay > 0 {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return err
+}
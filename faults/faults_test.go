@@ -0,0 +1,121 @@
+package faults
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInjectorSchedule(t *testing.T) {
+	inj := new(Injector).FailEvery(3, nil)
+	var failed []int
+	for n := 1; n <= 7; n++ {
+		if err := inj.Call(context.Background()); err != nil {
+			assert.ErrorIs(t, err, ErrInjected)
+			failed = append(failed, n)
+		}
+	}
+	assert.Equal(t, []int{3, 6}, failed)
+	assert.Equal(t, 7, inj.Calls())
+	assert.Equal(t, 2, inj.Failures())
+
+	inj.Reset()
+	assert.NoError(t, inj.Call(context.Background()))
+	assert.Equal(t, 1, inj.Calls())
+}
+
+func TestInjectorLatency(t *testing.T) {
+	inj := new(Injector).SlowEvery(2, 50*time.Millisecond)
+
+	start := time.Now()
+	require.NoError(t, inj.Call(context.Background()))
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+	require.NoError(t, inj.Call(context.Background()))
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.Equal(t, 1, inj.Delays())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	inj.SlowEvery(1, time.Minute)
+	assert.ErrorIs(t, inj.Call(ctx), context.DeadlineExceeded)
+}
+
+func TestRedisRetriesBrokenConnections(t *testing.T) {
+	mr := miniredis.RunT(t)
+	// Setting up a connection takes two writes, so failing every fifth
+	// leaves each connection a few commands
+	inj := new(Injector).FailEvery(5, nil)
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr(), MinRetryBackoff: time.Millisecond, MaxRetryBackoff: time.Millisecond})
+	rds.AddHook(RedisHook(inj))
+	ctx := context.Background()
+
+	for range 10 {
+		require.NoError(t, rds.Set(ctx, "k", "v", 0).Err())
+		v, err := rds.Get(ctx, "k").Result()
+		require.NoError(t, err)
+		assert.Equal(t, "v", v)
+	}
+	assert.Positive(t, inj.Failures())
+
+	// Without retries the failures reach the caller
+	noRetries := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	noRetries.AddHook(RedisHook(new(Injector).FailEvery(1, nil)))
+	assert.ErrorIs(t, noRetries.Ping(ctx).Err(), io.EOF)
+}
+
+// countDriver serves "SELECT n", answering every query with n.
+type countDriver struct{}
+
+func (countDriver) Open(string) (driver.Conn, error) { return countConn{}, nil }
+
+type countConn struct{}
+
+func (countConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (countConn) Close() error                        { return nil }
+func (countConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+func (countConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return &countRows{}, nil
+}
+
+type countRows struct{ done bool }
+
+func (r *countRows) Columns() []string { return []string{"n"} }
+func (r *countRows) Close() error      { return nil }
+func (r *countRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(42)
+	return nil
+}
+
+type countConnector struct{}
+
+func (countConnector) Connect(context.Context) (driver.Conn, error) { return countConn{}, nil }
+func (countConnector) Driver() driver.Driver                        { return countDriver{} }
+
+func TestSQLRetriesBadConnections(t *testing.T) {
+	inj := new(Injector).FailEvery(2, driver.ErrBadConn)
+	db := sql.OpenDB(Connector(countConnector{}, inj))
+	defer db.Close()
+
+	for range 10 {
+		var n int
+		require.NoError(t, db.QueryRow("SELECT n").Scan(&n))
+		assert.Equal(t, 42, n)
+	}
+	assert.Positive(t, inj.Failures())
+
+	inj.FailEvery(1, nil)
+	assert.ErrorIs(t, db.QueryRow("SELECT n").Scan(new(int)), ErrInjected)
+}
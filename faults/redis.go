@@ -0,0 +1,60 @@
+package faults
+
+import (
+	"context"
+	"io"
+	"net"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisHook returns a go-redis hook that sends every write to a Redis
+// connection through inj. A failed write breaks the connection, and
+// ErrInjected is reported as io.EOF, so go-redis retries the command on a
+// new connection up to its MaxRetries. The commands go-redis sends to set
+// up a connection are writes too, so FailEvery needs a period longer than
+// the setup, or no connection ever comes up. Add the hook before the client is first used, as it
+// only wraps new connections:
+//
+//	rds.AddHook(faults.RedisHook(inj))
+func RedisHook(inj *Injector) redis.Hook {
+	return redisHook{inj: inj}
+}
+
+type redisHook struct {
+	inj *Injector
+}
+
+func (h redisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := next(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &redisConn{Conn: c, inj: h.inj}, nil
+	}
+}
+
+func (h redisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return next
+}
+
+func (h redisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+type redisConn struct {
+	net.Conn
+	inj *Injector
+}
+
+func (c *redisConn) Write(b []byte) (int, error) {
+	if err := c.inj.Call(context.Background()); err != nil {
+		c.Conn.Close()
+		if err == ErrInjected {
+			err = io.EOF
+		}
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
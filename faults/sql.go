@@ -0,0 +1,122 @@
+package faults
+
+import (
+	"context"
+	"database/sql/driver"
+)
+
+// Connector wraps a database/sql connector so that every query, exec,
+// prepare, transaction start and ping first goes through inj. Failing with
+// driver.ErrBadConn makes database/sql retry on another connection, as it
+// does when a connection breaks:
+//
+//	db := sql.OpenDB(faults.Connector(pq.NewConnector(dsn), inj))
+func Connector(next driver.Connector, inj *Injector) driver.Connector {
+	return &connector{next: next, inj: inj}
+}
+
+type connector struct {
+	next driver.Connector
+	inj  *Injector
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	next, err := c.next.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: next, inj: c.inj}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.next.Driver()
+}
+
+// conn forwards to the wrapped connection. Optional interfaces the wrapped
+// connection lacks report driver.ErrSkip, so database/sql falls back as it
+// would without the wrapper.
+type conn struct {
+	driver.Conn
+	inj *Injector
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.inj.Call(ctx); err != nil {
+		return nil, err
+	}
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.inj.Call(ctx); err != nil {
+		return nil, err
+	}
+	return q.QueryContext(ctx, query, args)
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.inj.Call(ctx); err != nil {
+		return nil, err
+	}
+	return e.ExecContext(ctx, query, args)
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := c.inj.Call(ctx); err != nil {
+		return nil, err
+	}
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if err := c.inj.Call(ctx); err != nil {
+		return err
+	}
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) CheckNamedValue(v *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(v)
+	}
+	return driver.ErrSkip
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log"
+	"runtime/debug"
+	"strconv"
+
+	"github.com/nesymno/run-tests-example/config"
+)
+
+// ballast is a large, never-touched allocation that raises the heap size
+// the GC paces against without costing resident memory.
+var ballast []byte
+
+// configureGC applies the GC_PERCENT, MEMORY_LIMIT_MB and MEMORY_BALLAST_MB
+// settings. They mirror GOGC/GOMEMLIMIT but live alongside the rest of the
+// app configuration so load-test profiles can set them in one place. They
+// are strings so that unset leaves the runtime's own default; config.Load
+// has checked that they are integers.
+func configureGC(cfg *config.Config) {
+	if cfg.GCPercent != "" {
+		percent, _ := strconv.Atoi(cfg.GCPercent)
+		debug.SetGCPercent(percent)
+		log.Printf("GC percent set to %d", percent)
+	}
+
+	if cfg.MemoryLimitMB != "" {
+		limit, _ := strconv.ParseInt(cfg.MemoryLimitMB, 10, 64)
+		debug.SetMemoryLimit(limit << 20)
+		log.Printf("Memory limit set to %d MiB", limit)
+	}
+
+	if cfg.MemoryBallastMB != "" {
+		size, _ := strconv.Atoi(cfg.MemoryBallastMB)
+		ballast = make([]byte, size<<20)
+		log.Printf("Allocated %d MiB memory ballast", size)
+	}
+}
@@ -0,0 +1,103 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nesymno/run-tests-example/metrics"
+)
+
+// ErrCircuitOpen is returned without making a request while a host's
+// circuit is open.
+var ErrCircuitOpen = errors.New("circuit open")
+
+var circuitOpens = metrics.NewCounterVec("app_http_client_circuit_opens_total",
+	"Times a host's circuit opened after consecutive outbound failures.", "host")
+
+// breaker is a per-host circuit breaker. After threshold consecutive
+// failures, connection errors or 5xx responses, a host's circuit opens and
+// requests to it fail fast for cooldown. Then a single trial request is let
+// through: success closes the circuit, failure opens it again.
+type breaker struct {
+	next      http.RoundTripper
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu    sync.Mutex
+	hosts map[string]*circuit
+}
+
+type circuit struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func newBreaker(next http.RoundTripper, threshold int, cooldown time.Duration) *breaker {
+	return &breaker{next: next, threshold: threshold, cooldown: cooldown, now: time.Now, hosts: map[string]*circuit{}}
+}
+
+func (b *breaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if err := b.allow(host); err != nil {
+		return nil, err
+	}
+
+	resp, err := b.next.RoundTrip(req)
+	// A request abandoned by its caller or refused by the host rules says
+	// nothing about the host
+	if errors.Is(err, context.Canceled) || errors.Is(err, ErrBlocked) {
+		b.release(host)
+		return resp, err
+	}
+	b.record(host, err != nil || resp.StatusCode >= 500)
+	return resp, err
+}
+
+func (b *breaker) allow(host string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.hosts[host]
+	if c == nil {
+		c = &circuit{}
+		b.hosts[host] = c
+	}
+	if c.openUntil.IsZero() {
+		return nil
+	}
+	if b.now().Before(c.openUntil) || c.probing {
+		return fmt.Errorf("%s: %w", host, ErrCircuitOpen)
+	}
+	c.probing = true
+	return nil
+}
+
+func (b *breaker) release(host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.hosts[host].probing = false
+}
+
+func (b *breaker) record(host string, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.hosts[host]
+	if !failed {
+		*c = circuit{}
+		return
+	}
+
+	c.failures++
+	if c.probing || c.failures >= b.threshold {
+		c.openUntil = b.now().Add(b.cooldown)
+		c.probing = false
+		circuitOpens.With(host).Inc()
+	}
+}
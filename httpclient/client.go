@@ -0,0 +1,150 @@
+// Package httpclient builds the HTTP client shared by every outbound call
+// the app makes. All calls go through one tuned Transport, so connections
+// to a downstream are pooled and kept alive across features, every attempt
+// is counted per host, requests safe to repeat are retried within a budget,
+// a per-host circuit breaker stops hammering a downstream that keeps
+// failing, and the trace headers and deadline of the inbound request are
+// passed on.
+package httpclient
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nesymno/run-tests-example/metrics"
+)
+
+var (
+	clientRequests = metrics.NewCounterVec("app_http_client_requests_total",
+		"Outbound HTTP requests by host and status code, or error.", "host", "code")
+	clientDuration = metrics.NewHistogramVec("app_http_client_request_duration_seconds",
+		"Outbound HTTP request latency by host.",
+		[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "host")
+)
+
+// Options tunes the shared client. Zero durations and limits mean no limit,
+// as in http.Transport.
+type Options struct {
+	// Timeout bounds a whole request, including reading the body.
+	Timeout               time.Duration
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	IdleConnTimeout       time.Duration
+
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+
+	// TLS configures outbound TLS; nil uses the system roots with TLS 1.2
+	// as the minimum version.
+	TLS *tls.Config
+
+	// BreakerThreshold is the number of consecutive failures that opens a
+	// host's circuit, 0 to disable circuit breaking. BreakerCooldown is how
+	// long the circuit stays open before a single trial request is let
+	// through.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// MaxRetries is the number of retries per request, 0 to disable
+	// retries. RetryBackoff is the base of the jittered exponential delay
+	// between attempts.
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// RetryBudgetRatio is the retries each request adds to its host's
+	// budget, which holds at most RetryBudgetBurst.
+	RetryBudgetRatio float64
+	RetryBudgetBurst float64
+
+	// Allow and Deny restrict the hosts connections are made to. Deny wins;
+	// with no Allow rules every host not denied is allowed.
+	Allow, Deny HostRules
+}
+
+// DefaultOptions returns the options used unless configured otherwise.
+func DefaultOptions() Options {
+	deny, _ := ParseHostRules(DefaultDeny)
+	return Options{
+		Timeout:               10 * time.Second,
+		DialTimeout:           5 * time.Second,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   16,
+		BreakerThreshold:      5,
+		BreakerCooldown:       30 * time.Second,
+		MaxRetries:            2,
+		RetryBackoff:          100 * time.Millisecond,
+		RetryBudgetRatio:      0.1,
+		RetryBudgetBurst:      10,
+		Deny:                  deny,
+	}
+}
+
+// New builds a client with opts.
+func New(opts Options) *http.Client {
+	tlsConfig := opts.TLS
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	dialer := &net.Dialer{Timeout: opts.DialTimeout, KeepAlive: 30 * time.Second}
+	guard := &guard{
+		allow:  opts.Allow,
+		deny:   opts.Deny,
+		dial:   dialer.DialContext,
+		lookup: net.DefaultResolver.LookupNetIP,
+		proxy:  http.ProxyFromEnvironment,
+	}
+	transport := &http.Transport{
+		Proxy:                 guard.Proxy,
+		DialContext:           guard.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   opts.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		MaxIdleConns:          opts.MaxIdleConns,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       opts.MaxConnsPerHost,
+		ForceAttemptHTTP2:     true,
+	}
+
+	// Retries wrap the instrumentation, so every attempt is counted
+	var rt http.RoundTripper = transport
+	if opts.BreakerThreshold > 0 {
+		rt = newBreaker(rt, opts.BreakerThreshold, opts.BreakerCooldown)
+	}
+	rt = instrumented{rt}
+	if opts.MaxRetries > 0 {
+		rt = newRetrier(rt, opts.MaxRetries, opts.RetryBackoff, opts.RetryBudgetRatio, opts.RetryBudgetBurst)
+	}
+	rt = propagating{rt}
+	return &http.Client{Timeout: opts.Timeout, Transport: rt}
+}
+
+// instrumented counts and times every request by host.
+type instrumented struct {
+	next http.RoundTripper
+}
+
+func (t instrumented) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	code := "error"
+	switch {
+	case errors.Is(err, ErrCircuitOpen):
+		code = "circuit_open"
+	case err == nil:
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	clientRequests.With(req.URL.Host, code).Inc()
+	clientDuration.With(req.URL.Host).Observe(time.Since(start).Seconds())
+	return resp, err
+}
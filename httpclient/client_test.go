@@ -0,0 +1,155 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testOptions are the defaults without the deny list, which blocks the
+// loopback test servers listen on.
+func testOptions() Options {
+	opts := DefaultOptions()
+	opts.Deny = HostRules{}
+	return opts
+}
+
+// flaky serves 503 while failing is set and 200 otherwise.
+func flaky(t *testing.T) (*httptest.Server, *atomic.Bool, *atomic.Int64) {
+	var failing atomic.Bool
+	var hits atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &failing, &hits
+}
+
+func get(t *testing.T, client *http.Client, url string) (int, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	srv, failing, hits := flaky(t)
+	opts := testOptions()
+	opts.BreakerThreshold = 3
+	opts.BreakerCooldown = time.Hour
+	opts.MaxRetries = 0
+	client := New(opts)
+
+	failing.Store(true)
+	for i := 0; i < 3; i++ {
+		code, err := get(t, client, srv.URL)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+	}
+
+	_, err := get(t, client, srv.URL)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, int64(3), hits.Load(), "an open circuit must not reach the host")
+
+	u, _ := url.Parse(srv.URL)
+	assert.Equal(t, uint64(1), circuitOpens.With(u.Host).Value())
+	assert.Equal(t, uint64(1), clientRequests.With(u.Host, "circuit_open").Value())
+	assert.Equal(t, uint64(3), clientRequests.With(u.Host, "503").Value())
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	srv, failing, _ := flaky(t)
+	opts := testOptions()
+	opts.BreakerThreshold = 2
+	opts.MaxRetries = 0
+	client := New(opts)
+
+	for i := 0; i < 3; i++ {
+		failing.Store(true)
+		_, err := get(t, client, srv.URL)
+		require.NoError(t, err)
+		failing.Store(false)
+		_, err = get(t, client, srv.URL)
+		require.NoError(t, err, "failures that are not consecutive never open the circuit")
+	}
+}
+
+type stubTransport struct {
+	status int
+	err    error
+	calls  int
+}
+
+func (s *stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &http.Response{StatusCode: s.status, Body: http.NoBody, Request: req}, nil
+}
+
+func TestBreakerHalfOpenTrial(t *testing.T) {
+	now := time.Unix(0, 0)
+	stub := &stubTransport{err: errors.New("connection refused")}
+	b := newBreaker(stub, 1, time.Minute)
+	b.now = func() time.Time { return now }
+	req := httptest.NewRequest("GET", "http://downstream/", nil)
+
+	_, err := b.RoundTrip(req)
+	require.Error(t, err)
+	_, err = b.RoundTrip(req)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+
+	// After the cooldown one trial goes through; its failure reopens the
+	// circuit for another cooldown
+	now = now.Add(time.Minute)
+	_, err = b.RoundTrip(req)
+	require.NotErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 2, stub.calls)
+	_, err = b.RoundTrip(req)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+
+	// A successful trial closes it
+	now = now.Add(time.Minute)
+	stub.err, stub.status = nil, http.StatusOK
+	_, err = b.RoundTrip(req)
+	require.NoError(t, err)
+	_, err = b.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, 4, stub.calls)
+}
+
+func TestBreakerIgnoresCanceledRequests(t *testing.T) {
+	stub := &stubTransport{err: context.Canceled}
+	b := newBreaker(stub, 1, time.Minute)
+	req := httptest.NewRequest("GET", "http://downstream/", nil)
+
+	for i := 0; i < 3; i++ {
+		_, err := b.RoundTrip(req)
+		require.ErrorIs(t, err, context.Canceled)
+	}
+	assert.Equal(t, 3, stub.calls, "canceled requests must not open the circuit")
+}
+
+func TestBreakerIsPerHost(t *testing.T) {
+	stub := &stubTransport{err: errors.New("connection refused")}
+	b := newBreaker(stub, 1, time.Minute)
+
+	_, err := b.RoundTrip(httptest.NewRequest("GET", "http://a/", nil))
+	require.Error(t, err)
+	_, err = b.RoundTrip(httptest.NewRequest("GET", "http://b/", nil))
+	require.NotErrorIs(t, err, ErrCircuitOpen, "one host's failures must not open another's circuit")
+}
@@ -0,0 +1,104 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Deadline headers. DeadlineHeader is an absolute RFC 3339 time;
+// TimeoutHeader is the time left, in the grpc-timeout format of up to 8
+// digits and a unit: H, M, S, m (milliseconds), u (microseconds) or n
+// (nanoseconds), such as "250m".
+const (
+	DeadlineHeader = "X-Request-Deadline"
+	TimeoutHeader  = "Grpc-Timeout"
+)
+
+var timeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// ParseDeadline returns the deadline an inbound request asks for with
+// either header, the earlier if it sends both, relative to now. It
+// reports false if the request sends neither.
+func ParseDeadline(h http.Header, now time.Time) (time.Time, bool, error) {
+	var deadline time.Time
+	if v := h.Get(DeadlineHeader); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid %s %q, want an RFC 3339 time", DeadlineHeader, v)
+		}
+		deadline = t
+	}
+	if v := h.Get(TimeoutHeader); v != "" {
+		d, err := parseTimeout(v)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		if t := now.Add(d); deadline.IsZero() || t.Before(deadline) {
+			deadline = t
+		}
+	}
+	return deadline, !deadline.IsZero(), nil
+}
+
+func parseTimeout(v string) (time.Duration, error) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, fmt.Errorf("invalid %s %q, want up to 8 digits and a unit", TimeoutHeader, v)
+	}
+	unit, ok := timeoutUnits[v[len(v)-1]]
+	n, err := strconv.ParseUint(v[:len(v)-1], 10, 64)
+	if !ok || err != nil {
+		return 0, fmt.Errorf("invalid %s %q, want up to 8 digits and a unit", TimeoutHeader, v)
+	}
+	// 8 digits of hours do not fit in a Duration
+	if n > math.MaxInt64/uint64(unit) {
+		return 0, fmt.Errorf("invalid %s %q, longer than %v", TimeoutHeader, v, time.Duration(math.MaxInt64))
+	}
+	return time.Duration(n) * unit, nil
+}
+
+type deadlineKey struct{}
+
+// PropagateDeadline returns a context done at deadline, whose outbound
+// requests tell the downstream the time left with DeadlineHeader and
+// TimeoutHeader, so that it can give up when the caller does.
+func PropagateDeadline(ctx context.Context, deadline time.Time) (context.Context, context.CancelFunc) {
+	return context.WithDeadline(context.WithValue(ctx, deadlineKey{}, true), deadline)
+}
+
+// setDeadline adds the deadline headers to req if its context propagates
+// a deadline, leaving headers the caller set alone. The deadline is the
+// context's, which may be earlier than the one propagated.
+func setDeadline(req *http.Request) {
+	propagate, _ := req.Context().Value(deadlineKey{}).(bool)
+	deadline, ok := req.Context().Deadline()
+	if !propagate || !ok {
+		return
+	}
+	if req.Header.Get(DeadlineHeader) == "" {
+		req.Header.Set(DeadlineHeader, deadline.UTC().Format(time.RFC3339Nano))
+	}
+	if req.Header.Get(TimeoutHeader) == "" {
+		req.Header.Set(TimeoutHeader, formatTimeout(time.Until(deadline)))
+	}
+}
+
+// formatTimeout formats d for TimeoutHeader in milliseconds, rounded up,
+// or in seconds when that takes more than 8 digits.
+func formatTimeout(d time.Duration) string {
+	ms := (max(d, 0) + time.Millisecond - 1) / time.Millisecond
+	if ms <= 99999999 {
+		return strconv.FormatInt(int64(ms), 10) + "m"
+	}
+	return strconv.FormatInt(int64(min(ms/1000+1, 99999999)), 10) + "S"
+}
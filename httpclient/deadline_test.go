@@ -0,0 +1,92 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDeadline(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	cases := []struct {
+		deadline, timeout string
+		want              time.Time
+		err               string
+	}{
+		{},
+		{deadline: "2026-01-02T03:04:06.5Z", want: now.Add(1500 * time.Millisecond)},
+		{deadline: "2026-01-02T05:04:06+02:00", want: now.Add(time.Second)},
+		{timeout: "250m", want: now.Add(250 * time.Millisecond)},
+		{timeout: "2S", want: now.Add(2 * time.Second)},
+		{timeout: "99999999u", want: now.Add(99999999 * time.Microsecond)},
+		{deadline: "2026-01-02T03:04:06Z", timeout: "100m", want: now.Add(100 * time.Millisecond)},
+		{deadline: "2026-01-02T03:04:06Z", timeout: "1H", want: now.Add(time.Second)},
+		{deadline: "in a second", err: `invalid X-Request-Deadline "in a second"`},
+		{timeout: "250", err: `invalid Grpc-Timeout "250"`},
+		{timeout: "250ms", err: `invalid Grpc-Timeout "250ms"`},
+		{timeout: "123456789m", err: `invalid Grpc-Timeout "123456789m"`},
+		{timeout: "-1S", err: `invalid Grpc-Timeout "-1S"`},
+		{timeout: "99999999H", err: `invalid Grpc-Timeout "99999999H", longer than`},
+	}
+	for _, c := range cases {
+		h := http.Header{}
+		if c.deadline != "" {
+			h.Set(DeadlineHeader, c.deadline)
+		}
+		if c.timeout != "" {
+			h.Set(TimeoutHeader, c.timeout)
+		}
+		deadline, ok, err := ParseDeadline(h, now)
+		if c.err != "" {
+			assert.ErrorContains(t, err, c.err)
+			continue
+		}
+		require.NoError(t, err, h)
+		assert.Equal(t, !c.want.IsZero(), ok, h)
+		assert.True(t, c.want.Equal(deadline), "%v: got %v, want %v", h, deadline, c.want)
+	}
+}
+
+func TestPropagatesDeadline(t *testing.T) {
+	srv, got := stub(t)
+	client := New(testOptions())
+	deadline := time.Now().Add(5 * time.Second)
+
+	// Deadlines the app sets itself are not passed on
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Empty(t, got.Get(DeadlineHeader))
+	assert.Empty(t, got.Get(TimeoutHeader))
+
+	ctx, cancel = PropagateDeadline(context.Background(), deadline)
+	defer cancel()
+	req, err = http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	require.NoError(t, err)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	sent, err := time.Parse(time.RFC3339Nano, got.Get(DeadlineHeader))
+	require.NoError(t, err)
+	assert.True(t, deadline.Equal(sent))
+	left, err := parseTimeout(got.Get(TimeoutHeader))
+	require.NoError(t, err)
+	assert.InDelta(t, 5*time.Second, left, float64(time.Second))
+	assert.Empty(t, req.Header.Get(DeadlineHeader), "the caller's request is not modified")
+}
+
+func TestFormatTimeout(t *testing.T) {
+	assert.Equal(t, "0m", formatTimeout(-time.Second))
+	assert.Equal(t, "1m", formatTimeout(time.Microsecond))
+	assert.Equal(t, "1500m", formatTimeout(1500*time.Millisecond))
+	assert.Equal(t, "100001S", formatTimeout(100000*time.Second))
+}
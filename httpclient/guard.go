@@ -0,0 +1,170 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/nesymno/run-tests-example/metrics"
+)
+
+// ErrBlocked is returned when an outbound connection's target is denied, or
+// not allowed, by the configured host rules.
+var ErrBlocked = errors.New("outbound connection blocked")
+
+var clientBlocked = metrics.NewCounter("app_http_client_blocked_total",
+	"Outbound connections refused by the allow and deny lists.")
+
+// DefaultDeny blocks the targets of server-side request forgery: loopback,
+// link-local addresses, which include cloud metadata endpoints, private
+// networks and unspecified addresses.
+const DefaultDeny = "127.0.0.0/8,::1/128,169.254.0.0/16,fe80::/10,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,fc00::/7,0.0.0.0/8,::/128"
+
+// HostRules matches outbound targets by address, CIDR or host name. A name
+// rule starting with "*." matches every subdomain of the rest.
+type HostRules struct {
+	prefixes []netip.Prefix
+	names    []string
+}
+
+// ParseHostRules parses a comma-separated list of IPs, CIDRs and host
+// names.
+func ParseHostRules(s string) (HostRules, error) {
+	var rules HostRules
+	for _, rule := range strings.Split(s, ",") {
+		rule = strings.ToLower(strings.TrimSpace(rule))
+		if rule == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(rule); err == nil {
+			rules.prefixes = append(rules.prefixes, prefix.Masked())
+			continue
+		}
+		if addr, err := netip.ParseAddr(rule); err == nil {
+			rules.prefixes = append(rules.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		if strings.ContainsAny(rule, "/:") || strings.Contains(strings.TrimPrefix(rule, "*."), "*") {
+			return HostRules{}, fmt.Errorf("invalid host rule %q", rule)
+		}
+		rules.names = append(rules.names, strings.TrimSuffix(rule, "."))
+	}
+	return rules, nil
+}
+
+// Empty reports whether there are no rules.
+func (r HostRules) Empty() bool {
+	return len(r.prefixes) == 0 && len(r.names) == 0
+}
+
+func (r HostRules) matchAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range r.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r HostRules) matchName(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for _, rule := range r.names {
+		if suffix, ok := strings.CutPrefix(rule, "*."); ok {
+			if strings.HasSuffix(name, "."+suffix) {
+				return true
+			}
+		} else if name == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// guard resolves and checks every outbound connection before dialing it.
+// Names are resolved here and the checked address is dialed, so a name
+// cannot pass the check and then resolve somewhere else. Deny rules win
+// over allow rules; with no allow rules, everything not denied is allowed.
+type guard struct {
+	allow, deny HostRules
+	dial        func(ctx context.Context, network, addr string) (net.Conn, error)
+	lookup      func(ctx context.Context, network, host string) ([]netip.Addr, error)
+	proxy       func(req *http.Request) (*url.URL, error)
+}
+
+func (g *guard) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := g.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, addr := range addrs {
+		conn, err := g.dial(ctx, network, net.JoinHostPort(addr.Unmap().String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Proxy returns the proxy for req, if any. A proxied request's target is
+// dialed by the proxy rather than by DialContext, so it is checked here
+// instead, and blocked unless one of its addresses is permitted. The
+// proxy may resolve it differently, which the check cannot prevent; it is
+// the proxy's own rules that stop that.
+func (g *guard) Proxy(req *http.Request) (*url.URL, error) {
+	proxy, err := g.proxy(req)
+	if proxy == nil || err != nil {
+		return proxy, err
+	}
+	if _, err := g.resolve(req.Context(), req.URL.Hostname()); err != nil {
+		return nil, err
+	}
+	return proxy, nil
+}
+
+// resolve returns the addresses of host the rules permit, or ErrBlocked if
+// there are none.
+func (g *guard) resolve(ctx context.Context, host string) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	allowedName := false
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{addr}
+	} else {
+		if g.deny.matchName(host) {
+			return nil, g.blocked(host)
+		}
+		allowedName = g.allow.matchName(host)
+		if addrs, err = g.lookup(ctx, "ip", host); err != nil {
+			return nil, err
+		}
+	}
+
+	var permitted []netip.Addr
+	for _, addr := range addrs {
+		if g.deny.matchAddr(addr) || (!g.allow.Empty() && !allowedName && !g.allow.matchAddr(addr)) {
+			continue
+		}
+		permitted = append(permitted, addr)
+	}
+	if len(permitted) == 0 {
+		return nil, g.blocked(host)
+	}
+	return permitted, nil
+}
+
+func (g *guard) blocked(host string) error {
+	clientBlocked.Inc()
+	return fmt.Errorf("%s: %w", host, ErrBlocked)
+}
@@ -0,0 +1,144 @@
+package httpclient
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHostRules(t *testing.T) {
+	rules, err := ParseHostRules(" 10.0.0.0/8, 192.168.1.7 ,api.example.com,*.svc.cluster.local.,")
+	require.NoError(t, err)
+
+	assert.True(t, rules.matchAddr(netip.MustParseAddr("10.1.2.3")))
+	assert.True(t, rules.matchAddr(netip.MustParseAddr("::ffff:10.1.2.3")), "mapped IPv4 matches IPv4 rules")
+	assert.True(t, rules.matchAddr(netip.MustParseAddr("192.168.1.7")))
+	assert.False(t, rules.matchAddr(netip.MustParseAddr("192.168.1.8")))
+
+	assert.True(t, rules.matchName("API.example.com."))
+	assert.False(t, rules.matchName("evil-api.example.com"))
+	assert.True(t, rules.matchName("redis.default.svc.cluster.local"))
+	assert.False(t, rules.matchName("svc.cluster.local"), "a wildcard only matches subdomains")
+
+	for _, bad := range []string{"10.0.0.0/33", "host:80", "a.*.com"} {
+		_, err := ParseHostRules(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+// dialRecorder stands in for the real dialer and records what is dialed.
+type dialRecorder struct {
+	dialed []string
+}
+
+func (d *dialRecorder) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.dialed = append(d.dialed, addr)
+	client, server := net.Pipe()
+	server.Close()
+	return client, nil
+}
+
+func resolver(names map[string][]string) func(context.Context, string, string) ([]netip.Addr, error) {
+	return func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+		var addrs []netip.Addr
+		for _, s := range names[host] {
+			addrs = append(addrs, netip.MustParseAddr(s))
+		}
+		return addrs, nil
+	}
+}
+
+func newGuard(t *testing.T, allow, deny string, names map[string][]string) (*guard, *dialRecorder) {
+	allowRules, err := ParseHostRules(allow)
+	require.NoError(t, err)
+	denyRules, err := ParseHostRules(deny)
+	require.NoError(t, err)
+	rec := &dialRecorder{}
+	return &guard{allow: allowRules, deny: denyRules, dial: rec.dial, lookup: resolver(names)}, rec
+}
+
+func TestGuardDeniesMetadataAndLoopback(t *testing.T) {
+	g, rec := newGuard(t, "", DefaultDeny, map[string][]string{
+		"metadata.internal": {"169.254.169.254"},
+		"rebind.example":    {"127.0.0.1"},
+		"mixed.example":     {"127.0.0.1", "203.0.113.5"},
+	})
+	ctx := context.Background()
+
+	for _, addr := range []string{"169.254.169.254:80", "[::1]:80", "metadata.internal:80", "rebind.example:443"} {
+		_, err := g.DialContext(ctx, "tcp", addr)
+		assert.ErrorIs(t, err, ErrBlocked, addr)
+	}
+	assert.Empty(t, rec.dialed)
+
+	conn, err := g.DialContext(ctx, "tcp", "mixed.example:443")
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []string{"203.0.113.5:443"}, rec.dialed, "only the permitted address is dialed")
+}
+
+func TestGuardAllowList(t *testing.T) {
+	g, rec := newGuard(t, "10.0.0.0/8,*.svc.cluster.local", "10.0.0.1", map[string][]string{
+		"api.default.svc.cluster.local": {"172.20.0.4"},
+		"example.com":                   {"93.184.216.34"},
+		"inside.example":                {"10.2.3.4"},
+	})
+	ctx := context.Background()
+
+	for _, addr := range []string{"api.default.svc.cluster.local:80", "inside.example:80", "10.9.9.9:80"} {
+		conn, err := g.DialContext(ctx, "tcp", addr)
+		require.NoError(t, err, addr)
+		conn.Close()
+	}
+	for _, addr := range []string{"example.com:80", "8.8.8.8:53", "10.0.0.1:80"} {
+		_, err := g.DialContext(ctx, "tcp", addr)
+		assert.ErrorIs(t, err, ErrBlocked, addr)
+	}
+	assert.Equal(t, []string{"172.20.0.4:80", "10.2.3.4:80", "10.9.9.9:80"}, rec.dialed)
+}
+
+func TestGuardChecksProxiedTargets(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+	}))
+	defer proxy.Close()
+
+	g, _ := newGuard(t, "", DefaultDeny, map[string][]string{
+		"example.com":       {"93.184.216.34"},
+		"metadata.internal": {"169.254.169.254"},
+	})
+	proxyURL, err := url.Parse(proxy.URL)
+	require.NoError(t, err)
+	g.proxy = http.ProxyURL(proxyURL)
+	client := &http.Client{Transport: &http.Transport{Proxy: g.Proxy}}
+
+	// The proxy is dialed, not the target, so only Proxy sees the target
+	for _, target := range []string{"http://169.254.169.254/", "http://metadata.internal/", "http://10.1.2.3/", "http://[fd00::1]/"} {
+		_, err := client.Get(target)
+		assert.ErrorIs(t, err, ErrBlocked, target)
+	}
+	resp, err := client.Get("http://example.com/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, []string{"http://example.com/"}, proxied)
+}
+
+func TestClientBlocksDeniedTargets(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { calls++ }))
+	defer srv.Close()
+
+	before := clientBlocked.Value()
+	_, err := New(DefaultOptions()).Get(srv.URL)
+	require.ErrorIs(t, err, ErrBlocked)
+	assert.Zero(t, calls)
+	assert.Equal(t, before+1, clientBlocked.Value(), "blocked requests are not retried")
+}
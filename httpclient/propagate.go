@@ -0,0 +1,85 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"slices"
+)
+
+// PropagatedHeaders are the inbound headers Propagate forwards on outbound
+// calls: W3C trace context, B3, AWS X-Ray and the request ID, so a trace
+// stays complete across every hop.
+var PropagatedHeaders = []string{
+	"Traceparent",
+	"Tracestate",
+	"X-Request-Id",
+	"X-B3-Traceid",
+	"X-B3-Spanid",
+	"X-B3-Parentspanid",
+	"X-B3-Sampled",
+	"B3",
+	"X-Amzn-Trace-Id",
+}
+
+type propagatedKey struct{}
+
+// Propagate returns a context whose outbound requests carry the
+// PropagatedHeaders found in inbound, ctx itself if there are none.
+func Propagate(ctx context.Context, inbound http.Header) context.Context {
+	var h http.Header
+	for _, name := range PropagatedHeaders {
+		if v := inbound.Values(name); len(v) > 0 {
+			if h == nil {
+				h = propagated(ctx).Clone()
+			}
+			if h == nil {
+				h = http.Header{}
+			}
+			h[http.CanonicalHeaderKey(name)] = slices.Clone(v)
+		}
+	}
+	if h == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, propagatedKey{}, h)
+}
+
+// WithHeader returns a context whose outbound requests also carry the
+// header name, for values the app resolves itself, such as the tenant.
+func WithHeader(ctx context.Context, name, value string) context.Context {
+	h := propagated(ctx).Clone()
+	if h == nil {
+		h = http.Header{}
+	}
+	h.Set(name, value)
+	return context.WithValue(ctx, propagatedKey{}, h)
+}
+
+func propagated(ctx context.Context) http.Header {
+	h, _ := ctx.Value(propagatedKey{}).(http.Header)
+	return h
+}
+
+// propagating adds the headers of the request context, and its deadline
+// if propagated, to every request, leaving headers the caller set alone.
+type propagating struct {
+	next http.RoundTripper
+}
+
+func (t propagating) RoundTrip(req *http.Request) (*http.Response, error) {
+	h := propagated(req.Context())
+	propagate, _ := req.Context().Value(deadlineKey{}).(bool)
+	if len(h) == 0 && !propagate {
+		return t.next.RoundTrip(req)
+	}
+
+	// A RoundTripper must not modify the caller's request
+	req = req.Clone(req.Context())
+	for name, values := range h {
+		if _, ok := req.Header[name]; !ok {
+			req.Header[name] = slices.Clone(values)
+		}
+	}
+	setDeadline(req)
+	return t.next.RoundTrip(req)
+}
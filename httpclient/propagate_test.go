@@ -0,0 +1,56 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stub records the headers of the last request it received.
+func stub(t *testing.T) (*httptest.Server, *http.Header) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &got
+}
+
+func TestPropagatesInboundTraceHeaders(t *testing.T) {
+	srv, got := stub(t)
+	client := New(testOptions())
+
+	inbound := http.Header{}
+	inbound.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	inbound.Set("X-Request-ID", "req-1")
+	inbound.Set("X-B3-TraceId", "80f198ee56343ba8")
+	inbound.Set("Authorization", "Bearer secret")
+	ctx := WithHeader(Propagate(context.Background(), inbound), "X-Tenant-ID", "7")
+
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Request-ID", "set-by-caller")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", got.Get("Traceparent"))
+	assert.Equal(t, "80f198ee56343ba8", got.Get("X-B3-TraceId"))
+	assert.Equal(t, "7", got.Get("X-Tenant-ID"))
+	assert.Equal(t, "set-by-caller", got.Get("X-Request-ID"), "headers the caller sets win")
+	assert.Empty(t, got.Get("Authorization"), "only trace headers are passed on")
+	assert.Empty(t, req.Header.Get("Traceparent"), "the caller's request is not modified")
+}
+
+func TestNoHeadersWithoutInboundRequest(t *testing.T) {
+	srv, got := stub(t)
+	status, err := get(t, New(testOptions()), srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Empty(t, got.Get("Traceparent"))
+	assert.Empty(t, got.Get("X-Tenant-ID"))
+}
@@ -0,0 +1,151 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nesymno/run-tests-example/metrics"
+)
+
+// IdempotencyKeyHeader marks a request as safe to retry whatever its
+// method: the downstream is expected to apply it at most once.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxRetryBackoff caps the delay between attempts.
+const maxRetryBackoff = 2 * time.Second
+
+var (
+	clientRetries = metrics.NewCounterVec("app_http_client_retries_total",
+		"Outbound HTTP requests retried, by host.", "host")
+	clientRetryBudgetExhausted = metrics.NewCounterVec("app_http_client_retry_budget_exhausted_total",
+		"Outbound HTTP retries skipped because the host's retry budget was spent, by host.", "host")
+)
+
+// retrier retries failed requests that are safe to repeat: those with an
+// idempotent method or an Idempotency-Key header, and a body that can be
+// replayed. Connection errors and 502, 503 and 504 responses are retried,
+// with jittered exponential backoff.
+//
+// Retries draw on a per-host budget that every request tops up by ratio,
+// holding at most burst retries. While a host is healthy the budget stays
+// full; when it fails persistently, retries are limited to a fraction of
+// the traffic instead of multiplying it.
+type retrier struct {
+	next       http.RoundTripper
+	maxRetries int
+	backoff    time.Duration
+	ratio      float64
+	burst      float64
+
+	mu      sync.Mutex
+	budgets map[string]float64
+}
+
+func newRetrier(next http.RoundTripper, maxRetries int, backoff time.Duration, ratio, burst float64) *retrier {
+	return &retrier{next: next, maxRetries: maxRetries, backoff: backoff, ratio: ratio, burst: burst, budgets: map[string]float64{}}
+}
+
+func (r *retrier) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	r.deposit(host)
+
+	retryable := isIdempotent(req) && (req.Body == nil || req.Body == http.NoBody || req.GetBody != nil)
+	for attempt := 0; ; attempt++ {
+		resp, err := r.next.RoundTrip(req)
+		if !retryable || attempt >= r.maxRetries || !shouldRetry(resp, err) {
+			return resp, err
+		}
+		if !r.withdraw(host) {
+			clientRetryBudgetExhausted.With(host).Inc()
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		if err := sleep(req.Context(), jitter(r.backoff, attempt)); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		clientRetries.With(host).Inc()
+	}
+}
+
+func (r *retrier) deposit(host string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	budget, ok := r.budgets[host]
+	if !ok {
+		budget = r.burst
+	}
+	r.budgets[host] = min(r.burst, budget+r.ratio)
+}
+
+func (r *retrier) withdraw(host string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.budgets[host] < 1 {
+		return false
+	}
+	r.budgets[host]--
+	return true
+}
+
+// isIdempotent reports whether repeating req has the same effect as
+// sending it once.
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return req.Header.Get(IdempotencyKeyHeader) != ""
+}
+
+// shouldRetry reports whether an attempt failed in a way another attempt
+// may not. The caller giving up, an open circuit and a blocked target are
+// final.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) &&
+			!errors.Is(err, ErrCircuitOpen) && !errors.Is(err, ErrBlocked)
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// jitter returns a random delay up to base doubled attempt times, capped at
+// maxRetryBackoff.
+func jitter(base time.Duration, attempt int) time.Duration {
+	limit := min(base<<attempt, maxRetryBackoff)
+	if limit <= 0 {
+		return 0
+	}
+	return rand.N(limit)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,137 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sequence answers with the given statuses in turn, then 200.
+type sequence struct {
+	statuses []int
+	bodies   []string
+}
+
+func (s *sequence) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		body, _ := io.ReadAll(req.Body)
+		s.bodies = append(s.bodies, string(body))
+	}
+	status := http.StatusOK
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	return &http.Response{StatusCode: status, Body: http.NoBody, Request: req}, nil
+}
+
+func TestRetryIdempotentRequests(t *testing.T) {
+	next := &sequence{statuses: []int{503, 502}}
+	r := newRetrier(next, 2, time.Millisecond, 0.1, 10)
+
+	resp, err := r.RoundTrip(httptest.NewRequest("GET", "http://retry-get/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, uint64(2), clientRetries.With("retry-get").Value())
+}
+
+func TestRetryGivesUpAfterMaxRetries(t *testing.T) {
+	next := &sequence{statuses: []int{503, 503, 503, 503}}
+	r := newRetrier(next, 2, time.Millisecond, 0.1, 10)
+
+	resp, err := r.RoundTrip(httptest.NewRequest("GET", "http://retry-max/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Len(t, next.statuses, 1, "one attempt plus two retries")
+}
+
+func TestRetrySkipsNonIdempotentRequests(t *testing.T) {
+	next := &sequence{statuses: []int{503}}
+	r := newRetrier(next, 2, time.Millisecond, 0.1, 10)
+
+	resp, err := r.RoundTrip(httptest.NewRequest("POST", "http://retry-post/", strings.NewReader("{}")))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "a POST without an idempotency key is never repeated")
+	assert.Len(t, next.bodies, 1)
+}
+
+func TestRetryReplaysBodyWithIdempotencyKey(t *testing.T) {
+	next := &sequence{statuses: []int{503}}
+	r := newRetrier(next, 2, time.Millisecond, 0.1, 10)
+
+	req, err := http.NewRequest("POST", "http://retry-key/", strings.NewReader(`{"a":1}`))
+	require.NoError(t, err)
+	req.Header.Set(IdempotencyKeyHeader, "k1")
+
+	resp, err := r.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{`{"a":1}`, `{"a":1}`}, next.bodies)
+}
+
+func TestRetryBudgetLimitsRetries(t *testing.T) {
+	next := &sequence{statuses: []int{503, 503, 503, 503, 503, 503}}
+	r := newRetrier(next, 5, time.Millisecond, 0.5, 2)
+
+	// The budget starts full at 2 retries and the request adds half a one
+	resp, err := r.RoundTrip(httptest.NewRequest("GET", "http://retry-budget/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, uint64(2), clientRetries.With("retry-budget").Value())
+	assert.Equal(t, uint64(1), clientRetryBudgetExhausted.With("retry-budget").Value())
+}
+
+type failing struct {
+	err   error
+	calls int
+}
+
+func (f *failing) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.calls++
+	return nil, f.err
+}
+
+func TestRetryStopsOnFinalErrors(t *testing.T) {
+	for _, err := range []error{context.Canceled, context.DeadlineExceeded, ErrCircuitOpen} {
+		next := &failing{err: err}
+		r := newRetrier(next, 2, time.Millisecond, 0.1, 10)
+		_, got := r.RoundTrip(httptest.NewRequest("GET", "http://retry-final/", nil))
+		assert.ErrorIs(t, got, err)
+		assert.Equal(t, 1, next.calls, "%v must not be retried", err)
+	}
+
+	next := &failing{err: errors.New("connection reset")}
+	r := newRetrier(next, 2, time.Millisecond, 0.1, 10)
+	_, err := r.RoundTrip(httptest.NewRequest("GET", "http://retry-final/", nil))
+	assert.Error(t, err)
+	assert.Equal(t, 3, next.calls, "connection errors are retried")
+}
+
+func TestClientRetriesCountEveryAttempt(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	opts := testOptions()
+	opts.RetryBackoff = time.Millisecond
+	code, err := get(t, New(opts), srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	u, _ := url.Parse(srv.URL)
+	assert.Equal(t, uint64(1), clientRequests.With(u.Host, "503").Value())
+	assert.Equal(t, uint64(1), clientRequests.With(u.Host, "200").Value())
+}
@@ -0,0 +1,351 @@
+// Package jsonpolicy renders JSON responses under a field naming and null
+// policy. Response types declare snake_case names in their json tags;
+// Rewrite renames the fields of those types, and of Fields, to another
+// convention without touching map keys or opaque values, which are data.
+package jsonpolicy
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Naming conventions for field names.
+const (
+	SnakeCase = "snake_case"
+	CamelCase = "camelCase"
+)
+
+// Null policies.
+const (
+	// NullsKeep leaves null fields as the types declare them.
+	NullsKeep = "keep"
+	// NullsOmit drops every null field from objects.
+	NullsOmit = "omit"
+)
+
+// Request headers overriding the configured policy for one response.
+const (
+	NamingHeader = "X-JSON-Naming"
+	NullsHeader  = "X-JSON-Nulls"
+)
+
+// The headers in canonical form, which looks them up on every response
+// without canonicalizing them.
+var (
+	namingHeaderKey = http.CanonicalHeaderKey(NamingHeader)
+	nullsHeaderKey  = http.CanonicalHeaderKey(NullsHeader)
+)
+
+// Policy is how a response is rendered. The zero value renders it exactly
+// as encoding/json does.
+type Policy struct {
+	Naming string
+	Nulls  string
+}
+
+// Parse validates a naming and a null policy, empty for the defaults.
+func Parse(naming, nulls string) (Policy, error) {
+	p := Policy{Naming: naming, Nulls: nulls}
+	switch naming {
+	case "", SnakeCase, CamelCase:
+	default:
+		return Policy{}, fmt.Errorf("unknown JSON naming %q (use %s or %s)", naming, SnakeCase, CamelCase)
+	}
+	switch nulls {
+	case "", NullsKeep, NullsOmit:
+	default:
+		return Policy{}, fmt.Errorf("unknown JSON null policy %q (use %s or %s)", nulls, NullsKeep, NullsOmit)
+	}
+	return p, nil
+}
+
+// ForRequest returns def with the overrides of r's NamingHeader and
+// NullsHeader. Unknown values are ignored.
+func ForRequest(r *http.Request, def Policy) Policy {
+	p, err := Parse(r.Header.Get(namingHeaderKey), r.Header.Get(nullsHeaderKey))
+	if err != nil {
+		return def
+	}
+	if p.Naming != "" {
+		def.Naming = p.Naming
+	}
+	if p.Nulls != "" {
+		def.Nulls = p.Nulls
+	}
+	return def
+}
+
+// passthrough reports whether the policy leaves encoding/json output as
+// it is.
+func (p Policy) passthrough() bool {
+	return p.Naming != CamelCase && p.Nulls != NullsOmit
+}
+
+func (p Policy) name(field string) string {
+	if p.Naming == CamelCase {
+		return toCamel(field)
+	}
+	return field
+}
+
+// Fields is an ad hoc response object. Unlike other maps, its keys are
+// field names, renamed by the policy like struct fields.
+type Fields map[string]any
+
+// Encode writes v to w as json.Encoder does, under the policy. Nothing is
+// written if v cannot be encoded.
+func Encode(w io.Writer, v any, p Policy) error {
+	b, err := Marshal(v, p)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// Marshal returns the encoding of v under the policy. The values of a
+// Fields are rewritten by their dynamic types. Unlike json.Marshal, it
+// encodes NaN and ±Inf as null and replaces invalid UTF-8 in
+// json.RawMessage values, so only values of unsupported types, or whose
+// own MarshalJSON fails, cannot be encoded.
+func Marshal(v any, p Policy) ([]byte, error) {
+	fields, ok := v.(Fields)
+	if !ok || fields == nil {
+		b, err := marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return Rewrite(b, reflect.TypeOf(v), p)
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for _, k := range keys {
+		value, err := Marshal(fields[k], p)
+		if err != nil {
+			return nil, err
+		}
+		if p.Nulls == NullsOmit && string(value) == "null" {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(p.name(k))
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Rewrite applies the policy to data, the encoding/json output for a
+// value of type t.
+func Rewrite(data []byte, t reflect.Type, p Policy) ([]byte, error) {
+	if p.passthrough() {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	if err := rewrite(&buf, data, t, p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var (
+	fieldsType        = reflect.TypeFor[Fields]()
+	marshalerType     = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// opaque reports whether values of t are copied verbatim: values that
+// encode themselves, and values of unknown type.
+func opaque(t reflect.Type) bool {
+	if t == nil || t.Kind() == reflect.Interface {
+		return true
+	}
+	for _, t := range []reflect.Type{t, reflect.PointerTo(t)} {
+		if t.Implements(marshalerType) || t.Implements(textMarshalerType) {
+			return true
+		}
+	}
+	return false
+}
+
+func rewrite(buf *bytes.Buffer, data []byte, t reflect.Type, p Policy) error {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t != fieldsType && opaque(t) || bytes.Equal(data, []byte("null")) {
+		buf.Write(data)
+		return nil
+	}
+
+	switch {
+	case t == fieldsType:
+		return rewriteObject(buf, data, p, func(key string) (string, reflect.Type, bool) {
+			return p.name(key), nil, true
+		})
+	case t.Kind() == reflect.Struct:
+		fields := structFields(t)
+		return rewriteObject(buf, data, p, func(key string) (string, reflect.Type, bool) {
+			ft, ok := fields[key]
+			if !ok {
+				return key, nil, false
+			}
+			return p.name(key), ft, true
+		})
+	case t.Kind() == reflect.Map:
+		return rewriteObject(buf, data, p, func(key string) (string, reflect.Type, bool) {
+			return key, t.Elem(), false
+		})
+	case (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() != reflect.Uint8:
+		return rewriteArray(buf, data, t.Elem(), p)
+	}
+	buf.Write(data)
+	return nil
+}
+
+// rewriteObject copies a JSON object, renaming its keys and rewriting its
+// values as field says. Null values of fields are dropped under NullsOmit.
+func rewriteObject(buf *bytes.Buffer, data []byte, p Policy, field func(key string) (name string, t reflect.Type, isField bool)) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	buf.WriteByte('{')
+	first := true
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+
+		name, t, isField := field(key)
+		if isField && p.Nulls == NullsOmit && string(value) == "null" {
+			continue
+		}
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		// Names are re-encoded like encoding/json would
+		b, _ := json.Marshal(name)
+		buf.Write(b)
+		buf.WriteByte(':')
+		if err := rewrite(buf, value, t, p); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+func rewriteArray(buf *bytes.Buffer, data []byte, elem reflect.Type, p Policy) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	buf.WriteByte('[')
+	for i := 0; dec.More(); i++ {
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := rewrite(buf, value, elem, p); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(']')
+	return nil
+}
+
+var fieldCache sync.Map // reflect.Type -> map[string]reflect.Type
+
+// structFields maps the JSON names of t's fields, including those promoted
+// from embedded structs, to their types.
+func structFields(t reflect.Type) map[string]reflect.Type {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.(map[string]reflect.Type)
+	}
+	fields := map[string]reflect.Type{}
+	collectFields(t, fields)
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+func collectFields(t reflect.Type, fields map[string]reflect.Type) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			collectFields(ft, fields)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		// Fields of the outer struct win over promoted ones
+		if _, ok := fields[name]; !ok {
+			fields[name] = f.Type
+		}
+	}
+}
+
+// toCamel turns a snake_case name into camelCase, leaving other names
+// alone.
+func toCamel(name string) string {
+	if !strings.Contains(name, "_") {
+		return name
+	}
+	var b strings.Builder
+	upper := false
+	for i, r := range name {
+		switch {
+		case r == '_' && i > 0:
+			upper = true
+		case upper:
+			b.WriteRune(unicode.ToUpper(r))
+			upper = false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,192 @@
+package jsonpolicy
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"math"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/types"
+	"github.com/nesymno/run-tests-example/worker"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+var (
+	goldenTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	goldenID   = 7
+)
+
+// goldenValues cover renamed fields, embedded structs, maps whose keys are
+// data, opaque payloads and null fields.
+var goldenValues = map[string]any{
+	"test_data": []types.TestData{{
+		ID: 1, Name: "row", Data: "payload", Tags: []string{"smoke"}, Status: types.StatusActive,
+		CreatedAt: goldenTime, UpdatedAt: goldenTime, TestRunID: "run-1",
+		Comments: []types.Comment{{ID: 2, DataID: 1, Body: "looks good", CreatedAt: goldenTime}},
+	}, {
+		ID: 2, Name: "untagged", Data: "", Status: types.StatusArchived,
+	}},
+	"apikey_credentials": types.APIKeyCredentials{
+		APIKey: types.APIKey{ID: 3, Name: "ci", Prefix: "rte_ab", CreatedAt: goldenTime, ReplacedBy: &goldenID},
+		Key:    "rte_abcdef",
+	},
+	"apikey_request": types.APIKeyRequest{Name: "ci"},
+	"request_info": types.RequestInfo{
+		Method: "GET", URL: "/debug/request", Proto: "HTTP/1.1", Host: "localhost",
+		RemoteAddr: "127.0.0.1:5000", ClientIP: "127.0.0.1",
+		Headers: map[string][]string{"X_Snake_Header": {"kept"}},
+	},
+	"job": worker.Job{
+		ID: "job-1", Type: "insert", Payload: json.RawMessage(`{"test_run_id":"kept"}`),
+		Status: "queued", CreatedAt: goldenTime, UpdatedAt: goldenTime,
+	},
+	"fields": Fields{
+		"job_id":   "job-1",
+		"last_run": &types.QueueReport{Queues: []types.QueueStatus{{Name: "jobs", OldestAgeSeconds: 1.5}}},
+		"missing":  nil,
+	},
+}
+
+var goldenPolicies = map[string]Policy{
+	"default":   {},
+	"camel":     {Naming: CamelCase},
+	"omit":      {Nulls: NullsOmit},
+	"camelomit": {Naming: CamelCase, Nulls: NullsOmit},
+}
+
+func TestGolden(t *testing.T) {
+	for name, v := range goldenValues {
+		for policyName, p := range goldenPolicies {
+			t.Run(name+"/"+policyName, func(t *testing.T) {
+				var buf bytes.Buffer
+				require.NoError(t, Encode(&buf, v, p))
+
+				path := filepath.Join("testdata", name+"."+policyName+".golden")
+				if *update {
+					require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+				}
+				want, err := os.ReadFile(path)
+				require.NoError(t, err, "run go test ./jsonpolicy -update to create it")
+				assert.Equal(t, string(want), buf.String())
+			})
+		}
+	}
+}
+
+func TestDefaultPolicyMatchesEncodingJSON(t *testing.T) {
+	for name, v := range goldenValues {
+		var got, want bytes.Buffer
+		require.NoError(t, Encode(&got, v, Policy{Naming: SnakeCase, Nulls: NullsKeep}))
+		require.NoError(t, json.NewEncoder(&want).Encode(v))
+		assert.Equal(t, want.String(), got.String(), name)
+	}
+}
+
+func TestParse(t *testing.T) {
+	p, err := Parse(CamelCase, NullsOmit)
+	require.NoError(t, err)
+	assert.Equal(t, Policy{Naming: CamelCase, Nulls: NullsOmit}, p)
+
+	_, err = Parse("kebab-case", "")
+	assert.Error(t, err)
+	_, err = Parse("", "drop")
+	assert.Error(t, err)
+}
+
+func TestForRequest(t *testing.T) {
+	def := Policy{Naming: CamelCase}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	assert.Equal(t, def, ForRequest(r, def))
+
+	r.Header.Set(NamingHeader, SnakeCase)
+	r.Header.Set(NullsHeader, NullsOmit)
+	assert.Equal(t, Policy{Naming: SnakeCase, Nulls: NullsOmit}, ForRequest(r, def))
+
+	r.Header.Set(NamingHeader, "kebab-case")
+	assert.Equal(t, def, ForRequest(r, def), "invalid overrides are ignored")
+}
+
+func TestToCamel(t *testing.T) {
+	for in, want := range map[string]string{
+		"id":                    "id",
+		"test_run_id":           "testRunId",
+		"throughput_per_second": "throughputPerSecond",
+		"_private":              "_private",
+		"alreadyCamel":          "alreadyCamel",
+	} {
+		assert.Equal(t, want, toCamel(in), in)
+	}
+}
+
+func TestMarshalSanitizesFloatSpecials(t *testing.T) {
+	type inner struct {
+		Rate float64 `json:"rate"`
+	}
+	type report struct {
+		inner
+		Name    string             `json:"name"`
+		Ratio   float64            `json:"ratio,omitempty"`
+		Rates   []float64          `json:"rates"`
+		ByQueue map[string]float64 `json:"by_queue"`
+		Skipped *inner             `json:"skipped"`
+	}
+	v := report{
+		inner:   inner{Rate: math.NaN()},
+		Name:    "lag",
+		Rates:   []float64{1.5, math.Inf(1), math.Inf(-1)},
+		ByQueue: map[string]float64{"b": math.NaN(), "a": 2},
+	}
+
+	b, err := Marshal(v, Policy{})
+	require.NoError(t, err)
+	assert.Equal(t, `{"rate":null,"name":"lag","rates":[1.5,null,null],"by_queue":{"a":2,"b":null},"skipped":null}`, string(b))
+
+	b, err = Marshal(Fields{"oldest_age_seconds": math.Inf(1), "queue": v.inner}, Policy{Naming: CamelCase, Nulls: NullsOmit})
+	require.NoError(t, err)
+	assert.Equal(t, `{"queue":{}}`, string(b))
+}
+
+func TestEncodeSafeMatchesEncodingJSON(t *testing.T) {
+	// The fallback must agree with encoding/json on everything but float
+	// specials
+	for name, v := range goldenValues {
+		want, err := json.Marshal(v)
+		require.NoError(t, err)
+
+		var got bytes.Buffer
+		require.NoError(t, encodeSafe(&got, reflect.ValueOf(v)), name)
+		assert.Equal(t, string(want), got.String(), name)
+	}
+}
+
+func TestMarshalReplacesInvalidUTF8(t *testing.T) {
+	b, err := Marshal(worker.Job{ID: "job-1", Payload: json.RawMessage("\"bad \xff byte\"")}, Policy{})
+	require.NoError(t, err)
+	assert.True(t, utf8.Valid(b))
+	assert.Contains(t, string(b), `"payload":"bad `+"�"+` byte"`)
+
+	// encoding/json already does so for strings
+	b, err = Marshal("bad \xff byte", Policy{})
+	require.NoError(t, err)
+	assert.Equal(t, `"bad � byte"`, string(b))
+}
+
+func TestEncodeWritesNothingOnError(t *testing.T) {
+	var buf bytes.Buffer
+	err := Encode(&buf, Fields{"a": 1, "z": make(chan int)}, Policy{})
+	var unsupported *json.UnsupportedTypeError
+	assert.ErrorAs(t, err, &unsupported)
+	assert.Zero(t, buf.Len())
+}
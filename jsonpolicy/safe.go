@@ -0,0 +1,270 @@
+package jsonpolicy
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// marshal is json.Marshal that does not fail on float specials and never
+// returns invalid UTF-8. encoding/json rejects NaN and ±Inf, which are
+// encoded as null instead, and copies json.RawMessage values verbatim,
+// whose invalid bytes are replaced by U+FFFD. Both can only occur inside
+// strings, where U+FFFD is valid.
+func marshal(v any) ([]byte, error) {
+	b, err := json.Marshal(v)
+	var unsupported *json.UnsupportedValueError
+	if errors.As(err, &unsupported) {
+		var buf bytes.Buffer
+		err = encodeSafe(&buf, reflect.ValueOf(v))
+		b = buf.Bytes()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !utf8.Valid(b) {
+		b = bytes.ToValidUTF8(b, []byte("\uFFFD"))
+	}
+	return b, nil
+}
+
+// encodeSafe encodes v as encoding/json does, except for float specials.
+// It only runs once json.Marshal has failed, so it favors being simple
+// over being fast.
+func encodeSafe(buf *bytes.Buffer, v reflect.Value) error {
+	if !v.IsValid() {
+		buf.WriteString("null")
+		return nil
+	}
+	if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() {
+		buf.WriteString("null")
+		return nil
+	}
+
+	// Types that encode themselves cannot hold a float special the way
+	// json.Marshal rejects it, so they are left to it
+	t := v.Type()
+	if t.Implements(marshalerType) || t.Implements(textMarshalerType) ||
+		reflect.PointerTo(t).Implements(marshalerType) || reflect.PointerTo(t).Implements(textMarshalerType) {
+		if v.CanAddr() {
+			v = v.Addr()
+		}
+		b, err := json.Marshal(v.Interface())
+		buf.Write(b)
+		return err
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return encodeSafe(buf, v.Elem())
+	case reflect.Float32, reflect.Float64:
+		if f := v.Float(); math.IsNaN(f) || math.IsInf(f, 0) {
+			buf.WriteString("null")
+			return nil
+		}
+	case reflect.Struct:
+		return encodeStruct(buf, v)
+	case reflect.Map:
+		return encodeMap(buf, v)
+	case reflect.Slice:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		fallthrough
+	case reflect.Array:
+		buf.WriteByte('[')
+		for i := range v.Len() {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeSafe(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	}
+
+	b, err := json.Marshal(v.Interface())
+	buf.Write(b)
+	return err
+}
+
+func encodeStruct(buf *bytes.Buffer, v reflect.Value) error {
+	buf.WriteByte('{')
+	first := true
+	for _, f := range safeFields(v.Type()) {
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok || f.omitEmpty && isEmpty(fv) || f.omitZero && fv.IsZero() {
+			continue
+		}
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		name, _ := json.Marshal(f.name)
+		buf.Write(name)
+		buf.WriteByte(':')
+		if err := encodeSafe(buf, fv); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+func encodeMap(buf *bytes.Buffer, v reflect.Value) error {
+	if v.IsNil() {
+		buf.WriteString("null")
+		return nil
+	}
+
+	type entry struct {
+		key   string
+		value reflect.Value
+	}
+	var entries []entry
+	for iter := v.MapRange(); iter.Next(); {
+		key, err := mapKey(iter.Key())
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{key, iter.Value()})
+	}
+	slices.SortFunc(entries, func(a, b entry) int { return strings.Compare(a.key, b.key) })
+
+	buf.WriteByte('{')
+	for i, e := range entries {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(e.key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		if err := encodeSafe(buf, e.value); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// mapKey renders a map key as encoding/json does.
+func mapKey(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		b, err := tm.MarshalText()
+		return string(b), err
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+	return "", &json.UnsupportedTypeError{Type: k.Type()}
+}
+
+// fieldByIndex is v.FieldByIndex, reporting false where it passes a nil
+// embedded pointer, whose fields encoding/json leaves out.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// isEmpty is the omitempty test of encoding/json.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
+
+// safeField is a field encodeStruct writes.
+type safeField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+	omitZero  bool
+}
+
+// safeFields lists the encoded fields of t in order, with the fields of
+// embedded structs promoted in their place. Fields of the outer struct win
+// over promoted ones of the same name.
+func safeFields(t reflect.Type) []safeField {
+	var fields []safeField
+	seen := map[string]bool{}
+	var collect func(t reflect.Type, index []int)
+	collect = func(t reflect.Type, index []int) {
+		var embedded []reflect.StructField
+		for i := range t.NumField() {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+				embedded = append(embedded, f)
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			fields = append(fields, safeField{
+				name:      name,
+				index:     append(slices.Clone(index), i),
+				omitEmpty: strings.Contains(opts, "omitempty"),
+				omitZero:  strings.Contains(opts, "omitzero"),
+			})
+		}
+		for _, f := range embedded {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			collect(ft, append(slices.Clone(index), f.Index...))
+		}
+	}
+	collect(t, nil)
+
+	slices.SortStableFunc(fields, func(a, b safeField) int { return slices.Compare(a.index, b.index) })
+	return fields
+}
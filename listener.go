@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFdsStart is the first inherited descriptor under the systemd
+// socket-activation convention (0-2 are stdio).
+const listenFdsStart = 3
+
+// listen returns the socket the server accepts connections on. A socket
+// handed over by a supervisor through LISTEN_FDS/LISTEN_PID is preferred so
+// the process can be replaced in place; otherwise a fresh socket is bound,
+// with SO_REUSEPORT when reusePort is set so old and new processes can
+// share the port while the old one drains.
+func listen(port string, reusePort bool) (net.Listener, error) {
+	if ln, err := inheritedListener(); ln != nil || err != nil {
+		return ln, err
+	}
+
+	lc := net.ListenConfig{}
+	if reusePort {
+		lc.Control = reusePortControl
+	}
+	return lc.Listen(context.Background(), "tcp", ":"+port)
+}
+
+// inheritedListener returns the first socket passed via LISTEN_FDS, or nil
+// when no sockets were handed to this process.
+func inheritedListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+
+	// Don't leak the handover to child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+
+	f := os.NewFile(uintptr(listenFdsStart), "listener")
+	defer f.Close()
+
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use inherited listener: %v", err)
+	}
+	return ln, nil
+}
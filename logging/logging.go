@@ -0,0 +1,59 @@
+// Package logging holds the process-wide log level, which can be changed
+// at runtime. Once Setup has run, lines written with the standard log
+// package are logged at info level, so raising the level to warn silences
+// them and lowering it to debug adds the debug lines.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+var level = new(slog.LevelVar)
+
+// Setup makes a text handler writing to w at the current level the default
+// for both slog and the log package.
+func Setup(w io.Writer) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: level})))
+}
+
+// Level returns the current level.
+func Level() slog.Level {
+	return level.Level()
+}
+
+// SetLevel changes the level of every logger set up by Setup.
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// ParseLevel parses debug, info, warn or error, in any case.
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level %q, want debug, info, warn or error", s)
+}
+
+// Name returns the name ParseLevel accepts for l.
+func Name(l slog.Level) string {
+	return strings.ToLower(l.String())
+}
+
+// Step moves the level by steps: negative steps log more, positive steps
+// less, staying between debug and error.
+func Step(steps int) slog.Level {
+	l := level.Level() + slog.Level(4*steps)
+	l = max(slog.LevelDebug, min(slog.LevelError, l))
+	level.Set(l)
+	return l
+}
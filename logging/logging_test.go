@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLevel(t *testing.T) {
+	for _, name := range []string{"debug", "info", "warn", "error"} {
+		l, err := ParseLevel(name)
+		require.NoError(t, err)
+		assert.Equal(t, name, Name(l))
+	}
+	l, err := ParseLevel(" WARN ")
+	require.NoError(t, err)
+	assert.Equal(t, slog.LevelWarn, l)
+
+	_, err = ParseLevel("verbose")
+	assert.Error(t, err)
+}
+
+func TestStepStaysInRange(t *testing.T) {
+	defer SetLevel(Level())
+
+	SetLevel(slog.LevelInfo)
+	assert.Equal(t, slog.LevelDebug, Step(-1))
+	assert.Equal(t, slog.LevelDebug, Step(-1), "debug is the most verbose level")
+	assert.Equal(t, slog.LevelWarn, Step(2))
+	assert.Equal(t, slog.LevelError, Step(5))
+}
+
+func TestLevelFiltersStandardLogger(t *testing.T) {
+	defer SetLevel(Level())
+	var buf bytes.Buffer
+	Setup(&buf)
+
+	SetLevel(slog.LevelWarn)
+	log.Printf("hidden")
+	slog.Warn("shown")
+	SetLevel(slog.LevelDebug)
+	slog.Debug("debug line")
+	log.Printf("info line")
+
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), "shown")
+	assert.Contains(t, buf.String(), "debug line")
+	assert.Contains(t, buf.String(), "info line")
+}
@@ -2,118 +2,650 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/redis/go-redis/v9"
 
 	"github.com/nesymno/run-tests-example/app"
+	"github.com/nesymno/run-tests-example/cache"
+	"github.com/nesymno/run-tests-example/config"
+	"github.com/nesymno/run-tests-example/encryption"
+	"github.com/nesymno/run-tests-example/events"
+	"github.com/nesymno/run-tests-example/httpclient"
+	"github.com/nesymno/run-tests-example/jsonpolicy"
+	"github.com/nesymno/run-tests-example/logging"
+	"github.com/nesymno/run-tests-example/redact"
+	"github.com/nesymno/run-tests-example/satoken"
+	"github.com/nesymno/run-tests-example/servertiming"
+	"github.com/nesymno/run-tests-example/types"
+	"github.com/nesymno/run-tests-example/worker"
 )
 
 func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	if err := config.Apply(); err != nil {
+		log.Fatal(err)
 	}
 
-	// Initialize database connections
-	app, err := initApp()
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	cfg, settings, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Every log line passes the redactor, whichever code wrote it
+	redactor, err := redact.New(cfg.RedactPatterns, cfg.RedactParams)
+	if err != nil {
+		log.Fatalf("Invalid redaction settings: %v", err)
+	}
+	logLevel, err := logging.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logging.SetLevel(logLevel)
+	logging.Setup(redactor.Writer(os.Stderr))
+
+	configureGC(cfg)
+	router := app.NewRouter(http.DefaultServeMux)
+	router.Redactor = redactor
+	paths, err := app.ParsePathPolicy(cfg.PathTrailingSlash, cfg.PathCollapseSlashes, cfg.PathCase)
 	if err != nil {
+		log.Fatalf("Invalid path policy: %v", err)
+	}
+	router.Paths = paths
+
+	// The port is bound before the dependencies answer, so that probes
+	// tell a replica that is starting from one that is gone
+	ln, err := listen(cfg.Port, cfg.ReusePort)
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
+	handler := &handoff{}
+	handler.Set(app.StartingHandler(config.Profile()))
+	server := &http.Server{
+		Handler:      handler,
+		ReadTimeout:  time.Duration(cfg.HTTPReadTimeoutSeconds) * time.Second,
+		WriteTimeout: time.Duration(cfg.HTTPWriteTimeoutSeconds) * time.Second,
+		IdleTimeout:  time.Duration(cfg.HTTPIdleTimeoutSeconds) * time.Second,
+	}
+
+	// SIGTERM, as sent on pod termination, or SIGINT drains the server and
+	// stops the background loops before the connections are closed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, server, ln, time.Duration(cfg.ShutdownTimeoutSeconds)*time.Second)
+	}()
+	log.Printf("Starting server on %s (profile %q)", ln.Addr(), config.Profile())
+
+	// Initialize database connections, waiting for them to answer
+	app, err := initApp(ctx, cfg, settings)
+	if err != nil {
+		if ctx.Err() != nil {
+			<-served
+			log.Print("Shut down while starting")
+			return
+		}
 		log.Fatalf("Failed to initialize app: %v", err)
 	}
-	defer app.DB.Close()
-	defer app.Rds.Close()
+	router.JSON = app.JSON
+	app.DefaultLogLevel = logLevel
+	app.Release = releaseID(cfg.Release)
 
-	// Setup HTTP handlers
-	http.HandleFunc("/health", app.HealthHandler)
-	http.HandleFunc("/api/data", app.DataHandler)
-	http.HandleFunc("/api/cache", app.CacheHandler)
-	http.HandleFunc("/", app.RootHandler)
+	loops := &background{ctx: ctx}
+	loops.Go(app.RunLogLevelSync)
+	handleLogLevelSignals()
 
-	log.Printf("Starting server on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
-}
+	// Start background workers
+	app.RegisterJobs()
+	app.Jobs.RegisterMetrics()
+	loops.Go(func(ctx context.Context) { app.Jobs.Run(ctx, cfg.WorkerConcurrency) })
+	loops.Go(app.Schedules.Run)
+	if app.Batch != nil {
+		app.Batch.RegisterMetrics()
+		loops.Go(app.Batch.Run)
+	}
+	loops.Go(app.RunRetention)
+	loops.Go(app.RunCacheVerify)
+	if app.Concurrency.Max > 0 {
+		app.RegisterConcurrencyMetrics()
+		loops.Go(app.RunConcurrencyLimit)
+	}
+	loops.Go(app.RunWarmConnections)
+	loops.Go(app.RunHealthChecks)
+	loops.Go(app.RunSnapshots)
+	if app.Events != nil {
+		app.Events.RegisterMetrics()
+		loops.Go(app.RunEvents)
+	}
+	if cfg.PartitionTestData {
+		loops.Go(app.RunPartitionMaintenance)
+	}
 
-func initApp() (*app.App, error) {
-	// PostgreSQL connection
-	postgresHost := os.Getenv("POSTGRES_HOST")
-	if postgresHost == "" {
-		postgresHost = "postgres"
+	// Setup HTTP handlers
+	registerRoutes(router, app)
+	if err := router.Err(); err != nil {
+		log.Fatalf("Conflicting routes: %v", err)
 	}
-	postgresPort := os.Getenv("POSTGRES_PORT")
-	if postgresPort == "" {
-		postgresPort = "5432"
+	if err := app.SLOs.Validate(router.Routes()); err != nil {
+		log.Fatalf("Invalid SLOS: %v", err)
 	}
-	postgresUser := os.Getenv("POSTGRES_USER")
-	if postgresUser == "" {
-		postgresUser = "postgres"
+	router.SLOs = app.SLOs
+	router.LogRequests = cfg.LogRequests
+
+	// Shutdown waits for event feeds like any request otherwise
+	server.RegisterOnShutdown(app.CloseFeeds)
+
+	handler.Set(serverHandler(app, router))
+	app.MarkStarted()
+	log.Printf("Started, serving on %s", ln.Addr())
+	err = <-served
+	if err != nil {
+		log.Printf("Server stopped: %v", err)
 	}
-	postgresPass := os.Getenv("POSTGRES_PASSWORD")
-	if postgresPass == "" {
-		postgresPass = "postgres"
+
+	// Jobs in flight finish before the clients they use are closed
+	stop()
+	if !loops.Wait(backgroundStopTimeout) {
+		log.Printf("Background work still running after %s, closing connections anyway", backgroundStopTimeout)
 	}
-	postgresDB := os.Getenv("POSTGRES_DB")
-	if postgresDB == "" {
-		postgresDB = "testdb"
+	app.DB.Close()
+	app.Rds.Close()
+	if err != nil {
+		os.Exit(1)
 	}
+	log.Print("Shut down")
+}
+
+// backgroundStopTimeout bounds the wait for the background loops at
+// shutdown, after the server drained.
+const backgroundStopTimeout = 5 * time.Second
 
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		postgresHost, postgresPort, postgresUser, postgresPass, postgresDB)
+// loadConfig reads the settings, failing on any that is invalid alone or
+// together with another, so that a typo stops startup rather than leaving
+// the setting at its default.
+func loadConfig() (*config.Config, []config.Setting, error) {
+	cfg, settings, err := config.Load()
+	if err == nil {
+		err = cfg.Check()
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration, run `app config check` for details:\n%v", err)
+	}
+	if unknown := config.Unrecognized(os.Environ()); len(unknown) > 0 {
+		log.Printf("Ignoring unrecognized settings: %s", strings.Join(unknown, ", "))
+	}
+	return cfg, settings, nil
+}
 
-	db, err := sql.Open("postgres", dsn)
+// initApp connects to the dependencies and builds the App. PostgreSQL and
+// Redis are waited for until they answer or ctx is done.
+func initApp(ctx context.Context, cfg *config.Config, settings []config.Setting) (*app.App, error) {
+	// PostgreSQL connection
+	connector, err := pq.NewConnector(cfg.Postgres.DSN())
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to postgres: %v", err)
 	}
+	// Server-Timing and request deadlines need the clients to time their
+	// calls
+	db := sql.OpenDB(servertiming.Connector(connector))
+
+	// The pool keeps 2 idle connections by default, too few to keep
+	// POOL_MIN_IDLE_CONNS warm
+	warm := app.WarmPolicy{
+		MinIdle:  cfg.PoolMinIdleConns,
+		Interval: time.Duration(cfg.PoolKeepaliveSeconds) * time.Second,
+	}
+	db.SetMaxIdleConns(max(warm.MinIdle, 2))
 
 	// Test database connection
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping postgres: %v", err)
+	if err := waitForDependency(ctx, "postgres", db.PingContext); err != nil {
+		return nil, err
 	}
 
-	// Initialize database schema
-	if err := initDatabase(db); err != nil {
-		return nil, fmt.Errorf("failed to init database: %v", err)
+	// Apply pending migrations, unless they are left to `app migrate`
+	if cfg.MigrateOnStart {
+		if err := initDatabase(db); err != nil {
+			return nil, fmt.Errorf("failed to init database: %v", err)
+		}
 	}
 
-	// Redis connection
-	redisHost := os.Getenv("REDIS_HOST")
-	if redisHost == "" {
-		redisHost = "redis"
+	// Optionally convert test_data to monthly partitions
+	if cfg.PartitionTestData {
+		if err := app.PartitionTestData(context.Background(), db); err != nil {
+			return nil, fmt.Errorf("failed to partition test_data: %v", err)
+		}
 	}
-	redisPort := os.Getenv("REDIS_PORT")
-	if redisPort == "" {
-		redisPort = "6379"
+
+	// Verify the schema is one this build can serve
+	readOnly := false
+	if err := checkSchemaCompatibility(db, cfg.SchemaCompat); err != nil {
+		if cfg.SchemaMismatch != "readonly" {
+			return nil, err
+		}
+		log.Printf("Schema check failed, starting in read-only mode: %v", err)
+		readOnly = true
 	}
 
+	// Redis connection
 	rdb := redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%s", redisHost, redisPort),
+		Addr:     cfg.Redis.Addr(),
 		Password: "",
 		DB:       0,
 	})
+	rdb.AddHook(servertiming.RedisHook())
 
 	// Test Redis connection
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	err = waitForDependency(ctx, "redis", func(ctx context.Context) error { return rdb.Ping(ctx).Err() })
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	if err := rdb.Ping(ctx).Err(); err != nil {
-		return nil, fmt.Errorf("failed to ping redis: %v", err)
+
+	jobs := worker.New(rdb)
+	jobs.MaxAttempts = cfg.JobMaxAttempts
+	jobs.Backoff = time.Duration(cfg.JobRetryBackoffMS) * time.Millisecond
+
+	keyring, err := newKeyring(ctx, cfg.EncryptionKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	backend, memcachedServers, err := newCacheBackend(ctx, cfg.Cache, rdb)
+	if err != nil {
+		return nil, err
+	}
+	listCache, err := newListCache(cfg.Cache, backend)
+	if err != nil {
+		return nil, err
+	}
+	// Cached lists hold decrypted secrets, so they are encrypted too
+	if keyring != nil {
+		listCache.Sealer = keyring
+	}
+
+	// Dual-read mode: serve the list cache from a new Redis, falling back
+	// to the current one while it warms up
+	if cfg.Cache.DualRead {
+		newRdb := redis.NewClient(&redis.Options{
+			Addr:     cfg.Cache.NewRedisAddr,
+			Password: cfg.Cache.NewRedisPassword,
+		})
+		if err := newRdb.Ping(ctx).Err(); err != nil {
+			return nil, fmt.Errorf("failed to ping new cache redis: %v", err)
+		}
+
+		next, err := newListCache(cfg.Cache, cache.NewRedis(newRdb))
+		if err != nil {
+			return nil, err
+		}
+		next.Sealer = listCache.Sealer
+		next.MigrateFrom(listCache)
+		listCache = next
+	}
+
+	httpClient, err := newHTTPClient(cfg.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceAuth, err := newServiceAuth(cfg)
+	if err != nil {
+		return nil, err
+	}
+	serviceAccounts, err := satoken.ParseAllowlist(cfg.ServiceAuth.Allowed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SA_TOKEN_ALLOWED: %v", err)
+	}
+
+	trustedProxies, err := app.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	dependencies, err := app.ParseDependencies(cfg.ConnectivityTargets)
+	if err != nil {
+		return nil, err
+	}
+	dependencies = append([]app.Dependency{
+		{Name: "postgres", Addr: cfg.Postgres.Addr()},
+		{Name: "redis", Addr: cfg.Redis.Addr()},
+	}, dependencies...)
+	if cfg.Cache.DualRead {
+		dependencies = append(dependencies, app.Dependency{Name: "redis_new", Addr: cfg.Cache.NewRedisAddr})
+	}
+	for i, addr := range memcachedServers {
+		dependencies = append(dependencies, app.Dependency{Name: fmt.Sprintf("memcached_%d", i), Addr: addr})
 	}
 
-	return &app.App{DB: db, Rds: rdb}, nil
+	a := &app.App{
+		DB:                db,
+		Rds:               rdb,
+		ListCache:         listCache,
+		CacheStrategy:     cfg.Cache.Strategy,
+		HTTPClient:        httpClient,
+		Jobs:              jobs,
+		Schedules:         worker.NewScheduler(db, jobs),
+		AdminToken:        cfg.AdminToken,
+		Keyring:           keyring,
+		ServiceAuth:       serviceAuth,
+		ServiceAccounts:   serviceAccounts,
+		RequireAPIKeys:    cfg.APIKeyAuth,
+		APIKeyCacheTTL:    time.Duration(cfg.APIKeyCacheSecs) * time.Second,
+		ClockSkew:         clockSkew(cfg),
+		TrustedProxies:    trustedProxies,
+		Profile:           cfg.Env,
+		DebugRequest:      cfg.DebugRequest,
+		EnableReset:       cfg.EnableReset,
+		StrictJSON:        cfg.StrictJSON,
+		MethodOverride:    cfg.MethodOverride,
+		ServerTiming:      cfg.ServerTiming,
+		SerializeRequests: cfg.SerializeRequests,
+		CoverDir:          cfg.CoverDir,
+		Dependencies:      dependencies,
+		Settings:          settings,
+	}
+
+	a.Retention = app.RetentionPolicy{
+		Days:       cfg.Retention.Days,
+		BatchSize:  cfg.Retention.BatchSize,
+		MaxBatches: cfg.Retention.MaxBatches,
+		Interval:   time.Duration(cfg.Retention.IntervalSeconds) * time.Second,
+		Archive:    cfg.Retention.Archive,
+	}
+
+	a.Concurrency = app.ConcurrencyPolicy{
+		Max:           cfg.ConcurrencyLimitMax,
+		Min:           cfg.ConcurrencyLimitMin,
+		LatencyTarget: time.Duration(cfg.ConcurrencyLatencyTargetMS) * time.Millisecond,
+		Interval:      time.Duration(cfg.ConcurrencyProbeIntervalMS) * time.Millisecond,
+	}
+
+	a.Warm = warm
+	a.HealthChecks = app.HealthCheckPolicy{
+		TTL: time.Duration(cfg.HealthCacheMS) * time.Millisecond,
+	}
+
+	a.CacheVerify = app.CacheVerifyPolicy{
+		Interval: time.Duration(cfg.Cache.VerifyIntervalSeconds) * time.Second,
+		Sample:   cfg.Cache.VerifySample,
+		Heal:     cfg.Cache.VerifyHeal,
+	}
+
+	a.Snapshots = app.SnapshotPolicy{
+		Dir:      cfg.SnapshotDir,
+		Interval: time.Duration(cfg.SnapshotIntervalSeconds) * time.Second,
+	}
+
+	a.JSON, err = jsonpolicy.Parse(cfg.JSONNaming, cfg.JSONNulls)
+	if err != nil {
+		return nil, err
+	}
+
+	slos, err := app.ParseSLOs(cfg.SLOs)
+	if err != nil {
+		return nil, err
+	}
+	a.SLOs = app.NewSLOTracker(slos, time.Duration(cfg.SLOWindowMinutes)*time.Minute)
+
+	if cfg.EventsEnabled {
+		a.Events = events.NewBroadcaster(cfg.EventsBuffer, cfg.EventsSlowPolicy)
+		a.Events.MaxSubscribers = cfg.EventsMaxConnections
+		a.Feed = app.FeedPolicy{
+			Heartbeat:   time.Duration(cfg.EventsHeartbeatSeconds) * time.Second,
+			IdleTimeout: time.Duration(cfg.EventsIdleTimeoutSeconds) * time.Second,
+			History:     int64(cfg.EventsHistory),
+		}
+	}
+
+	a.QueueLimits = app.QueueLimits{
+		MaxDepth: int64(cfg.QueueMaxDepth),
+		MaxAge:   time.Duration(cfg.QueueMaxAgeSeconds) * time.Second,
+	}
+
+	if cfg.WriteBehind {
+		a.Batch = worker.NewBatcher(rdb, app.WriteBehindKey, a.InsertDataBatch)
+		a.Batch.Interval = time.Duration(cfg.BatchFlushIntervalMS) * time.Millisecond
+		a.Batch.MaxItems = cfg.BatchMaxItems
+	}
+	a.SetReadOnly(readOnly)
+	return a, nil
+}
+
+// newCacheBackend returns the store CACHE_BACKEND picks for the list
+// cache, rdb or memcached, with the memcached servers if any.
+func newCacheBackend(ctx context.Context, cfg config.CacheConfig, rdb *redis.Client) (cache.Cache, []string, error) {
+	switch cfg.Backend {
+	case "redis":
+		return cache.NewRedis(rdb), nil, nil
+	case "memcached":
+		var servers []string
+		for _, addr := range strings.Split(cfg.MemcachedServers, ",") {
+			if addr = strings.TrimSpace(addr); addr == "" {
+				continue
+			}
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				return nil, nil, fmt.Errorf("invalid MEMCACHED_SERVERS entry %q: %v", addr, err)
+			}
+			servers = append(servers, addr)
+		}
+		if len(servers) == 0 {
+			return nil, nil, fmt.Errorf("CACHE_BACKEND=memcached requires MEMCACHED_SERVERS")
+		}
+		mc := cache.NewMemcached(servers...)
+		mc.Timeout = time.Duration(cfg.MemcachedTimeoutMS) * time.Millisecond
+		if err := mc.Ping(ctx); err != nil {
+			return nil, nil, fmt.Errorf("failed to ping memcached: %v", err)
+		}
+		return mc, servers, nil
+	default:
+		return nil, nil, fmt.Errorf("invalid CACHE_BACKEND %q", cfg.Backend)
+	}
+}
+
+// newListCache builds the GET /api/data cache on backend.
+func newListCache(cfg config.CacheConfig, backend cache.Cache) (*cache.QueryCache, error) {
+	listCache := cache.NewQueryCacheOn(backend, "test_data_cache", 5*time.Minute)
+	listCache.Namespace = cache.Namespace(app.APIVersion, []types.TestData{})
+	listCache.ChunkSize = cfg.ChunkBytes
+	listCache.Compression = cfg.Compression
+	listCache.CompressMinBytes = cfg.CompressMinBytes
+	listCache.InvalidateDebounce = time.Duration(cfg.InvalidateDebounceMS) * time.Millisecond
+	if !cache.ValidCodec(listCache.Compression) {
+		return nil, fmt.Errorf("invalid CACHE_COMPRESSION %q", listCache.Compression)
+	}
+	return listCache, nil
+}
+
+// newHTTPClient builds the client shared by outbound calls.
+func newHTTPClient(cfg config.HTTPClientConfig) (*http.Client, error) {
+	opts, err := httpClientOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return httpclient.New(opts), nil
 }
 
-func initDatabase(db *sql.DB) error {
-	_, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS test_data (
-			id SERIAL PRIMARY KEY,
-			name VARCHAR(255) NOT NULL,
-			data TEXT,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)
-	`)
-	return err
+// httpClientOptions reads the HTTP_CLIENT_* settings.
+func httpClientOptions(cfg config.HTTPClientConfig) (httpclient.Options, error) {
+	opts := httpclient.DefaultOptions()
+	opts.Timeout = time.Duration(cfg.TimeoutMS) * time.Millisecond
+	opts.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	opts.MaxConnsPerHost = cfg.MaxConnsPerHost
+	opts.BreakerThreshold = cfg.BreakerThreshold
+	opts.BreakerCooldown = time.Duration(cfg.BreakerCooldownMS) * time.Millisecond
+	opts.MaxRetries = cfg.MaxRetries
+	opts.RetryBackoff = time.Duration(cfg.RetryBackoffMS) * time.Millisecond
+	opts.RetryBudgetRatio = float64(cfg.RetryBudgetPercent) / 100
+	opts.RetryBudgetBurst = float64(cfg.RetryBudgetBurst)
+
+	var err error
+	if opts.Allow, err = httpclient.ParseHostRules(cfg.Allow); err != nil {
+		return opts, fmt.Errorf("invalid HTTP_CLIENT_ALLOW: %v", err)
+	}
+	if opts.Deny, err = httpclient.ParseHostRules(cfg.Deny); err != nil {
+		return opts, fmt.Errorf("invalid HTTP_CLIENT_DENY: %v", err)
+	}
+
+	if cfg.CAFile != "" {
+		if opts.TLS, err = tlsWithCAFile("HTTP_CLIENT_CA_FILE", cfg.CAFile); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// tlsWithCAFile returns a TLS config trusting only the CAs in the PEM file
+// at path, read from the named setting.
+func tlsWithCAFile(setting, path string) (*tls.Config, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", setting, err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s %s holds no PEM certificates", setting, path)
+	}
+	return &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: roots}, nil
+}
+
+// newKeyring loads the keys encrypting test_data secrets, nil when
+// FIELD_ENCRYPTION_KEYS is unset.
+func newKeyring(ctx context.Context, keys string) (*encryption.Keyring, error) {
+	if keys == "" {
+		return nil, nil
+	}
+	keyring, err := encryption.Load(ctx, encryption.EnvKeys(keys))
+	if err != nil {
+		return nil, fmt.Errorf("invalid FIELD_ENCRYPTION_KEYS: %v", err)
+	}
+	return keyring, nil
+}
+
+// newServiceAuth builds the service account token verifier selected by
+// SA_TOKEN_AUTH, nil when it is off.
+func newServiceAuth(cfg *config.Config) (satoken.Verifier, error) {
+	sa := cfg.ServiceAuth
+	if sa.Mode == "off" {
+		return nil, nil
+	}
+
+	if sa.Mode == "jwks" {
+		if sa.JWKSFile != "" {
+			data, err := os.ReadFile(sa.JWKSFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read SA_TOKEN_JWKS_FILE: %v", err)
+			}
+			keys, err := satoken.ParseJWKS(data)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SA_TOKEN_JWKS_FILE: %v", err)
+			}
+			return newJWKSVerifier(cfg, keys), nil
+		}
+	}
+
+	// The API server is reached with the cluster's CA rather than the
+	// roots the shared client trusts, and with the app's own token, which
+	// the kubelet rotates, so it is read on every use
+	opts, err := httpClientOptions(cfg.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+	// The host rules keep clients from steering requests inward, but the
+	// API server is configured, not client-supplied, and its ClusterIP is
+	// in a private range they deny
+	opts.Allow, opts.Deny = httpclient.HostRules{}, httpclient.HostRules{}
+	if opts.TLS, err = tlsWithCAFile("SA_TOKEN_CA_FILE", sa.CAFile); err != nil {
+		return nil, err
+	}
+	client := httpclient.New(opts)
+	credentials := func() (string, error) {
+		token, err := os.ReadFile(sa.CredentialsFile)
+		return strings.TrimSpace(string(token)), err
+	}
+	apiServer := strings.TrimSuffix(sa.APIServer, "/")
+
+	switch sa.Mode {
+	case "jwks":
+		return newJWKSVerifier(cfg, &satoken.RemoteKeys{
+			URL:         apiServer + "/openid/v1/jwks",
+			Client:      client,
+			Credentials: credentials,
+			MinRefresh:  time.Minute,
+		}), nil
+	case "tokenreview":
+		var audiences []string
+		if sa.Audience != "" {
+			audiences = []string{sa.Audience}
+		}
+		return &satoken.TokenReviewer{
+			URL:         apiServer,
+			Client:      client,
+			Audiences:   audiences,
+			Credentials: credentials,
+			CacheTTL:    time.Duration(sa.CacheSeconds) * time.Second,
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid SA_TOKEN_AUTH %q", sa.Mode)
+	}
+}
+
+func newJWKSVerifier(cfg *config.Config, keys satoken.KeySet) *satoken.JWKSVerifier {
+	return &satoken.JWKSVerifier{
+		Keys:     keys,
+		Issuer:   cfg.ServiceAuth.Issuer,
+		Audience: cfg.ServiceAuth.Audience,
+		Leeway:   clockSkew(cfg),
+	}
+}
+
+// clockSkew is how far the clocks of this replica, its clients and its
+// dependencies may differ, CLOCK_SKEW_MS.
+func clockSkew(cfg *config.Config) time.Duration {
+	return time.Duration(cfg.ClockSkewMS) * time.Millisecond
+}
+
+// releaseID identifies the deployed build for the stored log level:
+// release, APP_RELEASE, when set, otherwise a hash of the executable,
+// which changes with every build deployed.
+func releaseID(release string) string {
+	if release != "" {
+		return release
+	}
+	path, err := os.Executable()
+	if err != nil {
+		return "unknown"
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "unknown"
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
 }
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogramBucketsAreCumulative(t *testing.T) {
+	h := &Histogram{name: "test_sizes", help: "Sizes.", buckets: []float64{1, 10}, counts: make([]uint64, 2)}
+	h.Observe(1)
+	h.Observe(5)
+	h.Observe(50)
+
+	var buf bytes.Buffer
+	h.write(&buf)
+
+	assert.Contains(t, buf.String(), `test_sizes_bucket{le="1"} 1`)
+	assert.Contains(t, buf.String(), `test_sizes_bucket{le="10"} 2`)
+	assert.Contains(t, buf.String(), `test_sizes_bucket{le="+Inf"} 3`)
+	assert.Contains(t, buf.String(), "test_sizes_sum 56")
+	assert.Contains(t, buf.String(), "test_sizes_count 3")
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	NewCounter("test_duplicate_total", "Duplicate.")
+	assert.Panics(t, func() { NewCounter("test_duplicate_total", "Duplicate.") })
+}
+
+func TestCounterVecFoldsSeriesPastTheLimit(t *testing.T) {
+	v := &CounterVec{name: "test_requests_total", help: "Requests.", labeled: newLabeled([]string{"path"}, func() *Counter { return &Counter{} })}
+	v.maxSeries = 2
+
+	v.With("/a").Inc()
+	v.With("/b").Inc()
+	before := labelOverflows.Value()
+	v.With("/c").Inc()
+	v.With("/d").Inc()
+	v.With("/a").Inc()
+	assert.Equal(t, uint64(2), labelOverflows.Value()-before)
+
+	var buf bytes.Buffer
+	v.write(&buf)
+	assert.Contains(t, buf.String(), `test_requests_total{path="/a"} 2`)
+	assert.Contains(t, buf.String(), `test_requests_total{path="__overflow__"} 2`)
+	assert.NotContains(t, buf.String(), `"/c"`)
+}
+
+func TestVecWithExistingSeriesDoesNotAllocate(t *testing.T) {
+	v := &CounterVec{name: "test_alloc_total", help: "Allocations.", labeled: newLabeled([]string{"method", "route", "code"}, func() *Counter { return &Counter{} })}
+	method, route, code := "GET", "data", "200"
+	v.With(method, route, code).Inc()
+
+	allocs := testing.AllocsPerRun(100, func() { v.With(method, route, code).Inc() })
+	assert.Zero(t, allocs)
+}
+
+func TestHistogramVecWritesLabels(t *testing.T) {
+	v := &HistogramVec{name: "test_latency", help: "Latency.", labeled: newLabeled([]string{"route"}, func() *Histogram {
+		return &Histogram{buckets: []float64{1}, counts: make([]uint64, 1)}
+	})}
+	v.With(`a"b`).Observe(0.5)
+
+	var buf bytes.Buffer
+	v.write(&buf)
+	assert.Contains(t, buf.String(), `test_latency_bucket{route="a\"b",le="1"} 1`)
+	assert.Contains(t, buf.String(), `test_latency_sum{route="a\"b"} 0.5`)
+	assert.Contains(t, buf.String(), `test_latency_count{route="a\"b"} 1`)
+}
+
+func TestGaugeVecWritesLabels(t *testing.T) {
+	v := &GaugeVec{name: "test_ratio", help: "Ratio.", labeled: newLabeled([]string{"area"}, func() *Gauge { return &Gauge{} })}
+	v.With("list").Set(0.75)
+	v.With("record").Set(1)
+
+	var buf bytes.Buffer
+	v.write(&buf)
+	assert.Contains(t, buf.String(), "# TYPE test_ratio gauge\n")
+	assert.Contains(t, buf.String(), `test_ratio{area="list"} 0.75`)
+	assert.Contains(t, buf.String(), `test_ratio{area="record"} 1`)
+}
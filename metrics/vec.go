@@ -0,0 +1,199 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultMaxSeries caps the label combinations of a labeled metric.
+const DefaultMaxSeries = 500
+
+// OverflowValue replaces every label value of observations made once a
+// labeled metric is full, so they are still counted in a single series.
+const OverflowValue = "__overflow__"
+
+var labelOverflows = NewCounter("app_metrics_label_overflow_total",
+	"Observations folded into the overflow series because a labeled metric hit its series limit.")
+
+// series is one label combination of a labeled metric.
+type series[T any] struct {
+	values []string
+	metric T
+}
+
+// labeled holds the series of a labeled metric, at most maxSeries of them
+// plus the overflow series.
+type labeled[T any] struct {
+	labels    []string
+	maxSeries int
+	create    func() T
+
+	mu     sync.Mutex
+	series map[string]*series[T]
+}
+
+func newLabeled[T any](labels []string, create func() T) *labeled[T] {
+	return &labeled[T]{labels: labels, maxSeries: DefaultMaxSeries, create: create, series: map[string]*series[T]{}}
+}
+
+func (l *labeled[T]) with(values []string) T {
+	if len(values) != len(l.labels) {
+		panic(fmt.Sprintf("metrics: got %d label values for %d labels", len(values), len(l.labels)))
+	}
+
+	// The key is built on the stack, and converting it to look a series
+	// up does not allocate, so observing an existing series is free
+	var buf [128]byte
+	key := buf[:0]
+	for i, v := range values {
+		if i > 0 {
+			key = append(key, '\xff')
+		}
+		key = append(key, v...)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s, ok := l.series[string(key)]; ok {
+		return s.metric
+	}
+
+	if len(l.series) >= l.maxSeries {
+		labelOverflows.Inc()
+		values = make([]string, len(l.labels))
+		for i := range values {
+			values[i] = OverflowValue
+		}
+		key = []byte(strings.Join(values, "\xff"))
+		if s, ok := l.series[string(key)]; ok {
+			return s.metric
+		}
+	}
+
+	s := &series[T]{values: slices.Clone(values), metric: l.create()}
+	l.series[string(key)] = s
+	return s.metric
+}
+
+// SetMaxSeries lowers or raises the series limit from DefaultMaxSeries,
+// for labels whose values come from clients.
+func (l *labeled[T]) SetMaxSeries(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.maxSeries = n
+}
+
+// sorted returns the series ordered by label values, with their labels
+// rendered for the exposition format.
+func (l *labeled[T]) sorted() ([]string, []T) {
+	l.mu.Lock()
+	keys := make([]string, 0, len(l.series))
+	for key := range l.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	labels := make([]string, len(keys))
+	metrics := make([]T, len(keys))
+	for i, key := range keys {
+		s := l.series[key]
+		labels[i] = l.render(s.values)
+		metrics[i] = s.metric
+	}
+	l.mu.Unlock()
+	return labels, metrics
+}
+
+// render formats label pairs as `a="x",b="y"`.
+func (l *labeled[T]) render(values []string) string {
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", l.labels[i], labelEscaper.Replace(value))
+	}
+	return strings.Join(pairs, ",")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	name, help string
+	*labeled[*Counter]
+}
+
+// NewCounterVec creates and registers a labeled counter.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{name: name, help: help, labeled: newLabeled(labels, func() *Counter { return &Counter{} })}
+	register(name, v)
+	return v
+}
+
+// With returns the counter for the given label values, in label order.
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.with(values)
+}
+
+func (v *CounterVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", v.name, v.help, v.name)
+	labels, counters := v.sorted()
+	for i, c := range counters {
+		fmt.Fprintf(w, "%s{%s} %d\n", v.name, labels[i], c.Value())
+	}
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	name, help string
+	*labeled[*Histogram]
+}
+
+// NewHistogramVec creates and registers a labeled histogram with the given
+// upper bucket bounds, which must be sorted.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	v := &HistogramVec{name: name, help: help, labeled: newLabeled(labels, func() *Histogram {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	})}
+	register(name, v)
+	return v
+}
+
+// With returns the histogram for the given label values, in label order.
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.with(values)
+}
+
+func (v *HistogramVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", v.name, v.help, v.name)
+	labels, histograms := v.sorted()
+	for i, h := range histograms {
+		h.writeSamples(w, v.name, labels[i])
+	}
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct {
+	name, help string
+	*labeled[*Gauge]
+}
+
+// NewGaugeVec creates and registers a labeled gauge.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{name: name, help: help, labeled: newLabeled(labels, func() *Gauge { return &Gauge{} })}
+	register(name, v)
+	return v
+}
+
+// With returns the gauge for the given label values, in label order.
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.with(values)
+}
+
+func (v *GaugeVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", v.name, v.help, v.name)
+	labels, gauges := v.sorted()
+	for i, g := range gauges {
+		fmt.Fprintf(w, "%s{%s} %g\n", v.name, labels[i], g.Value())
+	}
+}
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS test_data (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	data TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS test_data_created_at_idx ON test_data (created_at);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS test_data_tags_idx;
+
+ALTER TABLE test_data
+	DROP COLUMN IF EXISTS tags,
+	DROP COLUMN IF EXISTS status;
+
+DROP TYPE IF EXISTS test_data_status;
@@ -0,0 +1,10 @@
+DO $$ BEGIN
+	CREATE TYPE test_data_status AS ENUM ('active', 'archived');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+ALTER TABLE test_data
+	ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}',
+	ADD COLUMN IF NOT EXISTS status test_data_status NOT NULL DEFAULT 'active';
+
+CREATE INDEX IF NOT EXISTS test_data_tags_idx ON test_data USING GIN (tags);
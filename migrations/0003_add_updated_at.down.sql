@@ -0,0 +1 @@
+ALTER TABLE test_data DROP COLUMN IF EXISTS updated_at;
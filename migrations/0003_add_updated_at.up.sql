@@ -0,0 +1 @@
+ALTER TABLE test_data ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
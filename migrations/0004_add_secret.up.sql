@@ -0,0 +1,2 @@
+-- Sealed with FIELD_ENCRYPTION_KEYS as "<key id>:<nonce><ciphertext>"
+ALTER TABLE test_data ADD COLUMN IF NOT EXISTS secret BYTEA;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS tenants (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL UNIQUE,
+	isolation VARCHAR(16) NOT NULL CHECK (isolation IN ('row', 'schema')),
+	schema_name VARCHAR(63),
+	api_key_hash CHAR(64) NOT NULL UNIQUE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
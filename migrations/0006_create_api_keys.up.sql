@@ -0,0 +1,12 @@
+-- Keys are stored as their SHA-256 only; replaced_by links a rotated key to
+-- its successor
+CREATE TABLE IF NOT EXISTS api_keys (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	key_hash CHAR(64) NOT NULL UNIQUE,
+	key_prefix VARCHAR(16) NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMPTZ,
+	last_used_at TIMESTAMPTZ,
+	replaced_by INTEGER REFERENCES api_keys (id) ON DELETE SET NULL
+);
@@ -0,0 +1,5 @@
+-- Rows of row-isolated tenants carry their tenant; shared rows and rows in
+-- a tenant's own schema leave it NULL
+ALTER TABLE test_data ADD COLUMN IF NOT EXISTS tenant_id INTEGER;
+
+CREATE INDEX IF NOT EXISTS test_data_tenant_id_idx ON test_data (tenant_id);
@@ -0,0 +1 @@
+ALTER TABLE test_data DROP COLUMN IF EXISTS test_run_id;
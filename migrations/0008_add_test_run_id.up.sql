@@ -0,0 +1,4 @@
+-- The X-Test-Run-ID of the request that created the row
+ALTER TABLE test_data ADD COLUMN IF NOT EXISTS test_run_id VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS test_data_test_run_id_idx ON test_data (test_run_id);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS test_data_tenant_name_key;
@@ -0,0 +1,10 @@
+-- Names are unique per tenant, with the shared rows counting as one.
+-- Partitioned tables can only enforce uniqueness together with the
+-- partition key, so this is skipped when PARTITION_TEST_DATA has converted
+-- test_data already
+DO $$ BEGIN
+	IF (SELECT relkind FROM pg_class WHERE oid = 'test_data'::regclass) <> 'p' THEN
+		CREATE UNIQUE INDEX IF NOT EXISTS test_data_tenant_name_key ON test_data (COALESCE(tenant_id, 0), name);
+		DROP INDEX IF EXISTS test_data_name_key;
+	END IF;
+END $$;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS test_data_comments;
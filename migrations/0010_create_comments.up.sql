@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS test_data_comments (
+	id SERIAL PRIMARY KEY,
+	data_id INTEGER NOT NULL,
+	body TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS test_data_comments_data_id_idx ON test_data_comments (data_id);
+
+-- Like uniqueness, a foreign key needs a unique key on id alone, which a
+-- partitioned test_data doesn't have
+DO $$ BEGIN
+	IF (SELECT relkind FROM pg_class WHERE oid = 'test_data'::regclass) <> 'p' THEN
+		ALTER TABLE test_data_comments ADD CONSTRAINT test_data_comments_data_id_fkey
+			FOREIGN KEY (data_id) REFERENCES test_data (id) ON DELETE CASCADE;
+	END IF;
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
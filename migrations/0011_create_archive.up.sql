@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS test_data_archive (
+	id INTEGER PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	data TEXT,
+	created_at TIMESTAMP,
+	archived_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE test_data_archive
+	ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}',
+	ADD COLUMN IF NOT EXISTS status test_data_status NOT NULL DEFAULT 'active',
+	ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP,
+	ADD COLUMN IF NOT EXISTS tenant_id INTEGER,
+	ADD COLUMN IF NOT EXISTS secret BYTEA,
+	ADD COLUMN IF NOT EXISTS test_run_id VARCHAR(64);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS recurring_jobs (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	job_type VARCHAR(255) NOT NULL,
+	payload JSONB NOT NULL DEFAULT 'null',
+	interval_seconds INTEGER NOT NULL CHECK (interval_seconds > 0),
+	next_run_at TIMESTAMPTZ NOT NULL,
+	last_run_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
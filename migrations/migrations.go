@@ -0,0 +1,229 @@
+// Package migrations evolves the database schema in numbered steps. Each
+// step is a pair of SQL files embedded in the binary, NNNN_name.up.sql and
+// NNNN_name.down.sql, and the versions applied are recorded in the
+// schema_migrations table.
+//
+// Migrations 1 to 12 are the schema the app used to create inline at
+// startup. Their statements are idempotent, so that databases created back
+// then, which record version 12 only, have them applied as no-ops.
+// Migrations added since need not be.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// Migration is one step of the schema.
+type Migration struct {
+	Version int
+	Name    string
+	// Up applies the step, Down reverts it
+	Up   string
+	Down string
+}
+
+// String returns the migration's file name without its suffix, such as
+// "0001_create_test_data".
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+var all = mustLoad(files)
+
+// All returns every migration, oldest first.
+func All() []Migration {
+	return append([]Migration(nil), all...)
+}
+
+// Latest returns the version of the newest migration, the schema version
+// this build is written against.
+func Latest() int {
+	return all[len(all)-1].Version
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+func mustLoad(fsys fs.FS) []Migration {
+	migrations, err := load(fsys)
+	if err != nil {
+		panic(fmt.Sprintf("migrations: %v", err))
+	}
+	return migrations
+}
+
+// load reads the migrations in fsys, which must be numbered from 1 without
+// gaps and each have both an up and a down file.
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("%s is not named NNNN_name.up.sql or NNNN_name.down.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("version %d is both %s and %s", version, m.Name, match[2])
+		}
+
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("version %d is missing", i+1)
+		}
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("%s needs both an up and a down file", m)
+		}
+	}
+	if len(migrations) == 0 {
+		return nil, fmt.Errorf("no migrations")
+	}
+	return migrations, nil
+}
+
+// lockID is the advisory lock held while a migration is applied or
+// reverted, so that replicas starting together take turns.
+const lockID = 0x6d696772 // "migr"
+
+// Applied returns when each applied version was applied.
+func Applied(ctx context.Context, db *sql.DB) (map[int]time.Time, error) {
+	if err := createTable(ctx, db); err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, "SELECT version, COALESCE(applied_at, 'epoch') FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// Up applies every migration not applied yet, oldest first, each in a
+// transaction of its own, and returns those it applied.
+func Up(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	if err := createTable(ctx, db); err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, m := range all {
+		ok, err := step(ctx, db, m, true)
+		if err != nil {
+			return done, err
+		}
+		if ok {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// Down reverts the newest steps applied migrations, newest first, and
+// returns those it reverted.
+func Down(ctx context.Context, db *sql.DB, steps int) ([]Migration, error) {
+	if err := createTable(ctx, db); err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for i := len(all) - 1; i >= 0 && len(done) < steps; i-- {
+		ok, err := step(ctx, db, all[i], false)
+		if err != nil {
+			return done, err
+		}
+		if ok {
+			done = append(done, all[i])
+		}
+	}
+	return done, nil
+}
+
+// step applies (up) or reverts m unless it already is, reporting whether
+// it did.
+func step(ctx context.Context, db *sql.DB, m Migration, up bool) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", lockID); err != nil {
+		return false, err
+	}
+	var applied bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", m.Version).Scan(&applied)
+	if err != nil || applied == up {
+		return false, err
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, m.Up)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", m.Version)
+		}
+	} else {
+		_, err = tx.ExecContext(ctx, m.Down)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+		}
+	}
+	if err != nil {
+		return false, fmt.Errorf("migration %s: %v", m, err)
+	}
+	return true, tx.Commit()
+}
+
+// createTable creates schema_migrations, which the migrations cannot do
+// themselves as it records them.
+func createTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	return err
+}
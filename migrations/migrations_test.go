@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations := All()
+	require.NotEmpty(t, migrations)
+	assert.Equal(t, len(migrations), Latest())
+	assert.Equal(t, "0001_create_test_data", migrations[0].String())
+	for _, m := range migrations {
+		assert.NotEmpty(t, m.Up, m.String())
+		assert.NotEmpty(t, m.Down, m.String())
+	}
+}
+
+func TestLoadRejectsMalformedSets(t *testing.T) {
+	file := func(body string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(body)} }
+	for name, fsys := range map[string]fstest.MapFS{
+		"empty":      {},
+		"bad name":   {"0001_init.sql": file("SELECT 1")},
+		"gap":        {"0001_a.up.sql": file("SELECT 1"), "0001_a.down.sql": file("SELECT 1"), "0003_c.up.sql": file("SELECT 1"), "0003_c.down.sql": file("SELECT 1")},
+		"no down":    {"0001_a.up.sql": file("SELECT 1")},
+		"two names":  {"0001_a.up.sql": file("SELECT 1"), "0001_b.down.sql": file("SELECT 1")},
+		"empty down": {"0001_a.up.sql": file("SELECT 1"), "0001_a.down.sql": file("")},
+	} {
+		_, err := load(fsys)
+		assert.Error(t, err, name)
+	}
+
+	migrations, err := load(fstest.MapFS{
+		"0002_b.down.sql": file("DROP b"), "0002_b.up.sql": file("CREATE b"),
+		"0001_a.down.sql": file("DROP a"), "0001_a.up.sql": file("CREATE a"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []Migration{
+		{Version: 1, Name: "a", Up: "CREATE a", Down: "DROP a"},
+		{Version: 2, Name: "b", Up: "CREATE b", Down: "DROP b"},
+	}, migrations)
+}
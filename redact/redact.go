@@ -0,0 +1,149 @@
+// Package redact masks sensitive values, such as email addresses and
+// credentials, before text reaches a log or any other sink outside the
+// database. What counts as sensitive is configured as named patterns and
+// query parameter names.
+package redact
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Mask replaces every redacted value.
+const Mask = "[redacted]"
+
+// Patterns are the built-in patterns, selectable by name.
+var Patterns = map[string]string{
+	"email": `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	// The scheme is kept so the line still says what kind of credential
+	// was sent
+	"bearer": `(?i)\b(?:bearer|basic)\s+[A-Za-z0-9._~+/=-]+`,
+	"jwt":    `\beyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`,
+	// Tenant API keys and keys from /admin/apikeys, see app.TenantKeyHeader
+	// and app.APIKeyHeader
+	"api_key": `\b(?:tk|ak)_[0-9a-f]{64}\b`,
+}
+
+// DefaultPatterns and DefaultParams are what New is given unless
+// configured otherwise.
+const (
+	DefaultPatterns = "email,bearer,jwt,api_key"
+	DefaultParams   = "token,access_token,refresh_token,id_token,password,secret,api_key,key,code"
+)
+
+// Redactor masks values matching its patterns, and the values of its
+// sensitive query parameters in URLs.
+type Redactor struct {
+	patterns []*regexp.Regexp
+	params   map[string]bool
+}
+
+// New builds a redactor from comma-separated patterns, each the name of a
+// built-in pattern or a regular expression, and comma-separated query
+// parameter names, matched case-insensitively.
+func New(patterns, params string) (*Redactor, error) {
+	r := &Redactor{params: map[string]bool{}}
+	for _, p := range split(patterns) {
+		expr, ok := Patterns[p]
+		if !ok {
+			expr = p
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %v", p, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	for _, p := range split(params) {
+		r.params[strings.ToLower(p)] = true
+	}
+	return r, nil
+}
+
+func split(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// String masks every match of the patterns in s.
+func (r *Redactor) String(s string) string {
+	for _, re := range r.patterns {
+		s = re.ReplaceAllStringFunc(s, mask)
+	}
+	return s
+}
+
+// mask replaces a match, keeping the scheme of an Authorization value.
+func mask(match string) string {
+	if scheme, _, ok := strings.Cut(match, " "); ok {
+		switch strings.ToLower(scheme) {
+		case "bearer", "basic":
+			return scheme + " " + Mask
+		}
+	}
+	return Mask
+}
+
+// URL renders u as a request URI with the values of sensitive query
+// parameters masked and the patterns applied to the rest.
+func (r *Redactor) URL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return r.String(u.RequestURI())
+	}
+
+	// Rebuilt by hand rather than with url.Values, which would reorder
+	// the parameters. Values are matched decoded, so an escaped "@" does
+	// not hide an email address.
+	pairs := strings.Split(u.RawQuery, "&")
+	for i, pair := range pairs {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		if r.params[strings.ToLower(unescape(name))] {
+			pairs[i] = name + "=" + url.QueryEscape(Mask)
+			continue
+		}
+		decoded := unescape(value)
+		if masked := r.String(decoded); masked != decoded {
+			pairs[i] = name + "=" + url.QueryEscape(masked)
+		}
+	}
+	masked := *u
+	masked.RawQuery = strings.Join(pairs, "&")
+	return r.String(masked.RequestURI())
+}
+
+func unescape(s string) string {
+	if decoded, err := url.QueryUnescape(s); err == nil {
+		return decoded
+	}
+	return s
+}
+
+// Writer returns a writer masking everything written through it before
+// passing it on to w. The log package writes each entry with a single
+// call, so values are never split across writes.
+func (r *Redactor) Writer(w io.Writer) io.Writer {
+	return writer{r, w}
+}
+
+type writer struct {
+	r *Redactor
+	w io.Writer
+}
+
+func (w writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, w.r.String(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/nesymno/run-tests-example/app"
+	"github.com/nesymno/run-tests-example/metrics"
+)
+
+// registerRoutes registers every endpoint of a on router.
+func registerRoutes(router *app.Router, a *app.App) {
+	router.HandleFunc("health", "/health", a.HealthHandler)
+	router.HandleFunc("healthz", "GET /healthz", a.LivenessHandler)
+	router.HandleFunc("readyz", "GET /readyz", a.ReadyHandler)
+	router.HandleFunc("startupz", "GET /startupz", a.StartupHandler)
+	router.HandleFunc("data", "/api/data", a.DataHandler, a.RequireServiceAccount, a.RequireAPIKey, a.WithTenant, a.Chaos)
+	router.HandleFunc("events", "GET /api/events", a.EventsHandler, a.RequireServiceAccount, a.RequireAPIKey, a.WithTenantIdentity)
+	router.HandleFunc("test_run", "/api/runs/{id}", a.TestRunHandler, a.RequireServiceAccount, a.RequireAPIKey, a.WithTenant)
+	router.HandleFunc("data_checksum", "GET /api/data/checksum", a.ChecksumHandler, a.RequireServiceAccount, a.RequireAPIKey, a.WithTenant)
+	router.HandleFunc("data_generate", "/api/data/generate", a.GenerateHandler, a.RequireServiceAccount, a.RequireAPIKey, a.RequireAdmin)
+	router.HandleFunc("data_item", "/api/data/{id}", a.DataItemHandler, a.RequireServiceAccount, a.RequireAPIKey, a.WithTenant, a.SerializeByID, a.Chaos)
+	router.HandleFunc("data_comments", "/api/data/{id}/comments", a.CommentsHandler, a.RequireServiceAccount, a.RequireAPIKey, a.WithTenant, a.SerializeByID, a.Chaos)
+	router.HandleFunc("data_comment", "/api/data/{id}/comments/{comment_id}", a.CommentHandler, a.RequireServiceAccount, a.RequireAPIKey, a.WithTenant, a.SerializeByID, a.Chaos)
+	router.HandleFunc("cache", "/api/cache", a.CacheHandler, a.RequireServiceAccount, a.RequireAPIKey)
+	router.HandleFunc("time", "GET /api/time", a.TimeHandler, a.RequireServiceAccount, a.RequireAPIKey)
+	router.HandleFunc("jobs", "/api/jobs", a.JobsHandler, a.RequireServiceAccount, a.RequireAPIKey)
+	router.HandleFunc("job", "/api/jobs/{id}", a.JobHandler, a.RequireServiceAccount, a.RequireAPIKey)
+	router.HandleFunc("schedules", "/api/schedules", a.SchedulesHandler, a.RequireServiceAccount, a.RequireAPIKey)
+	router.HandleFunc("schedule", "/api/schedules/{id}", a.ScheduleHandler, a.RequireServiceAccount, a.RequireAPIKey)
+	router.HandleFunc("admin_maintenance", "/admin/maintenance", a.MaintenanceHandler, a.RequireAdmin)
+	router.HandleFunc("admin_batch_flush", "/admin/batch/flush", a.BatchFlushHandler, a.RequireAdmin)
+	router.HandleFunc("admin_retention", "/admin/retention", a.RetentionHandler, a.RequireAdmin)
+	router.HandleFunc("admin_cache_verify", "/admin/cache/verify", a.CacheVerifyHandler, a.RequireAdmin)
+	router.HandleFunc("admin_archive", "/admin/archive", a.ArchiveHandler, a.RequireAdmin)
+	router.HandleFunc("admin_encryption", "/admin/encryption", a.EncryptionHandler, a.RequireAdmin)
+	router.HandleFunc("admin_tenants", "/admin/tenants", a.TenantsHandler, a.RequireAdmin)
+	router.HandleFunc("admin_tenant", "/admin/tenants/{id}", a.TenantHandler, a.RequireAdmin)
+	router.HandleFunc("admin_apikeys", "/admin/apikeys", a.APIKeysHandler, a.RequireAdmin)
+	router.HandleFunc("admin_apikey", "/admin/apikeys/{id}", a.APIKeyHandler, a.RequireAdmin)
+	router.HandleFunc("admin_apikey_rotate", "/admin/apikeys/{id}/rotate", a.RotateAPIKeyHandler, a.RequireAdmin)
+	router.HandleFunc("admin_loglevel", "/admin/loglevel", a.LogLevelHandler, a.RequireAdmin)
+	router.HandleFunc("admin_chaos", "/admin/chaos/{run}", a.ChaosHandler, a.RequireAdmin)
+	router.HandleFunc("admin_coverage_flush", "/admin/coverage/flush", a.CoverageFlushHandler, a.RequireAdmin)
+	router.HandleFunc("admin_dead_jobs", "/admin/jobs/dead", a.DeadJobsHandler, a.RequireAdmin)
+	router.HandleFunc("admin_dead_job_retry", "/admin/jobs/dead/{id}/retry", a.RetryDeadJobHandler, a.RequireAdmin)
+	router.HandleFunc("debug_gc", "/debug/gc", a.DebugGCHandler)
+	router.HandleFunc("debug_request", "/debug/request", a.DebugRequestHandler)
+	router.HandleFunc("debug_connectivity", "/debug/connectivity", a.DebugConnectivityHandler, a.RequireAdmin)
+	router.HandleFunc("debug_cache_report", "GET /debug/cache-report", a.DebugCacheReportHandler)
+	router.HandleFunc("debug_queues", "GET /debug/queues", a.DebugQueuesHandler)
+	router.HandleFunc("debug_slo", "GET /debug/slo", a.DebugSLOHandler)
+	router.HandleFunc("debug_snapshot", "GET /debug/snapshot", a.DebugSnapshotHandler)
+	router.HandleFunc("debug_routes", "GET /debug/routes", router.RoutesHandler)
+	router.HandleFunc("debug_env", "/debug/env", a.DebugEnvHandler, a.RequireAdmin)
+	router.HandleFunc("debug_explain", "/debug/explain", a.DebugExplainHandler, a.RequireAdmin)
+	router.HandleFunc("test_reset", "/test/reset", a.ResetHandler)
+	router.HandleFunc("metrics", "/metrics", metrics.Handler)
+	router.HandleFunc("root", "/", a.RootHandler)
+}
+
+// serverHandler wraps router in the middleware that runs before routing.
+func serverHandler(a *app.App, router *app.Router) http.Handler {
+	return a.ServerTimingMiddleware(a.ClientIPMiddleware(a.DeadlineMiddleware(a.ConcurrencyMiddleware(a.MethodOverrideMiddleware(a.PropagationMiddleware(a.TestRunMiddleware(a.MaintenanceMiddleware(router))))))))
+}
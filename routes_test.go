@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/app"
+	"github.com/nesymno/run-tests-example/cache"
+	"github.com/nesymno/run-tests-example/config"
+	"github.com/nesymno/run-tests-example/events"
+	"github.com/nesymno/run-tests-example/worker"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/http")
+
+// downDB is a database that cannot be reached, so the golden files record
+// the responses of PostgreSQL-backed endpoints while it is down.
+type downDB struct{}
+
+var errDBDown = errors.New("database unavailable")
+
+func (downDB) Connect(context.Context) (driver.Conn, error) { return nil, errDBDown }
+func (downDB) Driver() driver.Driver                        { return nil }
+
+// goldenHandler serves every route of an app on miniredis and downDB.
+func goldenHandler(t *testing.T) http.Handler {
+	mr := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	db := sql.OpenDB(downDB{})
+	cfg, _, err := config.Load()
+	require.NoError(t, err)
+	listCache, err := newListCache(cfg.Cache, cache.NewRedis(rds))
+	require.NoError(t, err)
+	jobs := worker.New(rds)
+
+	a := &app.App{
+		DB:         db,
+		Rds:        rds,
+		ListCache:  listCache,
+		Jobs:       jobs,
+		Schedules:  worker.NewScheduler(db, jobs),
+		AdminToken: "admin-token",
+		Profile:    "test",
+		Events:     events.NewBroadcaster(4, events.DropOldest),
+
+		MethodOverride: true,
+	}
+	router := app.NewRouter(http.NewServeMux())
+	registerRoutes(router, a)
+	require.NoError(t, router.Err())
+	return serverHandler(a, router)
+}
+
+// goldenCase is a request whose response is compared with
+// testdata/http/<name>.golden.
+type goldenCase struct {
+	name   string
+	method string
+	path   string
+	header map[string]string
+	body   string
+}
+
+var admin = map[string]string{"X-Admin-Token": "admin-token"}
+
+var goldenCases = []goldenCase{
+	{name: "health", method: "GET", path: "/health"},
+	{name: "health_head", method: "HEAD", path: "/health"},
+	{name: "healthz", method: "GET", path: "/healthz"},
+	{name: "readyz", method: "GET", path: "/readyz"},
+	{name: "startupz_starting", method: "GET", path: "/startupz"},
+	{name: "root", method: "GET", path: "/"},
+	{name: "not_found", method: "GET", path: "/nope"},
+
+	{name: "data_list_db_down", method: "GET", path: "/api/data"},
+	{name: "data_list_deadline_passed", method: "GET", path: "/api/data", header: map[string]string{"X-Request-Deadline": "2000-01-01T00:00:00Z"}},
+	{name: "data_list_invalid_deadline", method: "GET", path: "/api/data", header: map[string]string{"Grpc-Timeout": "1s"}},
+	{name: "data_list_invalid_status", method: "GET", path: "/api/data?status=deleted"},
+	{name: "data_list_unknown_field", method: "GET", path: "/api/data?fields=id,password"},
+	{name: "data_list_unknown_include", method: "GET", path: "/api/data?include=audit"},
+	{name: "data_list_invalid_stream", method: "GET", path: "/api/data?stream=xml"},
+	{name: "data_list_stream_with_include", method: "GET", path: "/api/data?stream=true&include=comments"},
+	{name: "data_create_db_down", method: "POST", path: "/api/data", body: `{"name":"n"}`},
+	{name: "data_create_invalid_json", method: "POST", path: "/api/data", body: `{"name":`},
+	{name: "data_create_unsupported_media_type", method: "POST", path: "/api/data", header: map[string]string{"Content-Type": "text/plain"}, body: `{"name":"n"}`},
+	{name: "data_create_unsupported_charset", method: "POST", path: "/api/data", header: map[string]string{"Content-Type": "application/json; charset=utf-16"}, body: `{"name":"n"}`},
+	{name: "data_create_invalid_status", method: "POST", path: "/api/data", body: `{"name":"n","status":"deleted"}`},
+	{name: "data_create_invalid_test_run", method: "POST", path: "/api/data", body: `{"name":"n","test_run_id":"a b"}`},
+	{name: "data_create_invalid_test_run_header", method: "POST", path: "/api/data", header: map[string]string{"X-Test-Run-ID": "a b"}, body: `{"name":"n"}`},
+
+	{name: "data_item_invalid_id", method: "GET", path: "/api/data/x"},
+	{name: "data_item_db_down", method: "GET", path: "/api/data/1"},
+	{name: "data_item_update_invalid_status", method: "PUT", path: "/api/data/1", body: `{"name":"n","status":"deleted"}`},
+	{name: "data_item_patch_empty", method: "PATCH", path: "/api/data/1", body: `{}`},
+	{name: "data_item_method_not_allowed", method: "POST", path: "/api/data/1"},
+	{name: "data_checksum_db_down", method: "GET", path: "/api/data/checksum"},
+	{name: "data_checksum_invalid_status", method: "GET", path: "/api/data/checksum?status=deleted"},
+
+	{name: "events_invalid_buffer", method: "GET", path: "/api/events?buffer=0"},
+	{name: "events_invalid_last_event_id", method: "GET", path: "/api/events?last_event_id=x"},
+	{name: "events_method_not_allowed", method: "POST", path: "/api/events"},
+	{name: "events_options", method: "OPTIONS", path: "/api/events"},
+
+	{name: "test_run_delete_invalid_id", method: "DELETE", path: "/api/runs/a%20b"},
+	{name: "test_run_delete_db_down", method: "DELETE", path: "/api/runs/run-1"},
+	{name: "test_run_method_not_allowed", method: "GET", path: "/api/runs/run-1"},
+	{name: "test_run_method_override", method: "POST", path: "/api/runs/a%20b", header: map[string]string{"X-HTTP-Method-Override": "delete"}},
+	{name: "test_run_method_override_read", method: "POST", path: "/api/runs/run-1", header: map[string]string{"X-HTTP-Method-Override": "GET"}},
+
+	{name: "comments_invalid_id", method: "GET", path: "/api/data/x/comments"},
+	{name: "comments_list_db_down", method: "GET", path: "/api/data/1/comments"},
+	{name: "comments_add_empty_body", method: "POST", path: "/api/data/1/comments", body: `{"body":""}`},
+	{name: "comment_invalid_id", method: "DELETE", path: "/api/data/1/comments/x"},
+	{name: "comment_method_not_allowed", method: "GET", path: "/api/data/1/comments/2"},
+
+	{name: "cache_set", method: "POST", path: "/api/cache", body: `{"key":"k","value":"v","ttl":60}`},
+	{name: "cache_get_missing", method: "GET", path: "/api/cache?key=missing"},
+
+	{name: "job_unknown", method: "GET", path: "/api/jobs/unknown"},
+	{name: "jobs_options", method: "OPTIONS", path: "/api/jobs"},
+
+	{name: "admin_without_token", method: "GET", path: "/admin/maintenance"},
+	{name: "admin_wrong_token", method: "GET", path: "/admin/maintenance", header: map[string]string{"X-Admin-Token": "wrong"}},
+	{name: "admin_maintenance", method: "GET", path: "/admin/maintenance", header: admin},
+	{name: "admin_loglevel_invalid", method: "PUT", path: "/admin/loglevel", header: admin, body: `{"level":"loud"}`},
+	{name: "admin_chaos_unset", method: "GET", path: "/admin/chaos/run-1", header: admin},
+	{name: "admin_cache_verify_none", method: "GET", path: "/admin/cache/verify", header: admin},
+	{name: "admin_coverage_disabled", method: "POST", path: "/admin/coverage/flush", header: admin},
+	{name: "admin_apikeys_db_down", method: "GET", path: "/admin/apikeys", header: admin},
+	{name: "admin_tenants_db_down", method: "GET", path: "/admin/tenants", header: admin},
+	{name: "data_generate_without_token", method: "POST", path: "/api/data/generate?profile=small"},
+
+	{name: "debug_routes", method: "GET", path: "/debug/routes"},
+	{name: "debug_slo_none", method: "GET", path: "/debug/slo"},
+	{name: "debug_request_disabled", method: "GET", path: "/debug/request"},
+	{name: "test_reset_disabled", method: "POST", path: "/test/reset"},
+}
+
+// volatileHeaders differ between runs and are left out of golden files.
+var volatileHeaders = []string{"Date", "X-Request-Id", "X-Response-Time"}
+
+// render formats a response as its status, sorted headers and body, with
+// timestamps in JSON bodies replaced.
+func render(resp *http.Response, body []byte) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d %s\n", resp.StatusCode, http.StatusText(resp.StatusCode))
+	names := make([]string, 0, len(resp.Header))
+	for name := range resp.Header {
+		if !slices.Contains(volatileHeaders, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s: %s\n", name, strings.Join(resp.Header[name], ", "))
+	}
+	b.WriteString("\n")
+
+	var doc any
+	if json.Unmarshal(body, &doc) == nil {
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		enc.Encode(normalize(doc))
+	} else {
+		b.Write(body)
+	}
+	return b.String()
+}
+
+// normalize replaces timestamps, which change between runs.
+func normalize(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = normalize(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = normalize(e)
+		}
+	case string:
+		if _, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return "<timestamp>"
+		}
+	}
+	return v
+}
+
+func TestGoldenResponses(t *testing.T) {
+	handler := goldenHandler(t)
+
+	for _, c := range goldenCases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+			req.RemoteAddr = "192.0.2.1:1234"
+			if c.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			for name, value := range c.header {
+				req.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			got := c.method + " " + c.path + "\n" + render(rec.Result(), rec.Body.Bytes())
+			path := filepath.Join("testdata", "http", c.name+".golden")
+			if *update {
+				require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+				require.NoError(t, os.WriteFile(path, []byte(got), 0o644))
+				return
+			}
+			want, err := os.ReadFile(path)
+			require.NoError(t, err, "run go test -run TestGoldenResponses -update to create it")
+			assert.Equal(t, string(want), got)
+		})
+	}
+}
@@ -21,9 +21,6 @@ fi
 echo "✅ Docker build successful!"
 
 echo "Step 3: Waiting for services to be ready..."
-sleep 10
-
-echo "Step 5: Running tests with proper environment variables..."
 export APP_HOST=${APP_HOST:-localhost}
 export PORT=${PORT:-8080}
 export POSTGRES_HOST=${POSTGRES_HOST:-localhost}
@@ -34,6 +31,16 @@ export POSTGRES_DB=${POSTGRES_DB:-testdb}
 export REDIS_HOST=${REDIS_HOST:-localhost}
 export REDIS_PORT=${REDIS_PORT:-6379}
 
+./bin/app waitfor -checks=${WAIT_CHECKS:-postgres,redis} -timeout=${WAIT_TIMEOUT:-1m}
+
+if [ $? -ne 0 ]; then
+    echo "❌ Services did not become ready!"
+    exit 1
+fi
+echo "✅ Services ready!"
+
+echo "Step 5: Running tests with proper environment variables..."
+
 go test -v ./...
 
 TEST_EXIT_CODE=$?
@@ -0,0 +1,335 @@
+package satoken
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeySet looks up the public key a token was signed with by its key ID.
+type KeySet interface {
+	Key(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+// JWKSVerifier validates tokens locally: the signature against Keys, then
+// the issuer, audience and validity period.
+type JWKSVerifier struct {
+	Keys KeySet
+	// Issuer must match the token's iss claim, the cluster's
+	// --service-account-issuer.
+	Issuer string
+	// Audience must be one of the token's audiences; tokens projected
+	// for another service are rejected.
+	Audience string
+	// Leeway tolerates clock skew when checking exp and nbf.
+	Leeway time.Duration
+
+	now func() time.Time
+}
+
+// claims are the parts of a service account token that are checked.
+type claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	Expiry    *int64   `json:"exp"`
+	NotBefore *int64   `json:"nbf"`
+}
+
+// audience is the aud claim, which may be a single string or a list.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (v *JWKSVerifier) Verify(ctx context.Context, token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWT", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+
+	key, err := v.Keys.Key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	if err := v.checkClaims(c); err != nil {
+		return nil, err
+	}
+	return ParseSubject(c.Subject)
+}
+
+func (v *JWKSVerifier) checkClaims(c claims) error {
+	now := time.Now()
+	if v.now != nil {
+		now = v.now()
+	}
+
+	if v.Issuer != "" && c.Issuer != v.Issuer {
+		return fmt.Errorf("%w: issuer %q", ErrInvalidToken, c.Issuer)
+	}
+	if v.Audience != "" && !slices.Contains(c.Audience, v.Audience) {
+		return fmt.Errorf("%w: not issued for audience %q", ErrInvalidToken, v.Audience)
+	}
+	// Legacy secret-based tokens never expire, so they are refused
+	if c.Expiry == nil {
+		return fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	}
+	if now.After(time.Unix(*c.Expiry, 0).Add(v.Leeway)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if c.NotBefore != nil && now.Before(time.Unix(*c.NotBefore, 0).Add(-v.Leeway)) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// verifySignature checks sig over signed. Only the algorithms Kubernetes
+// signs service account tokens with are accepted, and the key type must
+// match the algorithm, so a token cannot pick a weaker check.
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: RS256 token signed with a non-RSA key", ErrInvalidToken)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() || len(sig) != 64 {
+			return fmt.Errorf("%w: ES256 token signed with a non-P-256 key", ErrInvalidToken)
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, alg)
+	}
+	return nil
+}
+
+// StaticKeys is a fixed key set, such as one read from a JWKS file.
+type StaticKeys map[string]crypto.PublicKey
+
+func (k StaticKeys) Key(_ context.Context, kid string) (crypto.PublicKey, error) {
+	key, ok := k[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
+
+// ParseJWKS parses a JSON Web Key Set, as served by the API server's
+// /openid/v1/jwks. Keys not usable for signature checks are skipped.
+func ParseJWKS(data []byte) (StaticKeys, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %v", err)
+	}
+
+	keys := StaticKeys{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil || len(e) > 4 {
+				return nil, fmt.Errorf("invalid RSA key %q", k.Kid)
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("invalid EC key %q", k.Kid)
+			}
+			pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+				return nil, fmt.Errorf("invalid EC key %q", k.Kid)
+			}
+			keys[k.Kid] = pub
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS holds no signing keys")
+	}
+	return keys, nil
+}
+
+// RemoteKeys fetches a JWKS from URL and caches it. An unknown key ID
+// triggers a refetch, so keys the cluster rotates in are picked up, but at
+// most once per MinRefresh, so tokens with made-up key IDs cannot make
+// every request fetch the set. Failed fetches count too, so an API server
+// that is down is not asked again on every request either.
+type RemoteKeys struct {
+	URL    string
+	Client *http.Client
+	// Credentials returns the bearer token fetches authenticate with,
+	// nil for none. The API server serves its JWKS to any authenticated
+	// caller.
+	Credentials func() (string, error)
+	MinRefresh  time.Duration
+
+	mu   sync.Mutex
+	keys StaticKeys
+	// err is the last fetch's error, and fetched when it ended
+	err     error
+	fetched time.Time
+	// fetching is closed when the fetch in progress, if any, ends
+	fetching chan struct{}
+}
+
+func (k *RemoteKeys) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	for {
+		k.mu.Lock()
+		if key, ok := k.keys[kid]; ok {
+			k.mu.Unlock()
+			return key, nil
+		}
+		if !k.fetched.IsZero() && time.Since(k.fetched) < k.MinRefresh {
+			err := k.err
+			k.mu.Unlock()
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+		}
+
+		// Callers arriving during a fetch wait for it rather than start
+		// their own, then look again
+		if fetching := k.fetching; fetching != nil {
+			k.mu.Unlock()
+			select {
+			case <-fetching:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		fetching := make(chan struct{})
+		k.fetching = fetching
+		k.mu.Unlock()
+
+		keys, err := k.fetch(ctx)
+
+		k.mu.Lock()
+		if err == nil {
+			k.keys = keys
+		}
+		// A fetch cut short by its caller says nothing about the API
+		// server, so it leaves the next caller free to fetch
+		if err == nil || ctx.Err() == nil {
+			k.err, k.fetched = err, time.Now()
+		}
+		k.fetching = nil
+		close(fetching)
+		k.mu.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+		return keys.Key(ctx, kid)
+	}
+}
+
+func (k *RemoteKeys) fetch(ctx context.Context) (StaticKeys, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", k.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if k.Credentials != nil {
+		credentials, err := k.Credentials()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWKS credentials: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+credentials)
+	}
+
+	resp, err := k.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	return ParseJWKS(data)
+}
@@ -0,0 +1,257 @@
+package satoken
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testIssuer = "https://kubernetes.default.svc.cluster.local"
+
+var testNow = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// sign builds a token the way the API server does.
+func sign(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+	enc := func(v any) string {
+		b, err := json.Marshal(v)
+		require.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": alg, "kid": kid}) + "." + enc(claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		require.NoError(t, err)
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"iss": testIssuer,
+		"sub": "system:serviceaccount:ci:runner",
+		"aud": []string{"run-tests-example"},
+		"exp": testNow.Add(time.Hour).Unix(),
+		"nbf": testNow.Add(-time.Minute).Unix(),
+	}
+}
+
+func rsaKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+func testVerifier(keys KeySet) *JWKSVerifier {
+	return &JWKSVerifier{
+		Keys:     keys,
+		Issuer:   testIssuer,
+		Audience: "run-tests-example",
+		now:      func() time.Time { return testNow },
+	}
+}
+
+func TestJWKSVerifierAcceptsValidTokens(t *testing.T) {
+	rsaPriv := rsaKey(t)
+	ecPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	v := testVerifier(StaticKeys{"r": &rsaPriv.PublicKey, "e": &ecPriv.PublicKey})
+
+	for _, token := range []string{
+		sign(t, "RS256", "r", rsaPriv, validClaims()),
+		sign(t, "ES256", "e", ecPriv, validClaims()),
+	} {
+		id, err := v.Verify(context.Background(), token)
+		require.NoError(t, err)
+		assert.Equal(t, &Identity{Namespace: "ci", ServiceAccount: "runner"}, id)
+	}
+
+	claims := validClaims()
+	claims["aud"] = "run-tests-example"
+	_, err = v.Verify(context.Background(), sign(t, "RS256", "r", rsaPriv, claims))
+	assert.NoError(t, err, "a single audience may be a plain string")
+}
+
+func TestJWKSVerifierRejectsInvalidTokens(t *testing.T) {
+	priv := rsaKey(t)
+	other := rsaKey(t)
+	v := testVerifier(StaticKeys{"r": &priv.PublicKey})
+
+	with := func(name string, value any) map[string]any {
+		c := validClaims()
+		if value == nil {
+			delete(c, name)
+		} else {
+			c[name] = value
+		}
+		return c
+	}
+
+	cases := map[string]string{
+		"expired":        sign(t, "RS256", "r", priv, with("exp", testNow.Add(-time.Minute).Unix())),
+		"no expiry":      sign(t, "RS256", "r", priv, with("exp", nil)),
+		"not yet valid":  sign(t, "RS256", "r", priv, with("nbf", testNow.Add(time.Minute).Unix())),
+		"wrong issuer":   sign(t, "RS256", "r", priv, with("iss", "https://evil.example.com")),
+		"wrong audience": sign(t, "RS256", "r", priv, with("aud", []string{"other"})),
+		"not an SA":      sign(t, "RS256", "r", priv, with("sub", "alice")),
+		"wrong key":      sign(t, "RS256", "r", other, validClaims()),
+		"unknown key":    sign(t, "RS256", "x", priv, validClaims()),
+		"algorithm":      sign(t, "ES256", "r", priv, validClaims()),
+		"garbage":        "not.a.token",
+	}
+	for name, token := range cases {
+		_, err := v.Verify(context.Background(), token)
+		assert.ErrorIs(t, err, ErrInvalidToken, name)
+	}
+
+	v.Leeway = 2 * time.Minute
+	_, err := v.Verify(context.Background(), cases["expired"])
+	assert.NoError(t, err, "leeway tolerates clock skew")
+}
+
+// jwks serves keys the way the API server does.
+func jwks(keys map[string]*rsa.PrivateKey) []byte {
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for kid, k := range keys {
+		set.Keys = append(set.Keys, map[string]string{
+			"kty": "RSA", "kid": kid, "use": "sig", "alg": "RS256",
+			"n": base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString([]byte{1, 0, 1}),
+		})
+	}
+	b, _ := json.Marshal(set)
+	return b
+}
+
+func TestRemoteKeysRefetchOnUnknownKey(t *testing.T) {
+	first, second := rsaKey(t), rsaKey(t)
+	served := map[string]*rsa.PrivateKey{"1": first}
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write(jwks(served))
+	}))
+	defer srv.Close()
+
+	keys := &RemoteKeys{URL: srv.URL, Client: srv.Client(), MinRefresh: time.Hour}
+	v := testVerifier(keys)
+	ctx := context.Background()
+
+	_, err := v.Verify(ctx, sign(t, "RS256", "1", first, validClaims()))
+	require.NoError(t, err)
+	_, err = v.Verify(ctx, sign(t, "RS256", "1", first, validClaims()))
+	require.NoError(t, err)
+	assert.Equal(t, 1, fetches, "keys are cached")
+
+	// A rotated-in key is not fetched again within MinRefresh
+	served["2"] = second
+	_, err = v.Verify(ctx, sign(t, "RS256", "2", second, validClaims()))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Equal(t, 1, fetches)
+
+	keys.MinRefresh = 0
+	_, err = v.Verify(ctx, sign(t, "RS256", "2", second, validClaims()))
+	require.NoError(t, err)
+	assert.Equal(t, 2, fetches)
+}
+
+func TestRemoteKeysThrottlesFailedFetches(t *testing.T) {
+	var fetches atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	keys := &RemoteKeys{URL: srv.URL, Client: srv.Client(), MinRefresh: time.Hour}
+	for range 3 {
+		_, err := keys.Key(context.Background(), "1")
+		assert.ErrorContains(t, err, "503")
+	}
+	assert.Equal(t, int64(1), fetches.Load())
+
+	// A fetch its caller gives up on is not held against the next one
+	keys = &RemoteKeys{URL: srv.URL, Client: srv.Client(), MinRefresh: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := keys.Key(ctx, "1")
+	assert.ErrorContains(t, err, "context canceled")
+	_, err = keys.Key(context.Background(), "1")
+	assert.ErrorContains(t, err, "503")
+}
+
+func TestRemoteKeysFetchesOnceForConcurrentCallers(t *testing.T) {
+	key := rsaKey(t)
+	var fetches atomic.Int64
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		w.Write(jwks(map[string]*rsa.PrivateKey{"1": key}))
+	}))
+	defer srv.Close()
+	keys := &RemoteKeys{URL: srv.URL, Client: srv.Client(), MinRefresh: time.Hour}
+
+	errs := make(chan error, 8)
+	for range cap(errs) {
+		go func() {
+			_, err := keys.Key(context.Background(), "1")
+			errs <- err
+		}()
+	}
+	require.Eventually(t, func() bool { return fetches.Load() == 1 }, 5*time.Second, time.Millisecond)
+
+	// The fetch does not hold the lock, so callers that give up return
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := keys.Key(ctx, "1")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(release)
+	for range cap(errs) {
+		assert.NoError(t, <-errs)
+	}
+	assert.Equal(t, int64(1), fetches.Load())
+}
+
+func TestParseJWKS(t *testing.T) {
+	_, err := ParseJWKS([]byte(`{"keys": []}`))
+	assert.Error(t, err)
+
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	doc := fmt.Sprintf(`{"keys": [
+		{"kty": "EC", "kid": "e", "crv": "P-256", "x": %q, "y": %q},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"}
+	]}`, base64.RawURLEncoding.EncodeToString(ec.X.Bytes()), base64.RawURLEncoding.EncodeToString(ec.Y.Bytes()))
+	keys, err := ParseJWKS([]byte(doc))
+	require.NoError(t, err)
+	assert.Len(t, keys, 1, "encryption keys are skipped")
+	assert.True(t, ec.PublicKey.Equal(keys["e"]))
+}
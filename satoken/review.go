@@ -0,0 +1,124 @@
+package satoken
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenReviewer asks the API server whether a token is valid, which also
+// catches tokens revoked by deleting their pod or service account. Verdicts
+// are cached for CacheTTL, so a busy caller costs one review per token per
+// TTL rather than one per request.
+type TokenReviewer struct {
+	// URL is the API server, such as https://kubernetes.default.svc.
+	URL    string
+	Client *http.Client
+	// Audiences the token must have been issued for, empty for the API
+	// server's own.
+	Audiences []string
+	// Credentials returns the bearer token the app authenticates its own
+	// reviews with, typically its mounted service account token, which
+	// the kubelet rotates.
+	Credentials func() (string, error)
+	CacheTTL    time.Duration
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]review
+}
+
+// review is a cached TokenReview verdict.
+type review struct {
+	identity *Identity
+	err      error
+	expires  time.Time
+}
+
+func (v *TokenReviewer) Verify(ctx context.Context, token string) (*Identity, error) {
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+
+	v.mu.Lock()
+	if r, ok := v.cache[key]; ok && now.Before(r.expires) {
+		v.mu.Unlock()
+		return r.identity, r.err
+	}
+	v.mu.Unlock()
+
+	identity, err := v.review(ctx, token)
+	// Only verdicts are cached; failing to reach the API server is not
+	if err == nil || errors.Is(err, ErrInvalidToken) {
+		v.mu.Lock()
+		if v.cache == nil {
+			v.cache = map[[sha256.Size]byte]review{}
+		}
+		for k, r := range v.cache {
+			if now.After(r.expires) {
+				delete(v.cache, k)
+			}
+		}
+		v.cache[key] = review{identity: identity, err: err, expires: now.Add(v.CacheTTL)}
+		v.mu.Unlock()
+	}
+	return identity, err
+}
+
+func (v *TokenReviewer) review(ctx context.Context, token string) (*Identity, error) {
+	body, err := json.Marshal(map[string]any{
+		"apiVersion": "authentication.k8s.io/v1",
+		"kind":       "TokenReview",
+		"spec":       map[string]any{"token": token, "audiences": v.Audiences},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST",
+		strings.TrimSuffix(v.URL, "/")+"/apis/authentication.k8s.io/v1/tokenreviews", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if v.Credentials != nil {
+		credentials, err := v.Credentials()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read reviewer credentials: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+credentials)
+	}
+
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token review failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("token review failed: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var result struct {
+		Status struct {
+			Authenticated bool   `json:"authenticated"`
+			Error         string `json:"error"`
+			User          struct {
+				Username string `json:"username"`
+			} `json:"user"`
+		} `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("token review failed: %v", err)
+	}
+	if !result.Status.Authenticated {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidToken, result.Status.Error)
+	}
+	return ParseSubject(result.Status.User.Username)
+}
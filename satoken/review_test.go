@@ -0,0 +1,97 @@
+package satoken
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenReviewer(t *testing.T) {
+	reviews := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reviews++
+		assert.Equal(t, "/apis/authentication.k8s.io/v1/tokenreviews", r.URL.Path)
+		assert.Equal(t, "Bearer reviewer", r.Header.Get("Authorization"))
+
+		var req struct {
+			Spec struct {
+				Token     string   `json:"token"`
+				Audiences []string `json:"audiences"`
+			} `json:"spec"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, []string{"run-tests-example"}, req.Spec.Audiences)
+
+		status := map[string]any{"authenticated": false, "error": "token has been invalidated"}
+		if req.Spec.Token == "good" {
+			status = map[string]any{
+				"authenticated": true,
+				"user":          map[string]any{"username": "system:serviceaccount:ci:runner"},
+			}
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"status": status})
+	}))
+	defer srv.Close()
+
+	v := &TokenReviewer{
+		URL:         srv.URL,
+		Client:      srv.Client(),
+		Audiences:   []string{"run-tests-example"},
+		Credentials: func() (string, error) { return "reviewer", nil },
+		CacheTTL:    time.Minute,
+	}
+	ctx := context.Background()
+
+	id, err := v.Verify(ctx, "good")
+	require.NoError(t, err)
+	assert.Equal(t, "system:serviceaccount:ci:runner", id.Subject())
+
+	_, err = v.Verify(ctx, "revoked")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Contains(t, err.Error(), "invalidated")
+
+	_, err = v.Verify(ctx, "good")
+	require.NoError(t, err)
+	_, err = v.Verify(ctx, "revoked")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Equal(t, 2, reviews, "verdicts are cached")
+}
+
+func TestTokenReviewerDoesNotCacheFailures(t *testing.T) {
+	reviews := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reviews++
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	v := &TokenReviewer{URL: srv.URL, Client: srv.Client(), CacheTTL: time.Minute}
+	for range 2 {
+		_, err := v.Verify(context.Background(), "token")
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrInvalidToken, "the token was never checked")
+	}
+	assert.Equal(t, 2, reviews)
+}
+
+func TestAllowlist(t *testing.T) {
+	list, err := ParseAllowlist(" ci/runner, monitoring/* ,")
+	require.NoError(t, err)
+
+	assert.True(t, list.Allows(&Identity{Namespace: "ci", ServiceAccount: "runner"}))
+	assert.False(t, list.Allows(&Identity{Namespace: "ci", ServiceAccount: "other"}))
+	assert.True(t, list.Allows(&Identity{Namespace: "monitoring", ServiceAccount: "prometheus"}))
+	assert.True(t, Allowlist(nil).Allows(&Identity{Namespace: "any", ServiceAccount: "one"}))
+
+	for _, bad := range []string{"runner", "ci/", "/runner", "a/b/c"} {
+		_, err := ParseAllowlist(bad)
+		assert.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,83 @@
+// Package satoken verifies Kubernetes service account tokens, so workloads
+// calling the API can be authenticated by the service account they run as.
+// Tokens are either validated locally against the cluster's JWKS or sent to
+// the API server's TokenReview endpoint.
+package satoken
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidToken is returned, possibly wrapped, for tokens that are
+// malformed, expired, wrongly signed or issued for someone else. Other
+// errors mean the token could not be checked.
+var ErrInvalidToken = errors.New("invalid service account token")
+
+// Identity is the service account a token was issued to.
+type Identity struct {
+	Namespace      string `json:"namespace"`
+	ServiceAccount string `json:"service_account"`
+}
+
+// Subject renders the identity as Kubernetes does in RBAC and audit logs.
+func (id Identity) Subject() string {
+	return subjectPrefix + id.Namespace + ":" + id.ServiceAccount
+}
+
+// Verifier checks a bearer token and returns whose it is.
+type Verifier interface {
+	Verify(ctx context.Context, token string) (*Identity, error)
+}
+
+const subjectPrefix = "system:serviceaccount:"
+
+// ParseSubject parses a "system:serviceaccount:<namespace>:<name>" subject.
+func ParseSubject(subject string) (*Identity, error) {
+	rest, ok := strings.CutPrefix(subject, subjectPrefix)
+	if !ok {
+		return nil, fmt.Errorf("%w: subject %q is not a service account", ErrInvalidToken, subject)
+	}
+	namespace, name, ok := strings.Cut(rest, ":")
+	if !ok || namespace == "" || name == "" {
+		return nil, fmt.Errorf("%w: malformed subject %q", ErrInvalidToken, subject)
+	}
+	return &Identity{Namespace: namespace, ServiceAccount: name}, nil
+}
+
+// Allowlist limits which service accounts are accepted. Entries are
+// "<namespace>/<name>", or "<namespace>/*" for every service account of a
+// namespace. An empty list allows every service account.
+type Allowlist []string
+
+// ParseAllowlist parses a comma-separated allowlist.
+func ParseAllowlist(s string) (Allowlist, error) {
+	var list Allowlist
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		namespace, name, ok := strings.Cut(entry, "/")
+		if !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid service account %q, want namespace/name", entry)
+		}
+		list = append(list, entry)
+	}
+	return list, nil
+}
+
+// Allows reports whether id is on the list.
+func (l Allowlist) Allows(id *Identity) bool {
+	if len(l) == 0 {
+		return true
+	}
+	for _, entry := range l {
+		if entry == id.Namespace+"/"+id.ServiceAccount || entry == id.Namespace+"/*" {
+			return true
+		}
+	}
+	return false
+}
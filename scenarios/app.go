@@ -0,0 +1,67 @@
+package scenarios
+
+import (
+	"net/url"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// Health checks that the app and its dependencies are healthy.
+func Health() *Scenario {
+	return New("health").
+		Get("/health").Named("health").
+		ExpectStatus(200).
+		ExpectJSON("status", "healthy").
+		ExpectJSON("database", "healthy").
+		ExpectJSON("cache", "healthy").
+		ExpectJSON("version", "1.0.0")
+}
+
+// Root checks the landing page.
+func Root() *Scenario {
+	return New("root").
+		Get("/").Named("root").
+		ExpectStatus(200).
+		ExpectBodyContains("KubeRLy Test App")
+}
+
+// DataLifecycle creates a row as test run run, reads it back, first from
+// the database and then from the cache, deletes the run and checks the row
+// is gone. The row is named after the run and rows of other runs are left
+// alone, so scenarios of different runs may share an app.
+func DataLifecycle(run string) *Scenario {
+	listing := "/api/data?test_run_id=" + url.QueryEscape(run)
+	name := "integration_test-" + run
+	return New("data lifecycle").
+		Post("/api/data", types.TestData{Name: name, Data: "test_data"}).Named("create").
+		WithHeader("X-Test-Run-ID", run).
+		ExpectStatus(201).
+		Get(listing).Named("read").
+		ExpectStatus(200).
+		ExpectHeader("X-Cache", "MISS").
+		ExpectLen("", 1).
+		ExpectJSON("0.name", name).
+		Capture("id", "0.id").
+		Get(listing).Named("read cached").
+		ExpectStatus(200).
+		ExpectHeader("X-Cache", "HIT").
+		Delete("/api/runs/"+url.PathEscape(run)).Named("delete run").
+		ExpectStatus(200).
+		ExpectJSON("rows", 1).
+		Get(listing).Named("read deleted").
+		ExpectStatus(200).
+		ExpectLen("", 0).
+		Get("/api/data/{{id}}/comments").Named("deleted row is gone").
+		ExpectStatus(404)
+}
+
+// CacheOperations sets a cache key and reads it back.
+func CacheOperations(key string) *Scenario {
+	return New("cache operations").
+		Post("/api/cache", map[string]any{"key": key, "value": "test_value", "ttl": 60}).Named("set").
+		ExpectStatus(201).
+		Get("/api/cache?key="+url.QueryEscape(key)).Named("get").
+		ExpectStatus(200).
+		ExpectJSON("key", key).
+		ExpectJSON("value", "test_value")
+}
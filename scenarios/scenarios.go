@@ -0,0 +1,461 @@
+// Package scenarios runs end-to-end test flows against a deployed app.
+// A scenario is a list of HTTP steps with expectations, built in Go or
+// loaded from JSON, so the same flows can be run by this repository's
+// integration tests and by external test platforms against any base URL.
+//
+//	s := scenarios.New("cache").
+//		Post("/api/data", types.TestData{Name: "n"}).ExpectStatus(201).
+//		Get("/api/data").ExpectStatus(200).ExpectHeader("X-Cache", "MISS").
+//		Get("/api/data").ExpectHeader("X-Cache", "HIT")
+//	report := s.Run(ctx, "http://localhost:8080", scenarios.Options{})
+package scenarios
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Scenario is a named sequence of steps, run in order. A failed step
+// stops the scenario.
+type Scenario struct {
+	Name  string `json:"name"`
+	Steps []Step `json:"steps"`
+}
+
+// Step is one request and what its response must look like. Path, header
+// values and string bodies may refer to variables as {{name}}.
+type Step struct {
+	Name   string            `json:"name,omitempty"`
+	Method string            `json:"method"`
+	Path   string            `json:"path"`
+	Header map[string]string `json:"header,omitempty"`
+	// Body is sent as JSON, or as is when it is a string
+	Body   any    `json:"body,omitempty"`
+	Expect Expect `json:"expect"`
+	// Capture stores values of the JSON response in variables, by path
+	Capture map[string]string `json:"capture,omitempty"`
+}
+
+// Expect lists the checks of a response. Zero fields are not checked.
+type Expect struct {
+	Status int `json:"status,omitempty"`
+	// Header values must match exactly
+	Header map[string]string `json:"header,omitempty"`
+	// BodyContains are substrings of the body
+	BodyContains []string `json:"body_contains,omitempty"`
+	// JSON maps paths of the JSON response to the values they must hold
+	JSON map[string]any `json:"json,omitempty"`
+	// Absent are paths the JSON response must not have
+	Absent []string `json:"absent,omitempty"`
+	// Len maps paths of the JSON response to the length of the array or
+	// object they must hold
+	Len map[string]int `json:"len,omitempty"`
+}
+
+// New starts a scenario.
+func New(name string) *Scenario {
+	return &Scenario{Name: name}
+}
+
+// Load reads a scenario defined as JSON.
+func Load(r io.Reader) (*Scenario, error) {
+	var s Scenario
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("loading scenario: %w", err)
+	}
+	return &s, nil
+}
+
+// Do adds a step; the Expect and Capture methods apply to the last step
+// added.
+func (s *Scenario) Do(method, path string, body any) *Scenario {
+	s.Steps = append(s.Steps, Step{Method: method, Path: path, Body: body})
+	return s
+}
+
+// Get adds a GET step.
+func (s *Scenario) Get(path string) *Scenario { return s.Do("GET", path, nil) }
+
+// Post adds a POST step.
+func (s *Scenario) Post(path string, body any) *Scenario { return s.Do("POST", path, body) }
+
+// Put adds a PUT step.
+func (s *Scenario) Put(path string, body any) *Scenario { return s.Do("PUT", path, body) }
+
+// Delete adds a DELETE step.
+func (s *Scenario) Delete(path string) *Scenario { return s.Do("DELETE", path, nil) }
+
+func (s *Scenario) last() *Step {
+	if len(s.Steps) == 0 {
+		panic("scenarios: no step to configure")
+	}
+	return &s.Steps[len(s.Steps)-1]
+}
+
+// Named names the last step in reports.
+func (s *Scenario) Named(name string) *Scenario {
+	s.last().Name = name
+	return s
+}
+
+// WithHeader sets a request header of the last step.
+func (s *Scenario) WithHeader(name, value string) *Scenario {
+	step := s.last()
+	if step.Header == nil {
+		step.Header = map[string]string{}
+	}
+	step.Header[name] = value
+	return s
+}
+
+// ExpectStatus expects the last step to respond with code.
+func (s *Scenario) ExpectStatus(code int) *Scenario {
+	s.last().Expect.Status = code
+	return s
+}
+
+// ExpectHeader expects a response header of the last step to be value.
+func (s *Scenario) ExpectHeader(name, value string) *Scenario {
+	e := &s.last().Expect
+	if e.Header == nil {
+		e.Header = map[string]string{}
+	}
+	e.Header[name] = value
+	return s
+}
+
+// ExpectBodyContains expects the body of the last step to contain sub.
+func (s *Scenario) ExpectBodyContains(sub string) *Scenario {
+	e := &s.last().Expect
+	e.BodyContains = append(e.BodyContains, sub)
+	return s
+}
+
+// ExpectJSON expects the value at path in the last step's JSON response
+// to equal want, compared as JSON.
+func (s *Scenario) ExpectJSON(path string, want any) *Scenario {
+	e := &s.last().Expect
+	if e.JSON == nil {
+		e.JSON = map[string]any{}
+	}
+	e.JSON[path] = want
+	return s
+}
+
+// ExpectAbsent expects the last step's JSON response to have nothing at
+// path.
+func (s *Scenario) ExpectAbsent(path string) *Scenario {
+	e := &s.last().Expect
+	e.Absent = append(e.Absent, path)
+	return s
+}
+
+// ExpectLen expects the array or object at path in the last step's JSON
+// response to have n elements.
+func (s *Scenario) ExpectLen(path string, n int) *Scenario {
+	e := &s.last().Expect
+	if e.Len == nil {
+		e.Len = map[string]int{}
+	}
+	e.Len[path] = n
+	return s
+}
+
+// Capture stores the value at path in the last step's JSON response in
+// the variable name, for later steps to use as {{name}}.
+func (s *Scenario) Capture(name, path string) *Scenario {
+	step := s.last()
+	if step.Capture == nil {
+		step.Capture = map[string]string{}
+	}
+	step.Capture[name] = path
+	return s
+}
+
+// Options configures a run.
+type Options struct {
+	// Client sends the requests, a client with a 10s timeout when nil
+	Client *http.Client
+	// Header is sent with every request, under the headers of each step
+	Header map[string]string
+	// Vars are the initial variables
+	Vars map[string]string
+}
+
+// Report is the outcome of a run.
+type Report struct {
+	Scenario string        `json:"scenario"`
+	Passed   bool          `json:"passed"`
+	Duration time.Duration `json:"duration_ns"`
+	Steps    []StepReport  `json:"steps"`
+}
+
+// StepReport is the outcome of a step. Steps after a failed one are not
+// run and not reported.
+type StepReport struct {
+	Name     string        `json:"name"`
+	Method   string        `json:"method"`
+	URL      string        `json:"url"`
+	Status   int           `json:"status,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+	Failures []string      `json:"failures,omitempty"`
+}
+
+// String formats the report as one line per step.
+func (r *Report) String() string {
+	var b strings.Builder
+	result := "PASS"
+	if !r.Passed {
+		result = "FAIL"
+	}
+	fmt.Fprintf(&b, "%s %s (%s)\n", result, r.Scenario, r.Duration.Round(time.Millisecond))
+	for _, step := range r.Steps {
+		result := "ok  "
+		if len(step.Failures) > 0 {
+			result = "FAIL"
+		}
+		fmt.Fprintf(&b, "  %s %-24s %s %s -> %d (%s)\n", result, step.Name, step.Method, step.URL, step.Status, step.Duration.Round(time.Millisecond))
+		for _, f := range step.Failures {
+			fmt.Fprintf(&b, "       %s\n", f)
+		}
+	}
+	return b.String()
+}
+
+// Run runs the scenario against the app at baseURL.
+func (s *Scenario) Run(ctx context.Context, baseURL string, opts Options) *Report {
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	vars := map[string]string{}
+	for k, v := range opts.Vars {
+		vars[k] = v
+	}
+
+	report := &Report{Scenario: s.Name, Passed: true}
+	start := time.Now()
+	for i, step := range s.Steps {
+		result := runStep(ctx, client, strings.TrimSuffix(baseURL, "/"), step, opts.Header, vars)
+		if result.Name == "" {
+			result.Name = fmt.Sprintf("step %d", i+1)
+		}
+		report.Steps = append(report.Steps, result)
+		if len(result.Failures) > 0 {
+			report.Passed = false
+			break
+		}
+	}
+	report.Duration = time.Since(start)
+	return report
+}
+
+func runStep(ctx context.Context, client *http.Client, baseURL string, step Step, header map[string]string, vars map[string]string) StepReport {
+	result := StepReport{Name: step.Name, Method: step.Method, URL: baseURL + expand(step.Path, vars)}
+	fail := func(format string, args ...any) StepReport {
+		result.Failures = append(result.Failures, fmt.Sprintf(format, args...))
+		return result
+	}
+
+	var body io.Reader
+	switch b := step.Body.(type) {
+	case nil:
+	case string:
+		body = strings.NewReader(expand(b, vars))
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			return fail("encoding body: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, step.Method, result.URL, body)
+	if err != nil {
+		return fail("%v", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for _, h := range []map[string]string{header, step.Header} {
+		for name, value := range h {
+			req.Header.Set(name, expand(value, vars))
+		}
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Duration = time.Since(start)
+		return fail("%v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	result.Duration = time.Since(start)
+	result.Status = resp.StatusCode
+	if err != nil {
+		return fail("reading body: %v", err)
+	}
+
+	e := step.Expect
+	if e.Status != 0 && resp.StatusCode != e.Status {
+		fail("status %d, want %d: %s", resp.StatusCode, e.Status, truncate(data))
+	}
+	for name, want := range e.Header {
+		if got := resp.Header.Get(name); got != expand(want, vars) {
+			fail("header %s is %q, want %q", name, got, expand(want, vars))
+		}
+	}
+	for _, sub := range e.BodyContains {
+		if !bytes.Contains(data, []byte(expand(sub, vars))) {
+			fail("body does not contain %q: %s", expand(sub, vars), truncate(data))
+		}
+	}
+
+	if len(e.JSON) == 0 && len(e.Absent) == 0 && len(e.Len) == 0 && len(step.Capture) == 0 {
+		return result
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fail("response is not JSON: %v", err)
+	}
+	for path, want := range e.JSON {
+		got, err := lookup(doc, path)
+		if err != nil {
+			fail("%v", err)
+			continue
+		}
+		if !equalJSON(got, want) {
+			fail("%s is %s, want %s", path, formatJSON(got), formatJSON(want))
+		}
+	}
+	for _, path := range e.Absent {
+		if got, err := lookup(doc, path); err == nil {
+			fail("%s is %s, want it absent", path, formatJSON(got))
+		}
+	}
+	for path, n := range e.Len {
+		got, err := lookup(doc, path)
+		if err != nil {
+			fail("%v", err)
+			continue
+		}
+		switch v := got.(type) {
+		case []any:
+			if len(v) != n {
+				fail("%s has %d elements, want %d", path, len(v), n)
+			}
+		case map[string]any:
+			if len(v) != n {
+				fail("%s has %d members, want %d", path, len(v), n)
+			}
+		default:
+			fail("%s is %s, not an array or object", path, formatJSON(got))
+		}
+	}
+	for name, path := range step.Capture {
+		got, err := lookup(doc, path)
+		if err != nil {
+			fail("capturing %s: %v", name, err)
+			continue
+		}
+		if s, ok := got.(string); ok {
+			vars[name] = s
+		} else {
+			vars[name] = formatJSON(got)
+		}
+	}
+	return result
+}
+
+// expand replaces {{name}} with the value of the variable name. Unknown
+// variables are left as they are, so the step fails visibly.
+func expand(s string, vars map[string]string) string {
+	for name, value := range vars {
+		s = strings.ReplaceAll(s, "{{"+name+"}}", value)
+	}
+	return s
+}
+
+// lookup returns the value at a dot-separated path of object members and
+// array indexes, such as "items.0.id". The empty path is the document.
+func lookup(doc any, path string) (any, error) {
+	if path == "" {
+		return doc, nil
+	}
+	v := doc
+	for _, part := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			next, ok := node[part]
+			if !ok {
+				return nil, fmt.Errorf("%s: no member %q", path, part)
+			}
+			v = next
+		case []any:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, fmt.Errorf("%s: no element %q in an array of %d", path, part, len(node))
+			}
+			v = node[i]
+		default:
+			return nil, fmt.Errorf("%s: %q is inside a %s", path, part, formatJSON(v))
+		}
+	}
+	return v, nil
+}
+
+// equalJSON compares a decoded value with a Go value as their JSON forms,
+// so 201 equals 201.0 and structs equal their objects.
+func equalJSON(got, want any) bool {
+	b, err := json.Marshal(want)
+	if err != nil {
+		return false
+	}
+	var normalized any
+	if err := json.Unmarshal(b, &normalized); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(got, normalized)
+}
+
+func formatJSON(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// truncate shortens a body for failure messages.
+func truncate(b []byte) string {
+	const limit = 200
+	s := strings.TrimSpace(string(b))
+	if len(s) > limit {
+		return s[:limit] + "..."
+	}
+	return s
+}
+
+// Test runs the scenario within a Go test, logging the report and failing
+// t unless every step passed.
+func (s *Scenario) Test(t testing.TB, baseURL string, opts Options) *Report {
+	t.Helper()
+	report := s.Run(t.Context(), baseURL, opts)
+	if report.Passed {
+		t.Log(report.String())
+	} else {
+		t.Error(report.String())
+	}
+	return report
+}
@@ -0,0 +1,81 @@
+package scenarios
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/apptest"
+)
+
+func TestAppScenariosPassAgainstMock(t *testing.T) {
+	m := apptest.NewMockServer()
+	defer m.Close()
+
+	Health().Test(t, m.URL, Options{})
+	report := DataLifecycle("run-1").Test(t, m.URL, Options{})
+	assert.Len(t, report.Steps, 6)
+	assert.Empty(t, m.Rows())
+}
+
+func TestFailedStepStopsScenario(t *testing.T) {
+	m := apptest.NewMockServer()
+	defer m.Close()
+
+	report := New("broken").
+		Get("/health").ExpectStatus(200).ExpectJSON("status", "sick").
+		Get("/health").
+		Run(context.Background(), m.URL, Options{})
+	assert.False(t, report.Passed)
+	require.Len(t, report.Steps, 1)
+	assert.Equal(t, []string{`status is "healthy", want "sick"`}, report.Steps[0].Failures)
+	assert.Contains(t, report.String(), "FAIL broken")
+}
+
+func TestCaptureAndHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			w.Write([]byte(`{"items":[{"id":7,"token":"abc"}]}`))
+		case "/items/7":
+			if r.Header.Get("Authorization") != "Bearer abc" || r.Header.Get("X-Suite") != "unit" {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("X-Item", "7")
+			w.Write([]byte(`{"id":7,"tags":["a","b"]}`))
+		}
+	}))
+	defer srv.Close()
+
+	report := New("capture").
+		Get("/token").Capture("id", "items.0.id").Capture("token", "items.0.token").
+		Get("/items/{{id}}").WithHeader("Authorization", "Bearer {{token}}").
+		ExpectStatus(200).ExpectHeader("X-Item", "{{id}}").ExpectLen("tags", 2).ExpectJSON("id", 7).
+		Run(context.Background(), srv.URL, Options{Header: map[string]string{"X-Suite": "unit"}})
+	assert.True(t, report.Passed, report.String())
+	assert.Equal(t, srv.URL+"/items/7", report.Steps[1].URL)
+}
+
+func TestLoad(t *testing.T) {
+	m := apptest.NewMockServer()
+	defer m.Close()
+
+	s, err := Load(strings.NewReader(`{
+		"name": "from json",
+		"steps": [
+			{"method": "POST", "path": "/api/data", "body": {"name": "n", "tags": ["smoke"]}, "expect": {"status": 201}},
+			{"method": "GET", "path": "/api/data?tag=smoke", "expect": {"status": 200, "len": {"": 1}, "json": {"0.tags": ["smoke"]}}}
+		]
+	}`))
+	require.NoError(t, err)
+	s.Test(t, m.URL, Options{})
+
+	_, err = Load(strings.NewReader(`{"name": "typo", "stpes": []}`))
+	assert.Error(t, err)
+}
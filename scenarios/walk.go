@@ -0,0 +1,210 @@
+package scenarios
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"slices"
+
+	"github.com/nesymno/run-tests-example/types"
+)
+
+// walkTags and walkStatuses are the values random rows and filters draw
+// from, few enough for filters to match often.
+var (
+	walkTags     = []string{"red", "green", "blue"}
+	walkStatuses = []string{types.StatusActive, types.StatusArchived}
+)
+
+// walkModel is the state of the API a random walk expects: the rows of its
+// test runs in creation order, which is ID order.
+type walkModel struct {
+	rows     []*walkRow
+	created  int
+	comments int
+}
+
+type walkRow struct {
+	name     string
+	tags     []string
+	status   string
+	run      string
+	id       string // variable holding the row's ID
+	comments []walkComment
+}
+
+type walkComment struct {
+	body string
+	id   string // variable holding the comment's ID
+}
+
+func (m *walkModel) matching(run, tag, status string) []*walkRow {
+	var rows []*walkRow
+	for _, row := range m.rows {
+		if row.run == run && (tag == "" || slices.Contains(row.tags, tag)) && (status == "" || row.status == status) {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// RandomWalk returns a scenario of n random operations on rows of the test
+// runs run+"-a" and run+"-b": creating rows, listing them with random
+// filters, adding and deleting comments, listing rows with their comments
+// and deleting a run. Every response is checked against what an in-memory
+// model of the API predicts, so a listing served from a cache entry that a
+// write should have invalidated fails the step. The same seed yields the
+// same scenario; a failing seed reproduces the failure.
+//
+// Rows are only ever listed within their test run, so walks of different
+// runs may share an app with other data.
+func RandomWalk(run string, seed uint64, n int) *Scenario {
+	rng := rand.New(rand.NewPCG(seed, seed))
+	runs := []string{run + "-a", run + "-b"}
+	m := &walkModel{}
+	s := New(fmt.Sprintf("random walk %s seed %d", run, seed))
+
+	for len(s.Steps) < n {
+		switch p := rng.IntN(100); {
+		case p < 30:
+			walkCreate(s, m, rng, runs[rng.IntN(2)])
+		case p < 55:
+			walkList(s, m, rng, runs[rng.IntN(2)])
+		case p < 70:
+			walkAddComment(s, m, rng)
+		case p < 80:
+			walkDeleteComment(s, m, rng)
+		case p < 95:
+			walkListComments(s, m, runs[rng.IntN(2)])
+		default:
+			walkDeleteRun(s, m, runs[rng.IntN(2)])
+		}
+	}
+	return s
+}
+
+// walkCreate creates a row, then lists its run to check the row shows up
+// and to capture its ID.
+func walkCreate(s *Scenario, m *walkModel, rng *rand.Rand, run string) {
+	m.created++
+	row := &walkRow{
+		name:   fmt.Sprintf("%s-%d", run, m.created),
+		tags:   []string{},
+		status: walkStatuses[rng.IntN(len(walkStatuses))],
+		run:    run,
+		id:     fmt.Sprintf("row%d", m.created),
+	}
+	for _, tag := range walkTags {
+		if rng.IntN(2) == 0 {
+			row.tags = append(row.tags, tag)
+		}
+	}
+	m.rows = append(m.rows, row)
+
+	s.Post("/api/data", types.TestData{Name: row.name, Tags: row.tags, Status: row.status}).
+		Named("create "+row.name).
+		WithHeader("X-Test-Run-ID", run).
+		ExpectStatus(201)
+
+	rows := m.matching(run, "", "")
+	s.Get("/api/data?fields=id,name&test_run_id=" + url.QueryEscape(run)).
+		Named("list after create").
+		ExpectStatus(200)
+	expectNames(s, rows)
+	s.Capture(row.id, fmt.Sprintf("%d.id", len(rows)-1))
+}
+
+// walkList lists a run with a random tag and status filter.
+func walkList(s *Scenario, m *walkModel, rng *rand.Rand, run string) {
+	q := url.Values{"test_run_id": {run}, "fields": {"name"}}
+	var tag, status string
+	if rng.IntN(2) == 0 {
+		tag = walkTags[rng.IntN(len(walkTags))]
+		q.Set("tag", tag)
+	}
+	if rng.IntN(3) == 0 {
+		status = walkStatuses[rng.IntN(len(walkStatuses))]
+		q.Set("status", status)
+	}
+	s.Get("/api/data?" + q.Encode()).Named("list").ExpectStatus(200)
+	expectNames(s, m.matching(run, tag, status))
+}
+
+func walkAddComment(s *Scenario, m *walkModel, rng *rand.Rand) {
+	if len(m.rows) == 0 {
+		return
+	}
+	row := m.rows[rng.IntN(len(m.rows))]
+	m.comments++
+	c := walkComment{body: fmt.Sprintf("comment %d", m.comments), id: fmt.Sprintf("comment%d", m.comments)}
+	row.comments = append(row.comments, c)
+
+	s.Post("/api/data/{{"+row.id+"}}/comments", map[string]string{"body": c.body}).
+		Named("comment on "+row.name).
+		ExpectStatus(201).
+		ExpectJSON("body", c.body).
+		Capture(c.id, "id")
+}
+
+func walkDeleteComment(s *Scenario, m *walkModel, rng *rand.Rand) {
+	var rows []*walkRow
+	for _, row := range m.rows {
+		if len(row.comments) > 0 {
+			rows = append(rows, row)
+		}
+	}
+	if len(rows) == 0 {
+		return
+	}
+	row := rows[rng.IntN(len(rows))]
+	i := rng.IntN(len(row.comments))
+	c := row.comments[i]
+	row.comments = slices.Delete(row.comments, i, i+1)
+
+	s.Delete("/api/data/{{" + row.id + "}}/comments/{{" + c.id + "}}").
+		Named("delete " + c.body).
+		ExpectStatus(204)
+}
+
+// walkListComments lists a run with the comments of each row.
+func walkListComments(s *Scenario, m *walkModel, run string) {
+	rows := m.matching(run, "", "")
+	s.Get("/api/data?fields=name,comments&include=comments&test_run_id=" + url.QueryEscape(run)).
+		Named("list with comments").
+		ExpectStatus(200)
+	expectNames(s, rows)
+	for i, row := range rows {
+		path := fmt.Sprintf("%d.comments", i)
+		if len(row.comments) == 0 {
+			s.ExpectAbsent(path)
+			continue
+		}
+		s.ExpectLen(path, len(row.comments))
+		for j, c := range row.comments {
+			s.ExpectJSON(fmt.Sprintf("%s.%d.body", path, j), c.body)
+		}
+	}
+}
+
+func walkDeleteRun(s *Scenario, m *walkModel, run string) {
+	rows := m.matching(run, "", "")
+	comments := 0
+	for _, row := range rows {
+		comments += len(row.comments)
+	}
+	m.rows = slices.DeleteFunc(m.rows, func(row *walkRow) bool { return row.run == run })
+
+	s.Delete("/api/runs/"+url.PathEscape(run)).
+		Named("delete run "+run).
+		ExpectStatus(200).
+		ExpectJSON("rows", len(rows)).
+		ExpectJSON("comments", comments)
+}
+
+// expectNames expects the last step to list exactly rows, in order.
+func expectNames(s *Scenario, rows []*walkRow) {
+	s.ExpectLen("", len(rows))
+	for i, row := range rows {
+		s.ExpectJSON(fmt.Sprintf("%d.name", i), row.name)
+	}
+}
@@ -0,0 +1,71 @@
+package scenarios
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nesymno/run-tests-example/apptest"
+)
+
+func TestRandomWalkIsDeterministic(t *testing.T) {
+	assert.Equal(t, RandomWalk("run", 7, 50), RandomWalk("run", 7, 50))
+	assert.NotEqual(t, RandomWalk("run", 7, 50), RandomWalk("run", 8, 50))
+}
+
+func TestRandomWalksMatchModel(t *testing.T) {
+	m := apptest.NewMockServer()
+	defer m.Close()
+
+	for seed := range uint64(20) {
+		RandomWalk(fmt.Sprintf("walk%d", seed), seed, 60).Test(t, m.URL, Options{})
+	}
+}
+
+// staleCache caches listings until rows are created or a run is deleted,
+// forgetting that comments change listings with include=comments.
+func staleCache(next http.Handler) http.Handler {
+	var mu sync.Mutex
+	cached := map[string][]byte{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method != "GET" || r.URL.Path != "/api/data" {
+			if r.URL.Path == "/api/data" || strings.HasPrefix(r.URL.Path, "/api/runs/") {
+				clear(cached)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if body, ok := cached[r.URL.RawQuery]; ok {
+			w.Write(body)
+			return
+		}
+		rec := httptest.NewRecorder()
+		next.ServeHTTP(rec, r)
+		cached[r.URL.RawQuery] = rec.Body.Bytes()
+		w.Write(bytes.Clone(rec.Body.Bytes()))
+	})
+}
+
+func TestRandomWalksFindStaleCacheEntries(t *testing.T) {
+	m := apptest.NewMockServer()
+	defer m.Close()
+	srv := httptest.NewServer(staleCache(m.Config.Handler))
+	defer srv.Close()
+
+	failed := 0
+	for seed := range uint64(20) {
+		if !RandomWalk(fmt.Sprintf("walk%d", seed), seed, 60).Run(context.Background(), srv.URL, Options{}).Passed {
+			failed++
+		}
+	}
+	assert.Positive(t, failed)
+}
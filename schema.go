@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/nesymno/run-tests-example/app"
+	"github.com/nesymno/run-tests-example/migrations"
+)
+
+// initDatabase applies the pending migrations, then brings the tenant
+// schemas up to date with the shared tables.
+func initDatabase(db *sql.DB) error {
+	ctx := context.Background()
+	applied, err := migrations.Up(ctx, db)
+	for _, m := range applied {
+		log.Printf("Applied migration %s", m)
+	}
+	if err != nil {
+		return err
+	}
+	return app.UpgradeTenantSchemas(ctx, db)
+}
+
+// checkSchemaCompatibility compares the version of the newest migration
+// this build has with the newest version recorded in schema_migrations. In
+// "strict" mode (the default) the two must match; in "forward" mode a
+// newer database schema is tolerated so the old build can keep serving
+// during a blue/green rollout; "off" skips the check.
+func checkSchemaCompatibility(db *sql.DB, mode string) error {
+	if mode == "off" {
+		return nil
+	}
+	schemaVersion := migrations.Latest()
+
+	var dbVersion int
+	err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&dbVersion)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	switch {
+	case dbVersion == schemaVersion:
+		return nil
+	case dbVersion > schemaVersion && mode == "forward":
+		return nil
+	case dbVersion > schemaVersion:
+		return fmt.Errorf("database schema version %d is newer than supported version %d", dbVersion, schemaVersion)
+	default:
+		return fmt.Errorf("database schema version %d is older than required version %d", dbVersion, schemaVersion)
+	}
+}
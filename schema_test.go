@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/migrations"
+)
+
+// TestSchemaRoundTrip applies initDatabase to a scratch schema in the
+// POSTGRES_* database, first empty and then holding the original test_data
+// table with rows, and checks that reapplying it changes neither the
+// schema nor the data, and that reverting every migration and applying
+// them again gives the same schema.
+func TestSchemaRoundTrip(t *testing.T) {
+	if os.Getenv("POSTGRES_HOST") == "" {
+		t.Skip("POSTGRES_HOST is not set")
+	}
+
+	t.Run("Empty Database", func(t *testing.T) {
+		db := scratchSchema(t)
+		require.NoError(t, initDatabase(db))
+		before := schemaSnapshot(t, db)
+		require.NoError(t, checkSchemaCompatibility(db, "strict"))
+
+		_, err := db.Exec(`INSERT INTO test_data (name, data, tags, status, secret, tenant_id, test_run_id)
+			VALUES ('full', 'payload', '{a,b}', 'archived', '\x01', 7, 'run-1')`)
+		require.NoError(t, err)
+		_, err = db.Exec("INSERT INTO test_data_comments (data_id, body) SELECT id, 'note' FROM test_data")
+		require.NoError(t, err)
+		rows := tableContents(t, db)
+
+		require.NoError(t, initDatabase(db), "reapplying the schema")
+		assert.Equal(t, before, schemaSnapshot(t, db), "schema changed when reapplied")
+		assert.Equal(t, rows, tableContents(t, db), "data changed when the schema was reapplied")
+		assert.NoError(t, checkSchemaCompatibility(db, "strict"))
+	})
+
+	t.Run("Original Table", func(t *testing.T) {
+		// The first schema, from before any column was added
+		db := scratchSchema(t)
+		_, err := db.Exec(`
+			CREATE TABLE test_data (
+				id SERIAL PRIMARY KEY,
+				name VARCHAR(255) NOT NULL,
+				data TEXT,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			)
+		`)
+		require.NoError(t, err)
+		_, err = db.Exec("INSERT INTO test_data (name, data) VALUES ('old1', 'data1'), ('old2', NULL)")
+		require.NoError(t, err)
+
+		require.NoError(t, initDatabase(db))
+		assert.NoError(t, checkSchemaCompatibility(db, "strict"))
+
+		// Old rows get the defaults of the columns added since
+		var tags []string
+		var status string
+		var secret []byte
+		var tenantID sql.NullInt64
+		var run sql.NullString
+		err = db.QueryRow("SELECT tags, status, secret, tenant_id, test_run_id FROM test_data WHERE name = 'old1'").
+			Scan(pq.Array(&tags), &status, &secret, &tenantID, &run)
+		require.NoError(t, err)
+		assert.Empty(t, tags)
+		assert.Equal(t, "active", status)
+		assert.Nil(t, secret)
+		assert.False(t, tenantID.Valid)
+		assert.False(t, run.Valid)
+
+		// And the upgraded schema is the one a new database gets
+		fresh := scratchSchema(t)
+		require.NoError(t, initDatabase(fresh))
+		assert.Equal(t, schemaSnapshot(t, fresh), schemaSnapshot(t, db))
+
+		rows := tableContents(t, db)
+		require.NoError(t, initDatabase(db), "reapplying the schema")
+		assert.Equal(t, rows, tableContents(t, db))
+	})
+
+	t.Run("Down and Up", func(t *testing.T) {
+		db := scratchSchema(t)
+		require.NoError(t, initDatabase(db))
+		before := schemaSnapshot(t, db)
+
+		reverted, err := migrations.Down(context.Background(), db, migrations.Latest())
+		require.NoError(t, err)
+		require.Len(t, reverted, migrations.Latest())
+		assert.Equal(t, migrations.Latest(), reverted[0].Version, "newest first")
+		assert.Error(t, checkSchemaCompatibility(db, "strict"))
+
+		require.NoError(t, initDatabase(db), "applying the migrations again")
+		assert.Equal(t, before, schemaSnapshot(t, db))
+		assert.NoError(t, checkSchemaCompatibility(db, "strict"))
+	})
+}
+
+// scratchSchema returns a connection to the POSTGRES_* database whose
+// search path is a new, empty schema, dropped when t ends.
+func scratchSchema(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		os.Getenv("POSTGRES_HOST"), os.Getenv("POSTGRES_PORT"), os.Getenv("POSTGRES_USER"),
+		os.Getenv("POSTGRES_PASSWORD"), os.Getenv("POSTGRES_DB"))
+	schema := fmt.Sprintf("schema_test_%d", time.Now().UnixNano())
+
+	admin, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { admin.Close() })
+	_, err = admin.Exec("CREATE SCHEMA " + pq.QuoteIdentifier(schema))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, err := admin.Exec("DROP SCHEMA " + pq.QuoteIdentifier(schema) + " CASCADE")
+		assert.NoError(t, err, "failed to drop scratch schema")
+	})
+
+	db, err := sql.Open("postgres", dsn+" search_path="+schema)
+	require.NoError(t, err)
+	// Closed before the schema is dropped, cleanups running last first
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// schemaSnapshot describes the tables, columns, indexes and constraints
+// of the connection's schema, in a stable order.
+func schemaSnapshot(t *testing.T, db *sql.DB) []string {
+	t.Helper()
+	return queryStrings(t, db, `
+		SELECT 'column ' || table_name || '.' || column_name || ' ' || data_type
+			|| ' null=' || is_nullable || ' default=' || COALESCE(column_default, '')
+		FROM information_schema.columns WHERE table_schema = current_schema()
+		UNION ALL
+		SELECT 'index ' || tablename || ' ' || regexp_replace(indexdef, ' ON \S+\.', ' ON ')
+		FROM pg_indexes WHERE schemaname = current_schema()
+		UNION ALL
+		SELECT 'constraint ' || conrelid::regclass || ' ' || conname || ' ' || pg_get_constraintdef(oid)
+		FROM pg_constraint WHERE connamespace = (SELECT oid FROM pg_namespace WHERE nspname = current_schema())
+		ORDER BY 1`)
+}
+
+// tableContents renders the rows of the data tables, in a stable order.
+func tableContents(t *testing.T, db *sql.DB) []string {
+	t.Helper()
+	return queryStrings(t, db, `
+		SELECT 'test_data ' || row_to_json(d)::text FROM test_data d
+		UNION ALL
+		SELECT 'test_data_comments ' || row_to_json(c)::text FROM test_data_comments c
+		UNION ALL
+		SELECT 'schema_migrations ' || version FROM schema_migrations
+		ORDER BY 1`)
+}
+
+func queryStrings(t *testing.T, db *sql.DB, query string) []string {
+	t.Helper()
+	rows, err := db.QueryContext(context.Background(), query)
+	require.NoError(t, err)
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var s string
+		require.NoError(t, rows.Scan(&s))
+		out = append(out, s)
+	}
+	require.NoError(t, rows.Err())
+	return out
+}
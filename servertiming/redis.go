@@ -0,0 +1,35 @@
+package servertiming
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisHook returns a go-redis hook adding the time of commands and
+// pipelines to the cache segment of the request they are made for:
+//
+//	rds.AddHook(servertiming.RedisHook())
+func RedisHook() redis.Hook {
+	return redisHook{}
+}
+
+type redisHook struct{}
+
+func (redisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (redisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		defer track(ctx, Cache)()
+		return next(ctx, cmd)
+	}
+}
+
+func (redisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		defer track(ctx, Cache)()
+		return next(ctx, cmds)
+	}
+}
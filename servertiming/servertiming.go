@@ -0,0 +1,146 @@
+// Package servertiming measures where the time of a request goes: waiting
+// for PostgreSQL, waiting for the cache, and in the app itself, reported
+// in a Server-Timing response header. A request is measured once its
+// context is made with WithTimings; the database and Redis clients add the
+// time of the calls made with such a context when they are instrumented
+// with Connector and RedisHook, and note the call the context ended
+// during, if any.
+package servertiming
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The segments of the Server-Timing header.
+const (
+	// DB is the time spent in PostgreSQL calls.
+	DB = "db"
+	// Cache is the time spent in Redis calls.
+	Cache = "cache"
+	// App is the rest of the request: the time of the request less the
+	// time of the other segments.
+	App = "app"
+)
+
+type timingsKey struct{}
+
+// Timings accumulates the time of one request per segment. Calls made
+// concurrently for the request all count, so the segments may add up to
+// more than the request took.
+type Timings struct {
+	start time.Time
+	db    atomic.Int64
+	cache atomic.Int64
+	// interrupted is the segment of the call the context ended during
+	interrupted atomic.Pointer[string]
+}
+
+// WithTimings starts measuring a request, returning the context its calls
+// are to be made with.
+func WithTimings(ctx context.Context) (context.Context, *Timings) {
+	t := &Timings{start: time.Now()}
+	return context.WithValue(ctx, timingsKey{}, t), t
+}
+
+// FromContext returns the timings of the request ctx belongs to, nil if it
+// is not measured.
+func FromContext(ctx context.Context) *Timings {
+	t, _ := ctx.Value(timingsKey{}).(*Timings)
+	return t
+}
+
+// add adds the time since start to segment.
+func (t *Timings) add(segment string, start time.Time) {
+	d := int64(time.Since(start))
+	if segment == DB {
+		t.db.Add(d)
+	} else {
+		t.cache.Add(d)
+	}
+}
+
+// track times a call to segment made with ctx, returning the func to call
+// once it returns, which does nothing when the request is not measured.
+func track(ctx context.Context, segment string) func() {
+	t := FromContext(ctx)
+	if t == nil {
+		return func() {}
+	}
+	start := time.Now()
+	live := ctx.Err() == nil
+	return func() {
+		t.add(segment, start)
+		if live && ctx.Err() != nil {
+			t.interrupted.CompareAndSwap(nil, &segment)
+		}
+	}
+}
+
+// Interrupted returns the segment of the first call the request's context
+// was cancelled or timed out during, "" if it ended between calls or has
+// not ended. Calls started once it ended don't count, as they fail at
+// once.
+func (t *Timings) Interrupted() string {
+	if segment := t.interrupted.Load(); segment != nil {
+		return *segment
+	}
+	return ""
+}
+
+// Header returns the Server-Timing header value for the request so far,
+// such as "db;dur=12.5, cache;dur=0.8, app;dur=3.1", in milliseconds.
+func (t *Timings) Header() string {
+	db := time.Duration(t.db.Load())
+	cache := time.Duration(t.cache.Load())
+	app := max(time.Since(t.start)-db-cache, 0)
+	return Format(map[string]time.Duration{DB: db, Cache: cache, App: app})
+}
+
+// Format returns a Server-Timing header value with the given durations of
+// the db, cache and app segments, in that order, leaving out the others.
+func Format(durations map[string]time.Duration) string {
+	var b strings.Builder
+	for _, segment := range []string{DB, Cache, App} {
+		d, ok := durations[segment]
+		if !ok {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(segment)
+		b.WriteString(";dur=")
+		b.WriteString(strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 1, 64))
+	}
+	return b.String()
+}
+
+// Parse reads the durations of a Server-Timing header value by segment
+// name, for clients asserting on them. Metrics without a duration are
+// ignored.
+func Parse(header string) (map[string]time.Duration, error) {
+	durations := map[string]time.Duration{}
+	for _, metric := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(metric), ";")
+		if name == "" {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if key != "dur" {
+				continue
+			}
+			ms, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid duration %q of %s", value, name)
+			}
+			durations[name] = time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+	return durations, nil
+}
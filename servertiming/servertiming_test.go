@@ -0,0 +1,155 @@
+package servertiming
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatAndParse(t *testing.T) {
+	header := Format(map[string]time.Duration{
+		App:   3100 * time.Microsecond,
+		DB:    12500 * time.Microsecond,
+		Cache: 800 * time.Microsecond,
+	})
+	assert.Equal(t, "db;dur=12.5, cache;dur=0.8, app;dur=3.1", header)
+
+	durations, err := Parse(header)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{
+		DB:    12500 * time.Microsecond,
+		Cache: 800 * time.Microsecond,
+		App:   3100 * time.Microsecond,
+	}, durations)
+
+	durations, err = Parse(`miss, edge;desc="CDN";dur=1`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{"edge": time.Millisecond}, durations)
+
+	_, err = Parse("db;dur=fast")
+	assert.ErrorContains(t, err, `invalid duration "fast" of db`)
+}
+
+// slowDB answers every call after delay.
+type slowDB struct{ delay time.Duration }
+
+func (d slowDB) Connect(context.Context) (driver.Conn, error) { return slowConn(d), nil }
+func (slowDB) Driver() driver.Driver                          { return nil }
+
+type slowConn struct{ delay time.Duration }
+
+func (c slowConn) Ping(context.Context) error { time.Sleep(c.delay); return nil }
+func (c slowConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	time.Sleep(c.delay)
+	return driver.RowsAffected(1), nil
+}
+func (c slowConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	time.Sleep(c.delay)
+	return emptyRows{}, nil
+}
+func (c slowConn) Begin() (driver.Tx, error)         { time.Sleep(c.delay); return slowTx(c), nil }
+func (slowConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (slowConn) Close() error                        { return nil }
+
+type slowTx struct{ delay time.Duration }
+
+func (t slowTx) Commit() error   { time.Sleep(t.delay); return nil }
+func (t slowTx) Rollback() error { time.Sleep(t.delay); return nil }
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string         { return nil }
+func (emptyRows) Close() error              { return nil }
+func (emptyRows) Next([]driver.Value) error { return io.EOF }
+
+func TestConnectorTimesCallsOfMeasuredRequests(t *testing.T) {
+	db := sql.OpenDB(Connector(slowDB{10 * time.Millisecond}))
+	defer db.Close()
+
+	// Calls without timings in their context are not measured
+	require.NoError(t, db.PingContext(context.Background()))
+
+	ctx, timings := WithTimings(context.Background())
+	require.NoError(t, db.PingContext(ctx))
+	_, err := db.ExecContext(ctx, "UPDATE t SET x = 1")
+	require.NoError(t, err)
+	rows, err := db.QueryContext(ctx, "SELECT 1")
+	require.NoError(t, err)
+	rows.Close()
+	tx, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	durations, err := Parse(timings.Header())
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, durations[DB], 50*time.Millisecond, "ping, exec, query, begin and commit")
+	assert.Less(t, durations[DB], 200*time.Millisecond)
+	assert.Zero(t, durations[Cache])
+	assert.Contains(t, durations, App)
+}
+
+// slowNetConn takes 10ms to send every write.
+type slowNetConn struct{ net.Conn }
+
+func (c slowNetConn) Write(b []byte) (int, error) {
+	time.Sleep(10 * time.Millisecond)
+	return c.Conn.Write(b)
+}
+
+func TestRedisHookTimesCommandsOfMeasuredRequests(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := new(net.Dialer).DialContext(ctx, network, addr)
+			return slowNetConn{conn}, err
+		},
+	})
+	defer rds.Close()
+	rds.AddHook(RedisHook())
+
+	ctx, timings := WithTimings(context.Background())
+	require.NoError(t, rds.Set(ctx, "k", "v", 0).Err())
+	_, err := rds.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.Get(ctx, "k")
+		p.Get(ctx, "k")
+		return nil
+	})
+	require.NoError(t, err)
+
+	durations, err := Parse(timings.Header())
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, durations[Cache], 20*time.Millisecond)
+	assert.Zero(t, durations[DB])
+	assert.Nil(t, FromContext(context.Background()))
+}
+
+func TestInterruptedIsTheCallTheContextEndedDuring(t *testing.T) {
+	db := sql.OpenDB(Connector(slowDB{20 * time.Millisecond}))
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ctx, timings := WithTimings(ctx)
+	assert.Equal(t, "", timings.Interrupted(), "not ended yet")
+	require.NoError(t, db.PingContext(ctx))
+	assert.Equal(t, DB, timings.Interrupted(), "timed out during the ping")
+
+	// Calls made once the context ended fail at once
+	_, err := db.ExecContext(ctx, "UPDATE t SET x = 1")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	ctx, timings = WithTimings(context.Background())
+	_, err = db.ExecContext(ctx, "UPDATE t SET x = 1")
+	require.NoError(t, err)
+	assert.Equal(t, "", timings.Interrupted(), "never ended")
+}
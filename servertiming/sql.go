@@ -0,0 +1,135 @@
+package servertiming
+
+import (
+	"context"
+	"database/sql/driver"
+	"time"
+)
+
+// Connector wraps a database/sql connector so that queries, execs,
+// prepares, transactions and pings add their time to the db segment of
+// the request they are made for. A query counts until its first rows are
+// returned, not while they are read:
+//
+//	db := sql.OpenDB(servertiming.Connector(pq.NewConnector(dsn)))
+func Connector(next driver.Connector) driver.Connector {
+	return &connector{next: next}
+}
+
+type connector struct {
+	next driver.Connector
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	next, err := c.next.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: next}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.next.Driver()
+}
+
+// conn forwards to the wrapped connection. Optional interfaces the wrapped
+// connection lacks report driver.ErrSkip, so database/sql falls back as it
+// would without the wrapper.
+type conn struct {
+	driver.Conn
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	defer track(ctx, DB)()
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	defer track(ctx, DB)()
+	return q.QueryContext(ctx, query, args)
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	defer track(ctx, DB)()
+	return e.ExecContext(ctx, query, args)
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	defer track(ctx, DB)()
+	var next driver.Tx
+	var err error
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		next, err = b.BeginTx(ctx, opts)
+	} else {
+		next, err = c.Conn.Begin()
+	}
+	t := FromContext(ctx)
+	if err != nil || t == nil {
+		return next, err
+	}
+	return &tx{Tx: next, timings: t}, nil
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	defer track(ctx, DB)()
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) CheckNamedValue(v *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(v)
+	}
+	return driver.ErrSkip
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+// tx times the end of a transaction begun for a measured request.
+type tx struct {
+	driver.Tx
+	timings *Timings
+}
+
+func (t *tx) Commit() error {
+	defer t.timings.add(DB, time.Now())
+	return t.Tx.Commit()
+}
+
+func (t *tx) Rollback() error {
+	defer t.timings.add(DB, time.Now())
+	return t.Tx.Rollback()
+}
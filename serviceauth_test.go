@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/config"
+)
+
+func TestServiceAuthReachesPrivateAPIServer(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"status": map[string]any{
+			"authenticated": true,
+			"user":          map[string]any{"username": "system:serviceaccount:ci:runner"},
+		}})
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	credentialsFile := filepath.Join(dir, "token")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caFile, ca, 0o600))
+	require.NoError(t, os.WriteFile(credentialsFile, []byte("reviewer\n"), 0o600))
+
+	// The test server listens on loopback, which the default rules deny
+	// like the private range of a ClusterIP
+	t.Setenv("SA_TOKEN_AUTH", "tokenreview")
+	t.Setenv("SA_TOKEN_API_SERVER", srv.URL)
+	t.Setenv("SA_TOKEN_CA_FILE", caFile)
+	t.Setenv("SA_TOKEN_CREDENTIALS_FILE", credentialsFile)
+	cfg, _, err := config.Load()
+	require.NoError(t, err)
+
+	verifier, err := newServiceAuth(cfg)
+	require.NoError(t, err)
+	id, err := verifier.Verify(context.Background(), "token")
+	require.NoError(t, err)
+	assert.Equal(t, "system:serviceaccount:ci:runner", id.Subject())
+}
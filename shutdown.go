@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// serve serves HTTP on ln until ctx is done, then stops accepting
+// connections and drains the requests in flight, which are cut off after
+// timeout. It returns nil once they are all done.
+func serve(ctx context.Context, server *http.Server, ln net.Listener, timeout time.Duration) error {
+	errs := make(chan error, 1)
+	go func() { errs <- server.Serve(ln) }()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, draining requests for up to %s", timeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(drainCtx); err != nil {
+		server.Close()
+		return fmt.Errorf("requests still in flight after %s were cut off", timeout)
+	}
+	return nil
+}
+
+// background runs the loops of the app with a context done at shutdown,
+// so that they can be waited for before the connections they use are
+// closed.
+type background struct {
+	ctx context.Context
+	wg  sync.WaitGroup
+}
+
+func (b *background) Go(run func(context.Context)) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		run(b.ctx)
+	}()
+}
+
+// Wait waits up to timeout for the loops to return, once their context is
+// done, and reports whether they did.
+func (b *background) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowServer serves requests that take delay, reporting each start on
+// started.
+func slowServer(t *testing.T, delay time.Duration) (*http.Server, net.Listener, chan struct{}) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	started := make(chan struct{}, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		time.Sleep(delay)
+		io.WriteString(w, "done")
+	})}
+	return server, ln, started
+}
+
+func TestServeDrainsRequestsInFlight(t *testing.T) {
+	server, ln, started := slowServer(t, 100*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, server, ln, time.Second) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		results <- result{string(body), err}
+	}()
+	<-started
+	cancel()
+
+	r := <-results
+	require.NoError(t, r.err)
+	assert.Equal(t, "done", r.body, "the request in flight completes")
+	assert.NoError(t, <-served)
+
+	_, err := net.Dial("tcp", ln.Addr().String())
+	assert.Error(t, err, "no connection is accepted after shutdown")
+}
+
+func TestServeCutsOffRequestsPastTimeout(t *testing.T) {
+	server, ln, started := slowServer(t, time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, server, ln, 50*time.Millisecond) }()
+
+	go http.Get("http://" + ln.Addr().String())
+	<-started
+	start := time.Now()
+	cancel()
+
+	assert.Error(t, <-served)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestBackgroundWaitsForLoops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	loops := &background{ctx: ctx}
+	stopped := false
+	loops.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		stopped = true
+	})
+	loops.Go(func(context.Context) {})
+
+	assert.False(t, loops.Wait(10*time.Millisecond), "the loops run until ctx is done")
+	cancel()
+	assert.True(t, loops.Wait(time.Second))
+	assert.True(t, stopped)
+}
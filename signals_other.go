@@ -0,0 +1,7 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package main
+
+// handleLogLevelSignals does nothing where SIGUSR1 and SIGUSR2 do not
+// exist; use PUT /admin/loglevel instead.
+func handleLogLevelSignals() {}
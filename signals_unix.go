@@ -0,0 +1,30 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/nesymno/run-tests-example/logging"
+)
+
+// handleLogLevelSignals makes SIGUSR1 log more and SIGUSR2 log less. Unlike
+// PUT /admin/loglevel, this only changes the level of this replica.
+func handleLogLevelSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			step := 1
+			if sig == syscall.SIGUSR1 {
+				step = -1
+			}
+			level := logging.Step(step)
+			slog.Log(context.Background(), max(level, slog.LevelInfo), "Log level changed", "signal", sig.String(), "level", logging.Name(level))
+		}
+	}()
+}
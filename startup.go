@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// handoff is a handler replaced while serving, so that the server answers
+// probes while the app starts and serves the app once it is up.
+type handoff struct {
+	handler atomic.Pointer[http.Handler]
+}
+
+func (h *handoff) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*h.handler.Load()).ServeHTTP(w, r)
+}
+
+// Set makes handler serve the requests from now on.
+func (h *handoff) Set(handler http.Handler) {
+	h.handler.Store(&handler)
+}
+
+// dependencyLogEvery is how many failed attempts to reach a dependency are
+// logged once, after the first.
+const dependencyLogEvery = 10
+
+// waitForDependency retries p every second until it passes or ctx is done.
+// Failures are logged now and then, so a dependency that never answers
+// shows in the logs.
+func waitForDependency(ctx context.Context, name string, p probe) error {
+	failures := 0
+	attempts, err := waitUntil(ctx, func(ctx context.Context) error {
+		err := p(ctx)
+		if err != nil {
+			if failures%dependencyLogEvery == 0 {
+				log.Printf("Waiting for %s: %v", name, err)
+			}
+			failures++
+		}
+		return err
+	}, time.Second)
+	if err != nil {
+		return fmt.Errorf("%s not ready after %d attempts: %v", name, attempts, err)
+	}
+	if failures > 0 {
+		log.Printf("%s ready after %d attempts", name, attempts)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nesymno/run-tests-example/app"
+	"github.com/nesymno/run-tests-example/types"
+)
+
+func TestServesProbesWhileStarting(t *testing.T) {
+	handler := &handoff{}
+	handler.Set(app.StartingHandler("test"))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	get := func(path string) *http.Response {
+		resp, err := http.Get(srv.URL + path)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := get("/health")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var health types.HealthResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&health))
+	assert.Equal(t, "starting", health.Status)
+	assert.Equal(t, "test", health.Profile)
+
+	assert.Equal(t, http.StatusOK, get("/healthz").StatusCode)
+	assert.Equal(t, http.StatusServiceUnavailable, get("/readyz").StatusCode)
+	assert.Equal(t, http.StatusServiceUnavailable, get("/startupz").StatusCode)
+	resp = get("/api/data")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+	// Once the app is up, it serves the same connections
+	handler.Set(goldenHandler(t))
+	assert.Equal(t, http.StatusBadRequest, get("/api/data?status=deleted").StatusCode)
+}
+
+func TestWaitForDependencyUntilDone(t *testing.T) {
+	calls := 0
+	err := waitForDependency(context.Background(), "postgres", func(context.Context) error {
+		if calls++; calls < 2 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = waitForDependency(ctx, "redis", func(context.Context) error { return errors.New("connection refused") })
+	assert.ErrorContains(t, err, "redis not ready after 1 attempts: connection refused")
+}
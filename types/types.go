@@ -1,13 +1,49 @@
 package types
 
 import (
+	"encoding/json"
 	"time"
+
+	"github.com/nesymno/run-tests-example/config"
+)
+
+// TestData statuses, matching the test_data_status enum.
+const (
+	StatusActive   = "active"
+	StatusArchived = "archived"
 )
 
 type TestData struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
-	Data string `json:"data"`
+	ID     int      `json:"id"`
+	Name   string   `json:"name"`
+	Data   string   `json:"data"`
+	Tags   []string `json:"tags"`
+	Status string   `json:"status"`
+
+	// Secret is encrypted at rest and returned decrypted. It can only be
+	// set when field encryption keys are configured.
+	Secret string `json:"secret,omitempty"`
+
+	// CreatedAt and UpdatedAt are encoded as RFC 3339 in UTC. Either may be
+	// given on import; when omitted the database fills in the insert time.
+	CreatedAt time.Time `json:"created_at,omitzero"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+
+	// TestRunID is the X-Test-Run-ID of the request that created the row
+	TestRunID string `json:"test_run_id,omitempty"`
+
+	// Comments is only populated when requested with ?include=comments
+	Comments []Comment `json:"comments,omitempty"`
+}
+
+// DataPatch partially updates a row with PATCH /api/data/{id}: only the
+// fields it sets are changed. An empty Secret removes the row's secret.
+type DataPatch struct {
+	Name   *string   `json:"name,omitempty"`
+	Data   *string   `json:"data,omitempty"`
+	Tags   *[]string `json:"tags,omitempty"`
+	Status *string   `json:"status,omitempty"`
+	Secret *string   `json:"secret,omitempty"`
 }
 
 type HealthResponse struct {
@@ -16,4 +52,394 @@ type HealthResponse struct {
 	Version   string    `json:"version"`
 	Database  string    `json:"database"`
 	Cache     string    `json:"cache"`
+	Profile   string    `json:"profile,omitempty"`
+
+	// CacheMemory is omitted when Redis memory stats cannot be read
+	CacheMemory *CacheMemory `json:"cache_memory,omitempty"`
+}
+
+// CacheMemory is the Redis memory usage reported by INFO.
+type CacheMemory struct {
+	UsedBytes   int64  `json:"used_bytes"`
+	MaxBytes    int64  `json:"max_bytes"`
+	Policy      string `json:"policy"`
+	EvictedKeys int64  `json:"evicted_keys"`
+	// Degraded is true while the list cache is bypassed after an OOM error
+	Degraded bool `json:"degraded"`
+}
+
+// CacheAreaReport summarizes how well one logical cache area performs
+// since the process started.
+type CacheAreaReport struct {
+	Area          string  `json:"area"`
+	Hits          uint64  `json:"hits"`
+	Misses        uint64  `json:"misses"`
+	HitRatio      float64 `json:"hit_ratio"`
+	Fills         uint64  `json:"fills"`
+	AvgFillMS     float64 `json:"avg_fill_ms"`
+	AvgValueBytes float64 `json:"avg_value_bytes"`
+	MaxValueBytes uint64  `json:"max_value_bytes"`
+}
+
+type CacheReport struct {
+	Areas  []CacheAreaReport `json:"areas"`
+	Memory *CacheMemory      `json:"memory,omitempty"`
+}
+
+// LogLevel is the body of PUT /admin/loglevel and the response of every
+// method.
+type LogLevel struct {
+	Level   string `json:"level"`
+	Default string `json:"default,omitempty"`
+}
+
+// TestRunCleanup is returned by DELETE /api/runs/{id}.
+type TestRunCleanup struct {
+	TestRunID string `json:"test_run_id"`
+	Rows      int64  `json:"rows"`
+	Comments  int64  `json:"comments"`
+	Archived  int64  `json:"archived"`
+}
+
+// ChaosConfig sets the faults injected into the requests of a test run,
+// through PUT /admin/chaos/{run}.
+type ChaosConfig struct {
+	ReadFailPercent  int `json:"read_fail_percent"`
+	WriteFailPercent int `json:"write_fail_percent"`
+	LatencyMS        int `json:"latency_ms"`
+	// Status is the code of injected failures, 503 by default
+	Status     int       `json:"status"`
+	TTLSeconds int       `json:"ttl_seconds"`
+	ExpiresAt  time.Time `json:"expires_at,omitzero"`
+}
+
+// QueueStatus is the state of one async subsystem in /debug/queues.
+// Lagging lists the limits it exceeds.
+type QueueStatus struct {
+	Name                string   `json:"name"`
+	Depth               int64    `json:"depth"`
+	Delayed             int64    `json:"delayed"`
+	Dead                int64    `json:"dead"`
+	OldestAgeSeconds    float64  `json:"oldest_age_seconds"`
+	ThroughputPerSecond float64  `json:"throughput_per_second"`
+	Lagging             []string `json:"lagging,omitempty"`
+}
+
+type QueueReport struct {
+	Queues        []QueueStatus `json:"queues"`
+	MaxDepth      int64         `json:"max_depth,omitempty"`
+	MaxAgeSeconds float64       `json:"max_age_seconds,omitempty"`
+}
+
+// SLOStatus is the compliance of one SLO in /debug/slo over the SLO
+// window. Compliance is null until a request is counted. BurnRates are
+// keyed by lookback window, such as "5m"; a rate of 1 spends the error
+// budget exactly over the SLO window.
+type SLOStatus struct {
+	Route                string             `json:"route"`
+	Kind                 string             `json:"kind"`
+	Objective            float64            `json:"objective"`
+	LatencyMillis        int64              `json:"latency_ms,omitempty"`
+	Requests             int64              `json:"requests"`
+	Good                 int64              `json:"good"`
+	Compliance           *float64           `json:"compliance"`
+	ErrorBudgetRemaining float64            `json:"error_budget_remaining"`
+	BurnRates            map[string]float64 `json:"burn_rates"`
+	Met                  bool               `json:"met"`
+}
+
+type SLOReport struct {
+	WindowSeconds int64       `json:"window_seconds"`
+	SLOs          []SLOStatus `json:"slos"`
+}
+
+// DeadlineExceeded is the 504 body of a request whose deadline passed.
+// Stage is where the request was when it did: db, cache, app, or transit
+// for a deadline already past when the request came in.
+type DeadlineExceeded struct {
+	Error    string    `json:"error"`
+	Stage    string    `json:"stage"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// Readiness is returned by the /healthz, /readyz and /startupz probes;
+// Reasons says why a replica is not ready.
+type Readiness struct {
+	Status  string   `json:"status"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+type MaintenanceStatus struct {
+	Enabled    bool      `json:"enabled"`
+	ReadOnly   bool      `json:"read_only"`
+	RetryAfter int       `json:"retry_after,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// RequestInfo is a request as the server saw it, returned by
+// /debug/request.
+type RequestInfo struct {
+	Method     string              `json:"method"`
+	URL        string              `json:"url"`
+	Proto      string              `json:"proto"`
+	Host       string              `json:"host"`
+	RemoteAddr string              `json:"remote_addr"`
+	ClientIP   string              `json:"client_ip"`
+	Headers    map[string][]string `json:"headers"`
+	TLS        *TLSInfo            `json:"tls,omitempty"`
+	// ForwardedProto is the scheme reported by a proxy terminating TLS
+	ForwardedProto string    `json:"forwarded_proto,omitempty"`
+	Trace          TraceInfo `json:"trace"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+type TLSInfo struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite"`
+	ServerName  string `json:"server_name,omitempty"`
+	Protocol    string `json:"protocol,omitempty"`
+	PeerCerts   int    `json:"peer_certificates"`
+}
+
+// TraceInfo holds the tracing identifiers found in the request headers.
+type TraceInfo struct {
+	TraceID   string `json:"trace_id,omitempty"`
+	SpanID    string `json:"span_id,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// ConnectivityReport is returned by /debug/connectivity.
+type ConnectivityReport struct {
+	Targets   []ConnectivityResult `json:"targets"`
+	Timestamp time.Time            `json:"timestamp"`
+}
+
+// ConnectivityResult is the outcome of resolving and dialing one
+// dependency. Stage says which step failed, if any.
+type ConnectivityResult struct {
+	Name          string   `json:"name"`
+	Address       string   `json:"address"`
+	Resolved      []string `json:"resolved,omitempty"`
+	DNSMillis     float64  `json:"dns_ms"`
+	ConnectMillis float64  `json:"connect_ms,omitempty"`
+	Reachable     bool     `json:"reachable"`
+	Stage         string   `json:"stage,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// EnvReport is returned by /debug/env.
+type EnvReport struct {
+	Settings []config.Setting `json:"settings"`
+	// Unrecognized lists variables that look like settings but are not
+	Unrecognized []string `json:"unrecognized"`
+}
+
+// Route is a registered route, returned by /debug/routes. Middleware
+// lists the route's own wrappers, outermost first.
+type Route struct {
+	Name       string   `json:"name"`
+	Method     string   `json:"method"`
+	Pattern    string   `json:"pattern"`
+	Middleware []string `json:"middleware"`
+	Handler    string   `json:"handler"`
+}
+
+// Tenant isolation modes.
+const (
+	// IsolationRow keeps a tenant's rows in the shared tables, scoped by
+	// tenant_id.
+	IsolationRow = "row"
+	// IsolationSchema gives a tenant its own copy of the data tables in a
+	// dedicated Postgres schema.
+	IsolationSchema = "schema"
+)
+
+type Tenant struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Isolation string    `json:"isolation"`
+	Schema    string    `json:"schema,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type TenantRequest struct {
+	Name      string `json:"name"`
+	Isolation string `json:"isolation"`
+}
+
+// TenantCredentials is returned once, when a tenant is provisioned; only
+// a hash of the API key is stored.
+type TenantCredentials struct {
+	Tenant
+	APIKey string `json:"api_key"`
+}
+
+// APIKey describes a key managed through /admin/apikeys; the key itself
+// is only returned when it is created or rotated.
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	// ReplacedBy is the key this one was rotated to.
+	ReplacedBy *int `json:"replaced_by,omitempty"`
+}
+
+// APIKeyRequest creates a key, or updates the fields it sets.
+type APIKeyRequest struct {
+	Name      string     `json:"name"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APIKeyRotation sets how long a rotated key keeps working next to its
+// replacement, a default when nil.
+type APIKeyRotation struct {
+	OverlapSeconds *int `json:"overlap_seconds"`
+}
+
+type APIKeyCredentials struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// Snapshot is the internal state of a replica at one point in time,
+// returned by /debug/snapshot and written periodically for soak tests.
+type Snapshot struct {
+	Timestamp     time.Time      `json:"timestamp"`
+	UptimeSeconds float64        `json:"uptime_seconds"`
+	Runtime       GCStats        `json:"runtime"`
+	DB            DBPoolStats    `json:"db"`
+	Redis         RedisPoolStats `json:"redis"`
+	Cache         CacheReport    `json:"cache"`
+}
+
+type DBPoolStats struct {
+	MaxOpen      int   `json:"max_open"`
+	Open         int   `json:"open"`
+	InUse        int   `json:"in_use"`
+	Idle         int   `json:"idle"`
+	WaitCount    int64 `json:"wait_count"`
+	WaitMillis   int64 `json:"wait_ms"`
+	ClosedIdle   int64 `json:"closed_idle"`
+	ClosedMaxAge int64 `json:"closed_max_lifetime"`
+}
+
+type RedisPoolStats struct {
+	Hits       uint32 `json:"hits"`
+	Misses     uint32 `json:"misses"`
+	Timeouts   uint32 `json:"timeouts"`
+	TotalConns uint32 `json:"total_conns"`
+	IdleConns  uint32 `json:"idle_conns"`
+	StaleConns uint32 `json:"stale_conns"`
+}
+
+type GCStats struct {
+	NumGC         uint32     `json:"num_gc"`
+	NumForcedGC   uint32     `json:"num_forced_gc"`
+	LastGC        *time.Time `json:"last_gc,omitempty"`
+	PauseTotal    string     `json:"pause_total"`
+	HeapAlloc     uint64     `json:"heap_alloc"`
+	HeapInuse     uint64     `json:"heap_inuse"`
+	HeapSys       uint64     `json:"heap_sys"`
+	NextGC        uint64     `json:"next_gc"`
+	Sys           uint64     `json:"sys"`
+	GCPercent     int        `json:"gc_percent"`
+	MemoryLimit   int64      `json:"memory_limit"`
+	Goroutines    int        `json:"goroutines"`
+	GCCPUFraction float64    `json:"gc_cpu_fraction"`
+	Timestamp     time.Time  `json:"timestamp"`
+}
+
+type JobRequest struct {
+	Type         string          `json:"type"`
+	Payload      json.RawMessage `json:"payload"`
+	RunAt        *time.Time      `json:"run_at,omitempty"`
+	DelaySeconds int             `json:"delay_seconds,omitempty"`
+}
+
+type ScheduleRequest struct {
+	Name            string          `json:"name"`
+	Type            string          `json:"type"`
+	Payload         json.RawMessage `json:"payload"`
+	IntervalSeconds int             `json:"interval_seconds"`
+	StartAt         *time.Time      `json:"start_at,omitempty"`
+}
+
+type RetentionReport struct {
+	Days       int       `json:"days"`
+	Archived   bool      `json:"archived"`
+	Purged     int64     `json:"purged"`
+	Batches    int       `json:"batches"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// CacheVerifyReport is the result of comparing sampled test_data rows with
+// their copies in the cached list.
+type CacheVerifyReport struct {
+	Epoch int64 `json:"epoch"`
+	// Cached is false when no list was cached, so nothing was compared
+	Cached bool `json:"cached"`
+	// Skipped is set when a write invalidated the list during the check,
+	// which then reports nothing
+	Skipped     bool              `json:"skipped,omitempty"`
+	Checked     int               `json:"checked"`
+	Divergences []CacheDivergence `json:"divergences"`
+	Healed      bool              `json:"healed"`
+	StartedAt   time.Time         `json:"started_at"`
+	FinishedAt  time.Time         `json:"finished_at"`
+}
+
+// CacheDivergence is a row whose cached copy differs from PostgreSQL. Kind
+// is "changed", "missing" from the cache, or "deleted" from PostgreSQL.
+type CacheDivergence struct {
+	ID   int    `json:"id"`
+	Kind string `json:"kind"`
+}
+
+// DataChecksum is the response of GET /api/data/checksum.
+type DataChecksum struct {
+	Algorithm string `json:"algorithm"`
+	Checksum  string `json:"checksum"`
+	Rows      int64  `json:"rows"`
+}
+
+// ServerTime is the response of GET /api/time.
+type ServerTime struct {
+	// Time is the wall clock of the replica, which may be stepped
+	Time      time.Time `json:"time"`
+	UnixNanos int64     `json:"unix_nanos"`
+	// MonotonicNanos is read from the monotonic clock since the replica
+	// started. It never jumps, so two readings give the time that passed.
+	MonotonicNanos int64     `json:"monotonic_nanos"`
+	StartedAt      time.Time `json:"started_at"`
+	// ClockSkewMillis is the clock difference the replica tolerates
+	ClockSkewMillis int64 `json:"clock_skew_ms"`
+}
+
+// EncryptionReport counts the test_data secrets sealed with each key.
+type EncryptionReport struct {
+	PrimaryKey string           `json:"primary_key"`
+	Keys       map[string]int64 `json:"keys"`
+	Resealed   int64            `json:"resealed,omitempty"`
+}
+
+type Comment struct {
+	ID        int       `json:"id"`
+	DataID    int       `json:"data_id"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type SeedResult struct {
+	Profile  string `json:"profile"`
+	Rows     int    `json:"rows"`
+	Inserted int64  `json:"inserted"`
+	Seed     uint64 `json:"seed"`
+	// Checksum is the SHA-256 of the generated rows, equal for every load
+	// of the same profile and seed
+	Checksum string `json:"checksum"`
+	Duration string `json:"duration"`
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/nesymno/run-tests-example/config"
+)
+
+// probe reports whether a dependency is ready, nil meaning it is.
+type probe func(ctx context.Context) error
+
+// attemptTimeout bounds a single probe, so one hung connection attempt
+// does not use up the whole wait.
+const attemptTimeout = 5 * time.Second
+
+// runWaitFor blocks until the selected dependencies are ready:
+// app waitfor -checks=postgres,redis,app -timeout=2m
+func runWaitFor(args []string) error {
+	fs := flag.NewFlagSet("waitfor", flag.ExitOnError)
+	checks := fs.String("checks", "postgres,redis", "comma-separated checks that must pass: postgres, redis, app")
+	timeout := fs.Duration("timeout", time.Minute, "give up after this long")
+	interval := fs.Duration("interval", time.Second, "delay between attempts")
+	appURL := fs.String("app-url", defaultAppURL(), "URL that must answer 200 for the app check")
+	fs.Parse(args)
+
+	// Only the addresses matter here; the app itself reports invalid
+	// settings when it starts
+	cfg, settings, err := config.Load()
+	if settings == nil {
+		return err
+	}
+	available := map[string]func() (probe, func()){
+		"postgres": func() (probe, func()) {
+			db, _ := sql.Open("postgres", cfg.Postgres.DSN())
+			return db.PingContext, func() { db.Close() }
+		},
+		"redis": func() (probe, func()) {
+			rdb := redis.NewClient(&redis.Options{Addr: cfg.Redis.Addr()})
+			return func(ctx context.Context) error { return rdb.Ping(ctx).Err() }, func() { rdb.Close() }
+		},
+		"app": func() (probe, func()) {
+			return httpProbe(*appURL), func() {}
+		},
+	}
+
+	probes := map[string]probe{}
+	for _, name := range strings.Split(*checks, ",") {
+		name = strings.TrimSpace(name)
+		open, ok := available[name]
+		if !ok {
+			return fmt.Errorf("unknown check %q", name)
+		}
+		p, release := open()
+		defer release()
+		probes[name] = p
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	return waitAll(ctx, probes, *interval)
+}
+
+// waitAll polls every probe concurrently until all pass or ctx expires,
+// and returns the last error of each probe that never passed.
+func waitAll(ctx context.Context, probes map[string]probe, interval time.Duration) error {
+	start := time.Now()
+	var mu sync.Mutex
+	var errs []error
+
+	var wg sync.WaitGroup
+	for name, p := range probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attempts, err := waitUntil(ctx, p, interval)
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s not ready after %d attempts: %v", name, attempts, err))
+				mu.Unlock()
+				return
+			}
+			log.Printf("%s ready after %s", name, time.Since(start).Round(time.Millisecond))
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// waitUntil retries p every interval until it passes or ctx expires. It
+// returns the number of attempts and, on failure, the last error.
+func waitUntil(ctx context.Context, p probe, interval time.Duration) (int, error) {
+	for attempts := 1; ; attempts++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, attemptTimeout)
+		err := p(attemptCtx)
+		cancel()
+		if err == nil {
+			return attempts, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempts, err
+		case <-time.After(interval):
+		}
+	}
+}
+
+// httpProbe passes once url answers 200 OK.
+func httpProbe(url string) probe {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s returned %s", url, resp.Status)
+		}
+		return nil
+	}
+}
+
+// defaultAppURL is the /startupz URL at APP_HOST:APP_PORT, the variables the
+// integration tests use to find the app.
+func defaultAppURL() string {
+	host := os.Getenv("APP_HOST")
+	if host == "" {
+		host = "localhost"
+	}
+	port := os.Getenv("APP_PORT")
+	if port == "" {
+		port = "8080"
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/startupz"
+}
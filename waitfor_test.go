@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitUntilRetriesUntilReady(t *testing.T) {
+	calls := 0
+	p := func(context.Context) error {
+		calls++
+		if calls < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	}
+
+	attempts, err := waitUntil(context.Background(), p, time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestWaitAllReportsChecksThatNeverPass(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := waitAll(ctx, map[string]probe{
+		"up":   func(context.Context) error { return nil },
+		"down": func(context.Context) error { return errors.New("connection refused") },
+	}, 10*time.Millisecond)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "down not ready")
+	assert.Contains(t, err.Error(), "connection refused")
+	assert.NotContains(t, err.Error(), "up not ready")
+}
+
+func TestHTTPProbe(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	p := httpProbe(srv.URL)
+	assert.Error(t, p(context.Background()))
+	status = http.StatusOK
+	assert.NoError(t, p(context.Background()))
+}
@@ -0,0 +1,137 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/nesymno/run-tests-example/metrics"
+)
+
+var (
+	batchSizes = metrics.NewHistogram("app_batch_flush_size",
+		"Items written per write-behind batch.",
+		[]float64{1, 5, 10, 25, 50, 100, 250, 500, 1000})
+	batchFlushErrors = metrics.NewCounter("app_batch_flush_errors_total",
+		"Write-behind batches that failed to flush.")
+)
+
+// ErrFlushInProgress is returned by Flush when another replica holds the
+// flush lock.
+var ErrFlushInProgress = errors.New("flush in progress elsewhere")
+
+// FlushFunc writes one batch of raw items to their final destination.
+type FlushFunc func(ctx context.Context, items []string) error
+
+// Batcher buffers items in a Redis list and hands them to a FlushFunc in
+// batches, every Interval or as soon as MaxItems are pending.
+//
+// Items sit only in Redis until flushed: anything accepted but not yet
+// flushed is lost if Redis loses data, and a batch whose flush fails is
+// retried as a whole, so the flush function must tolerate repeats.
+type Batcher struct {
+	Interval time.Duration
+	MaxItems int
+
+	rds   *redis.Client
+	key   string
+	flush FlushFunc
+	kick  chan struct{}
+}
+
+func NewBatcher(rds *redis.Client, key string, flush FlushFunc) *Batcher {
+	return &Batcher{
+		Interval: 500 * time.Millisecond,
+		MaxItems: 100,
+		rds:      rds,
+		key:      key,
+		flush:    flush,
+		kick:     make(chan struct{}, 1),
+	}
+}
+
+// Add appends an item to the pending batch.
+func (b *Batcher) Add(ctx context.Context, item any) error {
+	raw, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("failed to encode item: %v", err)
+	}
+
+	pending, err := b.rds.RPush(ctx, b.key, raw).Result()
+	if err != nil {
+		return err
+	}
+
+	if pending >= int64(b.MaxItems) {
+		select {
+		case b.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Pending returns the number of items waiting to be flushed.
+func (b *Batcher) Pending(ctx context.Context) (int64, error) {
+	return b.rds.LLen(ctx, b.key).Result()
+}
+
+// Flush writes every pending item and returns how many were flushed.
+func (b *Batcher) Flush(ctx context.Context) (int, error) {
+	// Only one replica may read and trim the list at a time
+	unlock, err := Lock(ctx, b.rds, b.key+":lock", time.Minute)
+	if err != nil {
+		return 0, err
+	}
+	if unlock == nil {
+		return 0, ErrFlushInProgress
+	}
+	defer unlock()
+
+	total := 0
+	for {
+		items, err := b.rds.LRange(ctx, b.key, 0, int64(b.MaxItems)-1).Result()
+		if err != nil {
+			return total, err
+		}
+		if len(items) == 0 {
+			return total, nil
+		}
+
+		if err := b.flush(ctx, items); err != nil {
+			batchFlushErrors.Inc()
+			return total, err
+		}
+		if err := b.rds.LTrim(ctx, b.key, int64(len(items)), -1).Err(); err != nil {
+			return total, err
+		}
+
+		batchSizes.Observe(float64(len(items)))
+		total += len(items)
+	}
+}
+
+// Run flushes on every tick or when a full batch is pending, until ctx is
+// done.
+func (b *Batcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-b.kick:
+		}
+
+		if _, err := b.Flush(ctx); err != nil && err != ErrFlushInProgress && ctx.Err() == nil {
+			log.Printf("batcher: flush of %s failed: %v", b.key, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DeadJobs returns up to limit dead-lettered jobs, newest first.
+func (q *Queue) DeadJobs(ctx context.Context, limit int) ([]*Job, error) {
+	ids, err := q.rds.LRange(ctx, deadKey, 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Job, 0, len(ids))
+	for _, id := range ids {
+		job, err := q.Get(ctx, id)
+		if err == ErrNotFound {
+			// The record expired; drop the dangling reference
+			q.rds.LRem(ctx, deadKey, 0, id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// RetryDead moves a dead-lettered job back onto the queue with a fresh
+// attempt budget.
+func (q *Queue) RetryDead(ctx context.Context, id string) (*Job, error) {
+	removed, err := q.rds.LRem(ctx, deadKey, 1, id).Result()
+	if err != nil {
+		return nil, err
+	}
+	if removed == 0 {
+		return nil, ErrNotFound
+	}
+
+	job, err := q.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Status = StatusQueued
+	job.Attempts = 0
+	job.Error = ""
+	job.UpdatedAt = time.Now().UTC()
+	if err := q.save(ctx, job); err != nil {
+		return nil, err
+	}
+	if err := q.rds.LPush(ctx, queueKey, job.ID).Err(); err != nil {
+		return nil, fmt.Errorf("failed to enqueue job: %v", err)
+	}
+	return job, nil
+}
+
+// PurgeDead deletes every dead-lettered job and returns how many were
+// removed.
+func (q *Queue) PurgeDead(ctx context.Context) (int, error) {
+	ids, err := q.rds.LRange(ctx, deadKey, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	keys := make([]string, 0, len(ids)+1)
+	for _, id := range ids {
+		keys = append(keys, jobKeyPrefix+id)
+	}
+	keys = append(keys, deadKey)
+
+	if err := q.rds.Del(ctx, keys...).Err(); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
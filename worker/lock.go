@@ -0,0 +1,31 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// releaseLock deletes KEYS[1] if it still holds the token ARGV[1].
+var releaseLock = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// Lock takes the Redis lock key for ttl, so that one replica at a time
+// does what it guards, and returns the function releasing it, nil if
+// another holder has it. The lock holds a token of its own, so a holder
+// running past ttl does not release the lock another took since.
+func Lock(ctx context.Context, rds *redis.Client, key string, ttl time.Duration) (func(), error) {
+	token := newID()
+	ok, err := rds.SetNX(ctx, key, token, ttl).Result()
+	if err != nil || !ok {
+		return nil, err
+	}
+	return func() {
+		releaseLock.Run(context.Background(), rds, []string{key}, token)
+	}, nil
+}
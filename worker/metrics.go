@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/nesymno/run-tests-example/metrics"
+)
+
+var (
+	processedJobs = metrics.NewCounter("app_jobs_processed_total",
+		"Job attempts processed by the workers.")
+	failedJobs = metrics.NewCounter("app_jobs_failed_total",
+		"Job attempts that returned an error.")
+	retriedJobs = metrics.NewCounter("app_jobs_retried_total",
+		"Failed job attempts scheduled for a retry.")
+	deadLetteredJobs = metrics.NewCounter("app_jobs_dead_lettered_total",
+		"Jobs moved to the dead-letter list after exhausting their attempts.")
+)
+
+// RegisterMetrics exports the queue's depths as gauges read at scrape time.
+func (q *Queue) RegisterMetrics() {
+	metrics.NewGaugeFunc("app_jobs_queue_depth", "Jobs waiting to be processed.",
+		q.lengthFunc(func(ctx context.Context) (int64, error) { return q.rds.LLen(ctx, queueKey).Result() }))
+	metrics.NewGaugeFunc("app_jobs_delayed", "Jobs waiting for their retry or run time.",
+		q.lengthFunc(func(ctx context.Context) (int64, error) { return q.rds.ZCard(ctx, delayedKey).Result() }))
+	metrics.NewGaugeFunc("app_jobs_dead", "Jobs in the dead-letter list.",
+		q.lengthFunc(func(ctx context.Context) (int64, error) { return q.rds.LLen(ctx, deadKey).Result() }))
+	metrics.NewGaugeFunc("app_jobs_oldest_age_seconds", "How long the next job to run has been waiting.",
+		func() float64 {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			age, err := q.oldestAge(ctx)
+			if err != nil {
+				return -1
+			}
+			return age.Seconds()
+		})
+	metrics.NewGaugeFunc("app_jobs_throughput", "Job attempts processed per second by this replica over the last minute.",
+		func() float64 { return q.processed.perSecond(time.Now()) })
+}
+
+// RegisterMetrics exports the number of buffered items as a gauge read at
+// scrape time.
+func (b *Batcher) RegisterMetrics() {
+	metrics.NewGaugeFunc("app_batch_pending", "Write-behind items waiting to be flushed.", func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		n, err := b.Pending(ctx)
+		if err != nil {
+			return -1
+		}
+		return float64(n)
+	})
+}
+
+func (q *Queue) lengthFunc(fn func(ctx context.Context) (int64, error)) func() float64 {
+	return func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		n, err := fn(ctx)
+		if err != nil {
+			return -1
+		}
+		return float64(n)
+	}
+}
@@ -0,0 +1,147 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Schedule is a recurring job definition persisted in the recurring_jobs
+// table. A new job is enqueued every Interval seconds starting at NextRunAt.
+type Schedule struct {
+	ID        int             `json:"id"`
+	Name      string          `json:"name"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Interval  int             `json:"interval_seconds"`
+	NextRunAt time.Time       `json:"next_run_at"`
+	LastRunAt *time.Time      `json:"last_run_at,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Scheduler enqueues recurring jobs on the queue when they come due. Several
+// replicas can run it concurrently; due rows are claimed with SKIP LOCKED.
+type Scheduler struct {
+	db    *sql.DB
+	queue *Queue
+}
+
+func NewScheduler(db *sql.DB, queue *Queue) *Scheduler {
+	return &Scheduler{db: db, queue: queue}
+}
+
+// Create stores a new schedule, filling in its ID and timestamps.
+func (s *Scheduler) Create(ctx context.Context, sched *Schedule) error {
+	if sched.NextRunAt.IsZero() {
+		sched.NextRunAt = time.Now()
+	}
+	if len(sched.Payload) == 0 {
+		sched.Payload = json.RawMessage("null")
+	}
+
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO recurring_jobs (name, job_type, payload, interval_seconds, next_run_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, next_run_at, created_at`,
+		sched.Name, sched.Type, []byte(sched.Payload), sched.Interval, sched.NextRunAt,
+	).Scan(&sched.ID, &sched.NextRunAt, &sched.CreatedAt)
+}
+
+// List returns all schedules ordered by their next run time.
+func (s *Scheduler) List(ctx context.Context) ([]Schedule, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, name, job_type, payload, interval_seconds, next_run_at, last_run_at, created_at
+		FROM recurring_jobs ORDER BY next_run_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []Schedule{}
+	for rows.Next() {
+		var sched Schedule
+		var payload []byte
+		var lastRun sql.NullTime
+		if err := rows.Scan(&sched.ID, &sched.Name, &sched.Type, &payload,
+			&sched.Interval, &sched.NextRunAt, &lastRun, &sched.CreatedAt); err != nil {
+			return nil, err
+		}
+		sched.Payload = payload
+		if lastRun.Valid {
+			sched.LastRunAt = &lastRun.Time
+		}
+		schedules = append(schedules, sched)
+	}
+	return schedules, rows.Err()
+}
+
+// Delete cancels a schedule.
+func (s *Scheduler) Delete(ctx context.Context, id int) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM recurring_jobs WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Run checks for due schedules every second until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.runDue(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("scheduler: %v", err)
+			}
+		}
+	}
+}
+
+// runDue advances every due schedule to its next run time and enqueues a
+// job for it. Runs missed while no scheduler was active are skipped rather
+// than replayed.
+func (s *Scheduler) runDue(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, `
+		WITH due AS (
+			SELECT id FROM recurring_jobs
+			WHERE next_run_at <= NOW()
+			ORDER BY next_run_at
+			LIMIT 100
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE recurring_jobs r SET
+			last_run_at = NOW(),
+			next_run_at = CASE
+				WHEN r.next_run_at + r.interval_seconds * INTERVAL '1 second' > NOW()
+				THEN r.next_run_at + r.interval_seconds * INTERVAL '1 second'
+				ELSE NOW() + r.interval_seconds * INTERVAL '1 second'
+			END
+		FROM due WHERE r.id = due.id
+		RETURNING r.id, r.job_type, r.payload`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var jobType string
+		var payload []byte
+		if err := rows.Scan(&id, &jobType, &payload); err != nil {
+			return err
+		}
+		if _, err := s.queue.Enqueue(ctx, jobType, json.RawMessage(payload)); err != nil {
+			log.Printf("scheduler: failed to enqueue schedule %d: %v", id, err)
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Stats is a snapshot of the queue.
+type Stats struct {
+	Depth   int64
+	Delayed int64
+	Dead    int64
+	// OldestAge is how long the next job to be dequeued has been runnable,
+	// 0 when the queue is empty.
+	OldestAge time.Duration
+	// Throughput is the job attempts this replica processed per second
+	// over the last minute.
+	Throughput float64
+}
+
+// Stats reads the queue's depths and lag from Redis.
+func (q *Queue) Stats(ctx context.Context) (Stats, error) {
+	pipe := q.rds.Pipeline()
+	depth := pipe.LLen(ctx, queueKey)
+	delayed := pipe.ZCard(ctx, delayedKey)
+	dead := pipe.LLen(ctx, deadKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Stats{}, err
+	}
+
+	age, err := q.oldestAge(ctx)
+	if err != nil {
+		return Stats{}, err
+	}
+	return Stats{
+		Depth:      depth.Val(),
+		Delayed:    delayed.Val(),
+		Dead:       dead.Val(),
+		OldestAge:  age,
+		Throughput: q.processed.perSecond(time.Now()),
+	}, nil
+}
+
+// oldestAge returns how long the job at the tail of the queue list, the
+// next one BRPOP takes, has been runnable: since its run time for delayed
+// jobs and retries, since its creation otherwise.
+func (q *Queue) oldestAge(ctx context.Context) (time.Duration, error) {
+	ids, err := q.rds.LRange(ctx, queueKey, -1, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	job, err := q.Get(ctx, ids[0])
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	since := job.CreatedAt
+	if job.RunAt != nil {
+		since = *job.RunAt
+	}
+	return max(time.Since(since), 0), nil
+}
+
+const rateWindow = 60
+
+// rate counts events per second over the last rateWindow seconds.
+type rate struct {
+	mu      sync.Mutex
+	counts  [rateWindow]uint64
+	seconds [rateWindow]int64
+}
+
+func (r *rate) add(now time.Time) {
+	s := now.Unix()
+	i := s % rateWindow
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.seconds[i] != s {
+		r.seconds[i] = s
+		r.counts[i] = 0
+	}
+	r.counts[i]++
+}
+
+func (r *rate) perSecond(now time.Time) float64 {
+	s := now.Unix()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var total uint64
+	for i, second := range r.seconds {
+		if s-second < rateWindow {
+			total += r.counts[i]
+		}
+	}
+	return float64(total) / rateWindow
+}
@@ -0,0 +1,387 @@
+// Package worker is a small Redis-backed background job queue. Jobs are
+// pushed onto a Redis list and their state is kept in a per-job key so
+// clients can poll for completion. Failed jobs are retried with exponential
+// backoff through a delayed sorted set and moved to a dead-letter list once
+// they run out of attempts.
+package worker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	queueKey   = "jobs:queue"
+	delayedKey = "jobs:delayed"
+	deadKey    = "jobs:dead"
+	// jobKeyPrefix keeps job records apart from the keys above, which
+	// job IDs would otherwise be looked up as
+	jobKeyPrefix = "jobs:job:"
+	// legacyJobKeyPrefix is where job records were saved before. Those
+	// still there expire within deadJobTTL.
+	legacyJobKeyPrefix = "jobs:"
+
+	// jobTTL is how long finished job records stay queryable.
+	jobTTL = 24 * time.Hour
+	// deadJobTTL keeps dead-lettered jobs around long enough to inspect.
+	deadJobTTL = 7 * 24 * time.Hour
+
+	maxBackoff = 5 * time.Minute
+)
+
+// Job statuses.
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusRetrying  = "retrying"
+	StatusDead      = "dead"
+	StatusScheduled = "scheduled"
+	StatusCanceled  = "canceled"
+)
+
+var (
+	// ErrNotFound is returned for unknown or expired job IDs.
+	ErrNotFound = errors.New("job not found")
+	// ErrNotCancelable is returned when canceling a job that is no longer
+	// waiting for its run time.
+	ErrNotCancelable = errors.New("job is not scheduled")
+)
+
+type Job struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Status    string          `json:"status"`
+	Attempts  int             `json:"attempts"`
+	Error     string          `json:"error,omitempty"`
+	RunAt     *time.Time      `json:"run_at,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// HandlerFunc processes the payload of a single job.
+type HandlerFunc func(ctx context.Context, payload json.RawMessage) error
+
+type Queue struct {
+	// MaxAttempts is how many times a job runs before it is dead-lettered.
+	MaxAttempts int
+	// Backoff is the delay before the first retry; it doubles per attempt.
+	Backoff time.Duration
+
+	rds       *redis.Client
+	mu        sync.RWMutex
+	handlers  map[string]HandlerFunc
+	processed rate
+}
+
+func New(rds *redis.Client) *Queue {
+	return &Queue{
+		MaxAttempts: 5,
+		Backoff:     time.Second,
+		rds:         rds,
+		handlers:    map[string]HandlerFunc{},
+	}
+}
+
+// Register sets the handler for a job type. It must be called before Run.
+func (q *Queue) Register(jobType string, h HandlerFunc) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[jobType] = h
+}
+
+// HasHandler reports whether jobs of the given type can be processed.
+func (q *Queue) HasHandler(jobType string) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	_, ok := q.handlers[jobType]
+	return ok
+}
+
+// Enqueue stores a new job and pushes it onto the queue.
+func (q *Queue) Enqueue(ctx context.Context, jobType string, payload any) (*Job, error) {
+	return q.EnqueueAt(ctx, jobType, payload, time.Time{})
+}
+
+// EnqueueAt stores a new job that becomes runnable at runAt. A zero or past
+// runAt queues the job immediately.
+func (q *Queue) EnqueueAt(ctx context.Context, jobType string, payload any, runAt time.Time) (*Job, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payload: %v", err)
+	}
+
+	now := time.Now().UTC()
+	job := &Job{
+		ID:        newID(),
+		Type:      jobType,
+		Payload:   raw,
+		Status:    StatusQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if runAt.After(now) {
+		runAt = runAt.UTC()
+		job.Status = StatusScheduled
+		job.RunAt = &runAt
+		if err := q.saveWithTTL(ctx, job, time.Until(runAt)+jobTTL); err != nil {
+			return nil, err
+		}
+		if err := q.schedule(ctx, job.ID, runAt); err != nil {
+			return nil, fmt.Errorf("failed to schedule job: %v", err)
+		}
+		return job, nil
+	}
+
+	if err := q.save(ctx, job); err != nil {
+		return nil, err
+	}
+	if err := q.rds.LPush(ctx, queueKey, job.ID).Err(); err != nil {
+		return nil, fmt.Errorf("failed to enqueue job: %v", err)
+	}
+	return job, nil
+}
+
+// Cancel removes a job that is still waiting for its run time, whether it
+// was scheduled for later or is waiting for a retry.
+func (q *Queue) Cancel(ctx context.Context, id string) (*Job, error) {
+	job, err := q.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	removed, err := q.rds.ZRem(ctx, delayedKey, id).Result()
+	if err != nil {
+		return nil, err
+	}
+	if removed == 0 {
+		return nil, ErrNotCancelable
+	}
+
+	job.Status = StatusCanceled
+	job.RunAt = nil
+	job.UpdatedAt = time.Now().UTC()
+	if err := q.save(ctx, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Get returns the current state of a job.
+func (q *Queue) Get(ctx context.Context, id string) (*Job, error) {
+	raw, err := q.rds.Get(ctx, jobKeyPrefix+id).Bytes()
+	if err == redis.Nil {
+		switch legacy := legacyJobKeyPrefix + id; legacy {
+		case queueKey, delayedKey, deadKey:
+		default:
+			raw, err = q.rds.Get(ctx, legacy).Bytes()
+		}
+	}
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var job Job
+	if err := json.Unmarshal(raw, &job); err != nil {
+		return nil, fmt.Errorf("failed to decode job %s: %v", id, err)
+	}
+	return &job, nil
+}
+
+// Run processes jobs with the given number of workers until ctx is done,
+// and returns once the jobs they were running are finished.
+func (q *Queue) Run(ctx context.Context, concurrency int) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		q.promoteLoop(ctx)
+	}()
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.loop(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) loop(ctx context.Context) {
+	for ctx.Err() == nil {
+		res, err := q.rds.BRPop(ctx, time.Second, queueKey).Result()
+		if err != nil {
+			if err != redis.Nil && ctx.Err() == nil {
+				log.Printf("worker: dequeue failed: %v", err)
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+		// A job taken off the queue runs to the end when ctx is done
+		// meanwhile, rather than being left marked running
+		q.process(context.WithoutCancel(ctx), res[1])
+	}
+}
+
+func (q *Queue) process(ctx context.Context, id string) {
+	job, err := q.Get(ctx, id)
+	if err != nil {
+		log.Printf("worker: dropping job %s: %v", id, err)
+		return
+	}
+
+	q.mu.RLock()
+	handler, ok := q.handlers[job.Type]
+	q.mu.RUnlock()
+	if !ok {
+		q.finish(ctx, job, fmt.Errorf("no handler for job type %q", job.Type))
+		return
+	}
+
+	job.Status = StatusRunning
+	job.UpdatedAt = time.Now().UTC()
+	if err := q.save(ctx, job); err != nil {
+		log.Printf("worker: failed to mark job %s running: %v", id, err)
+	}
+
+	q.finish(ctx, job, handler(ctx, job.Payload))
+}
+
+// finish records the outcome of an attempt, scheduling a retry or moving
+// the job to the dead-letter list when it fails.
+func (q *Queue) finish(ctx context.Context, job *Job, err error) {
+	job.Attempts++
+	job.UpdatedAt = time.Now().UTC()
+	processedJobs.Inc()
+	q.processed.add(job.UpdatedAt)
+
+	if err == nil {
+		job.Status = StatusSucceeded
+		job.Error = ""
+		job.RunAt = nil
+		if err := q.save(ctx, job); err != nil {
+			log.Printf("worker: failed to save job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	failedJobs.Inc()
+	job.Error = err.Error()
+	log.Printf("worker: job %s (%s) attempt %d failed: %v", job.ID, job.Type, job.Attempts, err)
+
+	if job.Attempts >= q.MaxAttempts {
+		job.Status = StatusDead
+		job.RunAt = nil
+		deadLetteredJobs.Inc()
+		if err := q.saveWithTTL(ctx, job, deadJobTTL); err != nil {
+			log.Printf("worker: failed to save job %s: %v", job.ID, err)
+		}
+		if err := q.rds.LPush(ctx, deadKey, job.ID).Err(); err != nil {
+			log.Printf("worker: failed to dead-letter job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	runAt := job.UpdatedAt.Add(q.backoff(job.Attempts))
+	job.Status = StatusRetrying
+	job.RunAt = &runAt
+	retriedJobs.Inc()
+	if err := q.save(ctx, job); err != nil {
+		log.Printf("worker: failed to save job %s: %v", job.ID, err)
+	}
+	if err := q.schedule(ctx, job.ID, runAt); err != nil {
+		log.Printf("worker: failed to schedule retry of job %s: %v", job.ID, err)
+	}
+}
+
+// backoff returns the delay before the retry following the given attempt.
+func (q *Queue) backoff(attempts int) time.Duration {
+	d := q.Backoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
+
+func (q *Queue) schedule(ctx context.Context, id string, runAt time.Time) error {
+	return q.rds.ZAdd(ctx, delayedKey, redis.Z{Score: float64(runAt.UnixMilli()), Member: id}).Err()
+}
+
+// promoteLoop moves delayed jobs onto the queue once they are due.
+func (q *Queue) promoteLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := q.promoteDue(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("worker: failed to promote delayed jobs: %v", err)
+			}
+		}
+	}
+}
+
+func (q *Queue) promoteDue(ctx context.Context) error {
+	ids, err := q.rds.ZRangeByScore(ctx, delayedKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   fmt.Sprint(time.Now().UnixMilli()),
+		Count: 100,
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		// Only the replica that wins the ZREM enqueues the job
+		removed, err := q.rds.ZRem(ctx, delayedKey, id).Result()
+		if err != nil {
+			return err
+		}
+		if removed == 0 {
+			continue
+		}
+		if err := q.rds.LPush(ctx, queueKey, id).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *Queue) save(ctx context.Context, job *Job) error {
+	return q.saveWithTTL(ctx, job, jobTTL)
+}
+
+func (q *Queue) saveWithTTL(ctx context.Context, job *Job, ttl time.Duration) error {
+	raw, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if err := q.rds.Set(ctx, jobKeyPrefix+job.ID, raw, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to save job: %v", err)
+	}
+	return nil
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
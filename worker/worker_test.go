@@ -0,0 +1,117 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoffDoublesUpToCap(t *testing.T) {
+	q := New(nil)
+	q.Backoff = time.Second
+
+	assert.Equal(t, time.Second, q.backoff(1))
+	assert.Equal(t, 2*time.Second, q.backoff(2))
+	assert.Equal(t, 4*time.Second, q.backoff(3))
+	assert.Equal(t, maxBackoff, q.backoff(20))
+}
+
+func TestRateCountsLastMinute(t *testing.T) {
+	var r rate
+	now := time.Unix(1_000_000, 0)
+	for i := range 120 {
+		r.add(now.Add(time.Duration(i) * time.Second))
+	}
+	end := now.Add(119 * time.Second)
+	assert.Equal(t, 1.0, r.perSecond(end), "one event per second in the window")
+	assert.Equal(t, 0.0, r.perSecond(end.Add(2*time.Minute)), "old events drop out")
+}
+
+func TestFlushReleasesOnlyItsOwnLock(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rds.Close() })
+	ctx := context.Background()
+
+	var flushed []string
+	b := NewBatcher(rds, "batch", func(ctx context.Context, items []string) error {
+		flushed = append(flushed, items...)
+		return nil
+	})
+	require.NoError(t, b.Add(ctx, 1))
+	n, err := b.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.False(t, mr.Exists("batch:lock"), "the lock is released")
+
+	// The lock expires mid-flush and another replica takes it
+	b.flush = func(ctx context.Context, items []string) error {
+		mr.Set("batch:lock", "other")
+		return nil
+	}
+	require.NoError(t, b.Add(ctx, 2))
+	_, err = b.Flush(ctx)
+	require.NoError(t, err)
+	got, err := mr.Get("batch:lock")
+	require.NoError(t, err)
+	assert.Equal(t, "other", got, "another replica's lock is left alone")
+	assert.Equal(t, []string{"1"}, flushed)
+}
+
+func TestLockReleasesOnlyItsOwnHold(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rds.Close() })
+	ctx := context.Background()
+
+	unlock, err := Lock(ctx, rds, "lock", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, unlock)
+	held, err := Lock(ctx, rds, "lock", time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, held, "the lock has a holder")
+
+	// The first holder runs past the TTL and another takes the lock
+	mr.FastForward(2 * time.Minute)
+	other, err := Lock(ctx, rds, "lock", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, other)
+	unlock()
+	assert.True(t, mr.Exists("lock"), "another holder's lock is left alone")
+	other()
+	assert.False(t, mr.Exists("lock"))
+}
+
+func TestJobIDsDoNotReachQueueKeys(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rds.Close() })
+	q := New(rds)
+	ctx := context.Background()
+
+	job, err := q.EnqueueAt(ctx, "test", nil, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	_, err = q.Enqueue(ctx, "test", nil)
+	require.NoError(t, err)
+	require.NoError(t, rds.RPush(ctx, deadKey, "gone").Err())
+
+	for _, id := range []string{"queue", "delayed", "dead"} {
+		_, err := q.Get(ctx, id)
+		assert.ErrorIs(t, err, ErrNotFound, id)
+	}
+	got, err := q.Get(ctx, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, job.ID, got.ID)
+
+	// Records saved before they had a prefix of their own are still found
+	legacy := `{"id":"0123456789abcdef0123456789abcdef","type":"test","status":"queued"}`
+	require.NoError(t, rds.Set(ctx, "jobs:0123456789abcdef0123456789abcdef", legacy, time.Hour).Err())
+	got, err = q.Get(ctx, "0123456789abcdef0123456789abcdef")
+	require.NoError(t, err)
+	assert.Equal(t, StatusQueued, got.Status)
+}